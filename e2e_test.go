@@ -164,11 +164,14 @@ func runIntegrationTest(t *testing.T, testFile string) {
 				if stdin == nil {
 					t.Skipf("this test case is not single patch: %v", args)
 				}
-				got, err := os.ReadFile(filepath.Join(dir, filePath))
+				// Use the absolute path like the CLI does so
+				// that import paths can be resolved.
+				absPath := filepath.Join(dir, filePath)
+				got, err := os.ReadFile(absPath)
 				require.NoError(t, err, "failed to read %q", filePath)
 				patchFile, err := patch.Parse(filePath, stdin)
 				require.NoError(t, err, "failed to parse patch file %q", filePath)
-				actual, err := patchFile.Apply(filePath, got)
+				actual, err := patchFile.Apply(absPath, got)
 				require.NoError(t, err, "failed to apply patch file %q", filePath)
 				assert.Equal(t, string(tt.Want), string(actual))
 			})
@@ -249,8 +252,6 @@ var testsToSkip = map[string]struct{}{
 	"const_to_var/single_top_level":  {},
 	"struct_field_list/zero":         {},
 	"struct_field_list/middle":       {},
-	"value_group_elision/func":       {}, // https://github.com/uber-go/gopatch/issues/6
-	"value_list_elision/foo":         {}, // https://github.com/uber-go/gopatch/issues/6
 	"range_value_elision/no_params":  {},
 	"range_value_elision/one_param":  {},
 	"range_value_elision/two_params": {},
@@ -362,6 +363,10 @@ func loadTestArchive(path string) (*testArchive, error) {
 			name := strings.TrimSuffix(f.Name, _stderr)
 			getTestFile(name).WantComment = singleTrailingNewline(f.Data)
 
+		case filepath.Base(f.Name) == "go.mod":
+			// Module definitions are reproduced as-is so that
+			// import paths can be determined from them.
+
 		case strings.HasSuffix(f.Name, _out):
 			name := strings.TrimSuffix(f.Name, _out) // foo.out.go => foo
 			getTestFile(name).Want = singleTrailingNewline(f.Data)
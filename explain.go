@@ -0,0 +1,153 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/uber-go/gopatch/internal/engine"
+)
+
+// explainTarget is a line of a Go file to explain matches for, specified
+// as "file.go:LINE".
+type explainTarget struct {
+	Filename string
+	Line     int
+}
+
+func parseExplainTarget(s string) (explainTarget, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return explainTarget{}, fmt.Errorf("invalid --explain %q: expected file.go:LINE", s)
+	}
+
+	line, err := strconv.Atoi(s[i+1:])
+	if err != nil || line <= 0 {
+		return explainTarget{}, fmt.Errorf("invalid --explain %q: line must be a positive number", s)
+	}
+
+	return explainTarget{Filename: s[:i], Line: line}, nil
+}
+
+// lineCount returns the number of lines in the given file contents,
+// numbered the same way as positions in the file. A last line without a
+// newline counts too.
+func lineCount(content []byte) int {
+	n := bytes.Count(content, []byte("\n"))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		n++
+	}
+	return n
+}
+
+// explain reports for each change in the given programs whether it matched
+// code on the requested line, and if not, why.
+//
+// Changes are run in order so that those referring to other changes see
+// their matches, but the file is not modified: all changes are explained
+// against the original contents of the file.
+func (cmd *mainCmd) explain(fset *token.FileSet, progs []*engine.Program, opts *options, log *log.Logger) error {
+	target, err := parseExplainTarget(opts.Explain)
+	if err != nil {
+		return err
+	}
+
+	filename := target.Filename
+	if !filepath.IsAbs(filename) {
+		cwd, err := cmd.Getwd()
+		if err != nil {
+			return fmt.Errorf("getwd: %w", err)
+		}
+		filename = filepath.Join(cwd, filename)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if lines := lineCount(content); target.Line > lines {
+		return fmt.Errorf("invalid --explain %q: %v has only %d lines", opts.Explain, target.Filename, lines)
+	}
+
+	var (
+		f  *ast.File
+		ti *engine.TypeInfo
+	)
+	if opts.TypeCheck {
+		typed := engine.LoadTypes(fset, []string{filename}, func(err error) {
+			log.Printf("type-check: %v", err)
+		})
+		tf := typed[filename]
+		f, ti = tf.File, tf.Types
+	}
+	if f == nil {
+		f, err = parser.ParseFile(fset, filename, content, parser.AllErrors|parser.ParseComments)
+		if err != nil {
+			return fmt.Errorf("could not parse %q: %v", target.Filename, err)
+		}
+	}
+
+	for _, prog := range progs {
+		bindings := engine.NewBindings()
+		bindings.Types = ti
+		for _, c := range prog.Changes {
+			cmd.printExplanation(fset, c, c.Explain(f, bindings, target.Line), target)
+
+			// Record the results of the change for the changes that
+			// refer to it.
+			c.Match(f, bindings)
+		}
+	}
+	return nil
+}
+
+// printExplanation prints an explanation for a change on a single line.
+func (cmd *mainCmd) printExplanation(fset *token.FileSet, c *engine.Change, e engine.Explanation, target explainTarget) {
+	subject := "change"
+	if len(c.Name) > 0 {
+		subject = fmt.Sprintf("change %q", c.Name)
+	}
+	fmt.Fprintf(cmd.Stdout, "%v: %v ", fset.Position(c.Pos()), subject)
+
+	switch {
+	case e.Matched:
+		fmt.Fprintf(cmd.Stdout, "matched %v:%v\n", target.Filename, target.Line)
+	case e.Pos.IsValid():
+		pos := fset.Position(e.Pos)
+		pos.Filename = target.Filename
+		fmt.Fprintf(cmd.Stdout, "did not match %v: %v", pos, e.Reason)
+		if e.PatchPos.IsValid() {
+			fmt.Fprintf(cmd.Stdout, " (%v)", fset.Position(e.PatchPos))
+		}
+		fmt.Fprintln(cmd.Stdout)
+	default:
+		fmt.Fprintf(cmd.Stdout, "did not match %v:%v: %v\n", target.Filename, target.Line, e.Reason)
+	}
+}
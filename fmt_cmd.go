@@ -0,0 +1,132 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/pkg/diff"
+	"github.com/uber-go/gopatch/internal/parse"
+	"go.uber.org/multierr"
+)
+
+type fmtArguments struct {
+	Patches []string `positional-arg-name:"patch"`
+}
+
+type fmtOptions struct {
+	List  bool         `short:"l" long:"list"`
+	Write bool         `short:"w" long:"write"`
+	Diff  bool         `short:"d" long:"diff"`
+	Args  fmtArguments `positional-args:"yes"`
+}
+
+func newFmtArgParser() (*flags.Parser, *fmtOptions) {
+	var opts fmtOptions
+	parser := flags.NewParser(&opts, flags.HelpFlag)
+	parser.Name = "gopatch fmt"
+
+	parser.FindOptionByLongName("list").
+		Description = "List files whose formatting differs from gopatch fmt's " +
+		"instead of printing their formatted contents."
+
+	parser.FindOptionByLongName("write").
+		Description = "Write the formatted contents back to the files " +
+		"instead of printing them."
+
+	parser.FindOptionByLongName("diff").
+		Description = "Print diffs of the changes to formatting " +
+		"instead of the formatted contents."
+
+	parser.Args()[0].
+		Description = "One or more patch files to format."
+
+	return parser, &opts
+}
+
+// runFmt formats patch files. By default, the formatted contents of each
+// file are printed. Errors are reported for each file that couldn't be
+// formatted after the rest are done.
+func (cmd *mainCmd) runFmt(args []string) error {
+	argParser, opts := newFmtArgParser()
+	if _, err := argParser.ParseArgs(args); err != nil {
+		return err
+	}
+
+	if len(opts.Args.Patches) == 0 {
+		argParser.WriteHelp(cmd.Stderr)
+		fmt.Fprintln(cmd.Stderr)
+
+		return errors.New("please provide at least one patch file")
+	}
+
+	// Like gofmt, a file that fails to format doesn't stop the others
+	// from being formatted.
+	var errs []error
+	for _, path := range opts.Args.Patches {
+		if err := cmd.fmtPatch(path, opts); err != nil {
+			errs = append(errs, fmt.Errorf("format patch %q: %w", path, err))
+		}
+	}
+	return multierr.Combine(errs...)
+}
+
+// fmtPatch formats the patch file at the given path, reporting or writing
+// the result based on the options.
+func (cmd *mainCmd) fmtPatch(path string, opts *fmtOptions) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	out, err := parse.Format(path, src)
+	if err != nil {
+		return err
+	}
+
+	changed := !bytes.Equal(src, out)
+	if opts.List && changed {
+		fmt.Fprintln(cmd.Stdout, path)
+	}
+	if opts.Write && changed {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, out, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if opts.Diff && changed {
+		if err := diff.Text(path, path, src, out, cmd.Stdout); err != nil {
+			return err
+		}
+	}
+
+	if !opts.List && !opts.Write && !opts.Diff {
+		_, err = cmd.Stdout.Write(out)
+	}
+	return err
+}
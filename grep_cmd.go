@@ -0,0 +1,205 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/engine"
+	"go.uber.org/multierr"
+)
+
+// Template used to print matches if --format is not provided.
+const defaultGrepFormat = "{{pos}}: {{match}}"
+
+type grepOptions struct {
+	Patches       []string          `short:"p" long:"patch" value-name:"file"`
+	PatchesFile   string            `short:"P" long:"patches-file" value-name:"file"`
+	Format        string            `short:"f" long:"format" value-name:"template"`
+	SkipGenerated bool              `long:"skip-generated"`
+	TypeCheck     bool              `long:"type-check"`
+	Params        map[string]string `short:"D" long:"param" value-name:"name=value" key-value-delimiter:"="`
+	Verbose       bool              `short:"v" long:"verbose"`
+	Args          arguments         `positional-args:"yes"`
+}
+
+func newGrepArgParser() (*flags.Parser, *grepOptions) {
+	var opts grepOptions
+	parser := flags.NewParser(&opts, flags.HelpFlag)
+	parser.Name = "gopatch grep"
+
+	parser.FindOptionByLongName("patch").
+		Description = "Path to a patch file specifying the code to search for. " +
+		"Multiple patches may be provided. " +
+		"If the flag is omitted, a patch will be read from stdin."
+
+	parser.FindOptionByLongName("patches-file").
+		Description = "File containing a list of paths to patch files. " +
+		"Each file must be listed on its own line."
+
+	parser.FindOptionByLongName("format").
+		Description = "Go template used to print each match. " +
+		"Metavariables captured by the match are available by name, e.g. {{.x}}, " +
+		"and the functions pos, match, and change return the position of the match, " +
+		"its source code, and the name of the change that found it. " +
+		"Defaults to \"" + defaultGrepFormat + "\"."
+
+	parser.FindOptionByLongName("skip-generated").
+		Description = "Skips searching files with generated code."
+
+	parser.FindOptionByLongName("type-check").
+		Description = "Type-check packages before searching them so that metavariables " +
+		"with type constraints match only values of those types."
+
+	parser.FindOptionByLongName("param").
+		Description = "Value for a parameter declared in patches with \"param\". " +
+		"This may be provided multiple times to set different parameters."
+
+	parser.FindOptionByLongName("verbose").
+		Description = "Report files that could not be type-checked or were skipped."
+
+	parser.Args()[0].
+		Description = "One or more files or directores containing Go code to search."
+
+	return parser, &opts
+}
+
+// grepMatch is a match being printed by the --format template.
+type grepMatch struct {
+	Pos    token.Position
+	Source string // matched code
+	Change string // name of the change
+}
+
+// runGrep reports code matched by patches without changing it. The "+"
+// sections of the patches, if any, are ignored.
+func (cmd *mainCmd) runGrep(args []string) error {
+	argParser, opts := newGrepArgParser()
+	if _, err := argParser.ParseArgs(args); err != nil {
+		return err
+	}
+
+	if len(opts.Args.Patterns) == 0 {
+		argParser.WriteHelp(cmd.Stderr)
+		fmt.Fprintln(cmd.Stderr)
+
+		return errors.New("please provide at least one pattern")
+	}
+
+	format := opts.Format
+	if len(format) == 0 {
+		format = defaultGrepFormat
+	}
+
+	// The functions report on the match being printed.
+	var cur grepMatch
+	tmpl, err := template.New("format").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{
+			"pos":    func() string { return cur.Pos.String() },
+			"match":  func() string { return cur.Source },
+			"change": func() string { return cur.Change },
+		}).
+		Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid --format: %w", err)
+	}
+
+	logOut := io.Discard
+	if opts.Verbose {
+		logOut = cmd.Stderr
+	}
+	log := log.New(logOut, "", 0)
+
+	fset := token.NewFileSet()
+	progs, err := loadPatches(fset, &options{
+		Patches:     opts.Patches,
+		PatchesFile: opts.PatchesFile,
+		Params:      opts.Params,
+	}, cmd.Stdin)
+	if err != nil {
+		return err
+	}
+
+	// Changes for which the template failed. These are reported once and
+	// skipped afterwards, e.g. if the template refers to a metavariable
+	// that only some of the changes declare.
+	failed := make(map[*engine.Change]struct{})
+
+	var errs []error
+	fileOpts := fileOptions{SkipGenerated: opts.SkipGenerated, TypeCheck: opts.TypeCheck}
+	err = cmd.forEachFile(fset, opts.Args.Patterns, fileOpts, log, func(gf *goFile) error {
+		tfile := fset.File(gf.File.Pos())
+		return matchChanges(progs, gf.File, gf.Types, func(c *engine.Change, d data.Data) error {
+			if _, ok := failed[c]; ok {
+				return nil
+			}
+
+			for _, m := range c.Matches(d) {
+				cur = grepMatch{
+					Pos:    fset.Position(m.Region.Pos),
+					Source: string(gf.Content[tfile.Offset(m.Region.Pos):tfile.Offset(m.Region.End)]),
+					Change: c.Name,
+				}
+				cur.Pos.Filename = gf.Path.Provided
+
+				if err := cmd.printGrepMatch(tmpl, c, m); err != nil {
+					errs = append(errs, fmt.Errorf("%v: %w", cur.Pos, err))
+					failed[c] = struct{}{}
+					break
+				}
+			}
+			return nil
+		})
+	})
+
+	return multierr.Append(err, multierr.Combine(errs...))
+}
+
+// printGrepMatch prints a match with the given template on its own line.
+func (cmd *mainCmd) printGrepMatch(tmpl *template.Template, c *engine.Change, m engine.Match) error {
+	// Metavariables that didn't capture anything are empty rather than
+	// missing so that only references to unknown names are errors.
+	vars := make(map[string]string)
+	for name, t := range c.Meta.Vars {
+		switch t {
+		case engine.ExprMetavarType, engine.IdentMetavarType, engine.ExprListMetavarType,
+			engine.ComputedIdentMetavarType:
+			vars[name] = m.Vars[name]
+		}
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, vars); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err := io.WriteString(cmd.Stdout, out.String())
+	return err
+}
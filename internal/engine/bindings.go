@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"github.com/uber-go/gopatch/internal/data"
+)
+
+// Bindings records the matches of named changes as a Program runs against a
+// single file. Changes that refer to other changes look up their results
+// here.
+//
+// A new Bindings must be used for each file.
+type Bindings struct {
+	// Type information for the file, if it was type-checked.
+	// Metavariables with type constraints match values of any type
+	// without it.
+	Types *TypeInfo
+
+	matches map[string][]Binding // change name => matches
+}
+
+// Binding is a single match of a named change.
+type Binding struct {
+	// Metavariables captured by the match.
+	Data data.Data
+
+	// Region of the file that was matched.
+	Region Region
+}
+
+// NewBindings builds a new, empty Bindings.
+func NewBindings() *Bindings {
+	return &Bindings{matches: make(map[string][]Binding)}
+}
+
+// Lookup returns the matches recorded for the change with the given name, or
+// nil if the change did not match.
+func (b *Bindings) Lookup(name string) []Binding {
+	return b.matches[name]
+}
+
+// record records the results of matching the named change with the given
+// match data.
+func (b *Bindings) record(name string, d data.Data) {
+	var fd fileMatchData
+	if !data.Lookup(d, fileMatchKey, &fd) {
+		return
+	}
+
+	bindings := make([]Binding, len(fd.Matches))
+	for i, m := range fd.Matches {
+		bindings[i] = Binding{
+			Data:   metavarsOf(m.data),
+			Region: m.region,
+		}
+	}
+	b.matches[name] = bindings
+}
+
+// metavarsOf returns a new Data holding only the metavariables captured in
+// the given Data.
+//
+// Other information recorded during a match is specific to the change that
+// recorded it, and must not leak into other changes.
+func metavarsOf(d data.Data) data.Data {
+	md := data.New()
+	for _, k := range d.Keys() {
+		switch k.(type) {
+		case metavarKey, importMetavarKey:
+			md = data.WithValue(md, k, d.Value(k))
+		}
+	}
+	return md
+}
@@ -25,9 +25,11 @@ import (
 	"go/ast"
 	"go/token"
 	"sort"
+	"strings"
 
 	"github.com/uber-go/gopatch/internal/data"
 	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/pgo"
 )
 
 // Change is a single Change in a program.
@@ -35,39 +37,260 @@ type Change struct {
 	Name string
 	Meta *Meta
 
+	// Name of the change inside whose matches this change runs, if any.
+	Within string
+
+	// Whether the change only searches for code without changing it.
+	// Such changes have no "+" section.
+	SearchOnly bool
+
+	// Whether other changes refer to this change by name, for example
+	// with "within" or "depends on" clauses.
+	Referenced bool
+
 	Comments []string
+	depends  dependency // nil if the change always runs
 	fset     *token.FileSet
+	header   token.Pos // start of the header
+	pos, end token.Pos // region of the patch
+	stmts    bool      // whether the patch is a list of statements
 	matcher  FileMatcher
 	replacer FileReplacer
 }
 
 func (c *compiler) compileChange(achange *parse.Change) *Change {
+	c.substituteParams(achange, c.compileParams(achange.Meta))
 	meta := c.compileMeta(achange.Meta)
 
+	var (
+		within    string
+		firstOnly bool
+		depends   []*parse.DependsClause
+	)
+	for _, clause := range achange.Clauses {
+		switch clause := clause.(type) {
+		case *parse.FirstClause:
+			firstOnly = true
+		case *parse.WithinClause:
+			within = c.compileWithin(clause, meta)
+		case *parse.DependsClause:
+			depends = append(depends, clause)
+		case *parse.FilesClause, *parse.ImportPathClause, *parse.TestsClause, *parse.TagClause:
+			// Handled by compileFileFilter.
+		default:
+			panic(fmt.Sprintf("unknown clause %T", clause))
+		}
+	}
+
 	mc := newMatcherCompiler(c.fset, meta, achange.Patch.Pos(), achange.Patch.End())
+	mc.firstOnly = firstOnly
+	mc.packages = c.packages
 	rc := newReplacerCompiler(c.fset, meta, achange.Patch.Pos(), achange.Patch.End())
+	rc.packages = c.packages
 
-	matcher := mc.compileFile(achange.Patch.Minus)
+	c.checkListMetavars(meta, achange.Patch)
+	c.checkPlusOnlyMetavars(meta, achange.Patch)
+	c.checkUnboundMetavars(meta, c.changes[within], achange.Patch)
+	rc.tags = pairStructTags(achange.Patch.Minus, achange.Patch.Plus)
+	c.checkStructTags(meta, achange.Patch, rc.tags)
+	matcher := c.compileMinus(mc, achange.Patch)
+	matcher.Filter = c.compileFileFilter(achange.Clauses)
 	replacer := rc.compileFile(achange.Patch.Plus)
 
 	ldots := mc.dots
 	rdots := rc.dots
-	connectDots(c.fset, ldots, rdots, rc.dotAssoc)
+	c.connectDots(ldots, rdots, rc.dotAssoc)
+	c.vetChange(achange, meta)
 
-	return &Change{
-		Name:     achange.Name, // TODO(abg): validate name
-		Meta:     meta,
-		fset:     c.fset,
-		matcher:  matcher,
-		replacer: replacer,
-		Comments: achange.Comments,
+	_, stmts := achange.Patch.Minus.Node.(*pgo.StmtList)
+	change := &Change{
+		Name:       achange.Name, // TODO(abg): validate name
+		Meta:       meta,
+		Within:     within,
+		SearchOnly: achange.Patch.SearchOnly,
+		depends:    c.compileDepends(depends),
+		fset:       c.fset,
+		header:     achange.HeaderPos,
+		pos:        achange.Patch.Pos(),
+		end:        achange.Patch.End(),
+		stmts:      stmts,
+		matcher:    matcher,
+		replacer:   replacer,
+		Comments:   achange.Comments,
+	}
+	for _, name := range referencedChanges(achange) {
+		if ref, ok := c.changes[name]; ok {
+			ref.Referenced = true
+		}
+	}
+	if len(change.Name) > 0 {
+		// Later references to the name would be ambiguous.
+		if prev, ok := c.changes[change.Name]; ok {
+			c.errf(change.header, "change %q is already declared at %v",
+				change.Name, c.fset.Position(prev.header))
+		} else {
+			c.changes[change.Name] = change
+		}
+	}
+	return change
+}
+
+// Pos returns the position at which the patch of this change starts.
+func (c *Change) Pos() token.Pos { return c.pos }
+
+// compileWithin compiles a "within" clause, making metavariables of the
+// enclosing change available to the given Meta.
+func (c *compiler) compileWithin(clause *parse.WithinClause, meta *Meta) string {
+	name := clause.Change.Name
+	outer, ok := c.changes[name]
+	if !ok {
+		c.errf(clause.Change.Pos(), "unknown change %q: "+
+			"changes must be declared before they are referenced", name)
+		return ""
+	}
+
+	for v, t := range outer.Meta.Vars {
+		if _, conflict := meta.Vars[v]; conflict {
+			c.errf(clause.Change.Pos(), "cannot run within change %q: "+
+				"metavariable %q is already defined by it", name, v)
+			continue
+		}
+		meta.Vars[v] = t
+		if seed, ok := outer.Meta.Seeds[v]; ok {
+			if meta.Seeds == nil {
+				meta.Seeds = make(map[string]string)
+			}
+			meta.Seeds[v] = seed
+		}
+		if e, ok := outer.Meta.Computed[v]; ok {
+			if meta.Computed == nil {
+				meta.Computed = make(map[string]computedExpr)
+			}
+			meta.Computed[v] = e
+		}
 	}
+	return name
 }
 
 // Match matches this change in the given Go AST and returns captured match
 // information it a data.Data object.
-func (c *Change) Match(f *ast.File) (d data.Data, ok bool) {
-	return c.matcher.Match(f, data.New())
+//
+// Bindings holds the results of changes that previously ran on the same
+// file. If this change matched, its own results are recorded into it.
+//
+// A change with "depends on" clauses doesn't match if the changes it depends
+// on didn't match as required.
+func (c *Change) Match(f *ast.File, b *Bindings) (d data.Data, ok bool) {
+	if c.depends != nil && !c.depends(b) {
+		return nil, false
+	}
+
+	if len(c.Within) == 0 && len(c.Meta.Inherited) == 0 {
+		d, ok = c.matcher.Match(f, withTypes(data.New(), b.Types))
+	} else {
+		d, ok = c.matchSeeds(f, c.seeds(b), b.Types)
+	}
+
+	if ok && len(c.Name) > 0 {
+		b.record(c.Name, d)
+	}
+	return d, ok
+}
+
+// seeds returns the starting points for matching this change: one for each
+// combination of bindings of the changes it refers to.
+//
+// A seed with an empty Region applies to the entire file.
+func (c *Change) seeds(b *Bindings) []Binding {
+	seeds := []Binding{{Data: data.New()}}
+	if len(c.Within) > 0 {
+		seeds = b.Lookup(c.Within)
+	}
+
+	// Group inherited variables by the change they come from, visiting
+	// changes in a stable order.
+	byChange := make(map[string][]string)
+	for name, from := range c.Meta.Inherited {
+		byChange[from] = append(byChange[from], name)
+	}
+	changes := make([]string, 0, len(byChange))
+	for from, names := range byChange {
+		sort.Strings(names)
+		changes = append(changes, from)
+	}
+	sort.Strings(changes)
+
+	for _, from := range changes {
+		names := byChange[from]
+		bindings := b.Lookup(from)
+
+		next := make([]Binding, 0, len(seeds)*len(bindings))
+		for _, seed := range seeds {
+			for _, binding := range bindings {
+				d := seed.Data
+				for _, name := range names {
+					k := metavarKey(name)
+					d = data.WithValue(d, k, binding.Data.Value(k))
+				}
+				next = append(next, Binding{Data: d, Region: seed.Region})
+			}
+		}
+		seeds = next
+	}
+
+	return seeds
+}
+
+// matchSeeds matches this change once for each of the given seeds, starting
+// with the metavariables bound by the seed and inside its region.
+//
+// A node matched by more than one seed is reported only once, for the first
+// seed that matched it.
+func (c *Change) matchSeeds(f *ast.File, seeds []Binding, ti *TypeInfo) (d data.Data, ok bool) {
+	type matchKey struct {
+		parent ast.Node
+		name   string
+		index  int
+	}
+
+	var matches []*SearchResult
+	seen := make(map[matchKey]struct{})
+	for _, seed := range seeds {
+		var (
+			sd  data.Data
+			sok bool
+		)
+		seedData := withTypes(seed.Data, ti)
+		if seed.Region == (Region{}) {
+			sd, sok = c.matcher.Match(f, seedData)
+		} else {
+			sd, sok = c.matcher.MatchWithin(f, seedData, seed.Region)
+		}
+		if !sok {
+			continue
+		}
+
+		var fd fileMatchData
+		data.Lookup(sd, fileMatchKey, &fd)
+		for _, m := range fd.Matches {
+			k := matchKey{parent: m.parent, name: m.name, index: m.index}
+			if _, dup := seen[k]; dup {
+				continue
+			}
+			seen[k] = struct{}{}
+			matches = append(matches, m)
+		}
+		d, ok = sd, true
+	}
+
+	if !ok {
+		return d, false
+	}
+
+	return data.WithValue(d, fileMatchKey, fileMatchData{
+		File:    f,
+		Matches: matches,
+	}), true
 }
 
 // Replace generates a replacement File based on previously captured match
@@ -76,12 +299,114 @@ func (c *Change) Replace(d data.Data, cl Changelog) (*ast.File, error) {
 	return c.replacer.Replace(d, cl)
 }
 
-func connectDots(fset *token.FileSet, lhs, rhs []token.Pos, conns map[token.Pos]token.Pos) error {
+// Match is a single match of a change in a file.
+type Match struct {
+	// Region of the file matched by the change.
+	Region Region
+
+	// Code captured by each of the metavariables of the change, printed
+	// as Go source. Expression list metavariables hold their items
+	// separated by commas, and computed identifiers hold the names
+	// computed from the other metavariables. Metavariables that captured
+	// nothing are absent, as are fresh identifiers.
+	Vars map[string]string
+}
+
+// Matches returns the individual matches held in the Data returned by
+// Match, in the order in which they appear in the file.
+//
+// A change made up of statements matches them inside blocks of statements.
+// Each occurrence of the statements in a block is a separate match.
+func (c *Change) Matches(d data.Data) []Match {
+	var fd fileMatchData
+	if !data.Lookup(d, fileMatchKey, &fd) {
+		return nil
+	}
+
+	var matches []Match
+	for _, m := range fd.Matches {
+		if !c.stmts {
+			matches = append(matches, c.newMatch(m.data, m.region))
+			continue
+		}
+
+		// The statements are surrounded by implicit "..."s at the
+		// start and the end of the patch. The matched statements are
+		// between the items skipped by them.
+		var rd sliceDotsRepeatData
+		if !data.Lookup(m.data, sliceDotsRepeatKey(c.pos), &rd) {
+			_, before := lookupSliceDotsSkipped(m.data, c.pos)
+			_, after := lookupSliceDotsSkipped(m.data, c.end)
+			matches = append(matches, c.newMatch(m.data, Region{Pos: before.End, End: after.Pos}))
+			continue
+		}
+
+		for i, od := range rd.Occurrences {
+			_, before := lookupSliceDotsSkipped(od, c.pos)
+			after := rd.Tail.Region
+			if i+1 < len(rd.Occurrences) {
+				_, after = lookupSliceDotsSkipped(rd.Occurrences[i+1], c.pos)
+			}
+			od = overlayData{Data: m.data, top: od}
+			matches = append(matches, c.newMatch(od, Region{Pos: before.End, End: after.Pos}))
+		}
+	}
+	return matches
+}
+
+// newMatch builds a Match for the given region from the metavariables
+// captured in d.
+func (c *Change) newMatch(d data.Data, r Region) Match {
+	vars := make(map[string]string)
+	for name, t := range c.Meta.Vars {
+		var items []metavarData
+		switch t {
+		case ExprMetavarType, IdentMetavarType:
+			var md metavarData
+			if !data.Lookup(d, metavarKey(name), &md) {
+				continue
+			}
+			items = append(items, md)
+		case ExprListMetavarType:
+			var ld metavarListData
+			if !data.Lookup(d, metavarKey(name), &ld) {
+				continue
+			}
+			items = ld.Items
+		case ComputedIdentMetavarType:
+			if e := c.Meta.Computed[name]; e != nil {
+				if v, err := e.Eval(d, NewChangelog(), r.Pos); err == nil {
+					vars[name] = v
+				}
+			}
+			continue
+		default:
+			continue
+		}
+
+		texts := make([]string, 0, len(items))
+		for _, md := range items {
+			v, err := md.Replace(data.New(), NewChangelog(), r.Pos)
+			if err != nil {
+				continue
+			}
+			texts = append(texts, Sprint(v))
+		}
+		vars[name] = strings.Join(texts, ", ")
+	}
+	return Match{Region: r, Vars: vars}
+}
+
+// connectDots associates each "..." in the "+" section of a change with the
+// nearest preceding "..." in the "-" section. A "..." in the "+" section that
+// can't be associated is reported as a problem found by Vet, and reproduces
+// nothing.
+func (c *compiler) connectDots(lhs, rhs []token.Pos, conns map[token.Pos]token.Pos) {
 	cache := make(map[token.Pos]token.Position)
 	getPosition := func(pos token.Pos) token.Position {
 		p, ok := cache[pos]
 		if !ok {
-			p = fset.Position(pos)
+			p = c.fset.Position(pos)
 			cache[pos] = p
 		}
 		return p
@@ -112,20 +437,16 @@ func connectDots(fset *token.FileSet, lhs, rhs []token.Pos, conns map[token.Pos]
 		})
 
 		if i == len(lhs) {
-			return fmt.Errorf(`%v: "..." in "+" section does not have an associated "..." in "-" section`, rpos)
+			c.warnf(r, `"..." in "+" section does not have an associated "..." in "-" section`)
+			continue
 		}
 
 		if other, conflict := conns[r]; conflict {
-			lpos := getPosition(lhs[i])
-			conflictPos := getPosition(other)
-			return fmt.Errorf(
-				`cannot associate "..." at %v with %v: already associated with %v`,
-				rpos, lpos, conflictPos,
-			)
+			c.warnf(r, `cannot associate "..." with %v: already associated with %v`,
+				getPosition(lhs[i]), getPosition(other))
+			continue
 		}
 
 		conns[r] = lhs[i]
 	}
-
-	return nil
 }
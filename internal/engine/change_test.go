@@ -0,0 +1,295 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestChangeMatches(t *testing.T) {
+	type match struct {
+		Text string // matched source
+		Vars map[string]string
+	}
+
+	tests := []struct {
+		desc  string
+		patch []byte
+		give  []byte // contents of foo.go
+		want  []match
+	}{
+		{
+			desc: "expression",
+			patch: text.Unlines(
+				"@@",
+				"var x, y expression",
+				"@@",
+				"-foo(x, y)",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"var (",
+				"	a = foo(1, bar())",
+				"	b = foo(c, d.e)",
+				")",
+			),
+			want: []match{
+				{Text: "foo(1, bar())", Vars: map[string]string{"x": "1", "y": "bar()"}},
+				{Text: "foo(c, d.e)", Vars: map[string]string{"x": "c", "y": "d.e"}},
+			},
+		},
+		{
+			desc: "expression list",
+			patch: text.Unlines(
+				"@@",
+				"var args expression list",
+				"@@",
+				"-foo(args)",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"var a = foo(1, 2, 3)",
+				"var b = foo()",
+			),
+			want: []match{
+				{Text: "foo(1, 2, 3)", Vars: map[string]string{"args": "1, 2, 3"}},
+				{Text: "foo()", Vars: map[string]string{"args": ""}},
+			},
+		},
+		{
+			desc: "computed identifier",
+			patch: text.Unlines(
+				"@@",
+				"var name identifier",
+				`var short identifier = trimPrefix(name, "Get")`,
+				"@@",
+				"-name()",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"var a = GetFoo()",
+			),
+			want: []match{
+				{Text: "GetFoo()", Vars: map[string]string{"name": "GetFoo", "short": "Foo"}},
+			},
+		},
+		{
+			desc: "statements",
+			patch: text.Unlines(
+				"@@",
+				"var x expression",
+				"@@",
+				"-x.Lock()",
+				"-defer x.Unlock()",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"func y() {",
+				"	a.Lock()",
+				"	defer a.Unlock()",
+				"	foo()",
+				"	b.Lock()",
+				"	defer b.Unlock()",
+				"}",
+			),
+			want: []match{
+				{Text: "a.Lock()\n\tdefer a.Unlock()", Vars: map[string]string{"x": "a"}},
+				{Text: "b.Lock()\n\tdefer b.Unlock()", Vars: map[string]string{"x": "b"}},
+			},
+		},
+		{
+			desc: "first statements only",
+			patch: text.Unlines(
+				"@ lock first @",
+				"var x expression",
+				"@@",
+				"-x.Lock()",
+				"-x.Unlock()",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"func y() {",
+				"	a.Lock()",
+				"	a.Unlock()",
+				"	b.Lock()",
+				"	b.Unlock()",
+				"}",
+			),
+			want: []match{
+				{Text: "a.Lock()\n\ta.Unlock()", Vars: map[string]string{"x": "a"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", tt.patch)
+			require.NoError(t, err)
+
+			p, err := Compile(fset, prog)
+			require.NoError(t, err)
+			require.Len(t, p.Changes, 1)
+			c := p.Changes[0]
+			assert.True(t, c.SearchOnly, "search only")
+
+			file, err := parser.ParseFile(fset, "foo.go", tt.give, 0)
+			require.NoError(t, err)
+
+			d, ok := c.Match(file, NewBindings())
+			require.True(t, ok, "no match")
+
+			tfile := fset.File(file.Pos())
+			var got []match
+			for _, m := range c.Matches(d) {
+				start, end := tfile.Offset(m.Region.Pos), tfile.Offset(m.Region.End)
+				got = append(got, match{
+					Text: string(tt.give[start:end]),
+					Vars: m.Vars,
+				})
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestChangeManyDotsNoMatch(t *testing.T) {
+	fset := token.NewFileSet()
+	prog, err := parse.Parse(fset, "test.patch", text.Unlines(
+		"@@",
+		"@@",
+		" foo()",
+		" ...",
+		" bar()",
+		" ...",
+		" baz()",
+		" ...",
+		" qux()",
+		" ...",
+		"-missing()",
+	))
+	require.NoError(t, err)
+
+	p, err := Compile(fset, prog)
+	require.NoError(t, err)
+	require.Len(t, p.Changes, 1)
+
+	var src strings.Builder
+	src.WriteString("package x\n\nfunc y() {\n\tfoo()\n")
+	for i := 0; i < 150; i++ {
+		src.WriteString("\tbar()\n\tbaz()\n\tqux()\n")
+	}
+	src.WriteString("}\n")
+
+	file, err := parser.ParseFile(fset, "foo.go", src.String(), 0)
+	require.NoError(t, err)
+
+	// Trying every span for each "..." takes time exponential in the
+	// number of dots so this wouldn't finish.
+	done := make(chan bool)
+	go func() {
+		_, ok := p.Changes[0].Match(file, NewBindings())
+		done <- ok
+	}()
+
+	select {
+	case ok := <-done:
+		assert.False(t, ok, "unexpected match")
+	case <-time.After(10 * time.Second):
+		t.Fatal("match did not finish")
+	}
+}
+
+func TestChangeReferenced(t *testing.T) {
+	fset := token.NewFileSet()
+	prog, err := parse.Parse(fset, "test.patch", text.Unlines(
+		"@ locked @",
+		"var mu expression",
+		"@@",
+		"-mu.Lock()",
+		"",
+		"@ unused @",
+		"@@",
+		"-foo()",
+		"",
+		"@ depends on locked @",
+		"@@",
+		"-unsafeCount()",
+		"+count()",
+	))
+	require.NoError(t, err)
+
+	p, err := Compile(fset, prog)
+	require.NoError(t, err)
+	require.Len(t, p.Changes, 3)
+	assert.True(t, p.Changes[0].Referenced, "locked")
+	assert.False(t, p.Changes[1].Referenced, "unused")
+	assert.False(t, p.Changes[2].Referenced, "unnamed")
+}
+
+func TestChangeDuplicateName(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "common.patch"), []byte(text.Unlines(
+		"@ foo @",
+		"var x identifier",
+		"@@",
+		"-foo(x)",
+	)), 0o644))
+
+	fset := token.NewFileSet()
+	mainPath := filepath.Join(dir, "main.patch")
+	prog, err := parse.Parse(fset, mainPath, text.Unlines(
+		`include "common.patch"`,
+		"",
+		"@ foo @",
+		"@@",
+		"-bar()",
+		"",
+		"@ within foo @",
+		"@@",
+		"-baz()",
+		"+qux()",
+	))
+	require.NoError(t, err)
+
+	_, err = Compile(fset, prog)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf(
+		`%v:3:1: change "foo" is already declared at %v:1:1`,
+		mainPath, filepath.Join(dir, "common.patch")))
+}
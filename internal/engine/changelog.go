@@ -22,6 +22,7 @@ package engine
 
 import (
 	"fmt"
+	"go/ast"
 	"go/token"
 
 	"github.com/google/go-intervals/intervalset"
@@ -34,13 +35,17 @@ type Changelog struct {
 	// submit changed/unchanged requests out of order.
 	plus  *intervalset.Set
 	minus *intervalset.Set
+
+	// Comments generated by the patch.
+	comments map[*ast.Comment]struct{}
 }
 
 // NewChangelog builds a new, empty Changelog
 func NewChangelog() Changelog {
 	return Changelog{
-		plus:  intervalset.Empty(),
-		minus: intervalset.Empty(),
+		plus:     intervalset.Empty(),
+		minus:    intervalset.Empty(),
+		comments: make(map[*ast.Comment]struct{}),
 	}
 }
 
@@ -54,6 +59,18 @@ func (c Changelog) Unchanged(start, end token.Pos) {
 	c.minus.Add((&span{Start: start, End: end}).AsSet())
 }
 
+// Commented records a comment generated by the patch. Generated comments
+// should be retained even if they're inside changed portions of the code.
+func (c Changelog) Commented(cm *ast.Comment) {
+	c.comments[cm] = struct{}{}
+}
+
+// IsCommented reports whether the given comment was generated by the patch.
+func (c Changelog) IsCommented(cm *ast.Comment) bool {
+	_, ok := c.comments[cm]
+	return ok
+}
+
 // Interval represents a consecutive set of positions in the source file.
 type Interval struct {
 	Start, End token.Pos
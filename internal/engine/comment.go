@@ -0,0 +1,623 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/goast"
+)
+
+// commentDots is the placeholder used inside patch comments. A comment line
+// that contains only "..." matches any number of comment lines. Elsewhere,
+// "..." matches any text inside a single comment line.
+const commentDots = "..."
+
+// commentLine is a single comment line from a patch.
+type commentLine struct {
+	Pos token.Pos // position of the comment in the patch
+
+	// Line in the patch file on which the comment appears. Context lines
+	// have the same line in both sections of the patch.
+	Line int
+
+	// Text of the comment, split around "...".
+	Parts []string
+
+	// Whether this line contains only "...".
+	Dots bool
+}
+
+func newCommentLine(fset *token.FileSet, c *ast.Comment) commentLine {
+	text := strings.TrimRightFunc(c.Text, isSpace)
+	return commentLine{
+		Pos:   c.Slash,
+		Line:  fset.Position(c.Slash).Line,
+		Parts: strings.Split(text, commentDots),
+		Dots:  commentBody(text) == commentDots,
+	}
+}
+
+// match matches the given comment text against this line, returning the
+// text matched by each "..." in it.
+func (l commentLine) match(text string) (captures []string, ok bool) {
+	text = strings.TrimRightFunc(text, isSpace)
+	if len(l.Parts) == 1 {
+		return nil, text == l.Parts[0]
+	}
+
+	first, last := l.Parts[0], l.Parts[len(l.Parts)-1]
+	if !strings.HasPrefix(text, first) {
+		return nil, false
+	}
+	text = text[len(first):]
+
+	captures = make([]string, 0, len(l.Parts)-1)
+	for _, part := range l.Parts[1 : len(l.Parts)-1] {
+		i := strings.Index(text, part)
+		if i < 0 {
+			return nil, false
+		}
+		captures = append(captures, text[:i])
+		text = text[i+len(part):]
+	}
+
+	if !strings.HasSuffix(text, last) {
+		return nil, false
+	}
+	return append(captures, text[:len(text)-len(last)]), true
+}
+
+// commentBody returns the text of a comment without its delimiters or
+// surrounding whitespace.
+func commentBody(text string) string {
+	if strings.HasPrefix(text, "/*") {
+		text = strings.TrimSuffix(text[2:], "*/")
+	} else {
+		text = strings.TrimPrefix(text, "//")
+	}
+	return strings.TrimSpace(text)
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// CommentGroupMatcher matches the comments attached to a declaration, spec,
+// or field.
+//
+//	@@
+//	@@
+//	 // ...
+//	-//nolint:errcheck
+//	 func Close() error {
+//	   ...
+//	 }
+//
+// Each line of the comment in the patch matches exactly one line of the
+// comment in the source, except lines containing only "...", which match
+// any number of lines. The matched comments are recorded so that
+// CommentGroupReplacer can reproduce them.
+type CommentGroupMatcher struct {
+	Lines []commentLine
+}
+
+func (c *matcherCompiler) compileCommentGroup(v reflect.Value) Matcher {
+	cg := v.Interface().(*ast.CommentGroup)
+	if cg == nil {
+		// Comments shouldn't affect the match unless the patch specifies
+		// them.
+		return successMatcher
+	}
+
+	lines := make([]commentLine, len(cg.List))
+	for i, comment := range cg.List {
+		lines[i] = newCommentLine(c.fset, comment)
+	}
+	return CommentGroupMatcher{Lines: lines}
+}
+
+// Match matches a comment group.
+func (m CommentGroupMatcher) Match(v reflect.Value, d data.Data, _ Region) (data.Data, bool) {
+	cg := v.Interface().(*ast.CommentGroup)
+
+	var got []*ast.Comment
+	if cg != nil {
+		got = cg.List
+	}
+
+	d, ok := m.matchLines(m.Lines, got, d)
+	if ok && cg != nil {
+		d = pushCommentGroupMatch(d, cg)
+	}
+	return d, ok
+}
+
+func (m CommentGroupMatcher) matchLines(lines []commentLine, got []*ast.Comment, d data.Data) (data.Data, bool) {
+	if len(lines) == 0 {
+		return d, len(got) == 0
+	}
+
+	line := lines[0]
+	if line.Dots {
+		// Prefer to match as few lines as possible.
+		for n := 0; n <= len(got); n++ {
+			md := pushCommentMatch(d, line.Line, commentMatch{Comments: got[:n]})
+			if md, ok := m.matchLines(lines[1:], got[n:], md); ok {
+				return md, true
+			}
+		}
+		return d, false
+	}
+
+	if len(got) == 0 {
+		return d, false
+	}
+
+	captures, ok := line.match(got[0].Text)
+	if !ok {
+		return d, false
+	}
+
+	d = pushCommentMatch(d, line.Line, commentMatch{
+		Comments: got[:1],
+		Captures: captures,
+	})
+	return m.matchLines(lines[1:], got[1:], d)
+}
+
+// CommentGroupReplacer generates the comments attached to a declaration,
+// spec, or field.
+//
+// Lines shared with the "-" section of the patch reproduce the comments they
+// matched. A new line replaces the "-" comment line right above it, if any,
+// and "..." inside it is replaced with the text matched by "..." in that
+// line.
+//
+//	@@
+//	@@
+//	-// TODO(...): ...
+//	+// FIXME(...): ...
+//	 func Run() {
+//	   ...
+//	 }
+type CommentGroupReplacer struct {
+	Fset  *token.FileSet
+	Lines []commentLine
+
+	// First line of the patch. We don't look for matched comments above
+	// it.
+	StartLine int
+}
+
+func (c *replacerCompiler) compileCommentGroup(v reflect.Value) Replacer {
+	cg := v.Interface().(*ast.CommentGroup)
+	if cg == nil {
+		// Comments that aren't specified in the patch are left untouched in
+		// the file.
+		return ValueReplacer{
+			Value: reflect.ValueOf((*ast.CommentGroup)(nil)),
+		}
+	}
+
+	lines := make([]commentLine, len(cg.List))
+	for i, comment := range cg.List {
+		lines[i] = newCommentLine(c.fset, comment)
+	}
+	return CommentGroupReplacer{
+		Fset:      c.fset,
+		Lines:     lines,
+		StartLine: c.fset.Position(c.patchStart).Line,
+	}
+}
+
+// Replace generates a comment group.
+//
+// New comments that replace a comment in the file take its position. Other
+// new comments don't have positions; FileReplacer positions them next to the
+// node they're attached to.
+func (r CommentGroupReplacer) Replace(d data.Data, cl Changelog, pos token.Pos) (reflect.Value, error) {
+	// Lines of this comment group that are new in the "+" section.
+	added := make(map[int]struct{})
+	for _, line := range r.Lines {
+		if _, ok := lookupCommentMatch(d, line.Line); !ok {
+			added[line.Line] = struct{}{}
+		}
+	}
+
+	var list []*ast.Comment
+	for _, line := range r.Lines {
+		if m, ok := lookupCommentMatch(d, line.Line); ok {
+			list = append(list, m.Comments...)
+			continue
+		}
+
+		// The comment line this replaces, if any, is the line above the
+		// new lines preceding it in the patch.
+		l := line.Line - 1
+		for ; l >= r.StartLine; l-- {
+			if _, ok := added[l]; !ok {
+				break
+			}
+		}
+		replaced, ok := lookupCommentMatch(d, l)
+		if !ok || r.isLine(l) {
+			// Context lines aren't replaced.
+			replaced = commentMatch{}
+		}
+
+		text, err := r.fill(line, replaced.Captures)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		c := &ast.Comment{Text: text}
+		if len(replaced.Comments) > 0 {
+			c.Slash = replaced.Comments[0].Slash
+		}
+		list = append(list, c)
+	}
+
+	var cg *ast.CommentGroup
+	if len(list) > 0 {
+		cg = &ast.CommentGroup{List: list}
+	}
+	return reflect.ValueOf(cg), nil
+}
+
+// isLine reports whether the given patch line is part of this comment
+// group.
+func (r CommentGroupReplacer) isLine(l int) bool {
+	for _, line := range r.Lines {
+		if line.Line == l {
+			return true
+		}
+	}
+	return false
+}
+
+// fill replaces "..." in a new comment line with text captured by "..." in
+// the comment line it replaces.
+func (r CommentGroupReplacer) fill(line commentLine, captures []string) (string, error) {
+	if len(line.Parts) == 1 {
+		return line.Parts[0], nil
+	}
+
+	if len(captures) != len(line.Parts)-1 {
+		return "", fmt.Errorf(`%v: comment has %d "..." but the comment it replaces matched %d`,
+			r.Fset.Position(line.Pos), len(line.Parts)-1, len(captures))
+	}
+
+	var sb strings.Builder
+	for i, part := range line.Parts {
+		if i > 0 {
+			sb.WriteString(captures[i-1])
+		}
+		sb.WriteString(part)
+	}
+	return sb.String(), nil
+}
+
+type commentMatchKey struct{ Line int }
+
+// commentMatch is the portion of a comment group in the source file
+// matched by a single comment line in the patch.
+type commentMatch struct {
+	Comments []*ast.Comment
+
+	// Text matched by each "..." inside the line.
+	Captures []string
+}
+
+func pushCommentMatch(d data.Data, line int, m commentMatch) data.Data {
+	return data.WithValue(d, commentMatchKey{Line: line}, m)
+}
+
+func lookupCommentMatch(d data.Data, line int) (m commentMatch, ok bool) {
+	ok = data.Lookup(d, commentMatchKey{Line: line}, &m)
+	return m, ok
+}
+
+type _commentGroupsKey struct{}
+
+var commentGroupsKey _commentGroupsKey
+
+// pushCommentGroupMatch records a comment group from the source file
+// matched by the patch. These comments are replaced by the "+" section of
+// the patch.
+func pushCommentGroupMatch(d data.Data, cg *ast.CommentGroup) data.Data {
+	groups := matchedCommentGroups(d)
+	return data.WithValue(d, commentGroupsKey, append(groups[:len(groups):len(groups)], cg))
+}
+
+func matchedCommentGroups(d data.Data) (groups []*ast.CommentGroup) {
+	_ = data.Lookup(d, commentGroupsKey, &groups)
+	return groups
+}
+
+// commentSlot identifies a comment field of a node.
+type commentSlot struct {
+	Node ast.Node
+	Doc  bool // Doc or line comment
+}
+
+// commentSlots calls fn with all comment groups attached to n or its
+// descendants.
+func commentSlots(n ast.Node, fn func(commentSlot, *ast.CommentGroup)) {
+	if n == nil || reflect.ValueOf(n).IsNil() {
+		return
+	}
+
+	ast.Inspect(n, func(n ast.Node) bool {
+		var doc, comment *ast.CommentGroup
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			doc = n.Doc
+		case *ast.GenDecl:
+			doc = n.Doc
+		case *ast.Field:
+			doc, comment = n.Doc, n.Comment
+		case *ast.ImportSpec:
+			doc, comment = n.Doc, n.Comment
+		case *ast.ValueSpec:
+			doc, comment = n.Doc, n.Comment
+		case *ast.TypeSpec:
+			doc, comment = n.Doc, n.Comment
+		default:
+			return true
+		}
+
+		if doc != nil {
+			fn(commentSlot{Node: n, Doc: true}, doc)
+		}
+		if comment != nil {
+			fn(commentSlot{Node: n}, comment)
+		}
+		return true
+	})
+}
+
+// replaceComments updates the comments of a file after the node "from" was
+// replaced with "to" for a match.
+//
+// go/printer prints the comments of a file from ast.File.Comments so comment
+// groups matched by the patch are removed from it, and comment groups
+// generated by the patch are positioned next to their nodes and added to it.
+//
+// pos is the position of the match. Nodes generated by the patch that don't
+// replace a node in the file take this position, so comments can't be
+// positioned next to them.
+func replaceComments(fset *token.FileSet, file *ast.File, cl Changelog, d data.Data, pos token.Pos, from, to ast.Node) error {
+	tfile := fset.File(file.Pos())
+	if tfile == nil {
+		return nil
+	}
+
+	removed := make(map[*ast.CommentGroup]struct{})
+	for _, cg := range matchedCommentGroups(d) {
+		removed[cg] = struct{}{}
+	}
+
+	existing := make(map[*ast.CommentGroup]struct{}, len(file.Comments))
+	comments := file.Comments[:0]
+	for _, cg := range file.Comments {
+		if _, ok := removed[cg]; ok {
+			continue
+		}
+		existing[cg] = struct{}{}
+		comments = append(comments, cg)
+	}
+	file.Comments = comments
+
+	// Nodes of the original code by the positions of the comments attached
+	// to them.
+	owners := make(map[token.Pos]ast.Node)
+	commentSlots(from, func(s commentSlot, cg *ast.CommentGroup) {
+		for _, c := range cg.List {
+			owners[c.Slash] = s.Node
+		}
+	})
+
+	// Comments around statements aren't attached to them in the AST.
+	fc := lookupFileComments(d)
+	stmtSlots := func(fn func(commentSlot, *ast.CommentGroup)) {
+		if fc == nil {
+			return
+		}
+		for _, cg := range matchedCommentGroups(d) {
+			if s := fc.Owner(cg); s != nil {
+				fn(commentSlot{Node: s, Doc: fc.Stmt(s).Doc == cg}, cg)
+			}
+		}
+	}
+	stmtSlots(func(s commentSlot, cg *ast.CommentGroup) {
+		for _, c := range cg.List {
+			owners[c.Slash] = s.Node
+		}
+	})
+
+	// Positions occupied by the new comments.
+	used := make(map[token.Pos]struct{})
+	var (
+		added []*ast.CommentGroup
+		err   error
+	)
+	add := func(s commentSlot, cg *ast.CommentGroup) {
+		if _, ok := existing[cg]; ok || err != nil {
+			return
+		}
+		existing[cg] = struct{}{}
+		added = append(added, cg)
+
+		for _, c := range cg.List {
+			if owner, ok := owners[c.Slash]; ok {
+				rebase(s.Node, owner)
+				break
+			}
+		}
+		if s.Node != to && s.Node.Pos() == pos {
+			err = fmt.Errorf("cannot add comment %q: "+
+				"comments may only be attached to code matched by the patch", cg.List[0].Text)
+			return
+		}
+
+		var prev token.Pos
+		for i, c := range cg.List {
+			cl.Commented(c)
+			if c.Slash.IsValid() {
+				used[c.Slash] = struct{}{}
+				if i+1 < len(cg.List) && !cg.List[i+1].Slash.IsValid() {
+					prev = c.End()
+				}
+				continue
+			}
+
+			var next token.Pos
+			for _, n := range cg.List[i+1:] {
+				if n.Slash.IsValid() {
+					next = n.Slash
+					break
+				}
+			}
+
+			switch {
+			case prev.IsValid():
+				c.Slash = prev
+			case next.IsValid():
+				c.Slash = next
+			case s.Doc:
+				c.Slash = ownLine(tfile, s.Node.Pos())
+			default:
+				c.Slash = s.Node.End()
+			}
+
+			// Following new comments go on the same position. They're
+			// printed on their own lines regardless.
+			prev = c.Slash
+		}
+	}
+	commentSlots(to, add)
+	if fc != nil {
+		for _, g := range fc.added {
+			add(g.Slot, g.Group)
+		}
+		fc.added = nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Remove lines that held deleted doc comments.
+	removeLines := func(s commentSlot, cg *ast.CommentGroup) {
+		if _, ok := removed[cg]; !ok || !s.Doc {
+			return
+		}
+
+		for _, c := range cg.List {
+			if _, ok := used[c.Slash]; ok {
+				continue
+			}
+			if line := tfile.Line(c.Pos()); line < tfile.LineCount() {
+				cl.Changed(c.Pos(), tfile.LineStart(line+1))
+			}
+		}
+	}
+	commentSlots(from, removeLines)
+
+	// Deleted comments above statements are inside code the patch leaves
+	// unchanged, so their lines are removed from the file directly.
+	var lines []int
+	stmtSlots(func(s commentSlot, cg *ast.CommentGroup) {
+		if !s.Doc {
+			return
+		}
+		for _, c := range cg.List {
+			if _, ok := used[c.Slash]; !ok {
+				lines = append(lines, tfile.Line(c.Pos()))
+			}
+		}
+	})
+	sort.Sort(sort.Reverse(sort.IntSlice(lines)))
+	for _, line := range lines {
+		if line < tfile.LineCount() {
+			tfile.MergeLine(line)
+		}
+	}
+
+	if len(added) > 0 {
+		file.Comments = append(file.Comments, added...)
+		sort.SliceStable(file.Comments, func(i, j int) bool {
+			return file.Comments[i].Pos() < file.Comments[j].Pos()
+		})
+	}
+	return nil
+}
+
+// rebase moves a node generated by the patch to the position of the node it
+// replaced if the patch couldn't determine its position.
+//
+// Nodes on lines changed by the patch take the position of the enclosing
+// match. Comments that stay with the node need its real position to be
+// printed next to it.
+func rebase(n, orig ast.Node) {
+	from, to := n.Pos(), orig.Pos()
+	if from == to || !from.IsValid() || !to.IsValid() {
+		return
+	}
+
+	goast.TransformPos(n, func(pos token.Pos) token.Pos {
+		if pos == from {
+			return to
+		}
+		return pos
+	})
+}
+
+// ownLine returns a position right before the line holding pos, on a line of
+// its own. A line is added to the file if necessary.
+func ownLine(tfile *token.File, pos token.Pos) token.Pos {
+	line := tfile.Line(pos)
+	if line <= 1 {
+		return pos
+	}
+
+	// The newline that ends the previous line.
+	nl := tfile.LineStart(line) - 1
+	offset := tfile.Offset(nl)
+
+	lines := tfile.Lines()
+	i := sort.SearchInts(lines, offset)
+	if i < len(lines) && lines[i] == offset {
+		// The previous line is empty.
+		return nl
+	}
+
+	lines = append(lines[:i:i], append([]int{offset}, lines[i:]...)...)
+	tfile.SetLines(lines)
+	return nl
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestCommentLineMatch(t *testing.T) {
+	tests := []struct {
+		desc         string
+		pattern      string
+		give         string
+		wantOK       bool
+		wantCaptures []string
+	}{
+		{
+			desc:    "exact",
+			pattern: "//nolint:errcheck",
+			give:    "//nolint:errcheck",
+			wantOK:  true,
+		},
+		{
+			desc:    "exact/mismatch",
+			pattern: "//nolint:errcheck",
+			give:    "//nolint:unused",
+		},
+		{
+			desc:    "trailing space",
+			pattern: "// foo ",
+			give:    "// foo",
+			wantOK:  true,
+		},
+		{
+			desc:         "suffix",
+			pattern:      "// Deprecated: ...",
+			give:         "// Deprecated: Use Bar instead.",
+			wantOK:       true,
+			wantCaptures: []string{"Use Bar instead."},
+		},
+		{
+			desc:         "multiple",
+			pattern:      "// TODO(...): ...",
+			give:         "// TODO(alice): make this faster",
+			wantOK:       true,
+			wantCaptures: []string{"alice", "make this faster"},
+		},
+		{
+			desc:         "empty capture",
+			pattern:      "// TODO...",
+			give:         "// TODO",
+			wantOK:       true,
+			wantCaptures: []string{""},
+		},
+		{
+			desc:    "prefix mismatch",
+			pattern: "// TODO(...): ...",
+			give:    "// FIXME(alice): make this faster",
+		},
+		{
+			desc:    "middle mismatch",
+			pattern: "// TODO(...): ...",
+			give:    "// TODO(alice) make this faster",
+		},
+		{
+			desc:    "suffix mismatch",
+			pattern: "// ... instead.",
+			give:    "// Use Bar.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			line := newCommentLine(token.NewFileSet(), &ast.Comment{Text: tt.pattern})
+			captures, ok := line.match(tt.give)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantCaptures, captures)
+		})
+	}
+}
+
+func TestCommentGroupMatcher(t *testing.T) {
+	tests := []struct {
+		desc    string
+		pattern []string
+		give    []string // nil for no comment
+		wantOK  bool
+	}{
+		{
+			desc:    "no comment",
+			pattern: []string{"// foo"},
+		},
+		{
+			desc:    "dots/no comment",
+			pattern: []string{"// ..."},
+			wantOK:  true,
+		},
+		{
+			desc:    "single",
+			pattern: []string{"// foo"},
+			give:    []string{"// foo"},
+			wantOK:  true,
+		},
+		{
+			desc:    "too many lines",
+			pattern: []string{"// foo"},
+			give:    []string{"// foo", "// bar"},
+		},
+		{
+			desc:    "too few lines",
+			pattern: []string{"// foo", "// bar"},
+			give:    []string{"// foo"},
+		},
+		{
+			desc:    "dots/leading",
+			pattern: []string{"// ...", "//nolint:errcheck"},
+			give:    []string{"// Close closes it.", "//", "//nolint:errcheck"},
+			wantOK:  true,
+		},
+		{
+			desc:    "dots/trailing",
+			pattern: []string{"// Close ...", "// ..."},
+			give:    []string{"// Close closes it.", "//", "// More details."},
+			wantOK:  true,
+		},
+		{
+			desc:    "dots/surrounding",
+			pattern: []string{"// ...", "//nolint:errcheck", "// ..."},
+			give:    []string{"// Close closes it.", "//nolint:errcheck", "// More details."},
+			wantOK:  true,
+		},
+		{
+			desc:    "dots/mismatch",
+			pattern: []string{"// ...", "//nolint:errcheck"},
+			give:    []string{"// Close closes it.", "//nolint:errcheck", "// More details."},
+		},
+		{
+			desc:    "block comment",
+			pattern: []string{"/* ... */"},
+			give:    []string{"/* foo */", "// bar"},
+			wantOK:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			file := fset.AddFile("test.patch", -1, 100*len(tt.pattern))
+			file.SetLinesForContent(make([]byte, 100*len(tt.pattern)))
+
+			lines := make([]commentLine, len(tt.pattern))
+			for i, p := range tt.pattern {
+				lines[i] = newCommentLine(fset, &ast.Comment{
+					Slash: file.Pos(i * 100),
+					Text:  p,
+				})
+			}
+			m := CommentGroupMatcher{Lines: lines}
+
+			var give *ast.CommentGroup
+			if tt.give != nil {
+				give = new(ast.CommentGroup)
+				for _, c := range tt.give {
+					give.List = append(give.List, &ast.Comment{Text: c})
+				}
+			}
+
+			d, ok := m.Match(reflect.ValueOf(give), data.New(), Region{})
+			require.Equal(t, tt.wantOK, ok)
+			if ok && give != nil {
+				assert.Equal(t, []*ast.CommentGroup{give}, matchedCommentGroups(d))
+			}
+		})
+	}
+}
+
+func TestCommentReplaceErrors(t *testing.T) {
+	fset := token.NewFileSet()
+	prog, err := parse.Parse(fset, "test.patch", text.Unlines(
+		"@@",
+		"@@",
+		"-// TODO: ...",
+		"+// TODO(...): ...",
+		" func Run() {",
+		"   ...",
+		" }",
+	))
+	require.NoError(t, err)
+
+	p, err := Compile(fset, prog)
+	require.NoError(t, err)
+
+	f, err := parser.ParseFile(fset, "foo.go", text.Unlines(
+		"package foo",
+		"",
+		"// TODO: make this faster",
+		"func Run() {",
+		"}",
+	), parser.ParseComments)
+	require.NoError(t, err)
+
+	change := p.Changes[0]
+	d, ok := change.Match(f, NewBindings())
+	require.True(t, ok)
+
+	_, err = change.Replace(d, NewChangelog())
+	require.Error(t, err)
+	assert.Equal(t, `test.patch:4:2: comment has 2 "..." but the comment it replaces matched 1`, err.Error())
+}
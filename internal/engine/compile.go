@@ -21,7 +21,6 @@
 package engine
 
 import (
-	"errors"
 	"fmt"
 	"go/token"
 
@@ -32,21 +31,56 @@ import (
 // Program is a collection of compiled changes.
 type Program struct {
 	Changes []*Change
+
+	// Names of the parameters declared by the changes.
+	Params []string
 }
 
 // Compile compiles a parsed gopatch Program.
-func Compile(fset *token.FileSet, p *parse.Program) (*Program, error) {
+func Compile(fset *token.FileSet, p *parse.Program, opts ...CompileOption) (*Program, error) {
 	c := newCompiler(fset)
-	return c.compileProgram(p), c.Err()
+	for _, opt := range opts {
+		opt.apply(c)
+	}
+	prog := c.compileProgram(p)
+	prog.Params = ParamNames(p)
+	return prog, c.Err()
 }
 
 type compiler struct {
 	fset   *token.FileSet
 	errors []error
+
+	// Named changes compiled so far.
+	changes map[string]*Change
+
+	// Modules containing the files being patched, shared by all changes.
+	modules *moduleCache
+
+	// Names of the packages imported by patches.
+	packages *packageNames
+
+	// Values of parameters declared by changes.
+	params map[string]string
+
+	// Likely mistakes found in the changes compiled so far, reported by
+	// Vet.
+	diagnostics []Diagnostic
+
+	// Positions of named changes that don't modify code and that no other
+	// change refers to so far.
+	noops map[string]token.Pos
 }
 
 func newCompiler(fset *token.FileSet) *compiler {
-	return &compiler{fset: fset}
+	modules := newModuleCache()
+	return &compiler{
+		fset:     fset,
+		changes:  make(map[string]*Change),
+		modules:  modules,
+		packages: newPackageNames(modules),
+		noops:    make(map[string]token.Pos),
+	}
 }
 
 // Convenience function to build error messages with positioning data.
@@ -54,10 +88,24 @@ func (c *compiler) errf(pos token.Pos, msg string, args ...any) {
 	if len(args) > 0 {
 		msg = fmt.Sprintf(msg, args...)
 	}
-	if pos.IsValid() {
-		msg = fmt.Sprintf("%v: %v", c.fset.Position(pos), msg)
+	c.errors = append(c.errors, &compileError{fset: c.fset, Pos: pos, Msg: msg})
+}
+
+// compileError is an error encountered during compilation.
+type compileError struct {
+	fset *token.FileSet
+
+	// Position in the patch at which the error occurred, if known.
+	Pos token.Pos
+
+	Msg string
+}
+
+func (e *compileError) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Msg
 	}
-	c.errors = append(c.errors, errors.New(msg))
+	return fmt.Sprintf("%v: %v", e.fset.Position(e.Pos), e.Msg)
 }
 
 // Err collates all the errors encountered during compilation and returns
@@ -0,0 +1,298 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/uber-go/gopatch/internal/data"
+)
+
+// ComputedIdentReplacer is compiled from a computed identifier metavariable
+// occurring in the plus section of the patch.
+//
+//	@@
+//	var name identifier
+//	var short identifier = trimSuffix(trimPrefix(name, "New"), "Client")
+//	@@
+//	-name()
+//	+short()
+//
+// The value of the identifier is computed from the values captured for other
+// metavariables. Code that would produce an invalid identifier, like an empty
+// name for "Get()" above, isn't matched.
+type ComputedIdentReplacer struct {
+	// Name of the metavariable.
+	Name string
+
+	// Expression that computes its value.
+	Expr computedExpr
+}
+
+// Replace computes and reproduces the value of the identifier.
+func (r ComputedIdentReplacer) Replace(d data.Data, cl Changelog, pos token.Pos) (reflect.Value, error) {
+	if r.Expr == nil {
+		return reflect.Value{}, fmt.Errorf("could not compute value for metavariable %q", r.Name)
+	}
+
+	name, err := r.Expr.Eval(d, cl, pos)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("could not compute value for metavariable %q: %v", r.Name, err)
+	}
+	if !isComputableIdent(name) {
+		// Matches that compute invalid identifiers are rejected by
+		// validComputed so this is a bug.
+		return reflect.Value{}, fmt.Errorf("could not compute value for metavariable %q: "+
+			"%q is not a valid identifier", r.Name, name)
+	}
+
+	return reflect.ValueOf(&ast.Ident{Name: name, NamePos: pos}), nil
+}
+
+// validComputed reports whether the given computed identifiers have valid
+// values for the metavariables captured in d. Identifiers whose
+// metavariables haven't been captured yet are ignored.
+func validComputed(exprs map[string]computedExpr, d data.Data) bool {
+	for _, e := range exprs {
+		if e == nil {
+			continue
+		}
+		name, err := e.Eval(d, NewChangelog(), token.NoPos)
+		if err == nil && !isComputableIdent(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// isComputableIdent reports whether name may be the value of a computed
+// identifier.
+func isComputableIdent(name string) bool {
+	return name != "_" && token.IsIdentifier(name)
+}
+
+// computedExpr is a compiled expression in the value of a computed
+// metavariable.
+type computedExpr interface {
+	// Eval computes the value of the expression from the given match data.
+	Eval(d data.Data, cl Changelog, pos token.Pos) (string, error)
+
+	// Metavars appends the names of metavariables this expression refers
+	// to.
+	Metavars(names []string) []string
+}
+
+// computedFuncs are the built-in functions available to computed
+// metavariables, keyed by name.
+var computedFuncs = map[string]struct {
+	// Number of arguments expected by the function. Variadic functions
+	// accept at least this many arguments.
+	Arity    int
+	Variadic bool
+
+	Call func(args []string) string
+}{
+	"trimPrefix": {Arity: 2, Call: func(args []string) string {
+		return strings.TrimPrefix(args[0], args[1])
+	}},
+	"trimSuffix": {Arity: 2, Call: func(args []string) string {
+		return strings.TrimSuffix(args[0], args[1])
+	}},
+	"upper": {Arity: 1, Call: func(args []string) string {
+		return strings.ToUpper(args[0])
+	}},
+	"lower": {Arity: 1, Call: func(args []string) string {
+		return strings.ToLower(args[0])
+	}},
+	"upperFirst": {Arity: 1, Call: func(args []string) string {
+		return mapFirst(args[0], unicode.ToUpper)
+	}},
+	"lowerFirst": {Arity: 1, Call: func(args []string) string {
+		return mapFirst(args[0], unicode.ToLower)
+	}},
+	"concat": {Arity: 2, Variadic: true, Call: func(args []string) string {
+		return strings.Join(args, "")
+	}},
+}
+
+// regexReplace is handled separately from computedFuncs because its pattern
+// is compiled ahead of time.
+const regexReplaceFunc = "regexReplace"
+
+// mapFirst applies f to the first rune of s.
+func mapFirst(s string, f func(rune) rune) string {
+	r, n := utf8.DecodeRuneInString(s)
+	if n == 0 {
+		return s
+	}
+	return string(f(r)) + s[n:]
+}
+
+func (c *compiler) compileComputed(meta *Meta, e ast.Expr) computedExpr {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		s, err := strconv.Unquote(e.Value)
+		if err != nil {
+			c.errf(e.Pos(), "invalid string %v: %v", e.Value, err)
+			return nil
+		}
+		return computedString(s)
+
+	case *ast.Ident:
+		switch t := meta.LookupVar(e.Name); t {
+		case IdentMetavarType:
+			return computedMetavar(e.Name)
+		case ComputedIdentMetavarType:
+			// Computed identifiers are compiled in the order they're
+			// declared so this is nil if it's declared later.
+			if ce, ok := meta.Computed[e.Name]; ok {
+				return ce
+			}
+			c.errf(e.Pos(), "computed identifier %q must be declared before it is used", e.Name)
+		case 0:
+			c.errf(e.Pos(), "unknown metavariable %q", e.Name)
+		default:
+			c.errf(e.Pos(), "cannot compute identifier from %v %q: "+
+				"only identifier metavariables may be used", t, e.Name)
+		}
+		return nil
+
+	case *ast.CallExpr:
+		name := e.Fun.(*ast.Ident).Name
+		args := make([]computedExpr, len(e.Args))
+		for i, arg := range e.Args {
+			if args[i] = c.compileComputed(meta, arg); args[i] == nil {
+				return nil
+			}
+		}
+
+		if name == regexReplaceFunc {
+			return c.compileRegexReplace(e, args)
+		}
+
+		fn, ok := computedFuncs[name]
+		switch {
+		case !ok:
+			c.errf(e.Pos(), "unknown function %q", name)
+			return nil
+		case fn.Variadic && len(args) < fn.Arity:
+			c.errf(e.Pos(), "%v expects at least %d arguments, got %d", name, fn.Arity, len(args))
+			return nil
+		case !fn.Variadic && len(args) != fn.Arity:
+			c.errf(e.Pos(), "%v expects %d arguments, got %d", name, fn.Arity, len(args))
+			return nil
+		}
+		return computedCall{Func: fn.Call, Args: args}
+
+	default:
+		// This is a bug in the parser.
+		panic(fmt.Sprintf("unexpected %T in computed metavariable", e))
+	}
+}
+
+// regexReplace(s, pattern, replacement) replaces matches of the regular
+// expression in s. The pattern must be a string literal.
+func (c *compiler) compileRegexReplace(e *ast.CallExpr, args []computedExpr) computedExpr {
+	if len(args) != 3 {
+		c.errf(e.Pos(), "%v expects 3 arguments, got %d", regexReplaceFunc, len(args))
+		return nil
+	}
+
+	pattern, ok := args[1].(computedString)
+	if !ok {
+		c.errf(e.Args[1].Pos(), "%v expects a string literal for the pattern", regexReplaceFunc)
+		return nil
+	}
+
+	re, err := regexp.Compile(string(pattern))
+	if err != nil {
+		c.errf(e.Args[1].Pos(), "invalid pattern: %v", err)
+		return nil
+	}
+
+	return computedCall{
+		Func: func(args []string) string {
+			return re.ReplaceAllString(args[0], args[2])
+		},
+		Args: args,
+	}
+}
+
+// computedString is a string literal.
+type computedString string
+
+func (s computedString) Eval(data.Data, Changelog, token.Pos) (string, error) {
+	return string(s), nil
+}
+
+func (computedString) Metavars(names []string) []string { return names }
+
+// computedMetavar is a reference to an identifier metavariable.
+type computedMetavar string
+
+func (m computedMetavar) Eval(d data.Data, cl Changelog, pos token.Pos) (string, error) {
+	v, err := MetavarReplacer{Name: string(m)}.Replace(d, cl, pos)
+	if err != nil {
+		return "", err
+	}
+
+	ident, ok := v.Interface().(*ast.Ident)
+	if !ok {
+		return "", fmt.Errorf("metavariable %q is not an identifier", string(m))
+	}
+	return ident.Name, nil
+}
+
+func (m computedMetavar) Metavars(names []string) []string {
+	return append(names, string(m))
+}
+
+// computedCall is a call to a built-in function.
+type computedCall struct {
+	Func func([]string) string
+	Args []computedExpr
+}
+
+func (c computedCall) Eval(d data.Data, cl Changelog, pos token.Pos) (string, error) {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		var err error
+		if args[i], err = arg.Eval(d, cl, pos); err != nil {
+			return "", err
+		}
+	}
+	return c.Func(args), nil
+}
+
+func (c computedCall) Metavars(names []string) []string {
+	for _, arg := range c.Args {
+		names = arg.Metavars(names)
+	}
+	return names
+}
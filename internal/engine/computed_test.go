@@ -0,0 +1,312 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestComputedIdentReplacer(t *testing.T) {
+	tests := []struct {
+		desc string
+		give string // value of the computed metavariable
+		name string // value captured for the "name" metavariable
+		want string
+
+		// Non-empty if replacement should fail.
+		wantErr string
+	}{
+		{
+			desc: "metavariable",
+			give: "name",
+			name: "Foo",
+			want: "Foo",
+		},
+		{
+			desc: "trimPrefix",
+			give: `trimPrefix(name, "Get")`,
+			name: "GetValue",
+			want: "Value",
+		},
+		{
+			desc: "trimPrefix/no match",
+			give: `trimPrefix(name, "Get")`,
+			name: "Value",
+			want: "Value",
+		},
+		{
+			desc: "trimSuffix",
+			give: `trimSuffix(name, "Client")`,
+			name: "NewFooClient",
+			want: "NewFoo",
+		},
+		{
+			desc: "regexReplace",
+			give: `regexReplace(name, "^(Get|Set)(.+)$", "${2}Value")`,
+			name: "GetFoo",
+			want: "FooValue",
+		},
+		{
+			desc: "upper",
+			give: "upper(name)",
+			name: "foo",
+			want: "FOO",
+		},
+		{
+			desc: "lower",
+			give: "lower(name)",
+			name: "FOO",
+			want: "foo",
+		},
+		{
+			desc: "upperFirst",
+			give: "upperFirst(name)",
+			name: "fooBar",
+			want: "FooBar",
+		},
+		{
+			desc: "lowerFirst",
+			give: "lowerFirst(name)",
+			name: "FooBar",
+			want: "fooBar",
+		},
+		{
+			desc: "concat",
+			give: `concat("Must", upperFirst(name), "V2")`,
+			name: "parse",
+			want: "MustParseV2",
+		},
+		{
+			desc:    "empty result",
+			give:    `trimPrefix(name, "Get")`,
+			name:    "Get",
+			wantErr: `could not compute value for metavariable "x": "" is not a valid identifier`,
+		},
+		{
+			desc:    "invalid result",
+			give:    `concat(name, "-v2")`,
+			name:    "foo",
+			wantErr: `could not compute value for metavariable "x": "foo-v2" is not a valid identifier`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", text.Unlines(
+				"@@",
+				"var name identifier",
+				"var x identifier = "+tt.give,
+				"@@",
+				"-name",
+				"+x",
+			))
+			require.NoError(t, err)
+
+			c := newCompiler(fset)
+			meta := c.compileMeta(prog.Changes[0].Meta)
+			require.NoError(t, c.Err())
+
+			d := data.WithValue(data.New(), metavarKey("name"), metavarData{
+				Replacer: newReplacerCompiler(fset, nil, 0, 0).
+					compile(reflect.ValueOf(ast.NewIdent(tt.name))),
+			})
+			r := newReplacerCompiler(fset, meta, 0, 0).
+				compile(reflect.ValueOf(ast.NewIdent("x")))
+
+			got, err := r.Replace(d, NewChangelog(), 0)
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Interface().(*ast.Ident).Name)
+		})
+	}
+}
+
+func TestCompileComputedMetavarErrors(t *testing.T) {
+	tests := []struct {
+		desc    string
+		give    []byte
+		wantErr string
+	}{
+		{
+			desc: "minus",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				`var short identifier = trimPrefix(name, "Get")`,
+				"@@",
+				"-foo(name, short)",
+				"+bar(short)",
+			),
+			wantErr: `test.patch:5:12: computed identifier "short" cannot be matched: ` +
+				`it may be used only in the "+" section`,
+		},
+		{
+			desc: "unknown function",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				"var short identifier = shorten(name)",
+				"@@",
+				"-name",
+				"+short",
+			),
+			wantErr: `test.patch:3:24: unknown function "shorten"`,
+		},
+		{
+			desc: "wrong number of arguments",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				"var short identifier = trimPrefix(name)",
+				"@@",
+				"-name",
+				"+short",
+			),
+			wantErr: `test.patch:3:24: trimPrefix expects 2 arguments, got 1`,
+		},
+		{
+			desc: "concat with one argument",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				"var short identifier = concat(name)",
+				"@@",
+				"-name",
+				"+short",
+			),
+			wantErr: `test.patch:3:24: concat expects at least 2 arguments, got 1`,
+		},
+		{
+			desc: "regexReplace with computed pattern",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				`var short identifier = regexReplace(name, name, "")`,
+				"@@",
+				"-name",
+				"+short",
+			),
+			wantErr: `test.patch:3:43: regexReplace expects a string literal for the pattern`,
+		},
+		{
+			desc: "invalid pattern",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				`var short identifier = regexReplace(name, "(", "")`,
+				"@@",
+				"-name",
+				"+short",
+			),
+			wantErr: "test.patch:3:43: invalid pattern: error parsing regexp: " +
+				"missing closing ): `(`",
+		},
+		{
+			desc: "unknown metavariable",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				"var short identifier = upper(nmae)",
+				"@@",
+				"-name",
+				"+short",
+			),
+			wantErr: `test.patch:3:30: unknown metavariable "nmae"`,
+		},
+		{
+			desc: "expression metavariable",
+			give: text.Unlines(
+				"@@",
+				"var x expression",
+				"var short identifier = upper(x)",
+				"@@",
+				"-foo(x)",
+				"+short()",
+			),
+			wantErr: `test.patch:3:30: cannot compute identifier from expression "x": ` +
+				`only identifier metavariables may be used`,
+		},
+		{
+			desc: "used before declaration",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				"var a identifier = upper(b)",
+				"var b identifier = lower(name)",
+				"@@",
+				"-name",
+				"+a",
+			),
+			wantErr: `test.patch:3:26: computed identifier "b" must be declared before it is used`,
+		},
+		{
+			desc: "expression",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				"var x expression = upper(name)",
+				"@@",
+				"-name",
+				"+x",
+			),
+			wantErr: `test.patch:3:20: only identifier metavariables may be computed`,
+		},
+		{
+			desc: "multiple names",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				"var a, b identifier = upper(name)",
+				"@@",
+				"-name",
+				"+a",
+			),
+			wantErr: `test.patch:3:23: computed metavariables must be declared one at a time`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", tt.give)
+			require.NoError(t, err)
+
+			_, err = Compile(fset, prog)
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
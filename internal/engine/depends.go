@@ -0,0 +1,100 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+
+	"github.com/uber-go/gopatch/internal/parse"
+)
+
+// dependency is a condition on the changes that matched a file before the
+// change that declares it. The change runs only if its condition holds.
+type dependency func(*Bindings) bool
+
+// compileDepends compiles the "depends on" clauses of a change into a
+// single dependency that holds only if all of them hold.
+//
+// Returns nil if the change has no such clauses.
+func (c *compiler) compileDepends(clauses []*parse.DependsClause) dependency {
+	var deps []dependency
+	for _, clause := range clauses {
+		if dep := c.compileDependency(clause.Cond); dep != nil {
+			deps = append(deps, dep)
+		}
+	}
+
+	switch len(deps) {
+	case 0:
+		return nil
+	case 1:
+		return deps[0]
+	default:
+		return func(b *Bindings) bool {
+			for _, dep := range deps {
+				if !dep(b) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+}
+
+func (c *compiler) compileDependency(e ast.Expr) dependency {
+	switch e := e.(type) {
+	case *ast.Ident:
+		name := e.Name
+		if _, ok := c.changes[name]; !ok {
+			c.errf(e.Pos(), "unknown change %q: "+
+				"changes must be declared before they are referenced", name)
+			return nil
+		}
+		return func(b *Bindings) bool {
+			return len(b.Lookup(name)) > 0
+		}
+
+	case *ast.ParenExpr:
+		return c.compileDependency(e.X)
+
+	case *ast.UnaryExpr:
+		x := c.compileDependency(e.X)
+		if x == nil {
+			return nil
+		}
+		return func(b *Bindings) bool { return !x(b) }
+
+	case *ast.BinaryExpr:
+		x, y := c.compileDependency(e.X), c.compileDependency(e.Y)
+		if x == nil || y == nil {
+			return nil
+		}
+		if e.Op == token.LAND {
+			return func(b *Bindings) bool { return x(b) && y(b) }
+		}
+		return func(b *Bindings) bool { return x(b) || y(b) }
+
+	default:
+		panic(fmt.Sprintf("unknown dependency %T", e))
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestDepends(t *testing.T) {
+	patch := text.Unlines(
+		"@ a @",
+		"@@",
+		"-a()",
+		"+a(1)",
+		"",
+		"@ b @",
+		"@@",
+		"-b()",
+		"+b(1)",
+		"",
+		"@ both depends on a && b @",
+		"@@",
+		"-x()",
+		"+x(1)",
+		"",
+		"@ either depends on a || b @",
+		"@@",
+		"-x()",
+		"+x(1)",
+		"",
+		"@ neither depends on !(a || b) @",
+		"@@",
+		"-x()",
+		"+x(1)",
+		"",
+		"@ onlya depends on a depends on !b @",
+		"@@",
+		"-x()",
+		"+x(1)",
+	)
+
+	tests := []struct {
+		desc string
+		give string // body of the function in the file
+		want []string
+	}{
+		{desc: "none", give: "x()", want: []string{"neither"}},
+		{desc: "a", give: "a(); x()", want: []string{"a", "either", "onlya"}},
+		{desc: "b", give: "b(); x()", want: []string{"b", "either"}},
+		{desc: "both", give: "a(); b(); x()", want: []string{"a", "b", "both", "either"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", patch)
+			require.NoError(t, err)
+
+			p, err := Compile(fset, prog)
+			require.NoError(t, err)
+
+			file, err := parser.ParseFile(fset, "foo.go", "package x; func y() {"+tt.give+"}", 0)
+			require.NoError(t, err)
+
+			var got []string
+			bindings := NewBindings()
+			for _, c := range p.Changes {
+				if _, ok := c.Match(file, bindings); ok {
+					got = append(got, c.Name)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDependsUnknownChange(t *testing.T) {
+	fset := token.NewFileSet()
+	prog, err := parse.Parse(fset, "test.patch", text.Unlines(
+		"@ foo depends on bar @",
+		"@@",
+		"-x()",
+		"+x(1)",
+		"",
+		"@ bar @",
+		"@@",
+		"-y()",
+		"+y(1)",
+	))
+	require.NoError(t, err)
+
+	_, err = Compile(fset, prog)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(),
+		`test.patch:1:18: unknown change "bar": changes must be declared before they are referenced`)
+}
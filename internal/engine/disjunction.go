@@ -0,0 +1,192 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/goast"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/pgo"
+)
+
+// AltMatcher matches if any of its alternatives match, trying them in order.
+// It's used to implement disjunctions.
+type AltMatcher struct {
+	Alternatives []Matcher // inv: len > 1
+}
+
+// Match matches the value against each alternative until one of them
+// matches.
+func (m AltMatcher) Match(v reflect.Value, d data.Data, r Region) (data.Data, bool) {
+	for _, alt := range m.Alternatives {
+		// Data is immutable so each alternative starts with the same
+		// data regardless of how far the previous one got.
+		if altd, ok := alt.Match(v, d, r); ok {
+			return altd, true
+		}
+	}
+	return d, false
+}
+
+// compileMinus compiles the "-" section of a patch and its alternatives, if
+// any, into a single FileMatcher.
+func (c *compiler) compileMinus(mc *matcherCompiler, patch *parse.Patch) FileMatcher {
+	matcher := mc.compileFile(patch.Minus)
+	if len(patch.Alternatives) == 0 {
+		return matcher
+	}
+
+	want := reflect.TypeOf(patch.Minus.Node)
+
+	// Metavariables used in the "+" section must be matched by every
+	// version of the "-" section.
+	plusVars := metavarsUsed(patch.Plus.Node, mc.meta)
+
+	var alts []Matcher
+	for i, minus := range append([]*pgo.File{patch.Minus}, patch.Alternatives...) {
+		if got := reflect.TypeOf(minus.Node); got != want {
+			c.errf(minus.Node.Pos(), "all branches of a disjunction must be of the same kind: "+
+				"expected %v, got %v", pgoNodeKind(want), pgoNodeKind(got))
+			continue
+		}
+
+		used := metavarsUsed(minus.Node, mc.meta)
+		if missing := missingStrings(plusVars, used); len(missing) > 0 {
+			c.errf(minus.Node.Pos(), "alternatives of disjunctions and optional lines must match "+
+				"all metavariables used in the \"+\" section: missing %v", strings.Join(missing, ", "))
+			continue
+		}
+
+		if i == 0 {
+			alts = append(alts, matcher.NodeMatcher)
+		} else {
+			alts = append(alts, mc.compileFile(minus).NodeMatcher)
+		}
+	}
+
+	matcher.NodeMatcher = AltMatcher{Alternatives: alts}
+	return matcher
+}
+
+// missingStrings returns items in want that are not in got. Both lists must
+// be sorted.
+func missingStrings(want, got []string) []string {
+	var missing []string
+	for _, s := range want {
+		if _, found := slices.BinarySearch(got, s); !found {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// pgoNodeKind returns a human-readable name for the given kind of pgo.Node.
+func pgoNodeKind(t reflect.Type) string {
+	switch t {
+	case reflect.TypeOf((*pgo.Expr)(nil)):
+		return "expression"
+	case reflect.TypeOf((*pgo.StmtList)(nil)):
+		return "statements"
+	case reflect.TypeOf((*pgo.FuncDecl)(nil)):
+		return "function declaration"
+	case reflect.TypeOf((*pgo.GenDecl)(nil)):
+		return "declaration"
+	default:
+		return t.String()
+	}
+}
+
+// metavarsUsed returns a sorted list of the metavariables referenced in the
+// given node. Fresh identifiers are omitted because they're never matched,
+// and computed identifiers are replaced with the metavariables they're
+// computed from.
+func metavarsUsed(n pgo.Node, meta *Meta) []string {
+	seen := make(map[string]struct{})
+	for _, ident := range metavarRefs(n, meta) {
+		switch meta.LookupVar(ident.Name) {
+		case FreshIdentMetavarType:
+			// Never matched.
+		case ComputedIdentMetavarType:
+			if e, ok := meta.Computed[ident.Name]; ok {
+				for _, name := range e.Metavars(nil) {
+					seen[name] = struct{}{}
+				}
+			}
+		default:
+			seen[ident.Name] = struct{}{}
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// metavarRefs returns all references to metavariables in the given node,
+// including those inside struct tags.
+func metavarRefs(n pgo.Node, meta *Meta) []*ast.Ident {
+	var refs []*ast.Ident
+	var visit func(reflect.Value)
+	visit = func(v reflect.Value) {
+		if !v.IsValid() {
+			return
+		}
+
+		switch v.Type() {
+		case goast.IdentPtrType:
+			if ident := v.Interface().(*ast.Ident); ident != nil && meta.LookupVar(ident.Name) != 0 {
+				refs = append(refs, ident)
+			}
+			return
+		case goast.FieldPtrType:
+			if f := v.Interface().(*ast.Field); f != nil && f.Tag != nil {
+				refs = append(refs, tagMetavars(f.Tag, meta)...)
+			}
+		case goast.ObjectPtrType:
+			// Ident.Obj forms a cycle.
+			return
+		}
+
+		switch v.Kind() {
+		case reflect.Array, reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				visit(v.Index(i))
+			}
+		case reflect.Interface, reflect.Ptr:
+			visit(v.Elem())
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				visit(v.Field(i))
+			}
+		}
+	}
+	visit(reflect.ValueOf(n))
+	return refs
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestAltMatcher(t *testing.T) {
+	fset := token.NewFileSet()
+	meta := &Meta{Vars: map[string]MetavarType{"x": IdentMetavarType}}
+	mc := newMatcherCompiler(fset, meta, 0, 0)
+
+	// foo(x) | bar(x)
+	m := AltMatcher{
+		Alternatives: []Matcher{
+			mc.compile(refl(&ast.CallExpr{
+				Fun:  ast.NewIdent("foo"),
+				Args: []ast.Expr{ast.NewIdent("x")},
+			})),
+			mc.compile(refl(&ast.CallExpr{
+				Fun:  ast.NewIdent("bar"),
+				Args: []ast.Expr{ast.NewIdent("x")},
+			})),
+		},
+	}
+
+	tests := []struct {
+		desc   string
+		give   ast.Node
+		wantOK bool
+		wantX  string
+	}{
+		{
+			desc:   "first",
+			give:   &ast.CallExpr{Fun: ast.NewIdent("foo"), Args: []ast.Expr{ast.NewIdent("a")}},
+			wantOK: true,
+			wantX:  "a",
+		},
+		{
+			desc:   "second",
+			give:   &ast.CallExpr{Fun: ast.NewIdent("bar"), Args: []ast.Expr{ast.NewIdent("b")}},
+			wantOK: true,
+			wantX:  "b",
+		},
+		{
+			desc: "neither",
+			give: &ast.CallExpr{Fun: ast.NewIdent("baz"), Args: []ast.Expr{ast.NewIdent("c")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			d, ok := m.Match(refl(tt.give), data.New(), Region{})
+			require.Equal(t, tt.wantOK, ok)
+			if !ok {
+				return
+			}
+
+			var md metavarData
+			require.True(t, data.Lookup(d, metavarKey("x"), &md))
+			x, err := md.Replace(d, NewChangelog(), 0)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantX, x.Interface().(*ast.Ident).Name)
+		})
+	}
+}
+
+func TestCompileDisjunctionErrors(t *testing.T) {
+	tests := []struct {
+		desc    string
+		give    []byte
+		wantErr string
+	}{
+		{
+			desc: "different kinds",
+			give: text.Unlines(
+				"@@",
+				"var x expression",
+				"@@",
+				"(",
+				"-foo(x)",
+				"|",
+				"-func foo() { x }",
+				")",
+				"+bar(x)",
+			),
+			wantErr: "test.patch:7:2: all branches of a disjunction must be of the same kind: " +
+				"expected expression, got function declaration",
+		},
+		{
+			desc: "different metavariables",
+			give: text.Unlines(
+				"@@",
+				"var x, y expression",
+				"@@",
+				"(",
+				"-foo(x)",
+				"|",
+				"-foo(y)",
+				")",
+				"+bar(x)",
+			),
+			wantErr: `test.patch:7:2: alternatives of disjunctions and optional lines must match ` +
+				`all metavariables used in the "+" section: missing x`,
+		},
+		{
+			desc: "optional line with metavariable",
+			give: text.Unlines(
+				"@@",
+				"var x, y expression",
+				"@@",
+				"-foo(x)",
+				"?bar(y)",
+				"+baz(x, y)",
+			),
+			wantErr: `test.patch:4:2: alternatives of disjunctions and optional lines must match ` +
+				`all metavariables used in the "+" section: missing y`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", tt.give)
+			require.NoError(t, err)
+
+			_, err = Compile(fset, prog)
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/goast"
+)
+
+// Explanation describes why a change did or didn't match the code on a
+// line of a file.
+type Explanation struct {
+	// Whether the change matched code on the line.
+	Matched bool
+
+	// Positions in the file and in the patch at which matching failed.
+	// These are invalid if the change couldn't match the file at all, for
+	// example, because of its imports.
+	Pos, PatchPos token.Pos
+
+	// Why the change didn't match. Empty if it matched.
+	Reason string
+}
+
+// Explain reports why this change does or doesn't match code on the given
+// line of the file. Nodes of the file that span the line are matched
+// against the change, and the point at which matching got the farthest
+// into the patch before failing is reported.
+//
+// Bindings holds the results of changes that previously ran on the same
+// file. Unlike Match, Explain doesn't record its results into it.
+func (c *Change) Explain(f *ast.File, b *Bindings, line int) Explanation {
+	if c.depends != nil && !c.depends(b) {
+		return Explanation{Reason: "the changes it depends on did not match as required"}
+	}
+
+	seeds := []Binding{{Data: data.New()}}
+	if len(c.Within) > 0 || len(c.Meta.Inherited) > 0 {
+		if len(c.Within) > 0 && len(b.Lookup(c.Within)) == 0 {
+			return Explanation{Reason: fmt.Sprintf("change %q it runs within did not match", c.Within)}
+		}
+		seeds = c.seeds(b)
+		if len(seeds) == 0 {
+			return Explanation{Reason: "the changes it uses metavariables from did not match"}
+		}
+	}
+
+	explaining.Add(1)
+	defer explaining.Add(-1)
+
+	t := &tracer{line: line, fset: c.fset}
+	for _, seed := range seeds {
+		var within *Region
+		if seed.Region != (Region{}) {
+			within = &seed.Region
+		}
+
+		d := withTracer(withTypes(seed.Data, b.Types), t)
+		matched, reason := c.matcher.explain(f, d, within, line)
+		if matched {
+			return Explanation{Matched: true}
+		}
+		if len(reason) > 0 {
+			return Explanation{Reason: reason}
+		}
+	}
+
+	if t.best == nil {
+		if len(c.Within) > 0 {
+			return Explanation{Reason: fmt.Sprintf(
+				"no code on line %d is inside a match of change %q", line, c.Within)}
+		}
+		return Explanation{Reason: fmt.Sprintf("no code on line %d", line)}
+	}
+
+	return Explanation{
+		Pos:      t.best.Pos,
+		PatchPos: t.best.PatchPos,
+		Reason:   t.best.Reason,
+	}
+}
+
+// explain matches against the nodes of the file that span the given line,
+// recording why they didn't match into the tracer held in d. If within is
+// non-nil, only nodes inside that region are considered.
+//
+// A non-empty reason is returned if the file as a whole can't match.
+func (m FileMatcher) explain(file *ast.File, d data.Data, within *Region, line int) (matched bool, reason string) {
+	if !m.matchFilter(file) {
+		return false, "file is excluded by the clauses of the change"
+	}
+
+	if m.Package != "" && m.Package != file.Name.Name {
+		return false, fmt.Sprintf("expected package %q, found %q", m.Package, file.Name.Name)
+	}
+
+	for _, im := range m.Imports.Imports {
+		var ok bool
+		d, ok = im.Match(file, d)
+		if ok {
+			continue
+		}
+		if goast.FindImportSpec(file, im.Path) == nil {
+			return false, fmt.Sprintf("file does not import %q", im.Path)
+		}
+		return false, fmt.Sprintf("%q is not imported with the name used by the patch", im.Path)
+	}
+
+	tfile := m.Fset.File(file.Pos())
+	ast.Inspect(file, func(n ast.Node) bool {
+		if matched || n == nil || !n.Pos().IsValid() {
+			return false
+		}
+
+		// Skip nodes that don't span the line. Their descendants
+		// can't span it either.
+		if tfile.Line(n.Pos()) > line || tfile.Line(n.End()) < line {
+			return false
+		}
+
+		// Patches never match entire files.
+		if _, ok := n.(*ast.File); ok {
+			return true
+		}
+
+		if within != nil {
+			if n.End() <= within.Pos || n.Pos() >= within.End {
+				return false
+			}
+			if n.Pos() < within.Pos || n.End() > within.End {
+				return true
+			}
+		}
+
+		_, matched = m.NodeMatcher.Match(reflect.ValueOf(n), d, nodeRegion(n))
+		return !matched
+	})
+
+	return matched, ""
+}
+
+type explainKey struct{}
+
+// explaining is the number of changes being explained. Matchers look for a
+// tracer only while it's positive so that they don't pay for tracing while
+// matching as usual.
+var explaining atomic.Int32
+
+// withTracer attaches a tracer to the given Data so that matchers record
+// why they failed into it.
+func withTracer(d data.Data, t *tracer) data.Data {
+	return data.WithValue(d, explainKey{}, t)
+}
+
+// lookupTracer returns the tracer attached to d, if any. This is cheap when
+// no change is being explained.
+func lookupTracer(d data.Data) *tracer {
+	if explaining.Load() == 0 {
+		return nil
+	}
+
+	var t *tracer
+	data.Lookup(d, explainKey{}, &t)
+	return t
+}
+
+// tracer records the reasons matchers failed while explaining a change,
+// keeping the one that got the farthest.
+type tracer struct {
+	fset *token.FileSet
+	line int // line being explained
+
+	// Positions of the patch nodes being matched, innermost last.
+	stack []token.Pos
+
+	// Number of mismatches recorded so far.
+	recorded int
+
+	// Failures are not recorded while this is positive. Matching "when"
+	// constraints is expected to fail and isn't of interest.
+	muted int
+
+	best *mismatch
+}
+
+// mismatch is a failure to match recorded by a tracer.
+type mismatch struct {
+	Pos      token.Pos // position in the file
+	PatchPos token.Pos // position in the patch
+	Depth    int       // number of patch nodes being matched
+	OnLine   bool      // whether Pos is on the line being explained
+	Reason   string
+}
+
+// enter records that the patch node at the given position is being matched.
+// The returned function must be called when matching the node is done.
+func (t *tracer) enter(pos token.Pos) (exit func()) {
+	t.stack = append(t.stack, pos)
+	return func() { t.stack = t.stack[:len(t.stack)-1] }
+}
+
+// mute stops recording failures until the returned function is called.
+func (t *tracer) mute() (unmute func()) {
+	t.muted++
+	return func() { t.muted-- }
+}
+
+// record records a failure to match inside the given region of the file at
+// the given position in the patch. If the position is invalid, the position
+// of the innermost patch node being matched is used.
+//
+// The failure replaces the previously recorded one if it happened farther
+// into the patch, or at the same place but deeper inside it, or at the same
+// depth but on the line being explained.
+func (t *tracer) record(r Region, patchPos token.Pos, reason string) {
+	if t.muted > 0 {
+		return
+	}
+	t.recorded++
+
+	m := mismatch{
+		Pos:      r.Pos,
+		Depth:    len(t.stack),
+		OnLine:   r.Pos.IsValid() && t.fset.Position(r.Pos).Line == t.line,
+		Reason:   reason,
+		PatchPos: patchPos,
+	}
+	for i := len(t.stack) - 1; i >= 0 && !m.PatchPos.IsValid(); i-- {
+		if t.stack[i].IsValid() {
+			m.PatchPos = t.stack[i]
+		}
+	}
+
+	if b := t.best; b != nil {
+		switch {
+		case m.PatchPos != b.PatchPos:
+			if m.PatchPos < b.PatchPos {
+				return
+			}
+		case m.Depth != b.Depth:
+			if m.Depth < b.Depth {
+				return
+			}
+		case !m.OnLine || b.OnLine:
+			return
+		}
+	}
+	t.best = &m
+}
+
+// traceMismatch records a failure to match inside the given region of the
+// file if d holds a tracer. The reason is built only if it's recorded.
+func traceMismatch(d data.Data, r Region, reason func() string) {
+	traceMismatchAt(d, r, token.NoPos, reason)
+}
+
+// traceMismatchAt is like traceMismatch but for a failure at a specific
+// position in the patch.
+func traceMismatchAt(d data.Data, r Region, patchPos token.Pos, reason func() string) {
+	if t := lookupTracer(d); t != nil && t.muted == 0 {
+		t.record(r, patchPos, reason())
+	}
+}
+
+// describeValue returns a short description of a value from the AST for
+// use in explanations.
+func describeValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nothing"
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return "nothing"
+		}
+	}
+
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if _, ok := v.Interface().(ast.Node); ok {
+		return typeName(v.Type())
+	}
+	if v.Kind() == reflect.Struct {
+		return typeName(v.Type())
+	}
+	return fmt.Sprintf("%q", fmt.Sprint(v.Interface()))
+}
+
+// quoteValue quotes a value from the AST for use in explanations. Values
+// that are already quoted, like the values of string literals, are used
+// as-is.
+func quoteValue(v any) string {
+	s := fmt.Sprint(v)
+	if _, err := strconv.Unquote(s); err == nil {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// typeName returns the name of the given type from the AST. Struct types
+// whose pointers are AST nodes are named as pointers.
+func typeName(t reflect.Type) string {
+	if t.Kind() == reflect.Struct && reflect.PointerTo(t).Implements(goast.NodeType) {
+		return "*" + t.String()
+	}
+	return t.String()
+}
+
+// expectedNameOr returns the name of the type matched by the given matcher,
+// or the fallback if it isn't known.
+func expectedNameOr(m Matcher, fallback string) string {
+	switch m := m.(type) {
+	case GenericNodeMatcher:
+		return expectedNameOr(m.Matcher, fallback)
+	case PtrMatcher:
+		return expectedNameOr(m.Matcher, fallback)
+	case InterfaceMatcher:
+		return expectedNameOr(m.Matcher, fallback)
+	case StructMatcher:
+		return typeName(m.Type)
+	}
+	return fallback
+}
+
+// describeCode returns the given code from the AST on a single line,
+// shortening it if necessary.
+func describeCode(v reflect.Value) string {
+	s := Sprint(v)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i] + " ..."
+	}
+	return s
+}
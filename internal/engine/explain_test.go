@@ -0,0 +1,303 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestExplain(t *testing.T) {
+	tests := []struct {
+		desc  string
+		patch []byte
+		give  []byte // contents of foo.go
+		line  int
+
+		wantMatched  bool
+		wantReason   string
+		wantPos      string // position in foo.go, if any
+		wantPatchPos string // position in test.patch, if any
+	}{
+		{
+			desc: "matched",
+			patch: text.Unlines(
+				"@@",
+				"@@",
+				"-foo(1)",
+				"+bar(1)",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"func y() {",
+				"	foo(1)",
+				"}",
+			),
+			line:        4,
+			wantMatched: true,
+		},
+		{
+			desc: "different literal",
+			patch: text.Unlines(
+				"@@",
+				"@@",
+				"-foo(1)",
+				"+bar(1)",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"func y() {",
+				"	foo(2)",
+				"}",
+			),
+			line:         4,
+			wantReason:   `expected "1", found "2"`,
+			wantPos:      "foo.go:4:6",
+			wantPatchPos: "test.patch:3:6",
+		},
+		{
+			desc: "different node type",
+			patch: text.Unlines(
+				"@@",
+				"var x expression",
+				"@@",
+				"-foo(x.Bar())",
+				"+bar(x)",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"func y() {",
+				"	foo(z)",
+				"}",
+			),
+			line:         4,
+			wantReason:   "expected *ast.CallExpr, found *ast.Ident",
+			wantPos:      "foo.go:4:6",
+			wantPatchPos: "test.patch:4:6",
+		},
+		{
+			desc: "argument count",
+			patch: text.Unlines(
+				"@@",
+				"@@",
+				"-foo(1, 2)",
+				"+bar()",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"var y = foo(1)",
+			),
+			line:         3,
+			wantReason:   "expected 2 items, found 1",
+			wantPos:      "foo.go:3:9",
+			wantPatchPos: "test.patch:3:2",
+		},
+		{
+			desc: "metavariable conflict",
+			patch: text.Unlines(
+				"@@",
+				"var x expression",
+				"@@",
+				"-foo(x, x)",
+				"+bar(x)",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"var y = foo(a, b)",
+			),
+			line:         3,
+			wantReason:   `metavariable "x" already matched a, found b`,
+			wantPos:      "foo.go:3:16",
+			wantPatchPos: "test.patch:4:9",
+		},
+		{
+			desc: "metavariable kind",
+			patch: text.Unlines(
+				"@@",
+				"var x identifier",
+				"@@",
+				"-foo(x)",
+				"+bar(x)",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"var y = foo(a.b)",
+			),
+			line:         3,
+			wantReason:   `metavariable "x" cannot match *ast.SelectorExpr`,
+			wantPos:      "foo.go:3:13",
+			wantPatchPos: "test.patch:4:6",
+		},
+		{
+			desc: "statements",
+			patch: text.Unlines(
+				"@@",
+				"var x expression",
+				"@@",
+				"-x.Lock()",
+				"-defer x.Unlock()",
+				"+x.Do()",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"func y() {",
+				"	a.Lock()",
+				"	defer b.Unlock()",
+				"}",
+			),
+			line:         5,
+			wantReason:   `metavariable "x" already matched a, found b`,
+			wantPos:      "foo.go:5:8",
+			wantPatchPos: "test.patch:5:8",
+		},
+		{
+			desc: "missing import",
+			patch: text.Unlines(
+				"@@",
+				"@@",
+				`import "errors"`,
+				"",
+				"-errors.New(foo)",
+				"+errors.New(bar)",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"var y = errors.New(foo)",
+			),
+			line:       3,
+			wantReason: `file does not import "errors"`,
+		},
+		{
+			desc: "depends",
+			patch: text.Unlines(
+				"@ a @",
+				"@@",
+				"-a()",
+				"+b()",
+				"",
+				"@ depends on a @",
+				"@@",
+				"-foo()",
+				"+bar()",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"var y = foo()",
+			),
+			line:       3,
+			wantReason: "the changes it depends on did not match as required",
+		},
+		{
+			desc: "no code",
+			patch: text.Unlines(
+				"@@",
+				"@@",
+				"-foo()",
+				"+bar()",
+			),
+			give: text.Unlines(
+				"package x",
+				"",
+				"",
+				"var y = foo()",
+			),
+			line:       2,
+			wantReason: "no code on line 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", tt.patch)
+			require.NoError(t, err)
+
+			p, err := Compile(fset, prog)
+			require.NoError(t, err)
+
+			file, err := parser.ParseFile(fset, "foo.go", tt.give, 0)
+			require.NoError(t, err)
+
+			// Explain the last change, running the others first.
+			bindings := NewBindings()
+			for _, c := range p.Changes[:len(p.Changes)-1] {
+				c.Match(file, bindings)
+			}
+			got := p.Changes[len(p.Changes)-1].Explain(file, bindings, tt.line)
+
+			assert.Equal(t, tt.wantMatched, got.Matched, "matched")
+			assert.Equal(t, tt.wantReason, got.Reason, "reason")
+
+			var gotPos, gotPatchPos string
+			if got.Pos.IsValid() {
+				gotPos = fset.Position(got.Pos).String()
+			}
+			if got.PatchPos.IsValid() {
+				gotPatchPos = fset.Position(got.PatchPos).String()
+			}
+			assert.Equal(t, tt.wantPos, gotPos, "pos")
+			assert.Equal(t, tt.wantPatchPos, gotPatchPos, "patch pos")
+		})
+	}
+}
+
+func TestTraceSliceMismatch(t *testing.T) {
+	explaining.Add(1)
+	defer explaining.Add(-1)
+
+	tr := &tracer{fset: token.NewFileSet()}
+	d := withTracer(data.New(), tr)
+
+	m := SliceMatcher{Items: []Matcher{nilMatcher}}
+	_, ok := m.Match(reflect.ValueOf(42), d, Region{})
+	assert.False(t, ok)
+	require.NotNil(t, tr.best)
+	assert.Equal(t, `expected 1 items, found "42"`, tr.best.Reason)
+}
+
+func TestTraceWithoutExplain(t *testing.T) {
+	// Tracers are ignored unless a change is being explained.
+	tr := &tracer{fset: token.NewFileSet()}
+	d := withTracer(data.New(), tr)
+
+	traceMismatch(d, Region{}, func() string {
+		t.Fatal("reason must not be built")
+		return ""
+	})
+	assert.Nil(t, tr.best)
+}
@@ -34,6 +34,12 @@ import (
 
 // FileMatcher matches Go files.
 type FileMatcher struct {
+	Fset *token.FileSet
+
+	// Decides whether the change applies to the file based on its path and
+	// build constraints.
+	Filter FileFilter
+
 	// Matches the package name, if any.
 	Package string
 
@@ -42,9 +48,16 @@ type FileMatcher struct {
 
 	// Matches nodes in the file.
 	NodeMatcher Matcher
+
+	// Computed identifiers that must have valid values for a node to
+	// match.
+	Computed map[string]computedExpr
 }
 
 func (c *matcherCompiler) compileFile(file *pgo.File) FileMatcher {
+	c.imports = importNames(file.Imports, c.meta, c.packages, true /* withNamed */)
+	c.comments = file.Comments
+
 	var m Matcher
 	switch n := file.Node.(type) {
 	case *pgo.Expr:
@@ -60,15 +73,33 @@ func (c *matcherCompiler) compileFile(file *pgo.File) FileMatcher {
 	}
 
 	return FileMatcher{
+		Fset:        c.fset,
 		Package:     file.Package,
 		Imports:     c.compileImports(file.Imports),
 		NodeMatcher: m,
+		Computed:    c.meta.computedVars(),
 	}
 }
 
 // Match matches against the file, recording information about all matches
 // found in it.
 func (m FileMatcher) Match(file *ast.File, d data.Data) (data.Data, bool) {
+	return m.match(file, d, nil)
+}
+
+// MatchWithin matches against the portion of the file inside the given
+// region, recording information about all matches found in it.
+func (m FileMatcher) MatchWithin(file *ast.File, d data.Data, r Region) (data.Data, bool) {
+	return m.match(file, d, &r)
+}
+
+// match matches against the file. If within is non-nil, only nodes inside
+// that region are considered.
+func (m FileMatcher) match(file *ast.File, d data.Data, within *Region) (data.Data, bool) {
+	if !m.matchFilter(file) {
+		return d, false
+	}
+
 	// Match package name.
 	if m.Package != "" && m.Package != file.Name.Name {
 		// TODO(abg): Use an identMatcher with a constraint.
@@ -80,6 +111,10 @@ func (m FileMatcher) Match(file *ast.File, d data.Data) (data.Data, bool) {
 		return d, ok
 	}
 
+	// Comments around statements are found only if the patch matches
+	// them.
+	d = data.WithValue(d, fileCommentsKey, &fileComments{Fset: m.Fset, File: file})
+
 	// To match the body, we use astutil.Apply which traverses the AST and
 	// provides a replaceable pointer to each node so that we can rewrite
 	// the AST in-place.
@@ -94,8 +129,20 @@ func (m FileMatcher) Match(file *ast.File, d data.Data) (data.Data, bool) {
 			return false
 		}
 
+		if within != nil {
+			if n.End() <= within.Pos || n.Pos() >= within.End {
+				// Nothing under this node is inside the region.
+				return false
+			}
+			if n.Pos() < within.Pos || n.End() > within.End {
+				// This node isn't inside the region but
+				// its descendants may be.
+				return true
+			}
+		}
+
 		d, ok := m.NodeMatcher.Match(reflect.ValueOf(n), d, nodeRegion(n))
-		if !ok {
+		if !ok || !validComputed(m.Computed, d) {
 			return true
 		}
 
@@ -120,6 +167,19 @@ func (m FileMatcher) Match(file *ast.File, d data.Data) (data.Data, bool) {
 	}), true
 }
 
+// matchFilter reports whether the file is accepted by the filter.
+func (m FileMatcher) matchFilter(file *ast.File) bool {
+	if m.Filter.IsZero() {
+		return true
+	}
+
+	var filename string
+	if f := m.Fset.File(file.Pos()); f != nil {
+		filename = f.Name()
+	}
+	return m.Filter.Match(filename, file)
+}
+
 // FileReplacer replaces an ast.File.
 type FileReplacer struct {
 	Fset *token.FileSet
@@ -135,6 +195,11 @@ type FileReplacer struct {
 }
 
 func (c *replacerCompiler) compileFile(file *pgo.File) FileReplacer {
+	// Packages imported with explicit names are always referenced by
+	// those names.
+	c.imports = importNames(file.Imports, c.meta, c.packages, false /* withNamed */)
+	c.comments = file.Comments
+
 	var r Replacer
 	switch n := file.Node.(type) {
 	case *pgo.Expr:
@@ -174,6 +239,7 @@ func (r FileReplacer) Replace(d data.Data, cl Changelog) (*ast.File, error) {
 		return nil, err
 	}
 
+	names := newFreshNames(file)
 	for _, m := range fd.Matches {
 		v := reflect.Indirect(reflect.ValueOf(m.parent)).FieldByName(m.name)
 		if !v.IsValid() {
@@ -185,7 +251,8 @@ func (r FileReplacer) Replace(d data.Data, cl Changelog) (*ast.File, error) {
 			v = v.Index(m.index)
 		}
 
-		give, err := r.NodeReplacer.Replace(m.data, cl, m.region.Pos)
+		md := data.WithValue(m.data, freshScopeKey, names.Scope(m.region))
+		give, err := r.NodeReplacer.Replace(md, cl, m.region.Pos)
 		if err != nil {
 			return nil, err
 		}
@@ -195,7 +262,12 @@ func (r FileReplacer) Replace(d data.Data, cl Changelog) (*ast.File, error) {
 		// (SelectorExpr) where only an identifier is allowed (in a variable
 		// declaration name, for example).
 		if give.Type().AssignableTo(v.Type()) {
+			from, _ := v.Interface().(ast.Node)
 			v.Set(give)
+			to, _ := give.Interface().(ast.Node)
+			if err := replaceComments(r.Fset, file, cl, m.data, m.region.Pos, from, to); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -0,0 +1,337 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"go/build/constraint"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/uber-go/gopatch/internal/glob"
+	"github.com/uber-go/gopatch/internal/parse"
+)
+
+// FileKind specifies whether a change applies to test files.
+type FileKind int
+
+// Kinds of files a change may apply to.
+const (
+	AnyFileKind     FileKind = iota // test and non-test files
+	TestFileKind                    // only _test.go files
+	NonTestFileKind                 // only files that aren't _test.go files
+)
+
+// FileFilter decides which files a change applies to based on their paths
+// and build constraints. The zero value accepts all files.
+type FileFilter struct {
+	// Glob patterns for the paths of files. If non-empty, a file's path
+	// must match at least one of them.
+	//
+	// Patterns that don't start with "/" may match any trailing portion of
+	// the path, so "*_test.go" matches test files in all directories.
+	Globs []string
+
+	// Whether the change applies to test files, non-test files, or both.
+	Kind FileKind
+
+	// Build tags that the build constraints of a file must require. A
+	// file with "//go:build a || b" requires neither a nor b. GOOS and
+	// GOARCH suffixes in file names, like "_linux.go", are constraints
+	// too.
+	Tags []string
+
+	// Patterns for the import path of the package containing a file. If
+	// non-empty, the import path must match at least one of them. Import
+	// paths are determined from the nearest go.mod file.
+	importPaths []importPathPattern
+
+	// Finds the modules containing files. This may be shared between
+	// filters so that go.mod files are read only once.
+	modules *moduleCache
+}
+
+func (c *compiler) compileFileFilter(clauses []parse.Clause) FileFilter {
+	var f FileFilter
+	for _, clause := range clauses {
+		switch clause := clause.(type) {
+		case *parse.FilesClause:
+			for _, lit := range clause.Patterns {
+				pattern, err := strconv.Unquote(lit.Value)
+				if err == nil {
+					err = glob.Validate(pattern)
+				}
+				if err != nil {
+					c.errf(lit.Pos(), "invalid pattern %v: %v", lit.Value, err)
+					continue
+				}
+				f.Globs = append(f.Globs, pattern)
+			}
+
+		case *parse.ImportPathClause:
+			for _, lit := range clause.Patterns {
+				pattern, err := strconv.Unquote(lit.Value)
+				if err != nil || len(pattern) == 0 {
+					c.errf(lit.Pos(), "invalid import path pattern %v", lit.Value)
+					continue
+				}
+				compiled, err := compileImportPathPattern(pattern)
+				if err != nil {
+					c.errf(lit.Pos(), "invalid import path pattern %v: %v", lit.Value, err)
+					continue
+				}
+				f.importPaths = append(f.importPaths, compiled)
+			}
+			f.modules = c.modules
+
+		case *parse.TestsClause:
+			kind := TestFileKind
+			if clause.Exclude {
+				kind = NonTestFileKind
+			}
+			if f.Kind != AnyFileKind && f.Kind != kind {
+				c.errf(clause.Pos(), `cannot use both "tests" and "notests"`)
+				continue
+			}
+			f.Kind = kind
+
+		case *parse.TagClause:
+			tag, err := strconv.Unquote(clause.Tag.Value)
+			if err != nil || !isBuildTag(tag) {
+				c.errf(clause.Tag.Pos(), "invalid build tag %v", clause.Tag.Value)
+				continue
+			}
+			f.Tags = append(f.Tags, tag)
+		}
+	}
+	return f
+}
+
+// isBuildTag reports whether s is a valid build tag.
+func isBuildTag(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, r := range s {
+		if !isLetter(r) && !isDigit(r) && r != '_' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetter(r rune) bool { return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' }
+func isDigit(r rune) bool  { return '0' <= r && r <= '9' }
+
+// IsZero reports whether this filter accepts all files.
+func (f FileFilter) IsZero() bool {
+	return len(f.Globs) == 0 && f.Kind == AnyFileKind &&
+		len(f.importPaths) == 0 && len(f.Tags) == 0
+}
+
+// Match reports whether a change with this filter applies to the file at
+// the given path.
+func (f FileFilter) Match(filename string, file *ast.File) bool {
+	switch isTest := strings.HasSuffix(filename, "_test.go"); f.Kind {
+	case TestFileKind:
+		if !isTest {
+			return false
+		}
+	case NonTestFileKind:
+		if isTest {
+			return false
+		}
+	}
+
+	if len(f.Globs) > 0 && !f.matchGlobs(filepath.ToSlash(filename)) {
+		return false
+	}
+
+	if len(f.importPaths) > 0 && !f.matchImportPaths(filepath.Dir(filename)) {
+		return false
+	}
+
+	if len(f.Tags) > 0 {
+		tags := requiredBuildTags(filename, file)
+		for _, tag := range f.Tags {
+			if _, ok := tags[tag]; !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (f FileFilter) matchGlobs(path string) bool {
+	for _, pattern := range f.Globs {
+		if !strings.HasPrefix(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		if glob.Match(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f FileFilter) matchImportPaths(dir string) bool {
+	modules := f.modules
+	if modules == nil {
+		modules = newModuleCache()
+	}
+
+	importPath, mod, ok := modules.ImportPath(dir)
+	if !ok {
+		return false
+	}
+
+	for _, pattern := range f.importPaths {
+		if pattern.Match(importPath, mod) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredBuildTags returns the build tags that the build constraints of the
+// given file require: the file is never built without them. These include
+// the GOOS and GOARCH implied by the file name.
+func requiredBuildTags(filename string, file *ast.File) map[string]struct{} {
+	tags := make(map[string]struct{})
+	for _, tag := range fileNameTags(filepath.Base(filename)) {
+		tags[tag] = struct{}{}
+	}
+
+	// Build constraints must appear before the package clause.
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package {
+			break
+		}
+		for _, c := range group.List {
+			if !constraint.IsGoBuild(c.Text) && !constraint.IsPlusBuild(c.Text) {
+				continue
+			}
+			if x, err := constraint.Parse(c.Text); err == nil {
+				for tag := range constraintTags(x, false) {
+					tags[tag] = struct{}{}
+				}
+			}
+		}
+	}
+	return tags
+}
+
+// fileNameTags returns the GOOS and GOARCH that the given file name
+// constrains the file to, following the rules of go/build:
+//
+//	*_GOOS
+//	*_GOARCH
+//	*_GOOS_GOARCH
+//
+// An optional "_test" may follow these.
+func fileNameTags(name string) []string {
+	if dot := strings.Index(name, "."); dot >= 0 {
+		name = name[:dot]
+	}
+
+	// The part before the first "_" is never a constraint, so "linux.go"
+	// isn't constrained to linux.
+	i := strings.Index(name, "_")
+	if i < 0 {
+		return nil
+	}
+	l := strings.Split(name[i:], "_")
+	if n := len(l); n > 0 && l[n-1] == "test" {
+		l = l[:n-1]
+	}
+
+	n := len(l)
+	switch {
+	case n >= 2 && knownOS[l[n-2]] && knownArch[l[n-1]]:
+		return []string{l[n-2], l[n-1]}
+	case n >= 1 && (knownOS[l[n-1]] || knownArch[l[n-1]]):
+		return []string{l[n-1]}
+	}
+	return nil
+}
+
+// knownOS and knownArch are the values of GOOS and GOARCH recognized in file
+// names by go/build.
+var (
+	knownOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true,
+		"freebsd": true, "hurd": true, "illumos": true, "ios": true,
+		"js": true, "linux": true, "nacl": true, "netbsd": true,
+		"openbsd": true, "plan9": true, "solaris": true, "wasip1": true,
+		"windows": true, "zos": true,
+	}
+	knownArch = map[string]bool{
+		"386": true, "amd64": true, "amd64p32": true, "arm": true,
+		"armbe": true, "arm64": true, "arm64be": true, "loong64": true,
+		"mips": true, "mipsle": true, "mips64": true, "mips64le": true,
+		"mips64p32": true, "mips64p32le": true, "ppc": true, "ppc64": true,
+		"ppc64le": true, "riscv": true, "riscv64": true, "s390": true,
+		"s390x": true, "sparc": true, "sparc64": true, "wasm": true,
+	}
+)
+
+// constraintTags returns the tags that must be set for the given build
+// constraint to be satisfied, or not satisfied if negated is true.
+//
+// A tag is required by "x || y" only if it's required by both x and y.
+func constraintTags(x constraint.Expr, negated bool) map[string]struct{} {
+	switch x := x.(type) {
+	case *constraint.TagExpr:
+		if negated {
+			return nil
+		}
+		return map[string]struct{}{x.Tag: {}}
+	case *constraint.NotExpr:
+		return constraintTags(x.X, !negated)
+	case *constraint.AndExpr:
+		// !(x && y) is !x || !y.
+		return combineTags(constraintTags(x.X, negated), constraintTags(x.Y, negated), !negated)
+	case *constraint.OrExpr:
+		// !(x || y) is !x && !y.
+		return combineTags(constraintTags(x.X, negated), constraintTags(x.Y, negated), negated)
+	default:
+		return nil
+	}
+}
+
+// combineTags returns the union of the given sets of tags if all is true,
+// and their intersection otherwise.
+func combineTags(x, y map[string]struct{}, all bool) map[string]struct{} {
+	tags := make(map[string]struct{})
+	for tag := range x {
+		if _, ok := y[tag]; ok || all {
+			tags[tag] = struct{}{}
+		}
+	}
+	if all {
+		for tag := range y {
+			tags[tag] = struct{}{}
+		}
+	}
+	return tags
+}
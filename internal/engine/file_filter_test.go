@@ -0,0 +1,338 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestFileFilterMatch(t *testing.T) {
+	tests := []struct {
+		desc     string
+		give     FileFilter
+		filename string
+		src      string // defaults to "package x"
+		want     bool
+	}{
+		{
+			desc:     "zero",
+			filename: "foo_test.go",
+			want:     true,
+		},
+		{
+			desc:     "tests/test file",
+			give:     FileFilter{Kind: TestFileKind},
+			filename: "foo_test.go",
+			want:     true,
+		},
+		{
+			desc:     "tests/non-test file",
+			give:     FileFilter{Kind: TestFileKind},
+			filename: "foo.go",
+			want:     false,
+		},
+		{
+			desc:     "notests/test file",
+			give:     FileFilter{Kind: NonTestFileKind},
+			filename: "foo_test.go",
+			want:     false,
+		},
+		{
+			desc:     "notests/non-test file",
+			give:     FileFilter{Kind: NonTestFileKind},
+			filename: "foo.go",
+			want:     true,
+		},
+		{
+			desc:     "glob/base name",
+			give:     FileFilter{Globs: []string{"*_gen.go"}},
+			filename: "/src/proj/internal/foo_gen.go",
+			want:     true,
+		},
+		{
+			desc:     "glob/trailing directories",
+			give:     FileFilter{Globs: []string{"internal/**"}},
+			filename: "/src/proj/internal/foo/bar.go",
+			want:     true,
+		},
+		{
+			desc:     "glob/no match",
+			give:     FileFilter{Globs: []string{"internal/**"}},
+			filename: "/src/proj/cmd/foo.go",
+			want:     false,
+		},
+		{
+			desc:     "glob/any of several",
+			give:     FileFilter{Globs: []string{"internal/**", "cmd/**"}},
+			filename: "/src/proj/cmd/foo.go",
+			want:     true,
+		},
+		{
+			desc:     "glob/absolute",
+			give:     FileFilter{Globs: []string{"/proj/**"}},
+			filename: "/src/proj/foo.go",
+			want:     false,
+		},
+		{
+			desc:     "tag/go:build",
+			give:     FileFilter{Tags: []string{"integration"}},
+			filename: "foo.go",
+			src:      "//go:build integration && linux\n\npackage x",
+			want:     true,
+		},
+		{
+			desc:     "tag/+build",
+			give:     FileFilter{Tags: []string{"integration"}},
+			filename: "foo.go",
+			src:      "// +build integration\n\npackage x",
+			want:     true,
+		},
+		{
+			desc:     "tag/negated",
+			give:     FileFilter{Tags: []string{"integration"}},
+			filename: "foo.go",
+			src:      "//go:build !integration\n\npackage x",
+			want:     false,
+		},
+		{
+			desc:     "tag/double negation",
+			give:     FileFilter{Tags: []string{"integration"}},
+			filename: "foo.go",
+			src:      "//go:build !(!integration || linux)\n\npackage x",
+			want:     true,
+		},
+		{
+			desc:     "tag/negated and",
+			give:     FileFilter{Tags: []string{"integration"}},
+			filename: "foo.go",
+			src:      "//go:build !(linux && !integration)\n\npackage x",
+			want:     false,
+		},
+		{
+			desc:     "tag/or",
+			give:     FileFilter{Tags: []string{"integration"}},
+			filename: "foo.go",
+			src:      "//go:build integration || e2e\n\npackage x",
+			want:     false,
+		},
+		{
+			desc:     "tag/or required on both sides",
+			give:     FileFilter{Tags: []string{"integration"}},
+			filename: "foo.go",
+			src:      "//go:build (integration && linux) || (integration && e2e)\n\npackage x",
+			want:     true,
+		},
+		{
+			desc:     "tag/no constraint",
+			give:     FileFilter{Tags: []string{"integration"}},
+			filename: "foo.go",
+			want:     false,
+		},
+		{
+			desc:     "tag/after package clause",
+			give:     FileFilter{Tags: []string{"integration"}},
+			filename: "foo.go",
+			src:      "package x\n\n//go:build integration",
+			want:     false,
+		},
+		{
+			desc:     "tag/file name GOOS",
+			give:     FileFilter{Tags: []string{"linux"}},
+			filename: "/src/proj/foo_linux.go",
+			want:     true,
+		},
+		{
+			desc:     "tag/file name GOOS and GOARCH",
+			give:     FileFilter{Tags: []string{"linux", "amd64"}},
+			filename: "foo_linux_amd64_test.go",
+			want:     true,
+		},
+		{
+			desc:     "tag/file name GOARCH",
+			give:     FileFilter{Tags: []string{"arm64"}},
+			filename: "foo_arm64.go",
+			want:     true,
+		},
+		{
+			desc:     "tag/file name without prefix",
+			give:     FileFilter{Tags: []string{"linux"}},
+			filename: "linux.go",
+			want:     false,
+		},
+		{
+			desc:     "tag/file name GOOS not last",
+			give:     FileFilter{Tags: []string{"linux"}},
+			filename: "foo_linux_helpers.go",
+			want:     false,
+		},
+		{
+			desc:     "tag/file name and go:build",
+			give:     FileFilter{Tags: []string{"linux", "integration"}},
+			filename: "foo_linux.go",
+			src:      "//go:build integration\n\npackage x",
+			want:     true,
+		},
+		{
+			desc:     "tag/all required",
+			give:     FileFilter{Tags: []string{"integration", "linux"}},
+			filename: "foo.go",
+			src:      "//go:build integration\n\npackage x",
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			src := tt.src
+			if len(src) == 0 {
+				src = "package x"
+			}
+			file, err := parser.ParseFile(token.NewFileSet(), tt.filename, src, parser.ParseComments)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, tt.give.Match(tt.filename, file))
+		})
+	}
+}
+
+func TestFileFilterMatchImportPaths(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "internal", "foo"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/app\n"), 0o644))
+
+	file, err := parser.ParseFile(token.NewFileSet(), "foo.go", "package foo", 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc     string
+		patterns []string
+		filename string
+		want     bool
+	}{
+		{
+			desc:     "root package",
+			patterns: []string{"example.com/app"},
+			filename: "main.go",
+			want:     true,
+		},
+		{
+			desc:     "subtree",
+			patterns: []string{"example.com/app/internal/..."},
+			filename: "internal/foo/foo.go",
+			want:     true,
+		},
+		{
+			desc:     "outside subtree",
+			patterns: []string{"example.com/app/internal/..."},
+			filename: "main.go",
+			want:     false,
+		},
+		{
+			desc:     "relative to module",
+			patterns: []string{"./cmd/...", "./internal/..."},
+			filename: "internal/foo/foo.go",
+			want:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var filter FileFilter
+			for _, pattern := range tt.patterns {
+				compiled, err := compileImportPathPattern(pattern)
+				require.NoError(t, err)
+				filter.importPaths = append(filter.importPaths, compiled)
+			}
+			got := filter.Match(filepath.Join(root, tt.filename), file)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompileFileFilterErrors(t *testing.T) {
+	tests := []struct {
+		desc    string
+		give    []byte
+		wantErr string
+	}{
+		{
+			desc: "invalid pattern",
+			give: text.Unlines(
+				`@ files "[" @`,
+				"@@",
+				"-foo",
+				"+bar",
+			),
+			wantErr: `test.patch:1:9: invalid pattern "[": syntax error in pattern`,
+		},
+		{
+			desc: "tests and notests",
+			give: text.Unlines(
+				"@ tests notests @",
+				"@@",
+				"-foo",
+				"+bar",
+			),
+			wantErr: `test.patch:1:9: cannot use both "tests" and "notests"`,
+		},
+		{
+			desc: "invalid tag",
+			give: text.Unlines(
+				`@ tag "a b" @`,
+				"@@",
+				"-foo",
+				"+bar",
+			),
+			wantErr: `test.patch:1:7: invalid build tag "a b"`,
+		},
+		{
+			desc: "empty import path",
+			give: text.Unlines(
+				`@ importpath "" @`,
+				"@@",
+				"-foo",
+				"+bar",
+			),
+			wantErr: `test.patch:1:14: invalid import path pattern ""`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", tt.give)
+			require.NoError(t, err)
+
+			_, err = Compile(fset, prog)
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
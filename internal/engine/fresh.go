@@ -0,0 +1,200 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strconv"
+
+	"github.com/uber-go/gopatch/internal/data"
+)
+
+// FreshIdentReplacer is compiled from a fresh identifier metavariable
+// occurring in the plus section of the patch.
+//
+//	@@
+//	var x expression
+//	var tmp fresh identifier
+//	@@
+//	-if x {
+//	+tmp := x
+//	+if tmp {
+//	   ...
+//	 }
+//
+// Each match generates a new name for the metavariable, starting with its
+// seed, that isn't already used in the top-level declaration enclosing the
+// match. All occurrences of the metavariable within a match get the same
+// name.
+type FreshIdentReplacer struct {
+	// Name of the metavariable.
+	Name string
+
+	// Preferred name for the generated identifier.
+	Seed string
+}
+
+// Replace generates a name for the fresh identifier.
+func (r FreshIdentReplacer) Replace(d data.Data, cl Changelog, pos token.Pos) (reflect.Value, error) {
+	var scope *freshScope
+	if !data.Lookup(d, freshScopeKey, &scope) {
+		return reflect.Value{}, fmt.Errorf("could not generate a name for fresh identifier %q", r.Name)
+	}
+
+	return reflect.ValueOf(&ast.Ident{
+		Name:    scope.Name(r.Name, r.Seed),
+		NamePos: pos,
+	}), nil
+}
+
+type _freshScopeKey struct{}
+
+var freshScopeKey _freshScopeKey
+
+// freshNames tracks the names taken in a file for generating fresh
+// identifiers.
+type freshNames struct {
+	file *ast.File
+
+	// Names taken in each top-level declaration of the file. The nil key
+	// holds the names taken anywhere in the file.
+	used map[ast.Decl]map[string]struct{}
+}
+
+func newFreshNames(f *ast.File) *freshNames {
+	return &freshNames{
+		file: f,
+		used: make(map[ast.Decl]map[string]struct{}),
+	}
+}
+
+// Scope builds a freshScope for a match in the given region of the file.
+func (n *freshNames) Scope(r Region) *freshScope {
+	var decl ast.Decl
+	for _, d := range n.file.Decls {
+		if d.Pos() <= r.Pos && r.End <= d.End() {
+			decl = d
+			break
+		}
+	}
+
+	used, ok := n.used[decl]
+	if !ok {
+		used = make(map[string]struct{})
+		n.used[decl] = used
+
+		var root ast.Node = n.file
+		if decl != nil {
+			root = decl
+			for _, name := range topLevelNames(n.file) {
+				used[name] = struct{}{}
+			}
+		}
+		ast.Inspect(root, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				used[ident.Name] = struct{}{}
+			}
+			return true
+		})
+	}
+
+	return &freshScope{used: used}
+}
+
+// topLevelNames returns the names declared at the top-level of the given
+// file, including the names of imported packages.
+func topLevelNames(f *ast.File) []string {
+	var names []string
+	for _, imp := range f.Imports {
+		if imp.Name != nil {
+			names = append(names, imp.Name.Name)
+		} else if path, err := strconv.Unquote(imp.Path.Value); err == nil {
+			names = append(names, guessPackageName(path))
+		}
+	}
+
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				names = append(names, decl.Name.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						names = append(names, name.Name)
+					}
+				case *ast.TypeSpec:
+					names = append(names, spec.Name.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// freshScope generates names for fresh identifiers in a single match.
+type freshScope struct {
+	// Names that are already taken. This is shared with other matches in
+	// the same top-level declaration.
+	used map[string]struct{}
+
+	// Names generated for each metavariable in this match.
+	picked map[string]string
+}
+
+// Name returns the name generated for the given metavariable, generating a
+// new one based on the seed if necessary.
+func (s *freshScope) Name(metavar, seed string) string {
+	if name, ok := s.picked[metavar]; ok {
+		return name
+	}
+
+	name := seed
+	for i := 2; ; i++ {
+		if _, taken := s.used[name]; !taken {
+			break
+		}
+		name = seed + strconv.Itoa(i)
+	}
+
+	s.used[name] = struct{}{}
+	if s.picked == nil {
+		s.picked = make(map[string]string)
+	}
+	s.picked[metavar] = name
+	return name
+}
+
+// renewFreshScope returns a copy of the given Data that generates new names
+// for fresh identifiers, avoiding the names generated so far.
+func renewFreshScope(d data.Data) data.Data {
+	var scope *freshScope
+	if !data.Lookup(d, freshScopeKey, &scope) {
+		return d
+	}
+	return data.WithValue(d, freshScopeKey, &freshScope{used: scope.used})
+}
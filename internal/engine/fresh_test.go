@@ -0,0 +1,81 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestCompileFreshMetavarErrors(t *testing.T) {
+	tests := []struct {
+		desc    string
+		give    []byte
+		wantErr string
+	}{
+		{
+			desc: "minus",
+			give: text.Unlines(
+				"@@",
+				"var tmp fresh identifier",
+				"@@",
+				"-foo(tmp)",
+				"+bar(tmp)",
+			),
+			wantErr: `test.patch:4:6: fresh identifier "tmp" cannot be matched: ` +
+				`it may be used only in the "+" section`,
+		},
+		{
+			desc: "disjunction",
+			give: text.Unlines(
+				"@@",
+				"var x expression",
+				"var tmp fresh identifier",
+				"@@",
+				"(",
+				"-foo(x)",
+				"|",
+				"-bar(x, tmp)",
+				")",
+				"+baz(x)",
+			),
+			wantErr: `test.patch:8:9: fresh identifier "tmp" cannot be matched: ` +
+				`it may be used only in the "+" section`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", tt.give)
+			require.NoError(t, err)
+
+			_, err = Compile(fset, prog)
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
@@ -23,7 +23,6 @@ package engine
 import (
 	"go/ast"
 	"go/token"
-	"path/filepath"
 	"reflect"
 
 	"github.com/uber-go/gopatch/internal/data"
@@ -53,11 +52,6 @@ func (c *matcherCompiler) compileImport(imp *ast.ImportSpec) ImportMatcher {
 		nameIsMetavar = c.meta.LookupVar(nameS) == IdentMetavarType
 	}
 
-	// TODO: In the future, we should try to determine the package name
-	// automatically if the named import is not provided. This is
-	// expensive because we'd have to resolve the package name from the
-	// import path, so the code would have to actually exist on-disk.
-
 	return ImportMatcher{
 		NameS:         nameS,
 		Name:          name,
@@ -81,15 +75,25 @@ func (m ImportMatcher) Match(file *ast.File, d data.Data) (_ data.Data, ok bool)
 	// | Patch import | File import | Behavior              |
 	// +--------------+-------------+-----------------------+
 	// | unnamed      | unnamed     | match                 |
-	// | unnamed      | named       | no match              |
+	// | unnamed      | named       | match if type-checked |
 	// | named        | unnamed     | match if metavariable |
 	// | named        | named       | match name            |
 	// +--------------+-------------+-----------------------+
 
 	// Patch import is unnamed. Match only if the file import is also
-	// unnamed.
+	// unnamed, or if the file was type-checked so that references to the
+	// package can be resolved regardless of its name.
 	if m.Name == nil {
-		return d, spec.Name == nil
+		if spec.Name == nil {
+			return d, true
+		}
+
+		switch name := spec.Name.Name; {
+		case name == "_", name == ".", lookupTypes(d) == nil:
+			return d, false
+		default:
+			return data.WithValue(d, importKey(m.Path), importData{Name: name}), true
+		}
 	}
 
 	if spec.Name == nil {
@@ -187,6 +191,10 @@ type ImportReplacer struct {
 
 	Path string // import path as a string
 	Fset *token.FileSet
+
+	// Resolves the package name of the import if the patch didn't name
+	// it.
+	Packages *packageNames
 }
 
 func (c *replacerCompiler) compileImport(imp *ast.ImportSpec) ImportReplacer {
@@ -201,15 +209,13 @@ func (c *replacerCompiler) compileImport(imp *ast.ImportSpec) ImportReplacer {
 		nameIsMetavar = c.meta.LookupVar(nameS) == IdentMetavarType
 	}
 
-	// TODO: Same as matcher, maybe we should attempt to determine the
-	// package name.
-
 	return ImportReplacer{
 		Name:          name,
 		NameS:         nameS,
 		Path:          goast.ImportPath(imp),
 		Fset:          c.fset,
 		NameIsMetavar: nameIsMetavar,
+		Packages:      c.packages,
 	}
 }
 
@@ -245,10 +251,20 @@ func (r ImportReplacer) Replace(d data.Data, cl Changelog, f *ast.File) (string,
 			pkgName = name
 		}
 
+	} else if idata := new(importData); data.Lookup(d, importKey(r.Path), idata) && len(idata.Name) > 0 {
+		// The "-" section matched this import under a different name.
+		// Keep using that name.
+		name = idata.Name
+		pkgName = name
 	} else {
-		// TODO: more sophisticated package name guessing logic here
-		// and below.
-		pkgName = filepath.Base(r.Path)
+		pkgName = r.Packages.FileName(r.Fset, f, r.Path)
+
+		// Name the import explicitly if the package name doesn't
+		// follow from the import path. This matches what goimports
+		// does.
+		if pkgName != guessPackageName(r.Path) {
+			name = pkgName
+		}
 	}
 
 	if !astutil.AddNamedImport(r.Fset, f, name, r.Path) {
@@ -259,8 +275,9 @@ func (r ImportReplacer) Replace(d data.Data, cl Changelog, f *ast.File) (string,
 
 // ImportsReplacer replaces a block of imports.
 type ImportsReplacer struct {
-	Imports []ImportReplacer
-	Fset    *token.FileSet
+	Imports  []ImportReplacer
+	Fset     *token.FileSet
+	Packages *packageNames
 }
 
 func (c *replacerCompiler) compileImports(imps []*ast.ImportSpec) ImportsReplacer {
@@ -268,7 +285,7 @@ func (c *replacerCompiler) compileImports(imps []*ast.ImportSpec) ImportsReplace
 	for _, imp := range imps {
 		rs = append(rs, c.compileImport(imp))
 	}
-	return ImportsReplacer{Imports: rs, Fset: c.fset}
+	return ImportsReplacer{Imports: rs, Fset: c.fset, Packages: c.packages}
 }
 
 // Replace adds zero or more imports t a file.
@@ -321,7 +338,7 @@ func (r ImportsReplacer) Cleanup(d data.Data, f *ast.File, newNames []string) er
 		}
 
 		if len(pkgName) == 0 {
-			pkgName = filepath.Base(imp)
+			pkgName = r.Packages.FileName(r.Fset, f, imp)
 		}
 
 		// If this import was replaced by an added import, kill it.
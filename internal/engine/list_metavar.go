@@ -0,0 +1,198 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/goast"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/pgo"
+)
+
+// ListMetavarMatcher captures zero or more consecutive items of a list of
+// expressions into an expression list metavariable.
+//
+//	@@
+//	var args expression list
+//	@@
+//	-foo(a, args)
+//	+bar(args, a)
+//
+// It's used by SliceDotsMatcher in place of "..." so the items it captures
+// are chosen the same way as those elided by "...". The first occurrence of
+// the metavariable captures the items, and later occurrences must match the
+// captured items.
+type ListMetavarMatcher struct {
+	Fset *token.FileSet
+
+	// Name of the metavariable.
+	Name string
+}
+
+// Allows reports whether the given items may be captured by the
+// metavariable.
+func (m *ListMetavarMatcher) Allows(items []reflect.Value, d data.Data) bool {
+	var ld metavarListData
+	if !data.Lookup(d, metavarKey(m.Name), &ld) {
+		return true // not captured yet
+	}
+
+	if len(ld.Items) != len(items) {
+		return false
+	}
+	for i, item := range items {
+		if _, ok := ld.Items[i].Match(item, data.New(), itemRegion(item)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Capture records the given items as the value of the metavariable if it
+// hasn't been captured already.
+func (m *ListMetavarMatcher) Capture(items []reflect.Value, d data.Data) data.Data {
+	key := metavarKey(m.Name)
+	if d.Value(key) != nil {
+		return d
+	}
+
+	var ld metavarListData
+	for _, item := range items {
+		r := itemRegion(item)
+		ld.Items = append(ld.Items, metavarData{
+			Matcher:  newMatcherCompiler(m.Fset, nil, r.Pos, r.End).compile(item),
+			Replacer: newReplacerCompiler(m.Fset, nil, r.Pos, r.End).compile(item),
+			Value:    item,
+		})
+	}
+	return data.WithValue(d, key, ld)
+}
+
+// metavarListData is the value captured by an expression list
+// metavariable.
+type metavarListData struct {
+	Items []metavarData
+}
+
+// replaceListMetavar reproduces the items captured by the given expression
+// list metavariable.
+func replaceListMetavar(name string, d data.Data, cl Changelog, pos token.Pos) ([]reflect.Value, error) {
+	var ld metavarListData
+	if !data.Lookup(d, metavarKey(name), &ld) {
+		return nil, fmt.Errorf("could not find value for metavariable %q", name)
+	}
+
+	items := make([]reflect.Value, 0, len(ld.Items))
+	for _, md := range ld.Items {
+		item, err := md.reproduce(d, cl, pos)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// listMetavarName returns the name of the expression list metavariable the
+// given item of a slice refers to, if any.
+func listMetavarName(meta *Meta, item reflect.Value) (string, bool) {
+	ident, ok := item.Interface().(*ast.Ident)
+	if !ok || ident == nil || meta.LookupVar(ident.Name) != ExprListMetavarType {
+		return "", false
+	}
+	return ident.Name, true
+}
+
+// itemRegion returns the Region of an item of a slice.
+func itemRegion(item reflect.Value) Region {
+	if n, ok := item.Interface().(ast.Node); ok {
+		return nodeRegion(n)
+	}
+	return Region{}
+}
+
+// checkListMetavars reports an error for each reference to an expression
+// list metavariable outside a list of expressions in the given patch.
+func (c *compiler) checkListMetavars(meta *Meta, patch *parse.Patch) {
+	files := append([]*pgo.File{patch.Minus, patch.Plus}, patch.Alternatives...)
+	seen := make(map[token.Pos]struct{})
+	for _, f := range files {
+		for _, ident := range misplacedListMetavars(f.Node, meta) {
+			if _, dup := seen[ident.Pos()]; dup {
+				// Alternatives share positions with Minus.
+				continue
+			}
+			seen[ident.Pos()] = struct{}{}
+			c.errf(ident.Pos(), "expression list metavariable %q may be used only "+
+				"in a list of expressions", ident.Name)
+		}
+	}
+}
+
+// misplacedListMetavars returns references to expression list metavariables
+// in the given node that aren't items of a list of expressions.
+func misplacedListMetavars(n pgo.Node, meta *Meta) []*ast.Ident {
+	var misplaced []*ast.Ident
+	var visit func(reflect.Value)
+	visit = func(v reflect.Value) {
+		if !v.IsValid() {
+			return
+		}
+
+		switch v.Type() {
+		case goast.ExprSliceType:
+			for i := 0; i < v.Len(); i++ {
+				if _, ok := listMetavarName(meta, v.Index(i)); !ok {
+					visit(v.Index(i))
+				}
+			}
+			return
+		case goast.IdentPtrType:
+			if ident := v.Interface().(*ast.Ident); ident != nil && meta.LookupVar(ident.Name) == ExprListMetavarType {
+				misplaced = append(misplaced, ident)
+			}
+			return
+		case goast.ObjectPtrType:
+			// Ident.Obj forms a cycle.
+			return
+		}
+
+		switch v.Kind() {
+		case reflect.Array, reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				visit(v.Index(i))
+			}
+		case reflect.Interface, reflect.Ptr:
+			visit(v.Elem())
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				visit(v.Field(i))
+			}
+		}
+	}
+	visit(reflect.ValueOf(n))
+	return misplaced
+}
@@ -21,6 +21,7 @@
 package engine
 
 import (
+	"fmt"
 	"go/ast"
 	"go/token"
 	"reflect"
@@ -59,6 +60,19 @@ type matcherCompiler struct {
 	// All dots found during match compilation.
 	dots []token.Pos
 
+	// Whether only the first match inside an elided scope should be
+	// matched. See SliceDotsMatcher.Repeat.
+	firstOnly bool
+
+	// Names of packages imported by the patch and their import paths.
+	imports map[string]string
+
+	// Resolves the names of packages imported without a name.
+	packages *packageNames
+
+	// Comments in the section of the patch being compiled.
+	comments []*ast.CommentGroup
+
 	patchStart, patchEnd token.Pos
 }
 
@@ -97,13 +111,29 @@ func (c *matcherCompiler) compile(v reflect.Value) Matcher {
 			}
 			return ok
 		})
+	case goast.SpecSliceType:
+		return c.compileSliceDots(v, func(n ast.Node) bool {
+			s, ok := n.(*ast.ValueSpec)
+			if ok {
+				_, ok = s.Type.(*pgo.Dots)
+			}
+			return ok
+		})
+	case goast.IdentPtrSliceType:
+		return c.compileSliceDots(v, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			return ok && id.Name == pgo.DotsIdent
+		})
 	case goast.ForStmtPtrType:
 		return c.compileForStmt(v)
 
 		// TODO: Dedupe
 	case goast.CommentGroupPtrType:
-		// Comments shouldn't affect match.
-		return successMatcher
+		return c.compileCommentGroup(v)
+	case goast.FieldType:
+		return c.compileField(v)
+	case goast.SelectorExprPtrType:
+		return c.compileSelector(v)
 	case goast.ObjectPtrType:
 		// Ident.Obj forms a cycle. We'll consider Object pointers to always
 		// match because the entites they point to will be matched separately
@@ -124,8 +154,21 @@ func (f matcherFunc) Match(v reflect.Value, d data.Data, _ Region) (data.Data, b
 
 var (
 	// nilMatcher is a Matcher that only matches nil values.
-	nilMatcher Matcher = matcherFunc(func(got reflect.Value) bool { return got.IsNil() })
+	nilMatcher Matcher = nilValueMatcher{}
 
 	// successMatcher always return true.
 	successMatcher Matcher = matcherFunc(func(reflect.Value) bool { return true })
 )
+
+// nilValueMatcher matches nil values only.
+type nilValueMatcher struct{}
+
+func (nilValueMatcher) Match(got reflect.Value, d data.Data, r Region) (data.Data, bool) {
+	if got.IsNil() {
+		return d, true
+	}
+	traceMismatch(d, r, func() string {
+		return fmt.Sprintf("expected nothing, found %s", describeValue(got))
+	})
+	return d, false
+}
@@ -21,7 +21,9 @@
 package engine
 
 import (
+	"fmt"
 	"go/token"
+	"strconv"
 
 	"github.com/uber-go/gopatch/internal/parse"
 )
@@ -32,14 +34,49 @@ type MetavarType int
 
 // Supported metavariable types.
 const (
-	ExprMetavarType  MetavarType = iota + 1 // expression
-	IdentMetavarType                        // identifier
+	ExprMetavarType          MetavarType = iota + 1 // expression
+	IdentMetavarType                                // identifier
+	ExprListMetavarType                             // expression list
+	FreshIdentMetavarType                           // fresh identifier
+	ComputedIdentMetavarType                        // computed identifier
 )
 
+// String returns the name of the metavariable type as used in patches.
+func (t MetavarType) String() string {
+	switch t {
+	case ExprMetavarType:
+		return "expression"
+	case IdentMetavarType:
+		return "identifier"
+	case ExprListMetavarType:
+		return "expression list"
+	case FreshIdentMetavarType:
+		return "fresh identifier"
+	case ComputedIdentMetavarType:
+		return "computed identifier"
+	default:
+		return fmt.Sprintf("MetavarType(%d)", int(t))
+	}
+}
+
 // Meta is the compiled representaton of a Meta section.
 type Meta struct {
 	// Variables defined in this Meta section and their types.
 	Vars map[string]MetavarType
+
+	// Variables inherited from other changes, and the names of the changes
+	// they're inherited from.
+	Inherited map[string]string
+
+	// Seeds for the names generated for fresh identifier metavariables.
+	Seeds map[string]string
+
+	// Expressions that compute the values of computed identifier
+	// metavariables.
+	Computed map[string]computedExpr
+
+	// Constraints on the types of values matched by metavariables.
+	Types map[string]*typeConstraint
 }
 
 // LookupVar returns the type of the given metavariable or zero value if it
@@ -51,22 +88,108 @@ func (m *Meta) LookupVar(name string) MetavarType {
 	return m.Vars[name]
 }
 
+// computedVars returns the expressions that compute the values of computed
+// identifier metavariables, if any.
+func (m *Meta) computedVars() map[string]computedExpr {
+	if m == nil {
+		return nil
+	}
+	return m.Computed
+}
+
 func (c *compiler) compileMeta(m *parse.Meta) *Meta {
 	vars := make(map[string]MetavarType)
 	declPos := make(map[string]token.Pos)
+	var inherited, seeds map[string]string
+	var computed []*parse.VarDecl
+	var constraints map[string]*typeConstraint
 
 	for _, decl := range m.Vars {
 		var t MetavarType
-		switch decl.Type.Name {
-		case "identifier":
+		switch list, fresh := decl.ListPos.IsValid(), decl.FreshPos.IsValid(); {
+		case decl.Value != nil && fresh:
+			c.errf(decl.Value.Pos(), "fresh metavariables cannot be computed")
+			continue
+		case decl.Type.Name == "identifier" && decl.Value != nil && !list:
+			t = ComputedIdentMetavarType
+		case decl.Value != nil:
+			c.errf(decl.Value.Pos(), "only identifier metavariables may be computed")
+			continue
+		case decl.Type.Name == "identifier" && fresh && !list:
+			t = FreshIdentMetavarType
+		case fresh:
+			c.errf(decl.FreshPos, "only identifier metavariables may be fresh")
+			continue
+		case decl.Type.Name == "identifier" && !list:
 			t = IdentMetavarType
-		case "expression":
+		case decl.Type.Name == "expression" && !list:
 			t = ExprMetavarType
+		case decl.Type.Name == "expression" && list:
+			t = ExprListMetavarType
+		case list:
+			c.errf(decl.Type.Pos(), "unknown metavariable type %q", decl.Type.Name+" list")
+			continue
 		default:
 			c.errf(decl.Type.Pos(), "unknown metavariable type %q", decl.Type.Name)
 			continue
 		}
 
+		var seed string
+		if decl.Seed != nil {
+			if t != FreshIdentMetavarType {
+				c.errf(decl.Seed.Pos(), "only fresh identifier metavariables may have a seed")
+				continue
+			}
+
+			var err error
+			seed, err = strconv.Unquote(decl.Seed.Value)
+			if err != nil || seed == "_" || !token.IsIdentifier(seed) {
+				c.errf(decl.Seed.Pos(), "invalid seed %v: must be an identifier", decl.Seed.Value)
+				continue
+			}
+		}
+
+		var constraint *typeConstraint
+		if tc := decl.Constraint; tc != nil {
+			switch {
+			case t != IdentMetavarType && t != ExprMetavarType:
+				c.errf(tc.Pos(), "%v metavariables cannot have type constraints", t)
+				continue
+			case decl.Change != nil:
+				c.errf(tc.Pos(), "inherited metavariables cannot have type constraints")
+				continue
+			}
+
+			var err error
+			if constraint, err = compileTypeConstraint(tc); err != nil {
+				c.errf(tc.TypePos, "invalid type %q: %v", tc.Type, err)
+				continue
+			}
+		}
+
+		if t == ComputedIdentMetavarType {
+			switch {
+			case decl.Change != nil:
+				c.errf(decl.Value.Pos(), "inherited metavariables cannot be computed")
+				continue
+			case len(decl.Names) > 1:
+				c.errf(decl.Value.Pos(), "computed metavariables must be declared one at a time")
+				continue
+			}
+			computed = append(computed, decl)
+		}
+
+		var from *Change
+		if decl.Change != nil {
+			var ok bool
+			from, ok = c.changes[decl.Change.Name]
+			if !ok {
+				c.errf(decl.Change.Pos(), "unknown change %q: "+
+					"changes must be declared before they are referenced", decl.Change.Name)
+				continue
+			}
+		}
+
 		for _, name := range decl.Names {
 			if name.Name == "_" {
 				// Underscore isn't a variable declaration.
@@ -79,10 +202,60 @@ func (c *compiler) compileMeta(m *parse.Meta) *Meta {
 					c.fset.Position(pos))
 				continue
 			}
+
+			if from != nil {
+				if got := from.Meta.LookupVar(name.Name); got != t {
+					if got == 0 {
+						c.errf(name.Pos(), "cannot inherit metavariable %q: "+
+							"change %q does not define it", name.Name, from.Name)
+					} else {
+						c.errf(name.Pos(), "cannot inherit metavariable %q: "+
+							"it is %v, not %v, in change %q", name.Name, got, t, from.Name)
+					}
+					continue
+				}
+
+				if inherited == nil {
+					inherited = make(map[string]string)
+				}
+				inherited[name.Name] = from.Name
+			}
+
+			if t == FreshIdentMetavarType {
+				if seeds == nil {
+					seeds = make(map[string]string)
+				}
+				seeds[name.Name] = name.Name
+				if len(seed) > 0 {
+					seeds[name.Name] = seed
+				}
+			}
+
+			if constraint != nil {
+				if constraints == nil {
+					constraints = make(map[string]*typeConstraint)
+				}
+				constraints[name.Name] = constraint
+			}
+
 			vars[name.Name] = t
 			declPos[name.Name] = name.Pos()
 		}
 	}
 
-	return &Meta{Vars: vars}
+	meta := &Meta{Vars: vars, Inherited: inherited, Seeds: seeds, Types: constraints}
+	for _, decl := range computed {
+		name := decl.Names[0].Name
+		if name == "_" {
+			continue
+		}
+
+		if meta.Computed == nil {
+			meta.Computed = make(map[string]computedExpr)
+		}
+		if e := c.compileComputed(meta, decl.Value); e != nil {
+			meta.Computed[name] = e
+		}
+	}
+	return meta
 }
@@ -35,6 +35,9 @@ func TestCompileMeta(t *testing.T) {
 		desc string
 		give *parse.Meta
 		want map[string]MetavarType
+
+		// Seeds for fresh identifiers, if any.
+		wantSeeds map[string]string
 	}{
 		{
 			desc: "empty",
@@ -89,6 +92,78 @@ func TestCompileMeta(t *testing.T) {
 				"baz": 0, // unknown
 			},
 		},
+		{
+			desc: "expression list",
+			give: &parse.Meta{
+				Vars: []*parse.VarDecl{
+					{
+						// var args expression list
+						Names:   []*ast.Ident{ast.NewIdent("args")},
+						Type:    ast.NewIdent("expression"),
+						ListPos: 20,
+					},
+				},
+			},
+			want: map[string]MetavarType{
+				"args": ExprListMetavarType,
+				"foo":  0, // unknown
+			},
+		},
+		{
+			desc: "fresh identifier",
+			give: &parse.Meta{
+				Vars: []*parse.VarDecl{
+					{
+						// var tmp fresh identifier
+						Names:    []*ast.Ident{ast.NewIdent("tmp")},
+						FreshPos: 10,
+						Type:     ast.NewIdent("identifier"),
+					},
+					{
+						// var e fresh identifier "err"
+						Names:    []*ast.Ident{ast.NewIdent("e")},
+						FreshPos: 40,
+						Type:     ast.NewIdent("identifier"),
+						Seed:     &ast.BasicLit{Kind: token.STRING, Value: `"err"`},
+					},
+				},
+			},
+			want: map[string]MetavarType{
+				"tmp": FreshIdentMetavarType,
+				"e":   FreshIdentMetavarType,
+				"foo": 0, // unknown
+			},
+			wantSeeds: map[string]string{
+				"tmp": "tmp",
+				"e":   "err",
+			},
+		},
+		{
+			desc: "computed identifier",
+			give: &parse.Meta{
+				Vars: []*parse.VarDecl{
+					{
+						// var name identifier
+						Names: []*ast.Ident{ast.NewIdent("name")},
+						Type:  ast.NewIdent("identifier"),
+					},
+					{
+						// var short identifier = upper(name)
+						Names: []*ast.Ident{ast.NewIdent("short")},
+						Type:  ast.NewIdent("identifier"),
+						Value: &ast.CallExpr{
+							Fun:  ast.NewIdent("upper"),
+							Args: []ast.Expr{ast.NewIdent("name")},
+						},
+					},
+				},
+			},
+			want: map[string]MetavarType{
+				"name":  IdentMetavarType,
+				"short": ComputedIdentMetavarType,
+				"foo":   0, // unknown
+			},
+		},
 		{
 			desc: "mix",
 			give: &parse.Meta{
@@ -160,6 +235,7 @@ func TestCompileMeta(t *testing.T) {
 					assert.Equal(t, wantType, gotType)
 				})
 			}
+			assert.Equal(t, tt.wantSeeds, meta.Seeds)
 		})
 	}
 }
@@ -183,6 +259,77 @@ func TestCompileMetaErrors(t *testing.T) {
 			},
 			wantErr: `unknown metavariable type "whateven"`,
 		},
+		{
+			desc: "unknown list type",
+			give: &parse.Meta{
+				Vars: []*parse.VarDecl{
+					{
+						// var foo identifier list
+						Names:   []*ast.Ident{ast.NewIdent("foo")},
+						Type:    ast.NewIdent("identifier"),
+						ListPos: 20,
+					},
+				},
+			},
+			wantErr: `unknown metavariable type "identifier list"`,
+		},
+		{
+			desc: "fresh expression",
+			give: &parse.Meta{
+				Vars: []*parse.VarDecl{
+					{
+						// var foo fresh expression
+						Names:    []*ast.Ident{ast.NewIdent("foo")},
+						FreshPos: 10,
+						Type:     ast.NewIdent("expression"),
+					},
+				},
+			},
+			wantErr: "only identifier metavariables may be fresh",
+		},
+		{
+			desc: "seed without fresh",
+			give: &parse.Meta{
+				Vars: []*parse.VarDecl{
+					{
+						// var foo identifier "bar"
+						Names: []*ast.Ident{ast.NewIdent("foo")},
+						Type:  ast.NewIdent("identifier"),
+						Seed:  &ast.BasicLit{Kind: token.STRING, Value: `"bar"`},
+					},
+				},
+			},
+			wantErr: "only fresh identifier metavariables may have a seed",
+		},
+		{
+			desc: "invalid seed",
+			give: &parse.Meta{
+				Vars: []*parse.VarDecl{
+					{
+						// var foo fresh identifier "not valid"
+						Names:    []*ast.Ident{ast.NewIdent("foo")},
+						FreshPos: 10,
+						Type:     ast.NewIdent("identifier"),
+						Seed:     &ast.BasicLit{Kind: token.STRING, Value: `"not valid"`},
+					},
+				},
+			},
+			wantErr: `invalid seed "not valid": must be an identifier`,
+		},
+		{
+			desc: "unknown change",
+			give: &parse.Meta{
+				Vars: []*parse.VarDecl{
+					{
+						// var decl.foo identifier
+						Change: ast.NewIdent("decl"),
+						Names:  []*ast.Ident{ast.NewIdent("foo")},
+						Type:   ast.NewIdent("identifier"),
+					},
+				},
+			},
+			wantErr: `unknown change "decl": changes must be declared before they are referenced`,
+		},
 		{
 			desc: "name conflict/same type",
 			give: &parse.Meta{
@@ -28,6 +28,8 @@ import (
 
 	"github.com/uber-go/gopatch/internal/data"
 	"github.com/uber-go/gopatch/internal/goast"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/pgo"
 )
 
 // MetavarMatcher is compiled from a metavarible occurring in the minus
@@ -53,6 +55,12 @@ type MetavarMatcher struct {
 
 	// Reports whether the provided type matches the metavariable declaration.
 	TypeMatches func(reflect.Type) bool
+
+	// Constraint on the Go type of the matched value, if any.
+	Constraint *typeConstraint
+
+	// Position of the metavariable in the patch, if any.
+	Pos token.Pos
 }
 
 func (c *matcherCompiler) compileIdent(v reflect.Value) Matcher {
@@ -77,12 +85,17 @@ func (c *matcherCompiler) compileIdent(v reflect.Value) Matcher {
 		Fset:        c.fset,
 		Name:        name,
 		TypeMatches: matchType,
+		Constraint:  c.meta.Types[name],
+		Pos:         ident.Pos(),
 	}
 }
 
 // Match matches a metavariable from the patch in the AST.
 func (m MetavarMatcher) Match(got reflect.Value, d data.Data, r Region) (data.Data, bool) {
 	if !m.TypeMatches(got.Type()) {
+		traceMismatchAt(d, r, m.Pos, func() string {
+			return fmt.Sprintf("metavariable %q cannot match %s", m.Name, describeValue(got))
+		})
 		return d, false
 	}
 
@@ -93,22 +106,68 @@ func (m MetavarMatcher) Match(got reflect.Value, d data.Data, r Region) (data.Da
 		// We've already seen this metavariable. Match the value without
 		// altering captured data.
 		_, ok := md.Match(got, data.New(), r)
+		if !ok {
+			m.traceConflict(md, got, d, r)
+		}
 		return d, ok
 	}
 
-	// We're seeing this for the first time. Capture it into a compiler and
-	// replacer so we can match and reproduce it later.
+	// We're seeing this for the first time. Check its type and capture it
+	// into a compiler and replacer so we can match and reproduce it later.
+	if m.Constraint != nil && !m.Constraint.Match(got, d) {
+		rel := "of type"
+		if m.Constraint.Implements {
+			rel = "that implements"
+		}
+		traceMismatchAt(d, r, m.Pos, func() string {
+			return fmt.Sprintf("metavariable %q requires a value %s %s, found %s",
+				m.Name, rel, m.Constraint.Text, describeCode(got))
+		})
+		return d, false
+	}
 	return data.WithValue(d, key, metavarData{
 		Matcher:  newMatcherCompiler(m.Fset, nil, r.Pos, r.End).compile(got),
 		Replacer: newReplacerCompiler(m.Fset, nil, r.Pos, r.End).compile(got),
+		Value:    got,
 	}), true
 }
 
+// traceConflict records that the given value didn't match the value
+// previously captured by the metavariable.
+func (m MetavarMatcher) traceConflict(md metavarData, got reflect.Value, d data.Data, r Region) {
+	if lookupTracer(d) == nil {
+		return
+	}
+
+	prev := "a different value"
+	if v, err := md.Replace(data.New(), NewChangelog(), r.Pos); err == nil {
+		prev = describeCode(v)
+	}
+	traceMismatchAt(d, r, m.Pos, func() string {
+		return fmt.Sprintf("metavariable %q already matched %s, found %s", m.Name, prev, describeCode(got))
+	})
+}
+
 type metavarKey string
 
 type metavarData struct {
 	Matcher
 	Replacer
+
+	// Value that was captured.
+	Value reflect.Value
+}
+
+// reproduce generates a copy of the captured value. Type information
+// recorded for the captured value is recorded for the copy too so that
+// later changes can check the types of code that this one moved.
+func (md metavarData) reproduce(d data.Data, cl Changelog, pos token.Pos) (reflect.Value, error) {
+	v, err := md.Replace(data.New(), cl, pos)
+	if err != nil {
+		return v, err
+	}
+	lookupTypes(d).copyTypes(md.Value, v)
+	return v, nil
 }
 
 func isExpression(t reflect.Type) bool {
@@ -145,9 +204,14 @@ type MetavarReplacer struct {
 
 func (c *replacerCompiler) compileIdent(v reflect.Value) Replacer {
 	name := v.Interface().(*ast.Ident).Name
-	if c.meta.LookupVar(name) == 0 {
+	switch c.meta.LookupVar(name) {
+	case 0:
 		// Not a metavariable. Reproduce the identifier as-is.
 		return c.compileGeneric(v)
+	case FreshIdentMetavarType:
+		return FreshIdentReplacer{Name: name, Seed: c.meta.Seeds[name]}
+	case ComputedIdentMetavarType:
+		return ComputedIdentReplacer{Name: name, Expr: c.meta.Computed[name]}
 	}
 	return MetavarReplacer{Name: name}
 }
@@ -158,11 +222,94 @@ func (m MetavarReplacer) Replace(d data.Data, cl Changelog, pos token.Pos) (refl
 
 	var md metavarData
 	if !data.Lookup(d, key, &md) {
-		// This will happen only if a metavariable was referenced in the plus
-		// section without being referenced in the minus section.
-		// TODO(abg): Guard against that during compilation instead.
+		// Metavariables referenced in the plus section without being
+		// referenced in the minus section are rejected during
+		// compilation, but this may still happen if only some
+		// branches of a disjunction reference it.
 		return reflect.Value{}, fmt.Errorf("could not find value for metavariable %q", m.Name)
 	}
 
-	return md.Replace(data.New(), cl, pos)
+	return md.reproduce(d, cl, pos)
+}
+
+// checkPlusOnlyMetavars reports an error for each reference to a fresh or
+// computed identifier metavariable in the "-" section of the given patch.
+// These metavariables are generated by the replacement so they can't be
+// matched.
+func (c *compiler) checkPlusOnlyMetavars(meta *Meta, patch *parse.Patch) {
+	if len(meta.Seeds) == 0 && len(meta.Computed) == 0 {
+		return
+	}
+
+	seen := make(map[token.Pos]struct{})
+	for _, f := range append([]*pgo.File{patch.Minus}, patch.Alternatives...) {
+		for _, ident := range metavarRefs(f.Node, meta) {
+			t := meta.LookupVar(ident.Name)
+			if t != FreshIdentMetavarType && t != ComputedIdentMetavarType {
+				continue
+			}
+			if _, dup := seen[ident.Pos()]; dup {
+				// Alternatives share positions with Minus.
+				continue
+			}
+			seen[ident.Pos()] = struct{}{}
+			c.errf(ident.Pos(), "%v %q cannot be matched: "+
+				"it may be used only in the \"+\" section", t, ident.Name)
+		}
+	}
+}
+
+// checkUnboundMetavars reports an error for each metavariable referenced in
+// the "+" section of the given patch that no version of the "-" section
+// matches. Such a metavariable would have no value to reproduce.
+//
+// Metavariables of the change that this one runs within, if any, and those
+// inherited from other changes are bound before the patch is matched.
+func (c *compiler) checkUnboundMetavars(meta *Meta, within *Change, patch *parse.Patch) {
+	bound := make(map[string]struct{})
+	for name := range meta.Inherited {
+		bound[name] = struct{}{}
+	}
+	if within != nil {
+		for name := range within.Meta.Vars {
+			bound[name] = struct{}{}
+		}
+	}
+	for _, f := range append([]*pgo.File{patch.Minus}, patch.Alternatives...) {
+		for _, ident := range fileMetavarRefs(f, meta) {
+			bound[ident.Name] = struct{}{}
+		}
+	}
+
+	reported := make(map[string]struct{})
+	for _, ident := range fileMetavarRefs(patch.Plus, meta) {
+		switch meta.LookupVar(ident.Name) {
+		case FreshIdentMetavarType, ComputedIdentMetavarType:
+			continue
+		}
+		if _, ok := bound[ident.Name]; ok {
+			continue
+		}
+		if _, dup := reported[ident.Name]; dup {
+			continue
+		}
+		reported[ident.Name] = struct{}{}
+		c.errf(ident.Pos(), "metavariable %q is used in the \"+\" section "+
+			"but never matched in the \"-\" section", ident.Name)
+	}
+}
+
+// fileMetavarRefs returns references to metavariables in the given file,
+// including the names of its imports.
+func fileMetavarRefs(f *pgo.File, meta *Meta) []*ast.Ident {
+	var refs []*ast.Ident
+	for _, imp := range f.Imports {
+		if imp.Name != nil && meta.LookupVar(imp.Name.Name) != 0 {
+			refs = append(refs, imp.Name)
+		}
+	}
+	if f.Node != nil {
+		refs = append(refs, metavarRefs(f.Node, meta)...)
+	}
+	return refs
 }
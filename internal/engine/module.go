@@ -0,0 +1,150 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+)
+
+// moduleCache finds the Go modules that contain directories by looking for
+// the nearest go.mod file, remembering the results.
+type moduleCache struct {
+	mu   sync.Mutex
+	dirs map[string]module // directory => module containing it
+}
+
+// module is a Go module on the file system.
+type module struct {
+	// Path of the module as declared in its go.mod. This is empty if the
+	// directory isn't part of a module.
+	Path string
+
+	// Root directory of the module.
+	Dir string
+}
+
+func newModuleCache() *moduleCache {
+	return &moduleCache{dirs: make(map[string]module)}
+}
+
+// ImportPath returns the import path of the package in the given directory,
+// and the module that contains it. It returns false if the directory isn't
+// part of a module.
+func (c *moduleCache) ImportPath(dir string) (string, module, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", module{}, false
+	}
+
+	mod := c.Module(dir)
+	if len(mod.Path) == 0 {
+		return "", mod, false
+	}
+
+	rel, err := filepath.Rel(mod.Dir, dir)
+	if err != nil {
+		return "", mod, false
+	}
+	return path.Join(mod.Path, filepath.ToSlash(rel)), mod, true
+}
+
+// Module returns the module containing the given directory. The path of
+// the module is empty if the directory isn't part of a module.
+func (c *moduleCache) Module(dir string) module {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return module{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookup(dir)
+}
+
+// lookup finds the module containing the given absolute directory. c.mu
+// must be held.
+func (c *moduleCache) lookup(dir string) module {
+	if mod, ok := c.dirs[dir]; ok {
+		return mod
+	}
+
+	var mod module
+	if bs, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+		mod = module{Path: modfile.ModulePath(bs), Dir: dir}
+	} else if parent := filepath.Dir(dir); parent != dir {
+		mod = c.lookup(parent)
+	}
+
+	c.dirs[dir] = mod
+	return mod
+}
+
+// importPathPattern is a compiled pattern for import paths in the form
+// accepted by "go list":
+//
+//   - "..." matches any string, including an empty string and slashes
+//   - a pattern ending with "/..." also matches the path without it
+//
+// Patterns that start with "./" match paths relative to the root of the
+// module containing the package. "." matches the root package itself.
+type importPathPattern struct {
+	// Whether the pattern is relative to the root of the module.
+	Relative bool
+
+	Regexp *regexp.Regexp
+}
+
+func compileImportPathPattern(pattern string) (importPathPattern, error) {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\.\.\.`, `.*`)
+	if prefix, ok := strings.CutSuffix(re, `/.*`); ok {
+		re = prefix + `(/.*)?`
+	}
+
+	compiled, err := regexp.Compile("^" + re + "$")
+	if err != nil {
+		return importPathPattern{}, err
+	}
+	return importPathPattern{
+		Relative: pattern == "." || strings.HasPrefix(pattern, "./"),
+		Regexp:   compiled,
+	}, nil
+}
+
+// Match reports whether the import path of a package in the given module
+// matches this pattern.
+func (p importPathPattern) Match(importPath string, mod module) bool {
+	if p.Relative {
+		rel, ok := strings.CutPrefix(importPath, mod.Path)
+		if !ok || (len(rel) > 0 && rel[0] != '/') {
+			return false
+		}
+		importPath = "." + rel
+	}
+	return p.Regexp.MatchString(importPath)
+}
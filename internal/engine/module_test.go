@@ -0,0 +1,111 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportPathPatternMatch(t *testing.T) {
+	mod := module{Path: "example.com/app", Dir: "/src/app"}
+
+	tests := []struct {
+		pattern    string
+		importPath string
+		want       bool
+	}{
+		{"example.com/app", "example.com/app", true},
+		{"example.com/app", "example.com/app/foo", false},
+		{"example.com/app/...", "example.com/app", true},
+		{"example.com/app/...", "example.com/app/foo/bar", true},
+		{"example.com/app/...", "example.com/application", false},
+		{"example.com/app/internal/...", "example.com/app/foo", false},
+		{"example.com/.../internal", "example.com/app/foo/internal", true},
+		{"example.com/.../internal", "example.com/app/internal/foo", false},
+		{"example.com/app...", "example.com/application", true},
+		{".", "example.com/app", true},
+		{".", "example.com/app/foo", false},
+		{"./...", "example.com/app/foo", true},
+		{"./foo", "example.com/app/foo", true},
+		{"./foo/...", "example.com/app/foo/bar", true},
+		{"./foo/...", "example.com/app/bar", false},
+		{"./foo", "example.com/other/foo", false},
+		{"./foo", "example.com/application/foo", false},
+	}
+
+	for _, tt := range tests {
+		pattern, err := compileImportPathPattern(tt.pattern)
+		require.NoError(t, err, "compile %q", tt.pattern)
+		assert.Equal(t, tt.want, pattern.Match(tt.importPath, mod),
+			"match(%q, %q)", tt.pattern, tt.importPath)
+	}
+}
+
+func TestModuleCacheImportPath(t *testing.T) {
+	root := t.TempDir()
+	writeFile := func(path, contents string) {
+		path = filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+	writeFile("app/go.mod", "module example.com/app\n")
+	writeFile("app/tools/go.mod", "// tools\n\nmodule example.com/app/tools\n")
+	writeFile("app/foo/bar/bar.go", "package bar\n")
+	writeFile("app/tools/gen/gen.go", "package gen\n")
+	writeFile("other/other.go", "package other\n")
+
+	tests := []struct {
+		dir     string
+		want    string
+		wantMod string
+		wantOK  bool
+	}{
+		{dir: "app", want: "example.com/app", wantMod: "example.com/app", wantOK: true},
+		{dir: "app/foo/bar", want: "example.com/app/foo/bar", wantMod: "example.com/app", wantOK: true},
+		{dir: "app/tools/gen", want: "example.com/app/tools/gen", wantMod: "example.com/app/tools", wantOK: true},
+		{dir: "other"},
+	}
+
+	cache := newModuleCache()
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			got, mod, ok := cache.ImportPath(filepath.Join(root, tt.dir))
+			if !tt.wantOK {
+				// The temporary directory may itself be inside
+				// a module so we can't assert that nothing was
+				// found. Just make sure it's not one of ours.
+				if ok {
+					assert.NotContains(t, mod.Path, "example.com")
+				}
+				return
+			}
+
+			require.True(t, ok)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantMod, mod.Path)
+		})
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/uber-go/gopatch/internal/goast"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/pgo"
+)
+
+// CompileOption customizes how a Program is compiled.
+type CompileOption interface {
+	apply(*compiler)
+}
+
+type paramsOption map[string]string
+
+func (o paramsOption) apply(c *compiler) { c.params = o }
+
+// Params supplies values for parameters declared by changes in the
+// program with "param" declarations, keyed by the names of the parameters.
+//
+// Compiling a program with parameters replaces them with their values in
+// the parsed program.
+func Params(values map[string]string) CompileOption {
+	return paramsOption(values)
+}
+
+// ParamNames returns the names of the parameters declared by changes in the
+// given program, in the order in which they're first declared.
+func ParamNames(p *parse.Program) []string {
+	var names []string
+	seen := make(map[string]struct{})
+	for _, c := range p.Changes {
+		if c.Meta == nil {
+			continue
+		}
+		for _, decl := range c.Meta.Params {
+			if decl == nil {
+				continue
+			}
+			for _, name := range decl.Names {
+				if _, ok := seen[name.Name]; !ok {
+					seen[name.Name] = struct{}{}
+					names = append(names, name.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// param is a parameter declared by a change, along with its value.
+type param struct {
+	// Whether this is an identifier parameter. Identifier parameters
+	// replace identifiers with the same name in addition to references
+	// inside strings.
+	Ident bool
+
+	Value string
+}
+
+// Compiles the parameter declarations in the metavariables section of a
+// change, returning the parameters keyed by name.
+func (c *compiler) compileParams(m *parse.Meta) map[string]param {
+	if len(m.Params) == 0 {
+		return nil
+	}
+
+	// Metavariables may not share names with parameters.
+	varPos := make(map[string]token.Pos)
+	for _, decl := range m.Vars {
+		if decl == nil {
+			continue
+		}
+		for _, name := range decl.Names {
+			varPos[name.Name] = name.Pos()
+		}
+	}
+
+	params := make(map[string]param)
+	paramPos := make(map[string]token.Pos)
+	for _, decl := range m.Params {
+		if decl == nil {
+			continue
+		}
+
+		var ident bool
+		switch decl.Type.Name {
+		case "identifier":
+			ident = true
+		case "string":
+		default:
+			c.errf(decl.Type.Pos(), `unknown parameter type %q: expected "identifier" or "string"`, decl.Type.Name)
+			continue
+		}
+
+		for _, name := range decl.Names {
+			if pos, ok := varPos[name.Name]; ok {
+				c.errf(name.Pos(), "cannot define parameter %q: "+
+					"name already taken by metavariable defined at %v", name.Name,
+					c.fset.Position(pos))
+				continue
+			}
+			if pos, ok := paramPos[name.Name]; ok {
+				c.errf(name.Pos(), "cannot define parameter %q: "+
+					"name already taken by parameter defined at %v", name.Name,
+					c.fset.Position(pos))
+				continue
+			}
+			paramPos[name.Name] = name.Pos()
+
+			value, ok := c.params[name.Name]
+			if !ok {
+				c.errf(name.Pos(), "missing value for parameter %q", name.Name)
+				continue
+			}
+			if ident && (value == "_" || !token.IsIdentifier(value)) {
+				c.errf(name.Pos(), "invalid value %q for parameter %q: must be an identifier", value, name.Name)
+				continue
+			}
+			params[name.Name] = param{Ident: ident, Value: value}
+		}
+	}
+	return params
+}
+
+// Replaces parameters in the given change with their values.
+//
+// Identifier parameters replace identifiers with the same name in the
+// patch. All parameters replace references to them in the form "${name}"
+// inside string literals and comments in the patch, and inside strings in
+// the header and the metavariables section. References inside strings to
+// names that the change doesn't declare as parameters are reported as
+// errors.
+func (c *compiler) substituteParams(achange *parse.Change, params map[string]param) {
+	declared := make(map[string]struct{})
+	for _, decl := range achange.Meta.Params {
+		if decl == nil {
+			continue
+		}
+		for _, name := range decl.Names {
+			declared[name.Name] = struct{}{}
+		}
+	}
+
+	s := paramSubstituter{
+		params: params,
+		undeclared: func(pos token.Pos, name string) {
+			// Declared parameters that are missing from params
+			// have already been reported.
+			if _, ok := declared[name]; !ok {
+				c.errf(pos, "undeclared parameter %q", name)
+			}
+		},
+	}
+	for _, f := range append([]*pgo.File{achange.Patch.Minus, achange.Patch.Plus}, achange.Patch.Alternatives...) {
+		if p, ok := params[f.Package]; ok && p.Ident {
+			f.Package = p.Value
+		}
+		s.visit(reflect.ValueOf(f), true /* idents */)
+	}
+
+	// Identifiers in the header and the metavariables section refer to
+	// changes and metavariables, not code.
+	s.visit(reflect.ValueOf(achange.Clauses), false /* idents */)
+	s.visit(reflect.ValueOf(achange.Meta.Vars), false /* idents */)
+}
+
+type paramSubstituter struct {
+	params map[string]param
+
+	// Called with references to names inside strings that aren't in
+	// params.
+	undeclared func(pos token.Pos, name string)
+}
+
+func (s *paramSubstituter) visit(v reflect.Value, idents bool) {
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return
+	}
+
+	switch v.Type() {
+	case goast.IdentPtrType:
+		ident := v.Interface().(*ast.Ident)
+		if p, ok := s.params[ident.Name]; idents && ok && p.Ident {
+			ident.Name = p.Value
+		}
+		return
+	case goast.BasicLitPtrType:
+		if lit := v.Interface().(*ast.BasicLit); lit.Kind == token.STRING {
+			lit.Value = s.expandLit(lit)
+		}
+		return
+	case goast.CommentPtrType:
+		c := v.Interface().(*ast.Comment)
+		c.Text = s.expand(c.Text, nil)
+		return
+	case goast.ObjectPtrType, goast.ScopePtrType:
+		// These form cycles.
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			s.visit(v.Index(i), idents)
+		}
+	case reflect.Interface, reflect.Ptr:
+		s.visit(v.Elem(), idents)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			s.visit(v.Field(i), idents)
+		}
+	}
+}
+
+// Expands references to parameters in the given text. "$${" is replaced
+// with a literal "${".
+//
+// undeclared, if non-nil, is called with the names of references that
+// aren't parameters.
+func (s *paramSubstituter) expand(text string, undeclared func(name string)) string {
+	if !strings.Contains(text, "${") {
+		return text
+	}
+
+	var out strings.Builder
+	for {
+		start := strings.Index(text, "${")
+		if start < 0 {
+			break
+		}
+		if start > 0 && text[start-1] == '$' {
+			out.WriteString(text[:start-1])
+			out.WriteString("${")
+			text = text[start+2:]
+			continue
+		}
+
+		end := strings.IndexByte(text[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+
+		out.WriteString(text[:start])
+		name := text[start+2 : end]
+		if p, ok := s.params[name]; ok {
+			out.WriteString(p.Value)
+		} else {
+			// Not a parameter. Leave it as-is.
+			if undeclared != nil && token.IsIdentifier(name) {
+				undeclared(name)
+			}
+			out.WriteString(text[start : end+1])
+		}
+		text = text[end+1:]
+	}
+	out.WriteString(text)
+	return out.String()
+}
+
+// Expands references to parameters inside the given Go string literal,
+// returning the new value of the literal.
+func (s *paramSubstituter) expandLit(blit *ast.BasicLit) string {
+	lit := blit.Value
+	if !strings.Contains(lit, "${") {
+		return lit
+	}
+
+	str, err := strconv.Unquote(lit)
+	if err != nil {
+		return lit
+	}
+
+	expanded := s.expand(str, func(name string) {
+		s.undeclared(blit.Pos(), name)
+	})
+	if expanded == str {
+		return lit
+	}
+
+	if strings.HasPrefix(lit, "`") && strconv.CanBackquote(expanded) {
+		return "`" + expanded + "`"
+	}
+	return strconv.Quote(expanded)
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestParams(t *testing.T) {
+	tests := []struct {
+		desc   string
+		patch  []byte
+		params map[string]string
+		give   []byte
+		want   []byte
+	}{
+		{
+			desc: "identifiers and import paths",
+			patch: text.Unlines(
+				"@@",
+				"param PKG string",
+				"param OLD, NEW identifier",
+				"var pkg identifier",
+				"@@",
+				` import pkg "${PKG}"`,
+				"",
+				"-pkg.OLD",
+				"+pkg.NEW",
+			),
+			params: map[string]string{
+				"PKG": "example.com/foo",
+				"OLD": "Get",
+				"NEW": "Fetch",
+			},
+			give: text.Unlines(
+				"package x",
+				"",
+				`import bar "example.com/foo"`,
+				"",
+				"func run() {",
+				"	bar.Get()",
+				"	Get()",
+				"}",
+			),
+			want: text.Unlines(
+				"package x",
+				"",
+				`import bar "example.com/foo"`,
+				"",
+				"func run() {",
+				"	bar.Fetch()",
+				"	Get()",
+				"}",
+			),
+		},
+		{
+			desc: "strings",
+			patch: text.Unlines(
+				"@@",
+				"param FROM, TO string",
+				"@@",
+				`-os.Getenv("${FROM}")`,
+				"+os.Getenv(`${TO}`)",
+			),
+			params: map[string]string{
+				"FROM": "HOME",
+				"TO":   `C:\Users`,
+			},
+			give: text.Unlines(
+				"package x",
+				"",
+				`var home, path = os.Getenv("HOME"), os.Getenv("PATH")`,
+			),
+			want: text.Unlines(
+				"package x",
+				"",
+				"var home, path = os.Getenv(`C:\\Users`), os.Getenv(\"PATH\")",
+			),
+		},
+		{
+			desc: "escaped reference",
+			patch: text.Unlines(
+				"@@",
+				"param NAME string",
+				"@@",
+				`-foo("${NAME}")`,
+				`+foo("$${NAME} ${1}")`,
+			),
+			params: map[string]string{"NAME": "x"},
+			give: text.Unlines(
+				"package x",
+				"",
+				`var y = foo("x")`,
+			),
+			want: text.Unlines(
+				"package x",
+				"",
+				`var y = foo("${NAME} ${1}")`,
+			),
+		},
+		{
+			desc: "computed",
+			patch: text.Unlines(
+				"@@",
+				"param PREFIX string",
+				"var name identifier",
+				`var short identifier = trimPrefix(name, "${PREFIX}")`,
+				"@@",
+				"-name()",
+				"+short()",
+			),
+			params: map[string]string{"PREFIX": "Get"},
+			give: text.Unlines(
+				"package x",
+				"",
+				"var y = GetFoo()",
+			),
+			want: text.Unlines(
+				"package x",
+				"",
+				"var y = Foo()",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", tt.patch)
+			require.NoError(t, err)
+
+			p, err := Compile(fset, prog, Params(tt.params))
+			require.NoError(t, err)
+
+			file, err := parser.ParseFile(fset, "foo.go", tt.give, 0)
+			require.NoError(t, err)
+
+			change := p.Changes[0]
+			d, ok := change.Match(file, NewBindings())
+			require.True(t, ok, "should match")
+
+			got, err := change.Replace(d, NewChangelog())
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, format.Node(&buf, fset, got))
+			assert.Equal(t, string(tt.want), buf.String())
+		})
+	}
+}
+
+func TestCompileParamErrors(t *testing.T) {
+	tests := []struct {
+		desc   string
+		meta   []string
+		diff   []string // defaults to replacing foo() with bar()
+		params map[string]string
+		want   string
+	}{
+		{
+			desc: "missing value",
+			meta: []string{"param OLD identifier"},
+			want: `test.patch:2:7: missing value for parameter "OLD"`,
+		},
+		{
+			desc:   "invalid identifier",
+			meta:   []string{"param OLD identifier"},
+			params: map[string]string{"OLD": "foo.Bar"},
+			want:   `test.patch:2:7: invalid value "foo.Bar" for parameter "OLD": must be an identifier`,
+		},
+		{
+			desc:   "unknown type",
+			meta:   []string{"param OLD expression"},
+			params: map[string]string{"OLD": "x"},
+			want:   `test.patch:2:11: unknown parameter type "expression": expected "identifier" or "string"`,
+		},
+		{
+			desc:   "conflict with metavariable",
+			meta:   []string{"var x identifier", "param x string"},
+			params: map[string]string{"x": "y"},
+			want: `test.patch:3:7: cannot define parameter "x": ` +
+				`name already taken by metavariable defined at test.patch:2:5`,
+		},
+		{
+			desc:   "conflict with parameter",
+			meta:   []string{"param x string", "param x identifier"},
+			params: map[string]string{"x": "y"},
+			want: `test.patch:3:7: cannot define parameter "x": ` +
+				`name already taken by parameter defined at test.patch:2:7`,
+		},
+		{
+			desc:   "undeclared",
+			meta:   []string{"param NAME string"},
+			diff:   []string{`-foo("${NAME}")`, `+bar("${NMAE}")`},
+			params: map[string]string{"NAME": "x"},
+			want:   `test.patch:5:6: undeclared parameter "NMAE"`,
+		},
+		{
+			desc: "undeclared without parameters",
+			diff: []string{`-foo("${NAME}")`},
+			want: `test.patch:3:6: undeclared parameter "NAME"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			lines := append([]string{"@@"}, tt.meta...)
+			lines = append(lines, "@@")
+			if len(tt.diff) > 0 {
+				lines = append(lines, tt.diff...)
+			} else {
+				lines = append(lines, "-foo()", "+bar()")
+			}
+
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", text.Unlines(lines...))
+			require.NoError(t, err)
+
+			_, err = Compile(fset, prog, Params(tt.params))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
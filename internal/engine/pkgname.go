@@ -0,0 +1,255 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"go/build"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/mod/modfile"
+	modver "golang.org/x/mod/module"
+)
+
+// packageNames resolves the names of packages from their import paths by
+// finding their source on disk, remembering the results.
+//
+// Packages are looked up in GOROOT, in the module that imports them, in
+// its vendor directory, and in the module cache at the versions required
+// by the importing module. If a package can't be found, its name is
+// guessed from its import path with guessPackageName.
+type packageNames struct {
+	mu       sync.Mutex
+	modules  *moduleCache
+	modCache string                   // GOMODCACHE
+	modFiles map[string]*modfile.File // module directory => go.mod
+	names    map[pkgNameKey]string
+}
+
+type pkgNameKey struct {
+	Path string // import path
+	Dir  string // directory of the module importing it
+}
+
+func newPackageNames(modules *moduleCache) *packageNames {
+	return &packageNames{
+		modules:  modules,
+		modCache: moduleCacheDir(),
+		modFiles: make(map[string]*modfile.File),
+		names:    make(map[pkgNameKey]string),
+	}
+}
+
+// moduleCacheDir returns the directory of the module cache.
+func moduleCacheDir() string {
+	if dir := os.Getenv("GOMODCACHE"); len(dir) > 0 {
+		return dir
+	}
+	if gopath := filepath.SplitList(build.Default.GOPATH); len(gopath) > 0 {
+		return filepath.Join(gopath[0], "pkg", "mod")
+	}
+	return ""
+}
+
+// Name returns the name of the package with the given import path, as
+// imported by a file in the given directory.
+//
+// Name may be called on a nil packageNames, in which case it guesses the
+// name from the import path.
+func (p *packageNames) Name(importPath, dir string) string {
+	if p == nil {
+		return guessPackageName(importPath)
+	}
+
+	mod := p.modules.Module(dir)
+	key := pkgNameKey{Path: importPath, Dir: mod.Dir}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if name, ok := p.names[key]; ok {
+		return name
+	}
+
+	name := ""
+	for _, dir := range p.candidateDirs(importPath, mod) {
+		if name = readPackageName(dir); len(name) > 0 {
+			break
+		}
+	}
+	if len(name) == 0 {
+		name = guessPackageName(importPath)
+	}
+
+	p.names[key] = name
+	return name
+}
+
+// FileName returns the name of the package with the given import path, as
+// imported by the given file.
+func (p *packageNames) FileName(fset *token.FileSet, f *ast.File, importPath string) string {
+	var dir string
+	if tf := fset.File(f.Pos()); tf != nil {
+		dir = filepath.Dir(tf.Name())
+	}
+	return p.Name(importPath, dir)
+}
+
+// candidateDirs returns the directories that may hold the source of the
+// package with the given import path, in the order in which they should
+// be searched. p.mu must be held.
+func (p *packageNames) candidateDirs(importPath string, mod module) []string {
+	dirs := []string{filepath.Join(build.Default.GOROOT, "src", filepath.FromSlash(importPath))}
+	if len(mod.Path) == 0 {
+		return dirs
+	}
+
+	if rel, ok := cutModulePath(importPath, mod.Path); ok {
+		return append(dirs, filepath.Join(mod.Dir, filepath.FromSlash(rel)))
+	}
+	dirs = append(dirs, filepath.Join(mod.Dir, "vendor", filepath.FromSlash(importPath)))
+
+	mf := p.modFile(mod.Dir)
+	if mf == nil {
+		return dirs
+	}
+
+	// Use the longest module path that contains the package.
+	var (
+		best    modver.Version
+		bestRel string
+	)
+	for _, req := range mf.Require {
+		rel, ok := cutModulePath(importPath, req.Mod.Path)
+		if ok && len(req.Mod.Path) > len(best.Path) {
+			best, bestRel = req.Mod, rel
+		}
+	}
+	if len(best.Path) == 0 {
+		return dirs
+	}
+
+	for _, rep := range mf.Replace {
+		if rep.Old.Path != best.Path || (len(rep.Old.Version) > 0 && rep.Old.Version != best.Version) {
+			continue
+		}
+		if len(rep.New.Version) == 0 {
+			// Replaced with a directory on disk.
+			root := rep.New.Path
+			if !filepath.IsAbs(root) {
+				root = filepath.Join(mod.Dir, root)
+			}
+			return append(dirs, filepath.Join(root, filepath.FromSlash(bestRel)))
+		}
+		best = rep.New
+	}
+
+	if len(p.modCache) == 0 {
+		return dirs
+	}
+	escPath, err := modver.EscapePath(best.Path)
+	if err != nil {
+		return dirs
+	}
+	escVersion, err := modver.EscapeVersion(best.Version)
+	if err != nil {
+		return dirs
+	}
+	root := filepath.Join(p.modCache, filepath.FromSlash(escPath)+"@"+escVersion)
+	return append(dirs, filepath.Join(root, filepath.FromSlash(bestRel)))
+}
+
+// modFile returns the parsed go.mod of the module in the given directory,
+// or nil if it couldn't be parsed. p.mu must be held.
+func (p *packageNames) modFile(dir string) *modfile.File {
+	if mf, ok := p.modFiles[dir]; ok {
+		return mf
+	}
+
+	var mf *modfile.File
+	filename := filepath.Join(dir, "go.mod")
+	if bs, err := os.ReadFile(filename); err == nil {
+		mf, _ = modfile.Parse(filename, bs, nil)
+	}
+	p.modFiles[dir] = mf
+	return mf
+}
+
+// cutModulePath reports whether the import path is inside the module with
+// the given path, returning the path of the package relative to the root
+// of the module.
+func cutModulePath(importPath, modPath string) (rel string, ok bool) {
+	if importPath == modPath {
+		return "", true
+	}
+	rel, ok = strings.CutPrefix(importPath, modPath+"/")
+	return rel, ok
+}
+
+// readPackageName returns the name of the package in the given directory,
+// or an empty string if there isn't one.
+func readPackageName(dir string) string {
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return ""
+	}
+
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil && pkg == nil {
+		return ""
+	}
+	return pkg.Name
+}
+
+// guessPackageName guesses the name of a package from its import path
+// using the usual conventions: the last element of the path, ignoring
+// major version suffixes like "/v2" and a "go-" prefix, up to the first
+// character that isn't valid in an identifier.
+//
+//	github.com/foo/bar     => bar
+//	github.com/foo/bar/v2  => bar
+//	github.com/foo/go-bar  => bar
+//	github.com/foo/bar-go  => bar
+//	gopkg.in/yaml.v3       => yaml
+func guessPackageName(importPath string) string {
+	base := path.Base(importPath)
+	if v, ok := strings.CutPrefix(base, "v"); ok {
+		if _, err := strconv.Atoi(v); err == nil {
+			if dir := path.Dir(importPath); dir != "." {
+				base = path.Base(dir)
+			}
+		}
+	}
+
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}); i > 0 {
+		base = base[:i]
+	}
+	return base
+}
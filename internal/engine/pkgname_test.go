@@ -0,0 +1,212 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestGuessPackageName(t *testing.T) {
+	tests := []struct {
+		give string
+		want string
+	}{
+		{"fmt", "fmt"},
+		{"net/http", "http"},
+		{"github.com/foo/bar", "bar"},
+		{"github.com/foo/bar/v2", "bar"},
+		{"github.com/foo/go-bar", "bar"},
+		{"github.com/foo/bar-go", "bar"},
+		{"github.com/foo/go-bar/v3", "bar"},
+		{"gopkg.in/yaml.v3", "yaml"},
+		{"v2", "v2"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, guessPackageName(tt.give), "guessPackageName(%q)", tt.give)
+	}
+}
+
+// writePackageNamesTree sets up a module cache and a module that imports
+// packages from it, returning the directory of the module.
+func writePackageNamesTree(t *testing.T) string {
+	root := t.TempDir()
+	writeFile := func(path, contents string) {
+		path = filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+	writeFile("app/go.mod", string(text.Unlines(
+		"module example.com/app",
+		"",
+		"require (",
+		"	example.com/go-bar v1.2.0",
+		"	example.com/Upper v0.1.0",
+		"	example.com/local v0.0.0",
+		"	gopkg.in/yaml.v3 v3.0.1",
+		")",
+		"",
+		"replace example.com/local => ./local",
+	)))
+	writeFile("app/internal/util2/util.go", "package util\n")
+	writeFile("app/local/thing/thing.go", "package widget\n")
+	writeFile("app/vendor/example.com/vendored/v.go", "package vend\n")
+	writeFile("modcache/example.com/go-bar@v1.2.0/bar.go", "package barlib\n")
+	writeFile("modcache/example.com/!upper@v0.1.0/sub/sub.go", "package subpkg\n")
+	writeFile("modcache/gopkg.in/yaml.v3@v3.0.1/yaml.go", "package yaml\n")
+	t.Setenv("GOMODCACHE", filepath.Join(root, "modcache"))
+	return filepath.Join(root, "app")
+}
+
+func TestPackageNames(t *testing.T) {
+	appDir := writePackageNamesTree(t)
+	names := newPackageNames(newModuleCache())
+
+	tests := []struct {
+		desc string
+		give string
+		want string
+	}{
+		{desc: "standard library", give: "net/http", want: "http"},
+		{desc: "local module", give: "example.com/app/internal/util2", want: "util"},
+		{desc: "module cache", give: "example.com/go-bar", want: "barlib"},
+		{desc: "escaped module path", give: "example.com/Upper/sub", want: "subpkg"},
+		{desc: "version suffix", give: "gopkg.in/yaml.v3", want: "yaml"},
+		{desc: "replaced", give: "example.com/local/thing", want: "widget"},
+		{desc: "vendored", give: "example.com/vendored", want: "vend"},
+		{desc: "not found", give: "example.com/missing/go-thing/v2", want: "thing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			assert.Equal(t, tt.want, names.Name(tt.give, filepath.Join(appDir, "cmd")))
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		var names *packageNames
+		assert.Equal(t, "bar", names.Name("example.com/go-bar", appDir))
+	})
+}
+
+func TestResolvedImportNames(t *testing.T) {
+	appDir := writePackageNamesTree(t)
+
+	patch := text.Unlines(
+		"@@",
+		"@@",
+		"-import \"example.com/go-bar\"",
+		"+import \"example.com/app/internal/util2\"",
+		"",
+		"-barlib.Run()",
+		"+util.Run()",
+	)
+
+	tests := []struct {
+		desc string
+		give []byte
+		want []byte
+	}{
+		{
+			desc: "replace import",
+			give: text.Unlines(
+				"package main",
+				"",
+				`import "example.com/go-bar"`,
+				"",
+				"func main() {",
+				"	barlib.Run()",
+				"}",
+			),
+			want: text.Unlines(
+				"package main",
+				"",
+				`import util "example.com/app/internal/util2"`,
+				"",
+				"func main() {",
+				"	util.Run()",
+				"}",
+			),
+		},
+		{
+			desc: "keep used import",
+			give: text.Unlines(
+				"package main",
+				"",
+				`import "example.com/go-bar"`,
+				"",
+				"func main() {",
+				"	barlib.Run()",
+				"	barlib.Stop()",
+				"}",
+			),
+			want: text.Unlines(
+				"package main",
+				"",
+				"import (",
+				`	util "example.com/app/internal/util2"`,
+				`	"example.com/go-bar"`,
+				")",
+				"",
+				"func main() {",
+				"	util.Run()",
+				"	barlib.Stop()",
+				"}",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", patch)
+			require.NoError(t, err)
+
+			p, err := Compile(fset, prog)
+			require.NoError(t, err)
+
+			file, err := parser.ParseFile(fset, filepath.Join(appDir, "main.go"), tt.give, 0)
+			require.NoError(t, err)
+
+			change := p.Changes[0]
+			d, ok := change.Match(file, NewBindings())
+			require.True(t, ok, "should match")
+
+			got, err := change.Replace(d, NewChangelog())
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, format.Node(&buf, fset, got))
+			assert.Equal(t, string(tt.want), buf.String())
+		})
+	}
+}
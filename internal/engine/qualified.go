@@ -0,0 +1,216 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/goast"
+)
+
+// importNames returns the names by which the given imports of a patch are
+// referenced, mapped to their import paths. Imports named with
+// metavariables, blank imports, and dot imports are left out, as are all
+// named imports unless withNamed is set.
+//
+// The names of unnamed imports are resolved relative to the current
+// directory.
+func importNames(imps []*ast.ImportSpec, meta *Meta, packages *packageNames, withNamed bool) map[string]string {
+	names := make(map[string]string)
+	for _, imp := range imps {
+		path := goast.ImportPath(imp)
+		var name string
+		if imp.Name != nil {
+			name = imp.Name.Name
+		} else {
+			name = packages.Name(path, ".")
+		}
+
+		switch {
+		case imp.Name != nil && !withNamed:
+			continue
+		case name == "_", name == ".", meta.LookupVar(name) != 0:
+			continue
+		}
+		names[name] = path
+	}
+	return names
+}
+
+// qualifiedIdent reports the import path of the package referenced by the
+// given selector, if its left side is the name of a package imported by
+// the patch.
+func qualifiedIdent(sel *ast.SelectorExpr, imports map[string]string) (path string, ok bool) {
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return "", false
+	}
+	path, ok = imports[x.Name]
+	return path, ok
+}
+
+// QualifiedIdentMatcher matches references to a package-level declaration
+// of a package imported by the patch.
+//
+//	@@
+//	@@
+//	 import "log"
+//
+//	-log.Printf(...)
+//	+log.Print(...)
+//
+// With type information, this matches by the object the reference resolves
+// to, regardless of the name the file imports the package with. References
+// to shadowed names never match. Without type information, it matches the
+// selector as written.
+type QualifiedIdentMatcher struct {
+	// Import path of the package.
+	Path string
+
+	// Name of the package and the declaration in the patch.
+	Package, Name string
+
+	// Matches the selector as written.
+	Selector Matcher
+}
+
+func (c *matcherCompiler) compileSelector(v reflect.Value) Matcher {
+	sel := v.Interface().(*ast.SelectorExpr)
+	path, ok := qualifiedIdent(sel, c.imports)
+	if !ok {
+		return c.compileGeneric(v)
+	}
+
+	return QualifiedIdentMatcher{
+		Path:     path,
+		Package:  sel.X.(*ast.Ident).Name,
+		Name:     sel.Sel.Name,
+		Selector: c.compileGeneric(v),
+	}
+}
+
+// Match matches a reference to a package-level declaration.
+func (m QualifiedIdentMatcher) Match(got reflect.Value, d data.Data, r Region) (data.Data, bool) {
+	sel, ok := got.Interface().(*ast.SelectorExpr)
+	if !ok || sel == nil {
+		return m.Selector.Match(got, d, r)
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return d, false
+	}
+
+	ti := lookupTypes(d)
+	if ti == nil {
+		return m.Selector.Match(got, d, r)
+	}
+
+	// Code written by earlier changes may have only one of these
+	// resolved.
+	xobj, obj := ti.Info.Uses[x], ti.Info.Uses[sel.Sel]
+	if xobj == nil && obj == nil {
+		// Not type-checked. Fall back to matching by syntax.
+		return m.Selector.Match(got, d, r)
+	}
+
+	if xobj != nil {
+		pkg, isPkg := xobj.(*types.PkgName)
+		if !isPkg || pkg.Imported().Path() != m.Path {
+			return d, false
+		}
+	}
+	if obj != nil && (obj.Pkg() == nil || obj.Pkg().Path() != m.Path || obj.Name() != m.Name) {
+		return d, false
+	}
+
+	// Match a copy that refers to the package by the name used in the
+	// patch so that positions are recorded as usual.
+	return m.Selector.Match(reflect.ValueOf(&ast.SelectorExpr{
+		X:   &ast.Ident{NamePos: x.NamePos, Name: m.Package},
+		Sel: sel.Sel,
+	}), d, r)
+}
+
+// QualifiedIdentReplacer generates references to package-level
+// declarations of packages imported by the patch without a name.
+//
+// If the file imports the package under a different name, the reference
+// uses that name.
+type QualifiedIdentReplacer struct {
+	// Import path of the package.
+	Path string
+
+	// Generates the selector as written.
+	Selector Replacer
+}
+
+func (c *replacerCompiler) compileSelector(v reflect.Value) Replacer {
+	sel := v.Interface().(*ast.SelectorExpr)
+	path, ok := qualifiedIdent(sel, c.imports)
+	if !ok {
+		return c.compileGeneric(v)
+	}
+
+	return QualifiedIdentReplacer{
+		Path:     path,
+		Selector: c.compileGeneric(v),
+	}
+}
+
+// Replace generates a reference to a package-level declaration.
+//
+// With type information, the reference is recorded as a use of the
+// declaration so that later changes match it by object too.
+func (r QualifiedIdentReplacer) Replace(d data.Data, cl Changelog, pos token.Pos) (reflect.Value, error) {
+	v, err := r.Selector.Replace(d, cl, pos)
+	if err != nil {
+		return v, err
+	}
+
+	sel, ok := v.Interface().(*ast.SelectorExpr)
+	if !ok || sel == nil {
+		return v, nil
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return v, nil
+	}
+
+	var idata importData
+	if data.Lookup(d, importKey(r.Path), &idata) && len(idata.Name) > 0 && x.Name != idata.Name {
+		x = &ast.Ident{NamePos: x.NamePos, Name: idata.Name}
+		sel.X = x
+	}
+
+	if ti := lookupTypes(d); ti != nil && ti.Info.Uses != nil {
+		if pkg := ti.lookupPackage(r.Path); pkg != nil {
+			if obj := pkg.Scope().Lookup(sel.Sel.Name); obj != nil {
+				ti.Info.Uses[x] = types.NewPkgName(x.NamePos, ti.Pkg, x.Name, pkg)
+				ti.Info.Uses[sel.Sel] = obj
+			}
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestQualifiedIdents(t *testing.T) {
+	patch := text.Unlines(
+		"@@",
+		"var x expression",
+		"@@",
+		" import \"log\"",
+		"",
+		"-log.Printf(x)",
+		"+log.Print(x)",
+	)
+
+	tests := []struct {
+		desc    string
+		give    []byte
+		noTypes bool // match without type information
+		want    []byte
+	}{
+		{
+			desc: "unnamed",
+			give: text.Unlines(
+				"package foo",
+				"",
+				`import "log"`,
+				"",
+				"func run() {",
+				`	log.Printf("a")`,
+				"}",
+			),
+			want: text.Unlines(
+				"package foo",
+				"",
+				`import "log"`,
+				"",
+				"func run() {",
+				`	log.Print("a")`,
+				"}",
+			),
+		},
+		{
+			desc: "aliased",
+			give: text.Unlines(
+				"package foo",
+				"",
+				`import stdlog "log"`,
+				"",
+				"func run() {",
+				`	stdlog.Printf("a")`,
+				"}",
+			),
+			want: text.Unlines(
+				"package foo",
+				"",
+				`import stdlog "log"`,
+				"",
+				"func run() {",
+				`	stdlog.Print("a")`,
+				"}",
+			),
+		},
+		{
+			desc: "aliased/no types",
+			give: text.Unlines(
+				"package foo",
+				"",
+				`import stdlog "log"`,
+				"",
+				"func run() {",
+				`	stdlog.Printf("a")`,
+				"}",
+			),
+			noTypes: true,
+		},
+		{
+			desc: "shadowed",
+			give: text.Unlines(
+				"package foo",
+				"",
+				`import "log"`,
+				"",
+				"type logger struct{}",
+				"",
+				"func (logger) Printf(string, ...any) {}",
+				"",
+				"func run() {",
+				`	log.Printf("a")`,
+				"	{",
+				"		log := logger{}",
+				`		log.Printf("b")`,
+				"	}",
+				"}",
+			),
+			want: text.Unlines(
+				"package foo",
+				"",
+				`import "log"`,
+				"",
+				"type logger struct{}",
+				"",
+				"func (logger) Printf(string, ...any) {}",
+				"",
+				"func run() {",
+				`	log.Print("a")`,
+				"	{",
+				"		log := logger{}",
+				`		log.Printf("b")`,
+				"	}",
+				"}",
+			),
+		},
+		{
+			desc: "other package with the same name",
+			give: text.Unlines(
+				"package foo",
+				"",
+				`import log "example.com/log"`,
+				"",
+				"func run() {",
+				`	log.Printf("a")`,
+				"}",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", patch)
+			require.NoError(t, err)
+
+			p, err := Compile(fset, prog)
+			require.NoError(t, err)
+
+			file, err := parser.ParseFile(fset, "foo.go", tt.give, 0)
+			require.NoError(t, err)
+
+			bindings := NewBindings()
+			if !tt.noTypes {
+				bindings.Types = typeCheck(t, fset, file)
+			}
+
+			change := p.Changes[0]
+			d, ok := change.Match(file, bindings)
+			if len(tt.want) == 0 {
+				assert.False(t, ok, "should not match")
+				return
+			}
+			require.True(t, ok, "should match")
+
+			got, err := change.Replace(d, NewChangelog())
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, format.Node(&buf, fset, got))
+			assert.Equal(t, string(tt.want), buf.String())
+		})
+	}
+}
+
+func TestQualifiedIdentsChained(t *testing.T) {
+	tests := []struct {
+		desc  string
+		patch []byte
+		give  []byte
+		want  []byte // body of run
+	}{
+		{
+			// The second change must not match the shadowed log.Print
+			// written by the first.
+			desc: "shadowed",
+			patch: text.Unlines(
+				"@@",
+				"var l, x expression",
+				"@@",
+				"-l.Printf(x)",
+				"+l.Print(x)",
+				"",
+				"@@",
+				"var x expression",
+				"@@",
+				` import "log"`,
+				"",
+				"-log.Print(x)",
+				"+log.Println(x)",
+			),
+			give: text.Unlines(
+				"package foo",
+				"",
+				`import "log"`,
+				"",
+				"type logger struct{}",
+				"",
+				"func (logger) Print(...any)          {}",
+				"func (logger) Printf(string, ...any) {}",
+				"",
+				"func run() {",
+				`	log.Printf("a")`,
+				"	{",
+				"		log := logger{}",
+				`		log.Printf("b")`,
+				"	}",
+				"}",
+			),
+			want: text.Unlines(
+				"func run() {",
+				`	log.Println("a")`,
+				"	{",
+				"		log := logger{}",
+				`		log.Print("b")`,
+				"	}",
+				"}",
+			),
+		},
+		{
+			// The second change must match stdlog.Print written by the
+			// first.
+			desc: "aliased",
+			patch: text.Unlines(
+				"@@",
+				"var x expression",
+				"@@",
+				` import "log"`,
+				"",
+				"-log.Printf(x)",
+				"+log.Print(x)",
+				"",
+				"@@",
+				"var x expression",
+				"@@",
+				` import "log"`,
+				"",
+				"-log.Print(x)",
+				"+log.Println(x)",
+			),
+			give: text.Unlines(
+				"package foo",
+				"",
+				`import stdlog "log"`,
+				"",
+				"func run() {",
+				`	stdlog.Printf("a")`,
+				"}",
+			),
+			want: text.Unlines(
+				"func run() {",
+				`	stdlog.Println("a")`,
+				"}",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", tt.patch)
+			require.NoError(t, err)
+
+			p, err := Compile(fset, prog)
+			require.NoError(t, err)
+
+			file, err := parser.ParseFile(fset, "foo.go", tt.give, 0)
+			require.NoError(t, err)
+
+			bindings := NewBindings()
+			bindings.Types = typeCheck(t, fset, file)
+
+			for _, c := range p.Changes {
+				if d, ok := c.Match(file, bindings); ok {
+					file, err = c.Replace(d, NewChangelog())
+					require.NoError(t, err)
+				}
+			}
+
+			var buf bytes.Buffer
+			require.NoError(t, format.Node(&buf, fset, file.Decls[len(file.Decls)-1]))
+			assert.Equal(t, string(tt.want), buf.String()+"\n")
+		})
+	}
+}
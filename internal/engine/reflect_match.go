@@ -21,7 +21,9 @@
 package engine
 
 import (
+	"fmt"
 	"go/ast"
+	"go/token"
 	"reflect"
 
 	"github.com/uber-go/gopatch/internal/data"
@@ -31,6 +33,9 @@ import (
 // GenericNodeMatcher is the top-level matcher for ast.Node objects.
 type GenericNodeMatcher struct {
 	Matcher // underlying matcher
+
+	// Position of the node in the patch, if any.
+	Pos token.Pos
 }
 
 // compileGeneric compiles a Matcher for arbitrary values inside a Go AST.
@@ -38,7 +43,11 @@ func (c *matcherCompiler) compileGeneric(v reflect.Value) (m Matcher) {
 	defer func() {
 		// Wrap with GenericNodeMatcher only if the type is a Go AST node.
 		if v.Type().Implements(goast.NodeType) {
-			m = GenericNodeMatcher{Matcher: m}
+			gm := GenericNodeMatcher{Matcher: m}
+			if !v.IsNil() {
+				gm.Pos = v.Interface().(ast.Node).Pos()
+			}
+			m = gm
 		}
 	}()
 
@@ -63,7 +72,21 @@ func (m GenericNodeMatcher) Match(got reflect.Value, d data.Data, r Region) (dat
 	if !got.IsNil() {
 		r = nodeRegion(got.Interface().(ast.Node))
 	}
-	return m.Matcher.Match(got, d, r)
+
+	t := lookupTracer(d)
+	if t == nil {
+		return m.Matcher.Match(got, d, r)
+	}
+
+	// Explaining a mismatch. Report failures not recorded by the
+	// underlying matcher as a failure to match this node.
+	defer t.enter(m.Pos)()
+	recorded := t.recorded
+	d, ok := m.Matcher.Match(got, d, r)
+	if !ok && t.recorded == recorded {
+		t.record(r, token.NoPos, fmt.Sprintf("%s does not match", describeCode(got)))
+	}
+	return d, ok
 }
 
 // PtrMatcher matches a non-nil pointer in the AST.
@@ -83,6 +106,9 @@ func (c *matcherCompiler) compilePtr(v reflect.Value) Matcher {
 // Match matches a non-nil pointer.
 func (m PtrMatcher) Match(got reflect.Value, d data.Data, r Region) (data.Data, bool) {
 	if got.Kind() != reflect.Ptr || got.IsNil() {
+		traceMismatch(d, r, func() string {
+			return fmt.Sprintf("expected %s, found nothing", expectedNameOr(m.Matcher, "a value"))
+		})
 		return d, false
 	}
 	return m.Matcher.Match(got.Elem(), d, r)
@@ -109,7 +135,16 @@ func (c *matcherCompiler) compileSlice(v reflect.Value) Matcher {
 
 // Match mathces a slice of values.
 func (m SliceMatcher) Match(got reflect.Value, d data.Data, r Region) (data.Data, bool) {
-	if got.Kind() != reflect.Slice || len(m.Items) != got.Len() {
+	if got.Kind() != reflect.Slice {
+		traceMismatch(d, r, func() string {
+			return fmt.Sprintf("expected %d items, found %s", len(m.Items), describeValue(got))
+		})
+		return d, false
+	}
+	if len(m.Items) != got.Len() {
+		traceMismatch(d, r, func() string {
+			return fmt.Sprintf("expected %d items, found %d", len(m.Items), got.Len())
+		})
 		return d, false
 	}
 
@@ -149,8 +184,16 @@ func (c *matcherCompiler) compileStruct(v reflect.Value) Matcher {
 // Match matches a struct.
 func (m StructMatcher) Match(got reflect.Value, d data.Data, r Region) (data.Data, bool) {
 	if m.Type != got.Type() {
+		traceMismatch(d, r, func() string {
+			return fmt.Sprintf("expected %s, found %s", typeName(m.Type), typeName(got.Type()))
+		})
 		return d, false
 	}
+	if t := lookupTracer(d); t != nil {
+		// The type matched so failures in the fields are deeper
+		// than failures to match the struct.
+		defer t.enter(token.NoPos)()
+	}
 	for i, f := range m.Fields {
 		var ok bool
 		d, ok = f.Match(got.Field(i), d, r)
@@ -176,6 +219,9 @@ func (c *matcherCompiler) compileInterface(v reflect.Value) Matcher {
 // Match matches non-nil interface nalues.
 func (m InterfaceMatcher) Match(got reflect.Value, d data.Data, r Region) (data.Data, bool) {
 	if got.Kind() != reflect.Interface || got.IsNil() {
+		traceMismatch(d, r, func() string {
+			return fmt.Sprintf("expected %s, found nothing", expectedNameOr(m.Matcher, "a value"))
+		})
 		return d, false
 	}
 	return m.Matcher.Match(got.Elem(), d, r)
@@ -188,9 +234,18 @@ type ValueMatcher struct {
 }
 
 // Match matches a value as-is.
-func (m ValueMatcher) Match(got reflect.Value, d data.Data, _ Region) (data.Data, bool) {
+func (m ValueMatcher) Match(got reflect.Value, d data.Data, r Region) (data.Data, bool) {
 	if m.Type != got.Type() {
+		traceMismatch(d, r, func() string {
+			return fmt.Sprintf("expected %s, found %s", m.Type, got.Type())
+		})
 		return d, false
 	}
-	return d, m.Value == got.Interface()
+	if m.Value != got.Interface() {
+		traceMismatch(d, r, func() string {
+			return fmt.Sprintf("expected %v, found %v", quoteValue(m.Value), quoteValue(got.Interface()))
+		})
+		return d, false
+	}
+	return d, true
 }
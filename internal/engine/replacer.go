@@ -45,6 +45,19 @@ type replacerCompiler struct {
 	dots     []token.Pos
 	dotAssoc map[token.Pos]token.Pos
 
+	// Struct tags in the "+" section mapped to the tags of the same fields
+	// in the "-" section.
+	tags map[*ast.BasicLit]*ast.BasicLit
+
+	// Names of packages imported by the patch and their import paths.
+	imports map[string]string
+
+	// Resolves the names of packages imported without a name.
+	packages *packageNames
+
+	// Comments in the section of the patch being compiled.
+	comments []*ast.CommentGroup
+
 	patchStart, patchEnd token.Pos
 }
 
@@ -88,15 +101,27 @@ func (c *replacerCompiler) compile(v reflect.Value) Replacer {
 			}
 			return ok
 		})
+	case goast.SpecSliceType:
+		return c.compileSliceDots(v, func(n ast.Node) bool {
+			s, ok := n.(*ast.ValueSpec)
+			if ok {
+				_, ok = s.Type.(*pgo.Dots)
+			}
+			return ok
+		})
+	case goast.IdentPtrSliceType:
+		return c.compileSliceDots(v, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			return ok && id.Name == pgo.DotsIdent
+		})
 	case goast.ForStmtPtrType:
 		return c.compileForStmt(v)
 	case goast.CommentGroupPtrType:
-		// TODO: We're currently ignoring comments in the replacement patch.
-		// We should probably record them and report them in the top-level
-		// file.
-		return ValueReplacer{
-			Value: reflect.ValueOf((*ast.CommentGroup)(nil)),
-		}
+		return c.compileCommentGroup(v)
+	case goast.FieldType:
+		return c.compileField(v)
+	case goast.SelectorExprPtrType:
+		return c.compileSelector(v)
 
 	case goast.ObjectPtrType:
 		// Ident.Obj forms a cycle so we'll replace it with a nil pointer.
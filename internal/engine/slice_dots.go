@@ -21,11 +21,13 @@
 package engine
 
 import (
+	"fmt"
 	"go/ast"
 	"go/token"
 	"reflect"
 
 	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/pgo"
 )
 
 // SliceDotsMatcher implements support for "..." in portions of the AST where
@@ -36,8 +38,21 @@ type SliceDotsMatcher struct {
 	// List of contiguous sections to match against.
 	Sections [][]Matcher // inv: len > 0
 
-	// Positions at which dots were found.
+	// Positions at which dots were found. These include the positions of
+	// expression list metavariables.
 	Dots []token.Pos // inv: len(dots) = len(sections) - 1
+
+	// Constraints placed on each of the dots.
+	Constraints []dotsConstraints // inv: len(constraints) = len(dots)
+
+	// Whether the sections between the first and the last "..." should be
+	// matched again for each non-overlapping occurrence in the list. This
+	// is set only if the list ends with a "...".
+	Repeat bool
+
+	// Computed identifiers that must have valid values for a match. Each
+	// repeated occurrence is checked separately.
+	Computed map[string]computedExpr
 }
 
 func (c *matcherCompiler) compileSliceDots(items reflect.Value, isDots func(ast.Node) bool) Matcher {
@@ -45,20 +60,40 @@ func (c *matcherCompiler) compileSliceDots(items reflect.Value, isDots func(ast.
 	// only.
 
 	var (
-		sections [][]Matcher
-		current  []Matcher
-		dots     []token.Pos
+		sections    [][]Matcher
+		current     []Matcher
+		dots        []token.Pos
+		constraints []dotsConstraints
+		lists       bool
 	)
+	comments := c.stmtComments(items)
 	for i := 0; i < items.Len(); i++ {
 		item := items.Index(i)
 		if n, ok := item.Interface().(ast.Node); ok && isDots(n) {
 			dotPos := n.Pos()
 			c.dots = append(c.dots, dotPos)
 			dots = append(dots, dotPos)
+			constraints = append(constraints, c.compileDotsConstraints(n))
+			sections = append(sections, current)
+			current = nil
+		} else if name, ok := listMetavarName(c.meta, item); ok {
+			// Expression list metavariables elide items like
+			// "..." but capture them.
+			lists = true
+			dots = append(dots, item.Interface().(ast.Node).Pos())
+			constraints = append(constraints, dotsConstraints{
+				List: &ListMetavarMatcher{Fset: c.fset, Name: name},
+			})
 			sections = append(sections, current)
 			current = nil
 		} else {
-			current = append(current, c.compile(item))
+			m := c.compile(item)
+			if s, ok := item.Interface().(ast.Stmt); ok {
+				if sc, ok := comments[s]; ok {
+					m = c.compileStmtComments(m, sc)
+				}
+			}
+			current = append(current, m)
 		}
 	}
 	sections = append(sections, current)
@@ -69,7 +104,119 @@ func (c *matcherCompiler) compileSliceDots(items reflect.Value, isDots func(ast.
 		return SliceMatcher{Items: sections[0]}
 	}
 
-	return SliceDotsMatcher{Sections: sections, Dots: dots}
+	return SliceDotsMatcher{
+		Sections:    sections,
+		Dots:        dots,
+		Constraints: constraints,
+		Repeat:      !c.firstOnly && !lists && len(dots) > 1 && len(current) == 0,
+		Computed:    c.meta.computedVars(),
+	}
+}
+
+// dotsConstraints holds the "when" constraints placed on a "...".
+type dotsConstraints struct {
+	// Expression list metavariable capturing the elided items, if this
+	// is one instead of a "...".
+	List *ListMetavarMatcher
+
+	// Whether the elided code may contain code matching the section
+	// following the "...". If set, the longest possible span of code is
+	// elided rather than the shortest.
+	Any bool
+
+	// The elided code must not contain nodes matching any of these.
+	Exclude []Matcher
+
+	// The elided code must contain nodes matching all of these.
+	Require []Matcher
+}
+
+// backtrack reports whether dots with these constraints should try longer
+// spans when the rest of the match fails.
+func (dc dotsConstraints) backtrack() bool {
+	return dc.List != nil || len(dc.Exclude) > 0 || len(dc.Require) > 0
+}
+
+// compileDotsConstraints compiles the "when" constraints of the given "...",
+// if any.
+func (c *matcherCompiler) compileDotsConstraints(n ast.Node) dotsConstraints {
+	var dc dotsConstraints
+	if es, ok := n.(*ast.ExprStmt); ok {
+		n = es.X
+	}
+	dots, ok := n.(*pgo.Dots)
+	if !ok {
+		return dc
+	}
+
+	for _, w := range dots.When {
+		switch {
+		case w.Any:
+			dc.Any = true
+		case w.Op == token.NEQ:
+			dc.Exclude = append(dc.Exclude, c.compile(reflect.ValueOf(w.X)))
+		case w.Op == token.EQL:
+			dc.Require = append(dc.Require, c.compile(reflect.ValueOf(w.X)))
+		}
+	}
+	return dc
+}
+
+// Allows reports whether the given elided items satisfy these constraints.
+// Metavariables referenced by the constraints must match the values already
+// recorded in d, if any.
+func (dc dotsConstraints) Allows(items []reflect.Value, d data.Data) bool {
+	if dc.List != nil && !dc.List.Allows(items, d) {
+		return false
+	}
+	for _, m := range dc.Exclude {
+		if containsMatch(items, m, d) {
+			return false
+		}
+	}
+	for _, m := range dc.Require {
+		if !containsMatch(items, m, d) {
+			return false
+		}
+	}
+	return true
+}
+
+// capture records the given elided items into the expression list
+// metavariable for these dots, if any.
+func (dc dotsConstraints) capture(items []reflect.Value, d data.Data) data.Data {
+	if dc.List == nil {
+		return d
+	}
+	return dc.List.Capture(items, d)
+}
+
+// containsMatch reports whether any of the given items or their descendants
+// match m.
+func containsMatch(items []reflect.Value, m Matcher, d data.Data) (found bool) {
+	// Failures to match here don't explain why the patch didn't match.
+	if t := lookupTracer(d); t != nil {
+		defer t.mute()()
+	}
+
+	for _, item := range items {
+		n, ok := item.Interface().(ast.Node)
+		if !ok {
+			continue
+		}
+
+		ast.Inspect(n, func(n ast.Node) bool {
+			if found || n == nil {
+				return false
+			}
+			_, found = m.Match(reflect.ValueOf(n), d, nodeRegion(n))
+			return !found
+		})
+		if found {
+			return true
+		}
+	}
+	return false
 }
 
 // Match matches
@@ -85,14 +232,78 @@ func (m SliceDotsMatcher) Match(got reflect.Value, d data.Data, r Region) (data.
 		return d, false
 	}
 
-	for i, section := range m.Sections[1:] {
-		idx, d, ok = findSection(m.Dots[i], section, gotItems, d, r, idx)
+	if m.Repeat {
+		return m.matchRepeated(gotItems, d, r, idx)
+	}
+
+	_, d, ok = m.matchGaps(0, len(m.Dots), gotItems, d, r, idx, true)
+	return d, ok
+}
+
+// matchRepeated matches the sections between the first and the last "..."
+// once for each non-overlapping occurrence of them in got[idx:]. Each
+// occurrence is matched independently starting with the given Data so that
+// metavariables may bind to different values in each.
+//
+// The returned Data holds the match data of the first occurrence so that
+// replacers unable to reproduce the repetition change only the first. The
+// data for all occurrences is recorded separately.
+func (m SliceDotsMatcher) matchRepeated(got []reflect.Value, d data.Data, r Region, idx int) (data.Data, bool) {
+	type occurrence struct {
+		Data data.Data
+		End  int // index after the occurrence
+	}
+
+	last := len(m.Dots) - 1
+	var occurrences []occurrence
+	for start := idx; ; {
+		end, od, ok := m.matchGaps(0, last, got, d, r, start, false)
+		if !ok {
+			break
+		}
+
+		occurrences = append(occurrences, occurrence{Data: od, End: end})
+		if end == start {
+			// Matched an empty occurrence. Matching again will
+			// not make progress.
+			break
+		}
+		start = end
+	}
+	if len(occurrences) == 0 {
+		return d, false
+	}
+
+	// The last "..." consumes the rest of the list.
+	matchTail := func(o occurrence) (data.Data, bool) {
+		_, d, ok := m.matchGaps(last, last+1, got, o.Data, r, o.End, true)
+		return d, ok
+	}
+
+	first, ok := matchTail(occurrences[0])
+	if !ok {
+		return d, false
+	}
+
+	// Drop occurrences from the end until the rest of the list satisfies
+	// the constraints of the last "...".
+	for len(occurrences) > 1 {
+		td, ok := matchTail(occurrences[len(occurrences)-1])
 		if !ok {
-			return d, false
+			occurrences = occurrences[:len(occurrences)-1]
+			continue
+		}
+
+		rd := sliceDotsRepeatData{Last: m.Dots[last]}
+		rd.Tail.Skipped, rd.Tail.Region = lookupSliceDotsSkipped(td, m.Dots[last])
+		for _, o := range occurrences {
+			rd.Occurrences = append(rd.Occurrences, o.Data)
 		}
+		first = data.WithValue(first, sliceDotsRepeatKey(m.Dots[0]), rd)
+		break
 	}
 
-	return d, idx == len(gotItems)
+	return first, true
 }
 
 // Returns Region for items[start:end].
@@ -114,6 +325,9 @@ func sectionRegion(items []reflect.Value, r Region, start, end int) Region {
 // were matched, the new index for the remaining matches is returned.
 func matchPrefix(want []Matcher, got []reflect.Value, d data.Data, r Region, idx int) (newIdx int, _ data.Data, ok bool) {
 	if len(want) > len(got)-idx {
+		traceMismatch(d, r, func() string {
+			return fmt.Sprintf("expected %d more items, found %d", len(want), len(got)-idx)
+		})
 		return idx, d, false
 	}
 
@@ -128,26 +342,80 @@ func matchPrefix(want []Matcher, got []reflect.Value, d data.Data, r Region, idx
 	return idx + len(want), d, true
 }
 
-// findSection attempts to match want starting at got[idx], moving onto idx+1,
-// idx+2, and so on until a match is found. Returns the new index for the
-// remaining matches.
+// matchGaps matches the dots at indexes [from, to) and the sections
+// following each of them starting at got[idx]. Returns the index after the
+// last matched section. If toEnd is set, the match must consume the rest of
+// got.
+//
+// Each of the dots skips over the fewest items that allow the section
+// following it to match. Only dots with constraints or lists try longer
+// spans if the rest of the match fails; trying every span for each "..."
+// would take time exponential in the number of dots. Items skipped over
+// must satisfy the constraints of the dots. If the constraints allow it,
+// the longest span is tried first instead. A match must not compute
+// invalid identifiers.
 //
 // Invariant: If ok is true, a list of skipped items will have been pushed to
-// Data.
-func findSection(dots token.Pos, want []Matcher, got []reflect.Value, d data.Data, r Region, idx int) (newIdx int, _ data.Data, ok bool) {
-	// Special case: Looking for "..." at the end of the list. Skip everything
-	// in got.
-	if len(want) == 0 {
-		r := sectionRegion(got, r, idx, len(got))
-		d := pushSliceDotsSkipped(d, dots, got[idx:], r)
-		return matchPrefix(want, got, d, r, len(got))
-	}
-
-	for i := idx; i < len(got); i++ {
-		r := sectionRegion(got, r, idx, i)
-		newIdx, newD, ok := matchPrefix(want, got, pushSliceDotsSkipped(d, dots, got[idx:i], r), r, i)
-		if ok {
-			return newIdx, newD, ok
+// Data for each of the dots.
+func (m SliceDotsMatcher) matchGaps(from, to int, got []reflect.Value, d data.Data, r Region, idx int, toEnd bool) (newIdx int, _ data.Data, ok bool) {
+	if from == to {
+		return idx, d, !toEnd || idx == len(got)
+	}
+
+	dots, dc, want := m.Dots[from], m.Constraints[from], m.Sections[from+1]
+	matchSection := func(i int) (int, data.Data, bool) {
+		if !dc.Allows(got[idx:i], d) {
+			return idx, d, false
+		}
+		sr := sectionRegion(got, r, idx, i)
+		d := dc.capture(got[idx:i], pushSliceDotsSkipped(d, dots, got[idx:i], sr))
+		next, d, ok := matchPrefix(want, got, d, sr, i)
+		// Checking computed identifiers here rather than after the
+		// match lets the dots skip past code that would compute an
+		// invalid identifier.
+		if !ok || !validComputed(m.Computed, d) {
+			return idx, d, false
+		}
+		return next, d, true
+	}
+	try := func(i int) (int, data.Data, bool) {
+		next, d, ok := matchSection(i)
+		if !ok {
+			return idx, d, false
+		}
+		return m.matchGaps(from+1, to, got, d, r, next, toEnd)
+	}
+
+	// Special case: Looking for the last "..." and the section after it
+	// at the end of the list. Only one span lets the section end with got.
+	if toEnd && from == len(m.Dots)-1 {
+		if i := len(got) - len(want); i >= idx {
+			return try(i)
+		}
+		traceMismatch(d, r, func() string {
+			return fmt.Sprintf("expected %d more items, found %d", len(want), len(got)-idx)
+		})
+		return idx, d, false
+	}
+
+	switch {
+	case dc.Any:
+		for i := len(got); i >= idx; i-- {
+			if newIdx, newD, ok := try(i); ok {
+				return newIdx, newD, ok
+			}
+		}
+	case dc.backtrack():
+		for i := idx; i <= len(got); i++ {
+			if newIdx, newD, ok := try(i); ok {
+				return newIdx, newD, ok
+			}
+		}
+	default:
+		for i := idx; i <= len(got); i++ {
+			if next, newD, ok := matchSection(i); ok {
+				return m.matchGaps(from+1, to, got, newD, r, next, toEnd)
+			}
 		}
 	}
 
@@ -163,6 +431,10 @@ type SliceDotsReplacer struct {
 	// Positions at which dots were found.
 	Dots []token.Pos // inv: len(dots) = len(sections) - 1
 
+	// Names of the expression list metavariables used in place of each
+	// of the dots, or empty strings for "...".
+	Lists []string // inv: len(lists) = len(dots)
+
 	dotAssoc map[token.Pos]token.Pos
 }
 
@@ -175,17 +447,31 @@ func (c *replacerCompiler) compileSliceDots(items reflect.Value, isDots func(ast
 		sections [][]Replacer
 		current  []Replacer
 		dots     []token.Pos
+		lists    []string
 	)
+	comments := c.stmtComments(items)
 	for i := 0; i < items.Len(); i++ {
 		item := items.Index(i)
 		if n, ok := item.Interface().(ast.Node); ok && isDots(n) {
 			dotPos := n.Pos()
 			c.dots = append(c.dots, dotPos)
 			dots = append(dots, dotPos)
+			lists = append(lists, "")
+			sections = append(sections, current)
+			current = nil
+		} else if name, ok := listMetavarName(c.meta, item); ok {
+			dots = append(dots, item.Interface().(ast.Node).Pos())
+			lists = append(lists, name)
 			sections = append(sections, current)
 			current = nil
 		} else {
-			current = append(current, c.compile(item))
+			r := c.compile(item)
+			if s, ok := item.Interface().(ast.Stmt); ok {
+				if sc, ok := comments[s]; ok {
+					r = c.compileStmtComments(r, sc)
+				}
+			}
+			current = append(current, r)
 		}
 	}
 	sections = append(sections, current)
@@ -202,6 +488,7 @@ func (c *replacerCompiler) compileSliceDots(items reflect.Value, isDots func(ast
 	return SliceDotsReplacer{
 		Type:     items.Type(),
 		Dots:     dots,
+		Lists:    lists,
 		Sections: sections,
 		dotAssoc: c.dotAssoc,
 	}
@@ -209,39 +496,90 @@ func (c *replacerCompiler) compileSliceDots(items reflect.Value, isDots func(ast
 
 // Replace replaces target Nodes in slices where elements may have been elided
 // in the patch.
+//
+// If the sections between the first and the last "..." were matched
+// repeatedly, they're reproduced once for each occurrence.
 func (r SliceDotsReplacer) Replace(d data.Data, cl Changelog, pos token.Pos) (reflect.Value, error) {
 	// TODO: Recurse into Cursor
 
-	type skippedSection struct {
-		Items  []reflect.Value
-		Region Region
-	}
-
-	var skipped []skippedSection
-	for _, dotPos := range r.Dots {
-		items, region := lookupSliceDotsSkipped(d, r.dotAssoc[dotPos])
-		skipped = append(skipped, skippedSection{
-			Items:  items,
-			Region: region,
-		})
-	}
-
 	var items []reflect.Value
-	for i, section := range r.Sections {
+	replaceSection := func(section []Replacer, d data.Data) error {
 		for _, replacer := range section {
 			item, err := replacer.Replace(d, cl, pos)
 			if err != nil {
-				return reflect.Value{}, err
+				return err
 			}
 			items = append(items, item)
 		}
+		return nil
+	}
+	appendSkipped := func(skipped []reflect.Value, region Region) {
+		items = append(items, skipped...)
+		cl.Unchanged(region.Pos, region.End)
+		pos = region.End
+	}
 
-		if i < len(skipped) {
-			s := skipped[i]
-			items = append(items, s.Items...)
-			cl.Unchanged(s.Region.Pos, s.Region.End)
-			pos = s.Region.End
+	// appendGap adds the items for the dots at index i.
+	//
+	// If adjoin is set and the dots skipped only a line break, the gap is
+	// marked as changed instead so that the replacement follows the
+	// previous one without a blank line.
+	appendGap := func(i int, d data.Data, adjoin bool) error {
+		if name := r.Lists[i]; len(name) > 0 {
+			list, err := replaceListMetavar(name, d, cl, pos)
+			items = append(items, list...)
+			return err
+		}
+		skipped, region := lookupSliceDotsSkipped(d, r.dotAssoc[r.Dots[i]])
+		if adjoin && len(skipped) == 0 && lookupFileComments(d).LineBreak(region.Pos, region.End) {
+			cl.Changed(region.Pos, region.End)
+			pos = region.End
+			return nil
 		}
+		appendSkipped(skipped, region)
+		return nil
+	}
+
+	last := len(r.Sections) - 1
+	occurrences := []data.Data{d}
+	var tail *sliceDotsData
+	if rd, ok := r.lookupRepeated(d); ok {
+		occurrences = occurrences[:0]
+		for i, od := range rd.Occurrences {
+			od = overlayData{Data: d, top: od}
+			if i > 0 {
+				// Don't reuse fresh identifiers across occurrences.
+				od = renewFreshScope(od)
+			}
+			occurrences = append(occurrences, od)
+		}
+		tail = &rd.Tail
+	}
+
+	if err := replaceSection(r.Sections[0], d); err != nil {
+		return reflect.Value{}, err
+	}
+	for j, od := range occurrences {
+		for i := 1; i < last; i++ {
+			// Occurrences next to each other have nothing between
+			// them. If the previous one shrank, leaving the space
+			// between them unchanged would leave a blank line.
+			adjoin := j > 0 && i == 1
+			if err := appendGap(i-1, od, adjoin); err != nil {
+				return reflect.Value{}, err
+			}
+			if err := replaceSection(r.Sections[i], od); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+	}
+	if tail != nil {
+		appendSkipped(tail.Skipped, tail.Region)
+	} else if err := appendGap(last-1, d, false); err != nil {
+		return reflect.Value{}, err
+	}
+	if err := replaceSection(r.Sections[last], d); err != nil {
+		return reflect.Value{}, err
 	}
 
 	// TODO: Need to explicitly handle nil vs empty
@@ -256,6 +594,22 @@ func (r SliceDotsReplacer) Replace(d data.Data, cl Changelog, pos token.Pos) (re
 	return result, nil
 }
 
+// lookupRepeated retrieves the data for repeated matches of the "-" sections
+// corresponding to this replacer's sections. Repetition is reproduced only
+// if this replacer's first and last "..." are associated with the first and
+// last "..." of the matcher and the last section is empty.
+func (r SliceDotsReplacer) lookupRepeated(d data.Data) (rd sliceDotsRepeatData, ok bool) {
+	last := len(r.Sections) - 1
+	if len(r.Sections[last]) > 0 || len(r.Lists[0]) > 0 || len(r.Lists[last-1]) > 0 {
+		return rd, false
+	}
+
+	if !data.Lookup(d, sliceDotsRepeatKey(r.dotAssoc[r.Dots[0]]), &rd) {
+		return rd, false
+	}
+	return rd, rd.Last == r.dotAssoc[r.Dots[last-1]]
+}
+
 type sliceDotsKey token.Pos
 
 type sliceDotsData struct {
@@ -275,3 +629,37 @@ func lookupSliceDotsSkipped(d data.Data, dots token.Pos) (result []reflect.Value
 	_ = data.Lookup(d, sliceDotsKey(dots), &sd)
 	return sd.Skipped, sd.Region
 }
+
+type sliceDotsRepeatKey token.Pos
+
+// sliceDotsRepeatData records the occurrences of the sections of a
+// SliceDotsMatcher that was matched repeatedly. It's keyed by the position
+// of its first "...".
+type sliceDotsRepeatData struct {
+	// Match data for each occurrence, in order.
+	Occurrences []data.Data
+
+	// Position of the last "..." and the items it skipped after the last
+	// occurrence.
+	Last token.Pos
+	Tail sliceDotsData
+}
+
+// overlayData is a data.Data that looks up values in top before falling
+// back to the embedded Data.
+type overlayData struct {
+	data.Data
+
+	top data.Data
+}
+
+func (d overlayData) Keys() []any {
+	return append(d.top.Keys(), d.Data.Keys()...)
+}
+
+func (d overlayData) Value(k any) any {
+	if v := d.top.Value(k); v != nil {
+		return v
+	}
+	return d.Data.Value(k)
+}
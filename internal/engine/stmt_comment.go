@@ -0,0 +1,322 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+	"sort"
+
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/goast"
+)
+
+// stmtComments holds the comments around a statement in a statement list.
+//
+// Statements don't hold their comments in the Go AST so these are found by
+// position: the comment group on the lines right above the statement, and
+// the comment group following it on its last line.
+//
+//	// Doc
+//	foo() // Comment
+type stmtComments struct {
+	Doc     *ast.CommentGroup
+	Comment *ast.CommentGroup
+}
+
+// findStmtComments records the comments around the given statements of a
+// statement list into dst. start is the position of the token opening the
+// list, if any. groups must be sorted by position.
+func findStmtComments(dst map[ast.Stmt]stmtComments, tfile *token.File, groups []*ast.CommentGroup, start token.Pos, stmts []ast.Stmt) {
+	inFile := func(pos token.Pos) bool {
+		return pos.IsValid() && tfile.Base() <= int(pos) && int(pos) <= tfile.Base()+tfile.Size()
+	}
+	// Line numbers ignore line directives. Sections of a patch use them
+	// to report the lines of the patch file.
+	line := func(pos token.Pos) int {
+		return tfile.PositionFor(pos, false).Line
+	}
+	search := func(pos token.Pos) int {
+		return sort.Search(len(groups), func(i int) bool {
+			return groups[i].Pos() >= pos
+		})
+	}
+
+	for i, s := range stmts {
+		if _, ok := s.(*ast.DeclStmt); ok || !inFile(s.Pos()) {
+			// Comments on declarations are attached to them.
+			continue
+		}
+
+		prev := start
+		if i > 0 {
+			prev = stmts[i-1].End()
+		}
+		var next token.Pos
+		if i+1 < len(stmts) {
+			next = stmts[i+1].Pos()
+		}
+
+		var sc stmtComments
+		if j := search(s.Pos()); j > 0 {
+			// Comments following the previous statement on the same
+			// line belong to it.
+			g := groups[j-1]
+			if line(g.End())+1 == line(s.Pos()) &&
+				(!inFile(prev) || (g.Pos() >= prev && line(g.Pos()) > line(prev))) {
+				sc.Doc = g
+			}
+		}
+		if j := search(s.End()); j < len(groups) {
+			g := groups[j]
+			if line(g.Pos()) == line(s.End()) && (!inFile(next) || g.End() <= next) {
+				sc.Comment = g
+			}
+		}
+
+		if sc.Doc != nil || sc.Comment != nil {
+			dst[s] = sc
+		}
+	}
+}
+
+func (c *matcherCompiler) stmtComments(items reflect.Value) map[ast.Stmt]stmtComments {
+	return patchStmtComments(c.fset, c.comments, items)
+}
+
+func (c *replacerCompiler) stmtComments(items reflect.Value) map[ast.Stmt]stmtComments {
+	return patchStmtComments(c.fset, c.comments, items)
+}
+
+// patchStmtComments finds the comments around the statements of a
+// statement list in the patch. items is a slice of statements or other
+// nodes.
+func patchStmtComments(fset *token.FileSet, groups []*ast.CommentGroup, items reflect.Value) map[ast.Stmt]stmtComments {
+	if len(groups) == 0 || items.Type() != goast.StmtSliceType || items.Len() == 0 {
+		return nil
+	}
+
+	stmts := make([]ast.Stmt, items.Len())
+	for i := range stmts {
+		stmts[i], _ = items.Index(i).Interface().(ast.Stmt)
+		if stmts[i] == nil {
+			return nil
+		}
+	}
+
+	tfile := fset.File(groups[0].Pos())
+	if tfile == nil {
+		return nil
+	}
+
+	comments := make(map[ast.Stmt]stmtComments)
+	findStmtComments(comments, tfile, groups, token.NoPos, stmts)
+	return comments
+}
+
+// stmtCommentsMatcher matches a statement and the comments around it.
+//
+//	@@
+//	@@
+//	-foo() //nolint:errcheck
+//	+foo()
+type stmtCommentsMatcher struct {
+	Stmt         Matcher
+	Doc, Comment Matcher
+}
+
+func (c *matcherCompiler) compileStmtComments(m Matcher, sc stmtComments) Matcher {
+	return stmtCommentsMatcher{
+		Stmt:    m,
+		Doc:     c.compileCommentGroup(reflect.ValueOf(sc.Doc)),
+		Comment: c.compileCommentGroup(reflect.ValueOf(sc.Comment)),
+	}
+}
+
+// Match matches a statement and its comments.
+func (m stmtCommentsMatcher) Match(v reflect.Value, d data.Data, r Region) (data.Data, bool) {
+	d, ok := m.Stmt.Match(v, d, r)
+	if !ok {
+		return d, false
+	}
+
+	var sc stmtComments
+	if s, ok := v.Interface().(ast.Stmt); ok {
+		if fc := lookupFileComments(d); fc != nil {
+			sc = fc.Stmt(s)
+		}
+	}
+
+	d, ok = m.Doc.Match(reflect.ValueOf(sc.Doc), d, r)
+	if !ok {
+		return d, false
+	}
+	return m.Comment.Match(reflect.ValueOf(sc.Comment), d, r)
+}
+
+// stmtCommentsReplacer generates a statement and the comments around it.
+//
+// The comments are added to the file by FileReplacer next to the generated
+// statement.
+type stmtCommentsReplacer struct {
+	Stmt         Replacer
+	Doc, Comment Replacer
+}
+
+func (c *replacerCompiler) compileStmtComments(r Replacer, sc stmtComments) Replacer {
+	return stmtCommentsReplacer{
+		Stmt:    r,
+		Doc:     c.compileCommentGroup(reflect.ValueOf(sc.Doc)),
+		Comment: c.compileCommentGroup(reflect.ValueOf(sc.Comment)),
+	}
+}
+
+// Replace generates a statement and its comments.
+func (r stmtCommentsReplacer) Replace(d data.Data, cl Changelog, pos token.Pos) (reflect.Value, error) {
+	v, err := r.Stmt.Replace(d, cl, pos)
+	if err != nil || !v.IsValid() {
+		return v, err
+	}
+
+	s, ok := v.Interface().(ast.Stmt)
+	fc := lookupFileComments(d)
+	if !ok || fc == nil {
+		return v, nil
+	}
+
+	for _, slot := range []struct {
+		r   Replacer
+		doc bool
+	}{
+		{r.Doc, true},
+		{r.Comment, false},
+	} {
+		cv, err := slot.r.Replace(d, cl, pos)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if cg, _ := cv.Interface().(*ast.CommentGroup); cg != nil {
+			fc.added = append(fc.added, stmtCommentGroup{
+				Slot:  commentSlot{Node: s, Doc: slot.doc},
+				Group: cg,
+			})
+		}
+	}
+	return v, nil
+}
+
+// fileComments provides access to the comments of the file being matched.
+type fileComments struct {
+	Fset *token.FileSet
+	File *ast.File
+
+	// Comments around statements in the file, and the statements owning
+	// each comment group. Built on first use.
+	stmts  map[ast.Stmt]stmtComments
+	owners map[*ast.CommentGroup]ast.Stmt
+
+	// Comments generated for statements by the patch. These are added to
+	// the file by replaceComments.
+	added []stmtCommentGroup
+}
+
+type stmtCommentGroup struct {
+	Slot  commentSlot
+	Group *ast.CommentGroup
+}
+
+// Stmt returns the comments around the given statement.
+func (fc *fileComments) Stmt(s ast.Stmt) stmtComments {
+	fc.index()
+	return fc.stmts[s]
+}
+
+// Owner returns the statement owning the given comment group, if any.
+func (fc *fileComments) Owner(cg *ast.CommentGroup) ast.Stmt {
+	fc.index()
+	return fc.owners[cg]
+}
+
+// LineBreak reports whether [pos, end) holds nothing but whitespace and a
+// single line break.
+func (fc *fileComments) LineBreak(pos, end token.Pos) bool {
+	if fc == nil {
+		return false
+	}
+	tfile := fc.Fset.File(pos)
+	if tfile == nil || tfile.Line(end)-tfile.Line(pos) != 1 {
+		return false
+	}
+	for _, cg := range fc.File.Comments {
+		if cg.Pos() >= pos && cg.End() <= end {
+			return false
+		}
+	}
+	return true
+}
+
+func (fc *fileComments) index() {
+	if fc.stmts != nil {
+		return
+	}
+
+	fc.stmts = make(map[ast.Stmt]stmtComments)
+	fc.owners = make(map[*ast.CommentGroup]ast.Stmt)
+	if len(fc.File.Comments) == 0 {
+		return
+	}
+
+	tfile := fc.Fset.File(fc.File.Pos())
+	if tfile == nil {
+		return
+	}
+
+	ast.Inspect(fc.File, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			findStmtComments(fc.stmts, tfile, fc.File.Comments, n.Lbrace, n.List)
+		case *ast.CaseClause:
+			findStmtComments(fc.stmts, tfile, fc.File.Comments, n.Colon, n.Body)
+		case *ast.CommClause:
+			findStmtComments(fc.stmts, tfile, fc.File.Comments, n.Colon, n.Body)
+		}
+		return true
+	})
+
+	for s, sc := range fc.stmts {
+		if sc.Doc != nil {
+			fc.owners[sc.Doc] = s
+		}
+		if sc.Comment != nil {
+			fc.owners[sc.Comment] = s
+		}
+	}
+}
+
+type _fileCommentsKey struct{}
+
+var fileCommentsKey _fileCommentsKey
+
+func lookupFileComments(d data.Data) (fc *fileComments) {
+	_ = data.Lookup(d, fileCommentsKey, &fc)
+	return fc
+}
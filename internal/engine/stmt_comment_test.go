@@ -0,0 +1,121 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestFileCommentsStmt(t *testing.T) {
+	type comments struct{ Doc, Comment string }
+
+	tests := []struct {
+		desc string
+		give []string   // body of a function
+		want []comments // for each simple statement
+	}{
+		{
+			desc: "no comments",
+			give: []string{"foo()", "bar()"},
+			want: []comments{{}, {}},
+		},
+		{
+			desc: "trailing",
+			give: []string{"foo() //nolint:errcheck", "bar()"},
+			want: []comments{{Comment: "//nolint:errcheck\n"}, {}},
+		},
+		{
+			desc: "leading",
+			give: []string{"foo()", "// TODO: bar", "// more", "bar()"},
+			want: []comments{{}, {Doc: "// TODO: bar\n// more\n"}},
+		},
+		{
+			desc: "separated by blank line",
+			give: []string{"foo()", "// bar", "", "bar()"},
+			want: []comments{{}, {}},
+		},
+		{
+			desc: "trailing comment of previous statement",
+			give: []string{"foo() // foo", "bar()"},
+			want: []comments{{Comment: "// foo\n"}, {}},
+		},
+		{
+			desc: "after opening brace",
+			give: []string{"if x { // x", "foo()", "}"},
+			want: []comments{{}},
+		},
+		{
+			desc: "case clause",
+			give: []string{"switch {", "case x:", "// x", "foo() // y", "}"},
+			want: []comments{{Doc: "// x\n", Comment: "// y\n"}},
+		},
+		{
+			desc: "declaration",
+			give: []string{"// x", "var x int // x", "foo()"},
+			want: []comments{{}, {}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			src := text.Unlines(append(append([]string{"package x", "", "func y() {"}, tt.give...), "}")...)
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, "foo.go", src, parser.ParseComments)
+			require.NoError(t, err)
+
+			fc := &fileComments{Fset: fset, File: file}
+			var got []comments
+			ast.Inspect(file.Decls[0], func(n ast.Node) bool {
+				switch n.(type) {
+				case *ast.ExprStmt, *ast.DeclStmt:
+					sc := fc.Stmt(n.(ast.Stmt))
+					got = append(got, comments{
+						Doc:     commentText(sc.Doc),
+						Comment: commentText(sc.Comment),
+					})
+					return false
+				}
+				return true
+			})
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// commentText returns the comments in a group as they appear in the
+// source, one per line.
+func commentText(cg *ast.CommentGroup) string {
+	if cg == nil {
+		return ""
+	}
+	var lines []string
+	for _, c := range cg.List {
+		lines = append(lines, c.Text)
+	}
+	return string(text.Unlines(lines...))
+}
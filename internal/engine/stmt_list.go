@@ -22,6 +22,7 @@ package engine
 
 import (
 	"errors"
+	"fmt"
 	"go/ast"
 	"go/token"
 	"reflect"
@@ -58,6 +59,9 @@ func (c *matcherCompiler) compilePGoStmtList(slist *pgo.StmtList) Matcher {
 func (m stmtSliceContainerMatcher) Match(v reflect.Value, d data.Data, r Region) (data.Data, bool) {
 	t := v.Type()
 	if t.Kind() != reflect.Ptr {
+		traceMismatch(d, r, func() string {
+			return fmt.Sprintf("expected a list of statements, found %s", describeValue(v))
+		})
 		return d, false
 	}
 
@@ -84,6 +88,9 @@ func (m stmtSliceContainerMatcher) Match(v reflect.Value, d data.Data, r Region)
 		stmtField = "Body"
 		stmtPreludeEnd = token.Pos(v.FieldByName("Colon").Int())
 	default:
+		traceMismatch(d, r, func() string {
+			return fmt.Sprintf("expected a list of statements, found %s", describeValue(v))
+		})
 		return d, false
 	}
 
@@ -141,6 +141,37 @@ func TestStmtSliceContainer(t *testing.T) {
 						},
 					}),
 				},
+				{
+					desc: "repeated",
+					// equivalent to,
+					//   {
+					//     foo
+					//     bar
+					//     qux
+					//     foo
+					//     bar
+					//   }
+					give: refl(&ast.BlockStmt{
+						Lbrace: 1,
+						List: []ast.Stmt{
+							&ast.ExprStmt{X: ast.NewIdent("foo")},
+							&ast.ExprStmt{X: ast.NewIdent("bar")},
+							&ast.ExprStmt{X: ast.NewIdent("qux")},
+							&ast.ExprStmt{X: ast.NewIdent("foo")},
+							&ast.ExprStmt{X: ast.NewIdent("bar")},
+						},
+						Rbrace: 20,
+					}),
+					want: refl(&ast.BlockStmt{
+						Lbrace: 1,
+						List: []ast.Stmt{
+							&ast.ExprStmt{X: ast.NewIdent("baz")},
+							&ast.ExprStmt{X: ast.NewIdent("qux")},
+							&ast.ExprStmt{X: ast.NewIdent("baz")},
+						},
+						Rbrace: 20,
+					}),
+				},
 				{
 					desc: "block mismatch",
 					give: refl(&ast.BlockStmt{
@@ -0,0 +1,777 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/goast"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/pgo"
+)
+
+// tagFieldIndex is the index of the Tag field in ast.Field.
+var tagFieldIndex = func() int {
+	f, _ := goast.FieldType.FieldByName("Tag")
+	return f.Index[0]
+}()
+
+// tagPair is a single key:"value" pair in a struct tag.
+type tagPair struct {
+	Key   string
+	Value string
+
+	// Quoted value as it appeared in the tag. This is empty if the value
+	// was changed.
+	raw string
+}
+
+// structTag is a struct tag parsed with the conventions of
+// reflect.StructTag.
+type structTag []tagPair
+
+// parseStructTag parses the contents of a struct tag. Unlike
+// reflect.StructTag, it reports an error for malformed tags.
+func parseStructTag(tag string) (structTag, error) {
+	var pairs structTag
+	for {
+		tag = strings.TrimLeft(tag, " ")
+		if tag == "" {
+			return pairs, nil
+		}
+
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return nil, fmt.Errorf("bad syntax for struct tag pair %q", tag)
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		// Scan the quoted value.
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return nil, fmt.Errorf("bad syntax for struct tag value of %q", key)
+		}
+		raw := tag[:i+1]
+		tag = tag[i+1:]
+
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return nil, fmt.Errorf("bad syntax for struct tag value of %q", key)
+		}
+		pairs = append(pairs, tagPair{Key: key, Value: value, raw: raw})
+	}
+}
+
+// String returns the contents of the struct tag.
+func (t structTag) String() string {
+	var sb strings.Builder
+	for i, p := range t {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(p.Key)
+		sb.WriteByte(':')
+		if p.raw != "" {
+			sb.WriteString(p.raw)
+		} else {
+			sb.WriteString(strconv.Quote(p.Value))
+		}
+	}
+	return sb.String()
+}
+
+// index returns the index of the pair with the given key, or -1.
+func (t structTag) index(key string) int {
+	for i, p := range t {
+		if p.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// unquoteTag returns the contents of a struct tag literal.
+func unquoteTag(lit *ast.BasicLit) (string, error) {
+	if lit.Kind != token.STRING {
+		return "", errors.New("struct tag must be a string")
+	}
+	return strconv.Unquote(lit.Value)
+}
+
+// tagElem is the key of a struct tag pair in a patch, or one of the
+// comma-separated parts of its value.
+type tagElem struct {
+	Text    string
+	Metavar bool // Text is the name of a metavariable
+	Dots    bool // "...", matching any number of parts
+}
+
+// tagPairPattern is a key:"value" pair of a struct tag in a patch.
+type tagPairPattern struct {
+	Key    tagElem
+	Values []tagElem
+}
+
+// dots returns the number of "..." in the value.
+func (p tagPairPattern) dots() (n int) {
+	for _, v := range p.Values {
+		if v.Dots {
+			n++
+		}
+	}
+	return n
+}
+
+// sameKey reports whether two pairs of a patch refer to the same key.
+func (p tagPairPattern) sameKey(o tagPairPattern) bool {
+	return p.Key == o.Key
+}
+
+// tagPattern is a struct tag in a patch.
+type tagPattern []tagPairPattern
+
+// compileTagPattern parses a struct tag in a patch. Keys and comma-separated
+// parts of values that name identifier metavariables refer to them, and
+// "..." in values matches any number of parts.
+func compileTagPattern(lit *ast.BasicLit, meta *Meta) (tagPattern, error) {
+	s, err := unquoteTag(lit)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := parseStructTag(s)
+	if err != nil {
+		return nil, err
+	}
+
+	elem := func(text string) tagElem {
+		return tagElem{
+			Text:    text,
+			Metavar: meta.LookupVar(text) != 0,
+			Dots:    text == "...",
+		}
+	}
+
+	pattern := make(tagPattern, len(tag))
+	for i, pair := range tag {
+		pattern[i].Key = elem(pair.Key)
+		if pattern[i].Key.Dots {
+			return nil, errors.New(`"..." cannot be used as a struct tag key`)
+		}
+		for _, part := range strings.Split(pair.Value, ",") {
+			pattern[i].Values = append(pattern[i].Values, elem(part))
+		}
+	}
+	return pattern, nil
+}
+
+// tagMetavars returns references to metavariables in a struct tag in a
+// patch. The references are positioned at the tag.
+func tagMetavars(lit *ast.BasicLit, meta *Meta) []*ast.Ident {
+	pattern, err := compileTagPattern(lit, meta)
+	if err != nil {
+		return nil
+	}
+
+	var refs []*ast.Ident
+	for _, p := range pattern {
+		for _, e := range append([]tagElem{p.Key}, p.Values...) {
+			if e.Metavar {
+				refs = append(refs, &ast.Ident{Name: e.Text, NamePos: lit.ValuePos})
+			}
+		}
+	}
+	return refs
+}
+
+// TagMatcher matches struct field tags by their key:"value" pairs.
+//
+//	@@
+//	var name identifier
+//	@@
+//	 type User struct {
+//	   ...
+//	-  ID int `json:"name,..."`
+//	+  ID int `json:"name,..." yaml:"name"`
+//	   ...
+//	 }
+//
+// The tag in the source must have all keys listed in the patch, in any
+// order. It may have other keys as well. Metavariables in keys and values
+// capture text from the tag into identifiers.
+type TagMatcher struct {
+	Fset *token.FileSet
+
+	// Position of the tag in the patch.
+	Pos token.Pos
+
+	Pattern tagPattern
+}
+
+func (c *matcherCompiler) compileField(v reflect.Value) Matcher {
+	m := c.compileStruct(v)
+
+	tag := v.Field(tagFieldIndex).Interface().(*ast.BasicLit)
+	if tag == nil || c.meta == nil {
+		return m
+	}
+
+	pattern, err := compileTagPattern(tag, c.meta)
+	if err != nil {
+		// Reported by checkStructTags.
+		return m
+	}
+
+	sm := m.(StructMatcher)
+	fields := append([]Matcher(nil), sm.Fields...)
+	fields[tagFieldIndex] = TagMatcher{
+		Fset:    c.fset,
+		Pos:     tag.ValuePos,
+		Pattern: pattern,
+	}
+	sm.Fields = fields
+	return sm
+}
+
+// Match matches a struct tag.
+func (m TagMatcher) Match(v reflect.Value, d data.Data, r Region) (data.Data, bool) {
+	lit, _ := v.Interface().(*ast.BasicLit)
+	if lit == nil {
+		return d, false
+	}
+
+	s, err := unquoteTag(lit)
+	if err != nil {
+		return d, false
+	}
+	tag, err := parseStructTag(s)
+	if err != nil {
+		return d, false
+	}
+
+	tm := tagMatch{
+		Tag:   lit,
+		Pairs: tag,
+		Match: make([]tagPairMatch, len(m.Pattern)),
+	}
+	d, ok := m.matchPairs(tm, 0, make([]bool, len(tag)), d, r)
+	return d, ok
+}
+
+func (m TagMatcher) matchPairs(tm tagMatch, i int, used []bool, d data.Data, r Region) (data.Data, bool) {
+	if i == len(m.Pattern) {
+		// Copy the match because the slices are reused while
+		// backtracking.
+		tm.Match = append([]tagPairMatch(nil), tm.Match...)
+		return pushTagMatch(m.Fset, d, m.Pos, tm), true
+	}
+
+	p := m.Pattern[i]
+	for j, pair := range tm.Pairs {
+		if used[j] {
+			continue
+		}
+
+		pd, ok := m.matchElem(p.Key, pair.Key, tm.Tag, d, r)
+		if !ok {
+			continue
+		}
+
+		pd, dots, ok := m.matchValues(p.Values, strings.Split(pair.Value, ","), tm.Tag, pd, r)
+		if !ok {
+			continue
+		}
+
+		used[j] = true
+		tm.Match[i] = tagPairMatch{Index: j, Dots: dots}
+		if pd, ok := m.matchPairs(tm, i+1, used, pd, r); ok {
+			return pd, true
+		}
+		used[j] = false
+	}
+	return d, false
+}
+
+// matchValues matches the comma-separated parts of a value, returning the
+// parts matched by each "...".
+func (m TagMatcher) matchValues(elems []tagElem, parts []string, lit *ast.BasicLit, d data.Data, r Region) (data.Data, [][]string, bool) {
+	if len(elems) == 0 {
+		return d, nil, len(parts) == 0
+	}
+
+	e := elems[0]
+	if e.Dots {
+		for n := 0; n <= len(parts); n++ {
+			if d, dots, ok := m.matchValues(elems[1:], parts[n:], lit, d, r); ok {
+				return d, append([][]string{parts[:n]}, dots...), true
+			}
+		}
+		return d, nil, false
+	}
+
+	if len(parts) == 0 {
+		return d, nil, false
+	}
+	d, ok := m.matchElem(e, parts[0], lit, d, r)
+	if !ok {
+		return d, nil, false
+	}
+	return m.matchValues(elems[1:], parts[1:], lit, d, r)
+}
+
+// matchElem matches a key or a part of a value.
+func (m TagMatcher) matchElem(e tagElem, text string, lit *ast.BasicLit, d data.Data, r Region) (data.Data, bool) {
+	if !e.Metavar {
+		return d, e.Text == text
+	}
+
+	mm := MetavarMatcher{
+		Fset:        m.Fset,
+		Name:        e.Text,
+		TypeMatches: isIdent,
+	}
+	return mm.Match(reflect.ValueOf(&ast.Ident{Name: text, NamePos: lit.ValuePos}), d, r)
+}
+
+type tagMatchKey posMatchKey
+
+// tagMatch is the match of a struct tag in the source.
+type tagMatch struct {
+	Tag   *ast.BasicLit
+	Pairs structTag
+
+	// Match for each pair in the pattern.
+	Match []tagPairMatch
+}
+
+type tagPairMatch struct {
+	// Index of the matched pair in the tag.
+	Index int
+
+	// Parts of the value matched by each "...".
+	Dots [][]string
+}
+
+func pushTagMatch(fset *token.FileSet, d data.Data, patchPos token.Pos, m tagMatch) data.Data {
+	p := fset.Position(patchPos)
+	return data.WithValue(d, tagMatchKey{Line: p.Line, Column: p.Column}, m)
+}
+
+func lookupTagMatch(fset *token.FileSet, d data.Data, patchPos token.Pos) (m tagMatch, ok bool) {
+	p := fset.Position(patchPos)
+	ok = data.Lookup(d, tagMatchKey{Line: p.Line, Column: p.Column}, &m)
+	return m, ok
+}
+
+// TagReplacer generates struct field tags.
+//
+// If the field's tag was matched by the "-" section of the patch, only the
+// keys listed in the patch are changed: keys that were removed from the
+// patch are deleted, keys that were renamed or whose values changed are
+// updated in place, and new keys are added next to the keys preceding them
+// in the patch. Other keys of the tag are left as-is. This includes keys
+// that the patch adds but that the tag already has: their values are kept.
+//
+// "..." in the value of a key reproduces the parts matched by "..." in the
+// value of the same key in the "-" section.
+type TagReplacer struct {
+	Fset *token.FileSet
+
+	// Position of the tag in the patch.
+	Pos token.Pos
+
+	Pattern tagPattern
+
+	// Tag in the "-" section of the patch for the same field, if any.
+	MinusPos     token.Pos
+	MinusPattern tagPattern
+
+	// Replacers for metavariables in the tag.
+	Metavars map[string]Replacer
+}
+
+func (c *replacerCompiler) compileField(v reflect.Value) Replacer {
+	r := c.compileStruct(v)
+
+	tag := v.Field(tagFieldIndex).Interface().(*ast.BasicLit)
+	if tag == nil || c.meta == nil {
+		return r
+	}
+
+	pattern, err := compileTagPattern(tag, c.meta)
+	if err != nil {
+		// Reported by checkStructTags.
+		return r
+	}
+
+	tr := TagReplacer{
+		Fset:     c.fset,
+		Pos:      tag.ValuePos,
+		Pattern:  pattern,
+		Metavars: make(map[string]Replacer),
+	}
+	if minus := c.tags[tag]; minus != nil {
+		if mp, err := compileTagPattern(minus, c.meta); err == nil {
+			tr.MinusPos = minus.ValuePos
+			tr.MinusPattern = mp
+		}
+	}
+	for _, ref := range tagMetavars(tag, c.meta) {
+		tr.Metavars[ref.Name] = c.compileIdent(reflect.ValueOf(ref))
+	}
+
+	sr := r.(StructReplacer)
+	fields := append([]Replacer(nil), sr.Fields...)
+	fields[tagFieldIndex] = tr
+	sr.Fields = fields
+	return sr
+}
+
+// Replace generates a struct tag.
+func (r TagReplacer) Replace(d data.Data, cl Changelog, pos token.Pos) (reflect.Value, error) {
+	var (
+		tag      structTag
+		minus    tagMatch
+		matched  bool
+		backtick = true
+	)
+	if r.MinusPattern != nil {
+		minus, matched = lookupTagMatch(r.Fset, d, r.MinusPos)
+	}
+
+	if matched {
+		tag = append(structTag(nil), minus.Pairs...)
+		backtick = strings.HasPrefix(minus.Tag.Value, "`")
+	}
+
+	// Resolved keys of the pairs in the "+" section.
+	keys := make([]string, len(r.Pattern))
+	values := make([]string, len(r.Pattern))
+	for i, p := range r.Pattern {
+		key, err := r.resolve(d, cl, pos, p.Key)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		keys[i] = key
+
+		dots := r.dots(p, minus, matched)
+		parts := make([]string, 0, len(p.Values))
+		for _, e := range p.Values {
+			if e.Dots {
+				if len(dots) == 0 {
+					return reflect.Value{}, fmt.Errorf(`%v: no value matched for "..." in struct tag key %q`,
+						r.Fset.Position(r.Pos), key)
+				}
+				parts = append(parts, dots[0]...)
+				dots = dots[1:]
+				continue
+			}
+
+			part, err := r.resolve(d, cl, pos, e)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			parts = append(parts, part)
+		}
+		values[i] = strings.Join(parts, ",")
+	}
+
+	// Keys of the tag matched by the "-" section. Only these may change.
+	matchedKeys := make(map[string]struct{}, len(minus.Match))
+	for _, pm := range minus.Match {
+		matchedKeys[minus.Pairs[pm.Index].Key] = struct{}{}
+	}
+
+	if matched {
+		// Delete keys that were matched but aren't in the "+" section. If
+		// the "+" section has a new key in the same place, the key was
+		// renamed so the new key takes its place.
+		deleted := make(map[int]struct{})
+		for i, pm := range minus.Match {
+			key := minus.Pairs[pm.Index].Key
+			switch {
+			case containsString(keys, key):
+				// Kept.
+			case i < len(keys) && tag.index(keys[i]) < 0:
+				tag[pm.Index] = tagPair{Key: keys[i], Value: values[i]}
+			default:
+				deleted[pm.Index] = struct{}{}
+			}
+		}
+		kept := tag[:0]
+		for i, p := range tag {
+			if _, ok := deleted[i]; !ok {
+				kept = append(kept, p)
+			}
+		}
+		tag = kept
+	}
+
+	// Index in tag after the last pair from the "+" section.
+	next := -1
+	for i, key := range keys {
+		if j := tag.index(key); j >= 0 {
+			_, ok := matchedKeys[key]
+			if ok && tag[j].Value != values[i] {
+				tag[j] = tagPair{Key: key, Value: values[i]}
+			}
+			next = j + 1
+			continue
+		}
+
+		at := next
+		if at < 0 {
+			// Place new keys before the first key that follows them
+			// in the patch.
+			at = len(tag)
+			for _, k := range keys[i+1:] {
+				if j := tag.index(k); j >= 0 {
+					at = j
+					break
+				}
+			}
+		}
+
+		tag = append(tag[:at], append(structTag{{Key: key, Value: values[i]}}, tag[at:]...)...)
+		next = at + 1
+	}
+
+	if len(tag) == 0 {
+		return reflect.Zero(reflect.TypeOf((*ast.BasicLit)(nil))), nil
+	}
+
+	s := tag.String()
+	value := strconv.Quote(s)
+	if backtick && strconv.CanBackquote(s) {
+		value = "`" + s + "`"
+	}
+
+	if matchedPos := lookupPosMatch(r.Fset, d, r.Pos); matchedPos.IsValid() {
+		pos = matchedPos
+	}
+	return reflect.ValueOf(&ast.BasicLit{
+		ValuePos: pos,
+		Kind:     token.STRING,
+		Value:    value,
+	}), nil
+}
+
+// dots returns the parts matched by "..." in the value of the pair with the
+// same key in the "-" section.
+func (r TagReplacer) dots(p tagPairPattern, minus tagMatch, matched bool) [][]string {
+	if !matched || p.dots() == 0 {
+		return nil
+	}
+
+	for i, mp := range r.MinusPattern {
+		if mp.sameKey(p) {
+			return minus.Match[i].Dots
+		}
+	}
+	return nil
+}
+
+// resolve returns the text for a key or part of a value.
+func (r TagReplacer) resolve(d data.Data, cl Changelog, pos token.Pos, e tagElem) (string, error) {
+	if !e.Metavar {
+		return e.Text, nil
+	}
+
+	v, err := r.Metavars[e.Text].Replace(d, cl, pos)
+	if err != nil {
+		return "", err
+	}
+	ident, ok := v.Interface().(*ast.Ident)
+	if !ok || ident == nil {
+		return "", fmt.Errorf("%v: metavariable %q used in struct tag must be an identifier",
+			r.Fset.Position(r.Pos), e.Text)
+	}
+	return ident.Name, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// pairStructTags pairs the struct tags in the "+" section of a patch with
+// the tags of the same fields in the "-" section. Fields are identified by
+// their names.
+func pairStructTags(minus, plus *pgo.File) map[*ast.BasicLit]*ast.BasicLit {
+	minusFields := taggedFields(minus.Node)
+	if len(minusFields) == 0 {
+		return nil
+	}
+
+	used := make([]bool, len(minusFields))
+	pairs := make(map[*ast.BasicLit]*ast.BasicLit)
+	for _, pf := range taggedFields(plus.Node) {
+		for i, mf := range minusFields {
+			if !used[i] && fieldNames(mf) == fieldNames(pf) {
+				used[i] = true
+				pairs[pf.Tag] = mf.Tag
+				break
+			}
+		}
+	}
+	return pairs
+}
+
+func fieldNames(f *ast.Field) string {
+	names := make([]string, len(f.Names))
+	for i, n := range f.Names {
+		names[i] = n.Name
+	}
+	return strings.Join(names, ",")
+}
+
+// taggedFields returns the struct fields with tags in the given node.
+func taggedFields(n pgo.Node) []*ast.Field {
+	var fields []*ast.Field
+	var visit func(reflect.Value)
+	visit = func(v reflect.Value) {
+		if !v.IsValid() {
+			return
+		}
+
+		switch v.Type() {
+		case goast.FieldPtrType:
+			if f := v.Interface().(*ast.Field); f != nil && f.Tag != nil {
+				fields = append(fields, f)
+			}
+		case goast.ObjectPtrType:
+			// Ident.Obj forms a cycle.
+			return
+		}
+
+		switch v.Kind() {
+		case reflect.Array, reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				visit(v.Index(i))
+			}
+		case reflect.Interface, reflect.Ptr:
+			visit(v.Elem())
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				visit(v.Field(i))
+			}
+		}
+	}
+	visit(reflect.ValueOf(n))
+	return fields
+}
+
+// checkStructTags reports errors for malformed struct tags in the given
+// patch, for metavariables in them that aren't identifiers, and for "..."
+// in the "+" section that doesn't have a counterpart in the "-" section.
+func (c *compiler) checkStructTags(meta *Meta, patch *parse.Patch, pairs map[*ast.BasicLit]*ast.BasicLit) {
+	seen := make(map[token.Pos]struct{})
+	check := func(f *ast.Field) (tagPattern, bool) {
+		if _, dup := seen[f.Tag.Pos()]; dup {
+			// Alternatives share positions with Minus.
+			return nil, false
+		}
+		seen[f.Tag.Pos()] = struct{}{}
+
+		// Tags that don't follow the conventions of reflect.StructTag are
+		// matched and reproduced literally.
+		if s, err := unquoteTag(f.Tag); err != nil {
+			return nil, false
+		} else if _, err := parseStructTag(s); err != nil {
+			return nil, false
+		}
+
+		pattern, err := compileTagPattern(f.Tag, meta)
+		if err != nil {
+			c.errf(f.Tag.Pos(), "invalid struct tag %v: %v", f.Tag.Value, err)
+			return nil, false
+		}
+
+		for _, p := range pattern {
+			for _, e := range append([]tagElem{p.Key}, p.Values...) {
+				if !e.Metavar {
+					continue
+				}
+				switch t := meta.LookupVar(e.Text); t {
+				case IdentMetavarType, FreshIdentMetavarType, ComputedIdentMetavarType:
+				default:
+					c.errf(f.Tag.Pos(), "%v %q cannot be used in a struct tag: "+
+						"only identifier metavariables are allowed", t, e.Text)
+				}
+			}
+		}
+		return pattern, true
+	}
+
+	for _, f := range append([]*pgo.File{patch.Minus}, patch.Alternatives...) {
+		for _, field := range taggedFields(f.Node) {
+			check(field)
+		}
+	}
+
+	for _, field := range taggedFields(patch.Plus.Node) {
+		pattern, ok := check(field)
+		if !ok {
+			continue
+		}
+
+		var minus tagPattern
+		if m := pairs[field.Tag]; m != nil {
+			minus, _ = compileTagPattern(m, meta)
+		}
+
+	pairs:
+		for _, p := range pattern {
+			n := p.dots()
+			if n == 0 {
+				continue
+			}
+			for _, mp := range minus {
+				if mp.sameKey(p) && mp.dots() >= n {
+					continue pairs
+				}
+			}
+			c.errf(field.Tag.Pos(), `"..." in the value of struct tag key %q `+
+				`must match "..." in the value of the same key in the "-" section`, p.Key.Text)
+		}
+	}
+}
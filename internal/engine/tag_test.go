@@ -0,0 +1,296 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestParseStructTag(t *testing.T) {
+	tests := []struct {
+		desc    string
+		give    string
+		want    structTag
+		wantErr string
+	}{
+		{desc: "empty"},
+		{
+			desc: "single",
+			give: `json:"name"`,
+			want: structTag{{Key: "json", Value: "name", raw: `"name"`}},
+		},
+		{
+			desc: "multiple",
+			give: `json:"name,omitempty"  yaml:"name"`,
+			want: structTag{
+				{Key: "json", Value: "name,omitempty", raw: `"name,omitempty"`},
+				{Key: "yaml", Value: "name", raw: `"name"`},
+			},
+		},
+		{
+			desc: "escaped quote",
+			give: `foo:"a\"b"`,
+			want: structTag{{Key: "foo", Value: `a"b`, raw: `"a\"b"`}},
+		},
+		{
+			desc:    "missing value",
+			give:    `json`,
+			wantErr: `bad syntax for struct tag pair "json"`,
+		},
+		{
+			desc:    "unquoted value",
+			give:    `json:name`,
+			wantErr: `bad syntax for struct tag pair "json:name"`,
+		},
+		{
+			desc:    "unterminated value",
+			give:    `json:"name`,
+			wantErr: `bad syntax for struct tag value of "json"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := parseStructTag(tt.give)
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStructTagString(t *testing.T) {
+	tag := structTag{
+		{Key: "json", Value: "name", raw: `"name"`},
+		{Key: "db", Value: `a"b`},
+	}
+	assert.Equal(t, `json:"name" db:"a\"b"`, tag.String())
+}
+
+func TestTagMatcher(t *testing.T) {
+	meta := &Meta{Vars: map[string]MetavarType{
+		"name": IdentMetavarType,
+		"key":  IdentMetavarType,
+	}}
+
+	tests := []struct {
+		desc    string
+		pattern string
+		give    string
+		wantOK  bool
+	}{
+		{
+			desc:    "exact",
+			pattern: "`json:\"id\"`",
+			give:    "`json:\"id\"`",
+			wantOK:  true,
+		},
+		{
+			desc:    "value mismatch",
+			pattern: "`json:\"id\"`",
+			give:    "`json:\"name\"`",
+		},
+		{
+			desc:    "missing key",
+			pattern: "`json:\"id\" yaml:\"id\"`",
+			give:    "`json:\"id\"`",
+		},
+		{
+			desc:    "extra keys",
+			pattern: "`json:\"id\"`",
+			give:    "`db:\"user_id\" json:\"id\" yaml:\"id\"`",
+			wantOK:  true,
+		},
+		{
+			desc:    "any order",
+			pattern: "`yaml:\"id\" json:\"id\"`",
+			give:    "`json:\"id\" yaml:\"id\"`",
+			wantOK:  true,
+		},
+		{
+			desc:    "double quoted",
+			pattern: "`json:\"id\"`",
+			give:    `"json:\"id\""`,
+			wantOK:  true,
+		},
+		{
+			desc:    "metavar value",
+			pattern: "`json:\"name\"`",
+			give:    "`json:\"id\"`",
+			wantOK:  true,
+		},
+		{
+			desc:    "metavar value/extra options",
+			pattern: "`json:\"name\"`",
+			give:    "`json:\"id,omitempty\"`",
+		},
+		{
+			desc:    "metavar repeated",
+			pattern: "`json:\"name\" yaml:\"name\"`",
+			give:    "`json:\"id\" yaml:\"id\"`",
+			wantOK:  true,
+		},
+		{
+			desc:    "metavar repeated/mismatch",
+			pattern: "`json:\"name\" yaml:\"name\"`",
+			give:    "`json:\"id\" yaml:\"ID\"`",
+		},
+		{
+			desc:    "metavar key",
+			pattern: "`key:\"id\"`",
+			give:    "`db:\"user_id\" yaml:\"id\"`",
+			wantOK:  true,
+		},
+		{
+			desc:    "metavar key/backtracks",
+			pattern: "`key:\"name\" json:\"name\"`",
+			give:    "`json:\"id\" db:\"user_id\" yaml:\"id\"`",
+			wantOK:  true,
+		},
+		{
+			desc:    "dots/empty",
+			pattern: "`json:\"name,...\"`",
+			give:    "`json:\"id\"`",
+			wantOK:  true,
+		},
+		{
+			desc:    "dots/options",
+			pattern: "`json:\"name,...\"`",
+			give:    "`json:\"id,omitempty,string\"`",
+			wantOK:  true,
+		},
+		{
+			desc:    "dots/middle",
+			pattern: "`json:\"name,...,string\"`",
+			give:    "`json:\"id,omitempty,string\"`",
+			wantOK:  true,
+		},
+		{
+			desc:    "dots/middle mismatch",
+			pattern: "`json:\"name,...,string\"`",
+			give:    "`json:\"id,omitempty\"`",
+		},
+		{
+			desc:    "malformed tag",
+			pattern: "`json:\"id\"`",
+			give:    "`json:id`",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			file := fset.AddFile("test.patch", -1, 100)
+			lit := &ast.BasicLit{
+				ValuePos: file.Pos(0),
+				Kind:     token.STRING,
+				Value:    tt.pattern,
+			}
+
+			pattern, err := compileTagPattern(lit, meta)
+			require.NoError(t, err)
+
+			m := TagMatcher{Fset: fset, Pos: lit.ValuePos, Pattern: pattern}
+			give := &ast.BasicLit{Kind: token.STRING, Value: tt.give}
+			_, ok := m.Match(reflect.ValueOf(give), data.New(), Region{})
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
+
+func TestCompileStructTagErrors(t *testing.T) {
+	tests := []struct {
+		desc    string
+		give    []byte
+		wantErr string // empty if it should compile
+	}{
+		{
+			// Matched literally.
+			desc: "malformed",
+			give: text.Unlines(
+				"@@",
+				"@@",
+				" type User struct {",
+				"-  ID string `json:id`",
+				"+  ID string `json:\"id\"`",
+				" }",
+			),
+		},
+		{
+			desc: "expression metavariable",
+			give: text.Unlines(
+				"@@",
+				"var x expression",
+				"@@",
+				" type User struct {",
+				"-  ID string `json:\"x\"`",
+				"+  ID string",
+				" }",
+			),
+			wantErr: `test.patch:5:14: expression "x" cannot be used in a struct tag: ` +
+				`only identifier metavariables are allowed`,
+		},
+		{
+			desc: "unmatched dots",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				"@@",
+				" type User struct {",
+				"-  ID string `json:\"name\"`",
+				"+  ID string `json:\"name,...\"`",
+				" }",
+			),
+			wantErr: `test.patch:6:14: "..." in the value of struct tag key "json" ` +
+				`must match "..." in the value of the same key in the "-" section`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", tt.give)
+			require.NoError(t, err)
+
+			_, err = Compile(fset, prog)
+			if len(tt.wantErr) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+)
+
+// TypedFile is a Go file loaded with type information.
+type TypedFile struct {
+	File  *ast.File
+	Types *TypeInfo
+}
+
+// LoadTypes parses and type-checks the packages in the directories holding
+// the given files, returning the files of those packages keyed by their
+// absolute paths. Imported packages are type-checked from source.
+//
+// Problems loading packages are reported to onError. Files that could not
+// be loaded are left out of the result, while packages that don't
+// type-check are returned with the partial information available for them.
+func LoadTypes(fset *token.FileSet, filenames []string, onError func(error)) map[string]TypedFile {
+	dirs := make(map[string]struct{})
+	for _, filename := range filenames {
+		if abs, err := filepath.Abs(filename); err == nil {
+			dirs[filepath.Dir(abs)] = struct{}{}
+		}
+	}
+	sortedDirs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sortedDirs = append(sortedDirs, dir)
+	}
+	sort.Strings(sortedDirs)
+
+	l := typeLoader{
+		fset:     fset,
+		importer: importer.ForCompiler(fset, "source", nil),
+		modules:  newModuleCache(),
+		onError:  onError,
+		files:    make(map[string]TypedFile),
+	}
+	for _, dir := range sortedDirs {
+		l.loadDir(dir)
+	}
+	return l.files
+}
+
+type typeLoader struct {
+	fset     *token.FileSet
+	importer types.Importer
+	modules  *moduleCache
+	onError  func(error)
+	files    map[string]TypedFile
+}
+
+// loadDir type-checks the package in the given directory along with its
+// tests.
+func (l *typeLoader) loadDir(dir string) {
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		l.onError(err)
+		return
+	}
+
+	importPath, _, ok := l.modules.ImportPath(dir)
+	if !ok {
+		importPath = bp.ImportPath
+		if importPath == "." {
+			importPath = bp.Name
+		}
+	}
+
+	var files []string
+	files = append(files, bp.GoFiles...)
+	files = append(files, bp.CgoFiles...)
+	files = append(files, bp.TestGoFiles...)
+	l.check(dir, importPath, files)
+
+	if len(bp.XTestGoFiles) > 0 {
+		l.check(dir, importPath+"_test", bp.XTestGoFiles)
+	}
+}
+
+// check type-checks a package made up of the given files in a directory.
+func (l *typeLoader) check(dir, importPath string, names []string) {
+	files := make([]*ast.File, 0, len(names))
+	for _, name := range names {
+		filename := filepath.Join(dir, name)
+		f, err := parser.ParseFile(l.fset, filename, nil, parser.AllErrors|parser.ParseComments)
+		if err != nil {
+			l.onError(err)
+			continue
+		}
+		files = append(files, f)
+	}
+
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	conf := types.Config{
+		Importer:    l.importer,
+		FakeImportC: true,
+		Error:       l.onError,
+	}
+
+	// Errors were reported to onError. The package holds all the
+	// information that could be determined regardless.
+	pkg, _ := conf.Check(importPath, l.fset, files, info)
+
+	ti := &TypeInfo{Pkg: pkg, Info: info}
+	for _, f := range files {
+		l.files[l.fset.File(f.Pos()).Name()] = TypedFile{File: f, Types: ti}
+	}
+}
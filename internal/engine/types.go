@@ -0,0 +1,292 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	"github.com/uber-go/gopatch/internal/data"
+	"github.com/uber-go/gopatch/internal/parse"
+)
+
+// TypeInfo holds type information for a file being matched.
+//
+// Metavariables with type constraints use it to check the types of the
+// values they match. Without type information, or for code that did not
+// type-check, they match values of any type.
+type TypeInfo struct {
+	// Package the file belongs to.
+	Pkg *types.Package
+
+	// Type information recorded while checking the package.
+	Info *types.Info
+
+	pkgs        map[string]*types.Package      // import path => package
+	constraints map[*typeConstraint]types.Type // nil if unresolved
+}
+
+// lookupPackage finds the package with the given import path among the
+// file's package and its transitive imports.
+func (ti *TypeInfo) lookupPackage(path string) *types.Package {
+	if ti.pkgs == nil {
+		ti.pkgs = make(map[string]*types.Package)
+		var visit func(*types.Package)
+		visit = func(pkg *types.Package) {
+			if _, ok := ti.pkgs[pkg.Path()]; ok {
+				return
+			}
+			ti.pkgs[pkg.Path()] = pkg
+			for _, imp := range pkg.Imports() {
+				visit(imp)
+			}
+		}
+		if ti.Pkg != nil {
+			visit(ti.Pkg)
+		}
+	}
+	return ti.pkgs[path]
+}
+
+// resolve returns the type named by a type constraint, or nil if it refers
+// to packages that aren't available to the file.
+func (ti *TypeInfo) resolve(tc *typeConstraint) types.Type {
+	if t, ok := ti.constraints[tc]; ok {
+		return t
+	}
+	if ti.constraints == nil {
+		ti.constraints = make(map[*typeConstraint]types.Type)
+	}
+
+	t := tc.resolve(ti)
+	ti.constraints[tc] = t
+	return t
+}
+
+type typeInfoKey struct{}
+
+// withTypes attaches type information for the file to the given Data.
+func withTypes(d data.Data, ti *TypeInfo) data.Data {
+	if ti == nil || ti.Info == nil {
+		return d
+	}
+	return data.WithValue(d, typeInfoKey{}, ti)
+}
+
+func lookupTypes(d data.Data) *TypeInfo {
+	var ti *TypeInfo
+	data.Lookup(d, typeInfoKey{}, &ti)
+	return ti
+}
+
+// copyTypes records the type information of the nodes in from for the
+// nodes in to, a copy of from made by a replacer. Nodes that replacers
+// create from scratch have no type information.
+func (ti *TypeInfo) copyTypes(from, to reflect.Value) {
+	if ti == nil || !from.IsValid() || !to.IsValid() {
+		return
+	}
+	src, srcOk := from.Interface().(ast.Node)
+	dst, dstOk := to.Interface().(ast.Node)
+	if !srcOk || !dstOk {
+		return
+	}
+
+	var srcNodes, dstNodes []ast.Node
+	ast.Inspect(src, func(n ast.Node) bool {
+		srcNodes = append(srcNodes, n)
+		return true
+	})
+	ast.Inspect(dst, func(n ast.Node) bool {
+		dstNodes = append(dstNodes, n)
+		return true
+	})
+	if len(srcNodes) != len(dstNodes) {
+		// Not a copy.
+		return
+	}
+
+	info := ti.Info
+	for i, n := range srcNodes {
+		if n == nil || n == dstNodes[i] || reflect.TypeOf(n) != reflect.TypeOf(dstNodes[i]) {
+			continue
+		}
+
+		if e, ok := n.(ast.Expr); ok {
+			if tv, ok := info.Types[e]; ok {
+				info.Types[dstNodes[i].(ast.Expr)] = tv
+			}
+		}
+		switch n := n.(type) {
+		case *ast.Ident:
+			if obj := info.Uses[n]; obj != nil {
+				info.Uses[dstNodes[i].(*ast.Ident)] = obj
+			}
+			if obj := info.Defs[n]; obj != nil {
+				info.Defs[dstNodes[i].(*ast.Ident)] = obj
+			}
+		case *ast.SelectorExpr:
+			if s := info.Selections[n]; s != nil {
+				info.Selections[dstNodes[i].(*ast.SelectorExpr)] = s
+			}
+		}
+	}
+}
+
+// qualifiedNameRe matches names qualified by the import paths of their
+// packages, like "net/http.Client" or "gopkg.in/yaml.v3.Node".
+var qualifiedNameRe = regexp.MustCompile(`([\w\-~.]+(?:/[\w\-~.]+)*)\.([\pL_][\pL\pN_]*)`)
+
+// typeConstraint is a compiled type constraint on a metavariable.
+//
+//	var x expression : *net/http.Client
+//	var c expression implements io.Closer
+type typeConstraint struct {
+	// Whether matched values must implement the type instead of having it.
+	Implements bool
+
+	// Type as written in the patch.
+	Text string
+
+	// Type with the import paths replaced by references to placeholder
+	// package names, and the import paths in the order of those
+	// placeholders.
+	Expr  string
+	Paths []string
+}
+
+// pkgPlaceholder returns the name used for the package with the given
+// index in a typeConstraint.
+func pkgPlaceholder(i int) string {
+	return "__pkg" + strconv.Itoa(i)
+}
+
+func compileTypeConstraint(c *parse.TypeConstraint) (*typeConstraint, error) {
+	tc := typeConstraint{Implements: c.Implements, Text: c.Type}
+
+	indexes := make(map[string]int)
+	tc.Expr = qualifiedNameRe.ReplaceAllStringFunc(c.Type, func(s string) string {
+		m := qualifiedNameRe.FindStringSubmatch(s)
+		path, name := m[1], m[2]
+		i, ok := indexes[path]
+		if !ok {
+			i = len(tc.Paths)
+			indexes[path] = i
+			tc.Paths = append(tc.Paths, path)
+		}
+		return pkgPlaceholder(i) + "." + name
+	})
+
+	expr, err := parser.ParseExpr(tc.Expr)
+	if err != nil {
+		return nil, errors.New("not a valid Go type")
+	}
+
+	// Names that aren't qualified by their packages must be predeclared.
+	var (
+		undefined string
+		visit     func(ast.Node) bool
+	)
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			return false
+		case *ast.Field:
+			// Skip the names of parameters and struct fields.
+			ast.Inspect(n.Type, visit)
+			return false
+		case *ast.Ident:
+			if _, ok := types.Universe.Lookup(n.Name).(*types.TypeName); !ok && len(undefined) == 0 {
+				undefined = n.Name
+			}
+		}
+		return true
+	}
+	ast.Inspect(expr, visit)
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("undefined: %v: "+
+			"types must be qualified by the import paths of their packages", undefined)
+	}
+
+	return &tc, nil
+}
+
+// resolve evaluates the type constraint against the packages available to
+// the file, returning nil if that isn't possible.
+func (tc *typeConstraint) resolve(ti *TypeInfo) types.Type {
+	pkg := types.NewPackage("gopatch/constraint", "constraint")
+	for i, path := range tc.Paths {
+		imp := ti.lookupPackage(path)
+		if imp == nil {
+			return nil
+		}
+		pkg.Scope().Insert(types.NewPkgName(token.NoPos, pkg, pkgPlaceholder(i), imp))
+	}
+
+	tv, err := types.Eval(token.NewFileSet(), pkg, token.NoPos, tc.Expr)
+	if err != nil || !tv.IsType() {
+		return nil
+	}
+	return tv.Type
+}
+
+// Match reports whether the given value satisfies the type constraint.
+//
+// Values without type information always satisfy it so that code that
+// doesn't type-check is still matched syntactically. Values that earlier
+// changes moved carry the type information of the original code.
+func (tc *typeConstraint) Match(v reflect.Value, d data.Data) bool {
+	ti := lookupTypes(d)
+	if ti == nil {
+		return true
+	}
+
+	expr, ok := v.Interface().(ast.Expr)
+	if !ok || reflect.ValueOf(expr).IsNil() {
+		return true
+	}
+
+	got := ti.Info.TypeOf(expr)
+	if got == nil || got == types.Typ[types.Invalid] {
+		return true
+	}
+	got = types.Default(got)
+
+	want := ti.resolve(tc)
+	if want == nil {
+		// The packages named by the constraint aren't available to
+		// the file, so none of its values can have the type.
+		return false
+	}
+
+	if tc.Implements {
+		iface, ok := want.Underlying().(*types.Interface)
+		return ok && types.Implements(got, iface)
+	}
+	return types.Identical(got, want)
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestTypeConstraints(t *testing.T) {
+	src := text.Unlines(
+		"package foo",
+		"",
+		"type Rows struct{}",
+		"",
+		"func (*Rows) Close() error { return nil }",
+		"",
+		"type File struct{}",
+		"",
+		"func (File) Close() error { return nil }",
+		"",
+		"type Closer interface{ Close() error }",
+		"",
+		"func run(r *Rows, f File, c Closer) {",
+		"	r.Close()",
+		"	f.Close()",
+		"	c.Close()",
+		"	unknown().Close()",
+		"}",
+	)
+
+	tests := []struct {
+		desc       string
+		constraint string
+		noTypes    bool   // match without type information
+		want       []bool // whether each call is changed
+	}{
+		{
+			desc:       "type/pointer",
+			constraint: ": *example.com/foo.Rows",
+			want:       []bool{true, false, false, true},
+		},
+		{
+			desc:       "type/named",
+			constraint: ": example.com/foo.File",
+			want:       []bool{false, true, false, true},
+		},
+		{
+			desc:       "type/interface",
+			constraint: ": example.com/foo.Closer",
+			want:       []bool{false, false, true, true},
+		},
+		{
+			desc:       "implements",
+			constraint: "implements example.com/foo.Closer",
+			want:       []bool{true, true, true, true},
+		},
+		{
+			desc:       "implements/literal",
+			constraint: "implements interface{ Close() error }",
+			want:       []bool{true, true, true, true},
+		},
+		{
+			desc:       "implements/not an interface",
+			constraint: "implements example.com/foo.File",
+			want:       []bool{false, false, false, true},
+		},
+		{
+			desc:       "unknown package",
+			constraint: ": *database/sql.Rows",
+			want:       []bool{false, false, false, true},
+		},
+		{
+			desc:       "no type information",
+			constraint: ": *example.com/foo.Rows",
+			noTypes:    true,
+			want:       []bool{true, true, true, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", text.Unlines(
+				"@@",
+				"var x expression "+tt.constraint,
+				"@@",
+				"-x.Close()",
+				"+x.Shutdown()",
+			))
+			require.NoError(t, err)
+
+			p, err := Compile(fset, prog)
+			require.NoError(t, err)
+
+			file, err := parser.ParseFile(fset, "foo.go", src, 0)
+			require.NoError(t, err)
+
+			bindings := NewBindings()
+			if !tt.noTypes {
+				bindings.Types = typeCheck(t, fset, file)
+			}
+
+			change := p.Changes[0]
+			got := file
+			if d, ok := change.Match(file, bindings); ok {
+				got, err = change.Replace(d, NewChangelog())
+				require.NoError(t, err)
+			}
+
+			body := got.Decls[len(got.Decls)-1].(*ast.FuncDecl).Body
+			require.Len(t, body.List, len(tt.want))
+			for i, want := range tt.want {
+				var buf bytes.Buffer
+				require.NoError(t, format.Node(&buf, fset, body.List[i]))
+				assert.Equal(t, want, bytes.Contains(buf.Bytes(), []byte("Shutdown")),
+					"statement %d: %s", i, buf.String())
+			}
+		})
+	}
+}
+
+func TestTypeConstraintsChained(t *testing.T) {
+	fset := token.NewFileSet()
+	prog, err := parse.Parse(fset, "test.patch", text.Unlines(
+		"@@",
+		"var x expression : *example.com/foo.File",
+		"@@",
+		"-x.Close()",
+		"+x.Shutdown()",
+		"",
+		"@@",
+		"var y expression : *example.com/foo.Reader",
+		"@@",
+		"-y.Shutdown()",
+		`+y.Reset("")`,
+		"",
+		"@@",
+		"var z expression : *example.com/foo.File",
+		"@@",
+		"-z.Shutdown()",
+		"+z.Stop()",
+	))
+	require.NoError(t, err)
+
+	p, err := Compile(fset, prog)
+	require.NoError(t, err)
+
+	file, err := parser.ParseFile(fset, "foo.go", text.Unlines(
+		"package foo",
+		"",
+		"type File struct{}",
+		"",
+		"func (*File) Close() error    { return nil }",
+		"func (*File) Shutdown() error { return nil }",
+		"func (*File) Stop() error     { return nil }",
+		"",
+		"type Reader struct{}",
+		"",
+		"func (*Reader) Shutdown() error { return nil }",
+		"func (*Reader) Reset(string)    {}",
+		"",
+		"func run(f *File, r *Reader) {",
+		"	f.Close()",
+		"	r.Shutdown()",
+		"}",
+	), 0)
+	require.NoError(t, err)
+
+	bindings := NewBindings()
+	bindings.Types = typeCheck(t, fset, file)
+
+	// The second change must not match f.Shutdown() written by the
+	// first, but the third must.
+	for _, c := range p.Changes {
+		if d, ok := c.Match(file, bindings); ok {
+			file, err = c.Replace(d, NewChangelog())
+			require.NoError(t, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, format.Node(&buf, fset, file.Decls[len(file.Decls)-1]))
+	assert.Equal(t, string(text.Unlines(
+		"func run(f *File, r *Reader) {",
+		"	f.Stop()",
+		`	r.Reset("")`,
+		"}",
+	)), buf.String()+"\n")
+}
+
+// typeCheck type-checks a file in the package example.com/foo, ignoring
+// errors. Imported packages are type-checked from source.
+func typeCheck(t *testing.T, fset *token.FileSet, file *ast.File) *TypeInfo {
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	pkg, _ := conf.Check("example.com/foo", fset, []*ast.File{file}, info)
+	return &TypeInfo{Pkg: pkg, Info: info}
+}
+
+func TestCompileTypeConstraintErrors(t *testing.T) {
+	tests := []struct {
+		desc    string
+		give    string
+		wantErr string
+	}{
+		{
+			desc: "unqualified name",
+			give: "var x expression : *Rows",
+			wantErr: `test.patch:2:20: invalid type "*Rows": undefined: Rows: ` +
+				`types must be qualified by the import paths of their packages`,
+		},
+		{
+			desc:    "syntax error",
+			give:    "var x expression : map[string",
+			wantErr: `test.patch:2:20: invalid type "map[string": not a valid Go type`,
+		},
+		{
+			desc:    "expression list",
+			give:    "var x expression list : int",
+			wantErr: `test.patch:2:23: expression list metavariables cannot have type constraints`,
+		},
+		{
+			desc:    "fresh identifier",
+			give:    "var x fresh identifier implements error",
+			wantErr: `test.patch:2:24: fresh identifier metavariables cannot have type constraints`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", text.Unlines(
+				"@@",
+				tt.give,
+				"@@",
+				"-foo(x)",
+				"+bar(x)",
+			))
+			require.NoError(t, err)
+
+			_, err = Compile(fset, prog)
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"sort"
+
+	"github.com/uber-go/gopatch/internal/goast"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/parse/section"
+	"github.com/uber-go/gopatch/internal/pgo"
+	"go.uber.org/multierr"
+)
+
+// Diagnostic is a problem found in a patch: a likely mistake, or an error
+// that prevents it from compiling.
+type Diagnostic struct {
+	// Position in the patch at which the problem was found.
+	Pos token.Pos
+
+	Message string
+
+	// Whether this problem prevents the patch from compiling.
+	Error bool
+}
+
+// Vet compiles the given Program and reports likely mistakes in it.
+//
+// Errors that prevent the program from compiling are reported as
+// diagnostics alongside likely mistakes so that one doesn't hide the
+// others. Errors that don't have a position in the patch are returned as an
+// error.
+//
+// Diagnostics are returned in the order in which they appear in the patch.
+func Vet(fset *token.FileSet, p *parse.Program, opts ...CompileOption) ([]Diagnostic, error) {
+	c := newCompiler(fset)
+	for _, opt := range opts {
+		opt.apply(c)
+	}
+	c.compileProgram(p)
+	for _, pos := range c.noops {
+		c.warnf(pos, noopMessage)
+	}
+
+	var errs []error
+	for _, err := range c.errors {
+		if cerr, ok := err.(*compileError); ok && cerr.Pos.IsValid() {
+			c.diagnostics = append(c.diagnostics, Diagnostic{
+				Pos:     cerr.Pos,
+				Message: cerr.Msg,
+				Error:   true,
+			})
+			continue
+		}
+		errs = append(errs, err)
+	}
+
+	// Different sections of a patch are parsed from different files in
+	// the FileSet so we can't compare positions directly.
+	diags := c.diagnostics
+	sort.SliceStable(diags, func(i, j int) bool {
+		pi, pj := fset.Position(diags[i].Pos), fset.Position(diags[j].Pos)
+		if pi.Filename != pj.Filename {
+			return pi.Filename < pj.Filename
+		}
+		if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Column < pj.Column
+	})
+	return diags, multierr.Combine(errs...)
+}
+
+// Records a problem to be reported by Vet.
+func (c *compiler) warnf(pos token.Pos, msg string, args ...any) {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	c.diagnostics = append(c.diagnostics, Diagnostic{Pos: pos, Message: msg})
+}
+
+// vetChange reports likely mistakes in the given change that can be found
+// without running it.
+func (c *compiler) vetChange(achange *parse.Change, meta *Meta) {
+	c.vetUnusedMetavars(achange, meta)
+
+	// "@ first @" names the change "first" for compatibility with patches
+	// written before the clause existed, but was likely meant as a clause.
+	if len(achange.Clauses) == 0 && section.IsClauseKeyword(achange.Name) {
+		c.warnf(achange.HeaderPos, "change is named %q, which is a clause keyword: "+
+			"to add the clause, name the change before it", achange.Name)
+	}
+
+	// A change that doesn't modify code is useful only as context for
+	// other changes. Changes that refer to it appear after it, so
+	// unreferenced changes are reported by Vet once all changes are
+	// compiled.
+	for _, name := range referencedChanges(achange) {
+		delete(c.noops, name)
+	}
+	patch := achange.Patch
+	if !patch.SearchOnly && len(patch.Alternatives) == 0 && equalFiles(patch.Minus, patch.Plus) {
+		if len(achange.Name) == 0 {
+			c.warnf(patch.Pos(), noopMessage)
+		} else {
+			c.noops[achange.Name] = patch.Pos()
+		}
+	}
+
+	if e, ok := patch.Minus.Node.(*pgo.Expr); ok && len(patch.Minus.Imports) == 0 {
+		if ident, ok := e.Expr.(*ast.Ident); ok && meta.Types[ident.Name] == nil {
+			switch meta.LookupVar(ident.Name) {
+			case ExprMetavarType, ExprListMetavarType:
+				c.warnf(ident.Pos(), "pattern matches every expression: "+
+					"the \"-\" section is only the metavariable %q", ident.Name)
+			}
+		}
+	}
+}
+
+// Message for changes that don't modify code.
+const noopMessage = `"-" and "+" sections of the change are identical: it has no effect`
+
+// referencedChanges returns the names of the changes that the given change
+// refers to through its "within" and "depends on" clauses and inherited
+// metavariables.
+func referencedChanges(achange *parse.Change) []string {
+	var names []string
+	for _, clause := range achange.Clauses {
+		switch clause := clause.(type) {
+		case *parse.WithinClause:
+			if clause.Change != nil {
+				names = append(names, clause.Change.Name)
+			}
+		case *parse.DependsClause:
+			ast.Inspect(clause.Cond, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Ident); ok {
+					names = append(names, ident.Name)
+				}
+				return true
+			})
+		}
+	}
+	for _, decl := range achange.Meta.Vars {
+		if decl != nil && decl.Change != nil {
+			names = append(names, decl.Change.Name)
+		}
+	}
+	return names
+}
+
+// vetUnusedMetavars reports metavariables declared by the change that
+// aren't referenced anywhere in it.
+func (c *compiler) vetUnusedMetavars(achange *parse.Change, meta *Meta) {
+	used := make(map[string]struct{})
+	patch := achange.Patch
+	for _, f := range append([]*pgo.File{patch.Minus, patch.Plus}, patch.Alternatives...) {
+		for _, ident := range fileMetavarRefs(f, meta) {
+			used[ident.Name] = struct{}{}
+		}
+	}
+	for _, decl := range achange.Meta.Vars {
+		if decl == nil || decl.Value == nil {
+			continue
+		}
+		// Computed values refer to other metavariables by name. Calls
+		// refer to built-in functions, which ast.Inspect visits as
+		// identifiers too but can't clash with metavariables in use.
+		ast.Inspect(decl.Value, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				used[ident.Name] = struct{}{}
+			}
+			return true
+		})
+	}
+
+	for _, decl := range achange.Meta.Vars {
+		if decl == nil {
+			continue
+		}
+		for _, name := range decl.Names {
+			if _, ok := used[name.Name]; !ok {
+				c.warnf(name.Pos(), "metavariable %q is declared but never used", name.Name)
+			}
+		}
+	}
+}
+
+// equalFiles reports whether two pgo files hold the same code, ignoring
+// positions.
+func equalFiles(a, b *pgo.File) bool {
+	return equalIgnoringPos(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+func equalIgnoringPos(a, b reflect.Value) bool {
+	if a.IsValid() != b.IsValid() {
+		return false
+	}
+	if !a.IsValid() {
+		return true
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.Type() {
+	case goast.PosType:
+		return true
+	case goast.ObjectPtrType, goast.ScopePtrType:
+		// These form cycles and are derived from the rest of the tree.
+		return true
+	}
+
+	switch a.Kind() {
+	case reflect.Array, reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalIgnoringPos(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Interface, reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equalIgnoringPos(a.Elem(), b.Elem())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !equalIgnoringPos(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.String:
+		return a.String() == b.String()
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() == b.Uint()
+	default:
+		// The go/ast types we compare don't hold maps, functions, or
+		// channels outside Scopes and Objects.
+		return true
+	}
+}
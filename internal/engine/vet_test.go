@@ -0,0 +1,338 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package engine
+
+import (
+	"fmt"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/parse"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestVet(t *testing.T) {
+	tests := []struct {
+		desc string
+		give []byte
+		want []string // line:column: message
+	}{
+		{
+			desc: "clean",
+			give: text.Unlines(
+				"@@",
+				"var x expression",
+				"@@",
+				"-foo(x)",
+				"+bar(x)",
+			),
+		},
+		{
+			desc: "unused metavariable",
+			give: text.Unlines(
+				"@@",
+				"var x, y expression",
+				"@@",
+				"-foo(x)",
+				"+bar(x)",
+			),
+			want: []string{`2:8: metavariable "y" is declared but never used`},
+		},
+		{
+			desc: "used by computed metavariable",
+			give: text.Unlines(
+				"@@",
+				"var name identifier",
+				`var short identifier = trimPrefix(name, "Get")`,
+				"var long identifier = upper(short)",
+				"@@",
+				"-name()",
+				"+long()",
+			),
+		},
+		{
+			desc: "used in import",
+			give: text.Unlines(
+				"@@",
+				"var pkg identifier",
+				"@@",
+				`-import pkg "example.com/foo"`,
+				`+import pkg "example.com/bar"`,
+				"",
+				"-pkg.Foo()",
+				"+pkg.Bar()",
+			),
+		},
+		{
+			desc: "plus dots without minus dots",
+			give: text.Unlines(
+				"@@",
+				"@@",
+				"-foo()",
+				"+foo(...)",
+			),
+			want: []string{`4:6: "..." in "+" section does not have an associated "..." in "-" section`},
+		},
+		{
+			desc: "identical",
+			give: text.Unlines(
+				"@@",
+				"@@",
+				" foo()",
+			),
+			want: []string{`3:1: "-" and "+" sections of the change are identical: it has no effect`},
+		},
+		{
+			desc: "identical but referenced",
+			give: text.Unlines(
+				"@ test @",
+				"var t identifier",
+				"@@",
+				" func Test(t *testing.T) {",
+				"   ...",
+				" }",
+				"",
+				"@ fatal within test @",
+				"@@",
+				"-t.Errorf(...)",
+				"+t.Fatalf(...)",
+			),
+		},
+		{
+			desc: "identical and unreferenced",
+			give: text.Unlines(
+				"@ foo @",
+				"@@",
+				" foo()",
+				"",
+				"@ bar @",
+				"@@",
+				"-bar()",
+				"+baz()",
+			),
+			want: []string{`3:1: "-" and "+" sections of the change are identical: it has no effect`},
+		},
+		{
+			desc: "search only",
+			give: text.Unlines(
+				"@@",
+				"var x expression",
+				"@@",
+				"-foo(x)",
+			),
+		},
+		{
+			desc: "keyword as name",
+			give: text.Unlines(
+				"@ first @",
+				"@@",
+				"-foo()",
+				"+bar()",
+				"",
+				"@ lock first @",
+				"@@",
+				"-baz()",
+				"+qux()",
+			),
+			want: []string{`1:1: change is named "first", which is a clause keyword: to add the clause, name the change before it`},
+		},
+		{
+			desc: "matches every expression",
+			give: text.Unlines(
+				"@@",
+				"var x expression",
+				"@@",
+				"-x",
+				"+wrap(x)",
+			),
+			want: []string{`4:2: pattern matches every expression: the "-" section is only the metavariable "x"`},
+		},
+		{
+			desc: "type constrained expression",
+			give: text.Unlines(
+				"@@",
+				"var x expression : error",
+				"@@",
+				"-x",
+				"+wrap(x)",
+			),
+		},
+		{
+			desc: "ordered by position",
+			give: text.Unlines(
+				"@@",
+				"var x, y expression",
+				"@@",
+				"-x",
+				"+x(...)",
+			),
+			want: []string{
+				`2:8: metavariable "y" is declared but never used`,
+				`4:2: pattern matches every expression: the "-" section is only the metavariable "x"`,
+				`5:4: "..." in "+" section does not have an associated "..." in "-" section`,
+			},
+		},
+		{
+			desc: "with compile errors",
+			give: text.Unlines(
+				"@@",
+				"var x, y, z expression",
+				"@@",
+				"-foo(x)",
+				"+bar(x, z)",
+				"",
+				"@@",
+				"@@",
+				" baz()",
+			),
+			want: []string{
+				`2:8: metavariable "y" is declared but never used`,
+				`5:9: metavariable "z" is used in the "+" section but never matched in the "-" section`,
+				`9:1: "-" and "+" sections of the change are identical: it has no effect`,
+			},
+		},
+		{
+			desc: "ordered across sections",
+			give: text.Unlines(
+				"@@",
+				"var x, y expression",
+				"@@",
+				" foo(x)",
+			),
+			want: []string{
+				`2:8: metavariable "y" is declared but never used`,
+				`4:1: "-" and "+" sections of the change are identical: it has no effect`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", tt.give)
+			require.NoError(t, err)
+
+			diags, err := Vet(fset, prog)
+			require.NoError(t, err)
+
+			var got []string
+			for _, d := range diags {
+				pos := fset.Position(d.Pos)
+				got = append(got, fmt.Sprintf("%d:%d: %v", pos.Line, pos.Column, d.Message))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUnboundMetavars(t *testing.T) {
+	tests := []struct {
+		desc    string
+		give    []byte
+		wantErr string // empty if it should compile
+	}{
+		{
+			desc: "unbound",
+			give: text.Unlines(
+				"@@",
+				"var x, y expression",
+				"@@",
+				"-foo(x)",
+				"+bar(x, y, y)",
+			),
+			wantErr: `test.patch:5:9: metavariable "y" is used in the "+" section but never matched in the "-" section`,
+		},
+		{
+			desc: "bound in a disjunction",
+			give: text.Unlines(
+				"@@",
+				"var x expression",
+				"@@",
+				"(",
+				"-foo(x)",
+				"|",
+				"-bar(x)",
+				")",
+				"+baz(x)",
+			),
+		},
+		{
+			desc: "bound by outer change",
+			give: text.Unlines(
+				"@ outer @",
+				"var x identifier",
+				"@@",
+				" func x() {",
+				"   ...",
+				" }",
+				"",
+				"@ inner within outer @",
+				"@@",
+				"-foo()",
+				"+foo(x)",
+			),
+		},
+		{
+			desc: "inherited",
+			give: text.Unlines(
+				"@ decl @",
+				"var x identifier",
+				"@@",
+				"-x()",
+				"+x(1)",
+				"",
+				"@@",
+				"var decl.x identifier",
+				"@@",
+				"-foo()",
+				"+x(2)",
+			),
+		},
+		{
+			desc: "fresh",
+			give: text.Unlines(
+				"@@",
+				"var x fresh identifier",
+				"@@",
+				"-foo()",
+				"+x := foo()",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			prog, err := parse.Parse(fset, "test.patch", tt.give)
+			require.NoError(t, err)
+
+			_, err = Compile(fset, prog)
+			if len(tt.wantErr) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package glob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"foo.go", "foo.go", true},
+		{"foo.go", "bar.go", false},
+		{"*.go", "foo.go", true},
+		{"*.go", "dir/foo.go", false},
+		{"*_test.go", "foo_test.go", true},
+		{"*_test.go", "foo.go", false},
+		{"dir/*.go", "dir/foo.go", true},
+		{"dir/*.go", "dir/sub/foo.go", false},
+		{"dir/**", "dir", true},
+		{"dir/**", "dir/foo.go", true},
+		{"dir/**", "dir/sub/foo.go", true},
+		{"dir/**", "other/foo.go", false},
+		{"**/foo.go", "foo.go", true},
+		{"**/foo.go", "a/b/foo.go", true},
+		{"**/foo.go", "a/b/bar.go", false},
+		{"a/**/b/*.go", "a/b/foo.go", true},
+		{"a/**/b/*.go", "a/x/y/b/foo.go", true},
+		{"a/**/b/*.go", "a/x/y/c/foo.go", false},
+		{"go.uber.org/*", "go.uber.org/zap", true},
+		{"go.uber.org/*", "go.uber.org/zap/zapcore", false},
+		{"[", "[", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Match(tt.pattern, tt.name),
+			"Match(%q, %q)", tt.pattern, tt.name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate("a/**/[abc]*.go"))
+	assert.Error(t, Validate("a/[b"))
+}
@@ -33,9 +33,11 @@ var (
 	StringType = reflect.TypeOf("")
 
 	// Structs
+	BasicLitType     = reflect.TypeOf(ast.BasicLit{})
 	BlockStmtType    = reflect.TypeOf(ast.BlockStmt{})
 	CaseClauseType   = reflect.TypeOf(ast.CaseClause{})
 	CommClauseType   = reflect.TypeOf(ast.CommClause{})
+	CommentType      = reflect.TypeOf(ast.Comment{})
 	CommentGroupType = reflect.TypeOf(ast.CommentGroup{})
 	FieldListType    = reflect.TypeOf(ast.FieldList{})
 	FieldType        = reflect.TypeOf(ast.Field{})
@@ -47,8 +49,11 @@ var (
 	ObjectType       = reflect.TypeOf(ast.Object{})
 	RangeStmtType    = reflect.TypeOf(ast.RangeStmt{})
 	ScopeType        = reflect.TypeOf(ast.Scope{})
+	SelectorExprType = reflect.TypeOf(ast.SelectorExpr{})
 
 	// Struct Pointers
+	BasicLitPtrType     = reflect.PtrTo(BasicLitType)
+	CommentPtrType      = reflect.PtrTo(CommentType)
 	CommentGroupPtrType = reflect.PtrTo(CommentGroupType)
 	FieldListPtrType    = reflect.PtrTo(FieldListType)
 	FieldPtrType        = reflect.PtrTo(FieldType)
@@ -60,14 +65,18 @@ var (
 	ObjectPtrType       = reflect.PtrTo(ObjectType)
 	RangeStmtPtrType    = reflect.PtrTo(RangeStmtType)
 	ScopePtrType        = reflect.PtrTo(ScopeType)
+	SelectorExprPtrType = reflect.PtrTo(SelectorExprType)
 
 	// Interfaces
 	ExprType = reflect.TypeOf((*ast.Expr)(nil)).Elem()
 	NodeType = reflect.TypeOf((*ast.Node)(nil)).Elem()
+	SpecType = reflect.TypeOf((*ast.Spec)(nil)).Elem()
 	StmtType = reflect.TypeOf((*ast.Stmt)(nil)).Elem()
 
 	// Slices
 	ExprSliceType     = reflect.SliceOf(ExprType)
 	FieldPtrSliceType = reflect.SliceOf(FieldPtrType)
+	IdentPtrSliceType = reflect.SliceOf(IdentPtrType)
+	SpecSliceType     = reflect.SliceOf(SpecType)
 	StmtSliceType     = reflect.SliceOf(StmtType)
 )
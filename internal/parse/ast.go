@@ -29,9 +29,53 @@ import (
 
 // Program is a single gopatch program consisting of one or more changes.
 type Program struct {
+	// Include directives at the top of the program, if any.
+	Includes []*Include
+
+	// Changes in the program, starting with those spliced in by its
+	// includes.
 	Changes []*Change
 }
 
+// Include is a directive at the top of a patch file that splices the
+// changes of another patch file into the program.
+//
+//	include "common.patch"
+//
+// The path may be followed by the names of changes to include. Changes they
+// depend on through "within" clauses and inherited metavariables are
+// included with them.
+//
+//	include "common.patch" getters setters
+type Include struct {
+	// Position at which the "include" keyword appears.
+	IncludePos token.Pos
+
+	// Path to the included file as a Go string, relative to the directory
+	// of the file that includes it.
+	Path *ast.BasicLit
+
+	// Names of the changes to include, if any. All changes are included
+	// if this is empty.
+	Changes []*ast.Ident
+}
+
+var _ ast.Node = (*Include)(nil)
+
+// Pos returns the position at which this directive starts.
+func (i *Include) Pos() token.Pos { return i.IncludePos }
+
+// End returns the position of the next character after this directive.
+func (i *Include) End() token.Pos {
+	if len(i.Changes) > 0 {
+		return i.Changes[len(i.Changes)-1].End()
+	}
+	if i.Path != nil {
+		return i.Path.End()
+	}
+	return token.NoPos
+}
+
 // Change is a single change in a patch. Changes are specified in the format,
 //
 //	@@
@@ -46,12 +90,22 @@ type Program struct {
 //	# metavariables go here
 //	@@
 //	# patch goes here
+//
+// The name may be followed by clauses that alter how the change is applied.
+//
+//	@ mychange within otherchange @
 type Change struct {
+	// Position at which the header of the change begins.
+	HeaderPos token.Pos
+
 	// Name for the change, if any.
 	//
 	// Names must be valid Go identifiers.
 	Name string
 
+	// Clauses specified in the header of the change, if any.
+	Clauses []Clause
+
 	// Metavariables defined for this change.
 	Meta *Meta
 
@@ -60,6 +114,230 @@ type Change struct {
 
 	// Comments for this change
 	Comments []string
+
+	// Tests embedded in the patch after this change, if any.
+	Tests []*Test
+}
+
+// Test is an example embedded in a patch after a change, made up of an input
+// Go file and the output expected after running the change on it.
+//
+//	-- example.in.go --
+//	package x
+//
+//	var y = foo()
+//	-- example.out.go --
+//	package x
+//
+//	var y = bar()
+type Test struct {
+	// Position at which the name of the input file begins.
+	NamePos token.Pos
+
+	// Name of the test. This is the name of the input file without the
+	// ".in.go" suffix.
+	Name string
+
+	// Contents of the input file.
+	Input []byte
+
+	// Contents of the expected output file, or nil if the test doesn't have
+	// one. The change must leave the input unchanged in that case.
+	Output []byte
+}
+
+// Clause is a clause in the header of a change.
+type Clause interface {
+	ast.Node
+
+	clause()
+}
+
+var (
+	_ Clause = (*FirstClause)(nil)
+	_ Clause = (*WithinClause)(nil)
+	_ Clause = (*FilesClause)(nil)
+	_ Clause = (*ImportPathClause)(nil)
+	_ Clause = (*TestsClause)(nil)
+	_ Clause = (*TagClause)(nil)
+	_ Clause = (*DependsClause)(nil)
+)
+
+// FirstClause limits a change to the first match inside each scope that
+// it elides with "...".
+//
+//	@ lock first @
+//
+// Without it, every non-overlapping match in the scope is changed.
+type FirstClause struct {
+	// Position at which the "first" keyword appears.
+	FirstPos token.Pos
+}
+
+func (*FirstClause) clause() {}
+
+// Pos returns the position at which this clause starts.
+func (c *FirstClause) Pos() token.Pos { return c.FirstPos }
+
+// End returns the position of the next character after this clause.
+func (c *FirstClause) End() token.Pos { return c.FirstPos + token.Pos(len("first")) }
+
+// WithinClause restricts a change to run only inside code matched by another
+// change.
+//
+//	@ inner within outer @
+//
+// The inner change has access to metavariables bound by the outer change.
+type WithinClause struct {
+	// Position at which the "within" keyword appears.
+	WithinPos token.Pos
+
+	// Name of the enclosing change.
+	Change *ast.Ident
+}
+
+func (*WithinClause) clause() {}
+
+// Pos returns the position at which this clause starts.
+func (c *WithinClause) Pos() token.Pos { return c.WithinPos }
+
+// End returns the position of the next character after this clause.
+func (c *WithinClause) End() token.Pos {
+	if c.Change != nil {
+		return c.Change.End()
+	}
+	return token.NoPos
+}
+
+// FilesClause restricts a change to files whose paths match one of the
+// given glob patterns.
+//
+//	@ files "internal/**" "cmd/**" @
+type FilesClause struct {
+	// Position at which the "files" keyword appears.
+	FilesPos token.Pos
+
+	// Glob patterns as Go strings.
+	Patterns []*ast.BasicLit
+}
+
+func (*FilesClause) clause() {}
+
+// Pos returns the position at which this clause starts.
+func (c *FilesClause) Pos() token.Pos { return c.FilesPos }
+
+// End returns the position of the next character after this clause.
+func (c *FilesClause) End() token.Pos {
+	if len(c.Patterns) > 0 {
+		return c.Patterns[len(c.Patterns)-1].End()
+	}
+	return token.NoPos
+}
+
+// ImportPathClause restricts a change to files in packages whose import
+// paths match one of the given patterns.
+//
+//	@ importpath "github.com/org/repo/internal/..." @
+type ImportPathClause struct {
+	// Position at which the "importpath" keyword appears.
+	ImportPathPos token.Pos
+
+	// Import path patterns as Go strings.
+	Patterns []*ast.BasicLit
+}
+
+func (*ImportPathClause) clause() {}
+
+// Pos returns the position at which this clause starts.
+func (c *ImportPathClause) Pos() token.Pos { return c.ImportPathPos }
+
+// End returns the position of the next character after this clause.
+func (c *ImportPathClause) End() token.Pos {
+	if len(c.Patterns) > 0 {
+		return c.Patterns[len(c.Patterns)-1].End()
+	}
+	return token.NoPos
+}
+
+// TestsClause restricts a change to test files, or with "notests", to files
+// that aren't tests.
+//
+//	@ tests @
+//	@ notests @
+type TestsClause struct {
+	// Position at which the "tests" or "notests" keyword appears.
+	TestsPos token.Pos
+
+	// Whether this is a "notests" clause.
+	Exclude bool
+}
+
+func (*TestsClause) clause() {}
+
+// Pos returns the position at which this clause starts.
+func (c *TestsClause) Pos() token.Pos { return c.TestsPos }
+
+// End returns the position of the next character after this clause.
+func (c *TestsClause) End() token.Pos {
+	if c.Exclude {
+		return c.TestsPos + token.Pos(len("notests"))
+	}
+	return c.TestsPos + token.Pos(len("tests"))
+}
+
+// TagClause restricts a change to files with a build constraint that
+// requires the given build tag.
+//
+//	@ tag "integration" @
+type TagClause struct {
+	// Position at which the "tag" keyword appears.
+	TagPos token.Pos
+
+	// Build tag as a Go string.
+	Tag *ast.BasicLit
+}
+
+func (*TagClause) clause() {}
+
+// Pos returns the position at which this clause starts.
+func (c *TagClause) Pos() token.Pos { return c.TagPos }
+
+// End returns the position of the next character after this clause.
+func (c *TagClause) End() token.Pos {
+	if c.Tag != nil {
+		return c.Tag.End()
+	}
+	return token.NoPos
+}
+
+// DependsClause runs a change only if earlier named changes matched, or
+// didn't match, the same file.
+//
+//	@ addimport depends on rewrite @
+//	@ fallback depends on !primary @
+//	@ cleanup depends on (foo || bar) && !baz @
+type DependsClause struct {
+	// Position at which the "depends" keyword appears.
+	DependsPos token.Pos
+
+	// Condition on the changes that matched. This is made up of
+	// *ast.Ident names of changes, *ast.UnaryExpr negations with "!",
+	// *ast.BinaryExpr combinations with "&&" and "||", and *ast.ParenExpr
+	// groups.
+	Cond ast.Expr
+}
+
+func (*DependsClause) clause() {}
+
+// Pos returns the position at which this clause starts.
+func (c *DependsClause) Pos() token.Pos { return c.DependsPos }
+
+// End returns the position of the next character after this clause.
+func (c *DependsClause) End() token.Pos {
+	if c.Cond != nil {
+		return c.Cond.End()
+	}
+	return token.NoPos
 }
 
 // Meta represents the metavariables section of a change.
@@ -68,12 +346,72 @@ type Change struct {
 type Meta struct {
 	// Variables declared in this section.
 	Vars []*VarDecl
+
+	// Parameters declared in this section.
+	Params []*ParamDecl
+}
+
+// ParamDecl is a single param declaration in a metavariable block.
+//
+//	param old, new identifier
+//	param pkg string
+//
+// Parameters are given values when the patch is compiled, and are
+// replaced with those values throughout the change.
+type ParamDecl struct {
+	// Position at which the "param" keyword appears.
+	ParamPos token.Pos
+
+	// Names of the parameters declared in this statement.
+	Names []*ast.Ident
+
+	// Type of the parameters.
+	Type *ast.Ident
+}
+
+var _ ast.Node = (*ParamDecl)(nil)
+
+// Pos returns the position at which this declaration starts.
+func (d *ParamDecl) Pos() token.Pos { return d.ParamPos }
+
+// End returns the position of the next character after this declaration.
+func (d *ParamDecl) End() token.Pos {
+	if d.Type != nil {
+		return d.Type.End()
+	}
+	return token.NoPos
 }
 
 // VarDecl is a single var declaration in a metavariable block.
 //
 //	var foo, bar identifier
 //	var baz, qux expression
+//
+// Variables may be inherited from an earlier named change by qualifying them
+// with the name of that change.
+//
+//	var decl.name identifier
+//
+// The type may be followed by "list" for variables that capture zero or more
+// values.
+//
+//	var args expression list
+//
+// Identifiers may be marked "fresh" for variables that are generated by the
+// replacement, optionally with a seed for the generated name.
+//
+//	var tmp fresh identifier "err"
+//
+// Computed identifiers specify how their value is derived from other
+// metavariables.
+//
+//	var short identifier = trimPrefix(name, "Get")
+//
+// Identifiers and expressions may be constrained to values of a specific
+// type, or of types that implement an interface.
+//
+//	var x expression : *net/http.Client
+//	var c expression implements io.Closer
 type VarDecl struct {
 	// Position at which the "var" keyword appears.
 	VarPos token.Pos
@@ -82,13 +420,63 @@ type VarDecl struct {
 	// code so that we can track positional data for when identifiers appear
 	// in the patch file.
 
+	// Name of the change from which these variables are inherited, if any.
+	Change *ast.Ident
+
 	// Names of the variables declared in this statement.
 	Names []*ast.Ident
 
+	// Position at which the "fresh" keyword appears before the type, if
+	// the variables are fresh.
+	FreshPos token.Pos
+
 	// Type of the variables.
 	Type *ast.Ident
+
+	// Position at which the "list" keyword appears after the type, if
+	// the variables are lists.
+	ListPos token.Pos
+
+	// Seed for the names of fresh variables, if any.
+	Seed *ast.BasicLit
+
+	// Value of a computed variable, if any. This is made up of
+	// *ast.BasicLit strings, *ast.Ident references to metavariables, and
+	// *ast.CallExpr calls to built-in functions.
+	Value ast.Expr
+
+	// Type constraint on the values of the variables, if any.
+	Constraint *TypeConstraint
+}
+
+// TypeConstraint restricts the values matched by a metavariable to those of
+// a specific Go type.
+//
+//	: *net/http.Client
+//	implements io.Closer
+type TypeConstraint struct {
+	// Position at which the ":" or "implements" keyword appears.
+	KeywordPos token.Pos
+
+	// Whether the values must implement the type instead of having it.
+	Implements bool
+
+	// Position at which the type starts.
+	TypePos token.Pos
+
+	// Type as written in the patch, with packages referred to by their
+	// full import paths.
+	Type string
 }
 
+var _ ast.Node = (*TypeConstraint)(nil)
+
+// Pos returns the position at which this constraint starts.
+func (c *TypeConstraint) Pos() token.Pos { return c.KeywordPos }
+
+// End returns the position of the next character after this constraint.
+func (c *TypeConstraint) End() token.Pos { return c.TypePos + token.Pos(len(c.Type)) }
+
 var _ ast.Node = (*VarDecl)(nil)
 
 // Pos returns the position at which this declaration starts.
@@ -96,6 +484,18 @@ func (d *VarDecl) Pos() token.Pos { return d.VarPos }
 
 // End returns the position of the next character after this declaration.
 func (d *VarDecl) End() token.Pos {
+	if d.Constraint != nil {
+		return d.Constraint.End()
+	}
+	if d.Value != nil {
+		return d.Value.End()
+	}
+	if d.Seed != nil {
+		return d.Seed.End()
+	}
+	if d.ListPos.IsValid() {
+		return d.ListPos + token.Pos(len("list"))
+	}
 	if d.Type != nil {
 		return d.Type.End()
 	}
@@ -111,6 +511,17 @@ type Patch struct {
 	// The before and after versions of the Patch broken apart from the
 	// unified diff.
 	Minus, Plus *pgo.File
+
+	// Other versions of Minus, if the patch has disjunctions. Minus and
+	// each of these holds a different combination of the branches of the
+	// disjunctions.
+	//
+	// Positions in these refer to the same file as Minus.
+	Alternatives []*pgo.File
+
+	// Whether the patch has only a "-" section. Such patches search for
+	// code without changing it, and Plus holds a copy of Minus.
+	SearchOnly bool
 }
 
 var _ ast.Node = (*Patch)(nil)
@@ -24,7 +24,12 @@ import "github.com/uber-go/gopatch/internal/parse/section"
 
 // Parses the change at index i.
 func (p *parser) parseChange(i int, c *section.Change) (_ *Change, err error) {
-	change := Change{Name: c.Name}
+	change := Change{HeaderPos: c.HeaderPos, Name: c.Name}
+
+	change.Clauses, err = p.parseClauses(i, c)
+	if err != nil {
+		return nil, err
+	}
 
 	change.Meta, err = p.parseMeta(i, c)
 	if err != nil {
@@ -36,6 +41,11 @@ func (p *parser) parseChange(i int, c *section.Change) (_ *Change, err error) {
 		return nil, err
 	}
 
+	change.Tests, err = p.parseTests(c)
+	if err != nil {
+		return nil, err
+	}
+
 	change.Comments = c.Comments
 	return &change, nil
 }
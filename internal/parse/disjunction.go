@@ -0,0 +1,343 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parse
+
+import (
+	"bytes"
+	"go/token"
+
+	"github.com/uber-go/gopatch/internal/parse/section"
+)
+
+// Disjunctions are specified with lines holding only "(", "|", or ")",
+// without a prefix.
+//
+//	(
+//	-foo(x)
+//	|
+//	-bar(x)
+//	)
+//
+// "(" always opens a disjunction. "|" and ")" are recognized only inside
+// one. This keeps unprefixed ")" lines, as used to close import groups,
+// working as before.
+const (
+	_disjOpen  = "("
+	_disjOr    = "|"
+	_disjClose = ")"
+)
+
+// _maxPatchVersions is the maximum number of versions a patch may expand to
+// with its disjunctions and optional lines. Each version is compiled and
+// matched separately so the count is limited to keep patches with many
+// optional lines from taking forever.
+const _maxPatchVersions = 1024
+
+// patchItem is a single line of a patch, an optional line, or a
+// disjunction.
+type patchItem struct {
+	Line *section.Line
+
+	// Optional line prefixed with "?", if this is one. An optional line
+	// is a disjunction between the line prefixed with "-" and nothing.
+	Optional *section.Line
+
+	// If this is a disjunction, the marker lines for it ("(", "|"s, and
+	// ")") and the lines inside each of its branches.
+	Markers  []*section.Line
+	Branches [][]*patchItem
+}
+
+// expandDisjunctions expands the disjunctions and optional lines in a
+// patch, returning a copy of the patch for each combination of their
+// branches. Returns the patch as-is if it doesn't have any of these.
+//
+// Versions in which optional lines are present are listed before those in
+// which they're absent.
+//
+// Lines that aren't selected in a copy, and the markers of the disjunctions,
+// are blanked out without changing their lengths so that the code in the "-"
+// section of each copy appears at the same offsets.
+func (p *parser) expandDisjunctions(patch section.Section) ([]section.Section, error) {
+	items, err := p.readPatchItems(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 1
+	for _, item := range items {
+		count = capVersions(count * item.versions())
+		if count > _maxPatchVersions {
+			return nil, p.errf(item.pos(),
+				"patch expands to more than %d versions: use fewer disjunctions or optional lines",
+				_maxPatchVersions)
+		}
+	}
+
+	var expand func(items []*patchItem) [][]*section.Line
+	expand = func(items []*patchItem) [][]*section.Line {
+		results := [][]*section.Line{nil}
+		for _, item := range items {
+			if item.Line != nil {
+				for i, r := range results {
+					results[i] = append(r, item.Line)
+				}
+				continue
+			}
+
+			if item.Optional != nil {
+				present := deletedLine(item.Optional)
+				absent := blankLine(present)
+
+				var next [][]*section.Line
+				for _, r := range results {
+					next = append(next, append(append([]*section.Line(nil), r...), present))
+				}
+				for _, r := range results {
+					next = append(next, append(append([]*section.Line(nil), r...), absent))
+				}
+				results = next
+				continue
+			}
+
+			var next [][]*section.Line
+			for _, r := range results {
+				for i, branch := range item.Branches {
+					for _, lines := range expand(branch) {
+						next = append(next, append(append([]*section.Line(nil), r...), blankBranches(item, i, lines)...))
+					}
+				}
+			}
+			results = next
+		}
+		return results
+	}
+
+	var versions []section.Section
+	for _, lines := range expand(items) {
+		versions = append(versions, section.Section(lines))
+	}
+	return versions, nil
+}
+
+// versions returns the number of versions the given item expands to, up to
+// one more than _maxPatchVersions.
+func (item *patchItem) versions() int {
+	switch {
+	case item.Line != nil:
+		return 1
+	case item.Optional != nil:
+		return 2
+	}
+
+	var total int
+	for _, branch := range item.Branches {
+		n := 1
+		for _, item := range branch {
+			n = capVersions(n * item.versions())
+		}
+		total = capVersions(total + n)
+	}
+	return total
+}
+
+// pos returns the position at which the given item starts.
+func (item *patchItem) pos() token.Pos {
+	switch {
+	case item.Line != nil:
+		return item.Line.Pos()
+	case item.Optional != nil:
+		return item.Optional.Pos()
+	}
+	return item.Markers[0].Pos()
+}
+
+// capVersions caps a number of versions at one more than _maxPatchVersions
+// so that it can't overflow.
+func capVersions(n int) int {
+	if n > _maxPatchVersions {
+		return _maxPatchVersions + 1
+	}
+	return n
+}
+
+// blankBranches returns the lines of the given disjunction with lines
+// selected for the branch at index i, and all other lines blanked out.
+func blankBranches(item *patchItem, i int, selected []*section.Line) []*section.Line {
+	var lines []*section.Line
+	for j, marker := range item.Markers {
+		lines = append(lines, blankLine(marker))
+		if j == len(item.Branches) {
+			break // ")"
+		}
+
+		if j == i {
+			lines = append(lines, selected...)
+			continue
+		}
+
+		for _, l := range flattenItems(item.Branches[j]) {
+			lines = append(lines, blankLine(l))
+		}
+	}
+	return lines
+}
+
+// flattenItems returns all lines in the given items, including markers of
+// disjunctions, in order.
+func flattenItems(items []*patchItem) []*section.Line {
+	var lines []*section.Line
+	for _, item := range items {
+		if item.Line != nil {
+			lines = append(lines, item.Line)
+			continue
+		}
+
+		if item.Optional != nil {
+			lines = append(lines, deletedLine(item.Optional))
+			continue
+		}
+
+		for i, marker := range item.Markers {
+			lines = append(lines, marker)
+			if i < len(item.Branches) {
+				lines = append(lines, flattenItems(item.Branches[i])...)
+			}
+		}
+	}
+	return lines
+}
+
+// blankLine returns a copy of the given line with its contents replaced with
+// spaces. The "-" prefix is retained so that the line contributes the same
+// number of characters to the "-" section and none to the "+" section.
+func blankLine(l *section.Line) *section.Line {
+	text := bytes.Repeat([]byte{' '}, len(l.Text))
+	if len(l.Text) > 0 && l.Text[0] == '-' {
+		text[0] = '-'
+	}
+	return &section.Line{StartPos: l.StartPos, Text: text}
+}
+
+// deletedLine returns a copy of the given optional line with its "?" prefix
+// replaced with "-".
+func deletedLine(l *section.Line) *section.Line {
+	text := append([]byte("-"), l.Text[1:]...)
+	return &section.Line{StartPos: l.StartPos, Text: text}
+}
+
+// readPatchItems reads the lines of a patch, grouping disjunctions.
+func (p *parser) readPatchItems(patch section.Section) ([]*patchItem, error) {
+	r := patchItemReader{p: p, lines: patch}
+	return r.readItems(0)
+}
+
+type patchItemReader struct {
+	p     *parser
+	lines section.Section
+	idx   int
+}
+
+// readItems reads items until the end of the patch, or until a "|" or ")"
+// when inside a disjunction.
+func (r *patchItemReader) readItems(depth int) ([]*patchItem, error) {
+	var items []*patchItem
+	for r.idx < len(r.lines) {
+		line := r.lines[r.idx]
+		switch marker := disjunctionMarker(line); {
+		case marker == _disjOpen:
+			item, err := r.readDisjunction(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+
+		case depth > 0 && (marker == _disjOr || marker == _disjClose):
+			return items, nil
+
+		case len(line.Text) > 0 && line.Text[0] == '?':
+			items = append(items, &patchItem{Optional: line})
+			r.idx++
+
+		default:
+			if depth > 0 && !isBranchLine(line) {
+				return nil, r.p.errf(line.Pos(),
+					`unexpected %q: only "-" and "?" lines are allowed inside a disjunction`, line.Text)
+			}
+			items = append(items, &patchItem{Line: line})
+			r.idx++
+		}
+	}
+
+	if depth > 0 {
+		return nil, r.p.errf(r.lines[len(r.lines)-1].End(),
+			`unexpected end of patch, expected %q`, _disjClose)
+	}
+	return items, nil
+}
+
+// readDisjunction reads a disjunction starting at a "(" line.
+func (r *patchItemReader) readDisjunction(depth int) (*patchItem, error) {
+	open := r.lines[r.idx]
+	r.idx++ // (
+
+	item := patchItem{Markers: []*section.Line{open}}
+	for {
+		branch, err := r.readItems(depth)
+		if err != nil {
+			return nil, err
+		}
+		if len(branch) == 0 {
+			return nil, r.p.errf(item.Markers[len(item.Markers)-1].Pos(),
+				"disjunction branches cannot be empty")
+		}
+		item.Branches = append(item.Branches, branch)
+
+		marker := r.lines[r.idx]
+		item.Markers = append(item.Markers, marker)
+		r.idx++
+		if disjunctionMarker(marker) == _disjClose {
+			break
+		}
+	}
+
+	if len(item.Branches) < 2 {
+		return nil, r.p.errf(open.Pos(), "disjunction must have at least two branches")
+	}
+	return &item, nil
+}
+
+// disjunctionMarker returns the disjunction marker on the given line, or an
+// empty string if the line isn't a marker.
+func disjunctionMarker(l *section.Line) string {
+	switch s := string(bytes.TrimRight(l.Text, " \t\r\n")); s {
+	case _disjOpen, _disjOr, _disjClose:
+		return s
+	}
+	return ""
+}
+
+// isBranchLine reports whether the given line may appear inside a branch of
+// a disjunction. Only deleted and blank lines are allowed.
+func isBranchLine(l *section.Line) bool {
+	text := bytes.TrimSpace(l.Text)
+	return len(text) == 0 || l.Text[0] == '-'
+}
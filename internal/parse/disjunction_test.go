@@ -0,0 +1,251 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parse
+
+import (
+	"bytes"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/parse/section"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestExpandDisjunctions(t *testing.T) {
+	tests := []struct {
+		desc string
+		give []string
+
+		// Contents of the "-" section of each version.
+		wantMinus []string
+	}{
+		{
+			desc:      "no disjunctions",
+			give:      []string{"-foo()", "+bar()"},
+			wantMinus: []string{"foo()\n"},
+		},
+		{
+			desc: "close without open",
+			give: []string{
+				"import (",
+				`-  "foo"`,
+				")",
+			},
+			wantMinus: []string{"import (\n  \"foo\"\n)\n"},
+		},
+		{
+			desc: "two branches",
+			give: []string{
+				"(",
+				"-foo()",
+				"|",
+				"-barbaz()",
+				")",
+				"+qux()",
+			},
+			wantMinus: []string{
+				" \nfoo()\n \n        \n \n",
+				" \n     \n \nbarbaz()\n \n",
+			},
+		},
+		{
+			desc: "nested",
+			give: []string{
+				"(",
+				"-a",
+				"|",
+				"(",
+				"-b",
+				"|",
+				"-c",
+				")",
+				")",
+			},
+			wantMinus: []string{
+				" \na\n \n \n \n \n \n \n \n",
+				" \n \n \n \nb\n \n \n \n \n",
+				" \n \n \n \n \n \nc\n \n \n",
+			},
+		},
+		{
+			desc: "sequential",
+			give: []string{
+				"(",
+				"-a",
+				"|",
+				"-b",
+				")",
+				" x",
+				"(",
+				"-c",
+				"|",
+				"-d",
+				")",
+			},
+			wantMinus: []string{
+				" \na\n \n \n \n x\n \nc\n \n \n \n",
+				" \na\n \n \n \n x\n \n \n \nd\n \n",
+				" \n \n \nb\n \n x\n \nc\n \n \n \n",
+				" \n \n \nb\n \n x\n \n \n \nd\n \n",
+			},
+		},
+		{
+			desc: "optional",
+			give: []string{
+				" foo()",
+				"?bar()",
+				"-baz()",
+			},
+			wantMinus: []string{
+				" foo()\nbar()\nbaz()\n",
+				" foo()\n     \nbaz()\n",
+			},
+		},
+		{
+			desc: "optional in disjunction",
+			give: []string{
+				"(",
+				"-a",
+				"?b",
+				"|",
+				"-c",
+				")",
+			},
+			wantMinus: []string{
+				" \na\nb\n \n \n \n",
+				" \na\n \n \n \n \n",
+				" \n \n \n \nc\n \n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			give := text.Unlines(tt.give...)
+			file := fset.AddFile("test.patch", -1, len(give))
+			file.SetLinesForContent(give)
+
+			got, err := newParser(fset).expandDisjunctions(linesOf(file, give))
+			require.NoError(t, err)
+
+			gotMinus := make([]string, len(got))
+			for i, v := range got {
+				minus, _ := splitPatch(v)
+				gotMinus[i] = string(minus.Contents)
+			}
+			assert.Equal(t, tt.wantMinus, gotMinus)
+		})
+	}
+}
+
+func TestExpandDisjunctionsErrors(t *testing.T) {
+	tests := []struct {
+		desc    string
+		give    []string
+		wantErr string
+	}{
+		{
+			desc:    "unclosed",
+			give:    []string{"(", "-a", "|", "-b"},
+			wantErr: `test.patch:4:3: unexpected end of patch, expected ")"`,
+		},
+		{
+			desc:    "single branch",
+			give:    []string{"(", "-a", ")"},
+			wantErr: "test.patch:1:1: disjunction must have at least two branches",
+		},
+		{
+			desc:    "empty branch",
+			give:    []string{"(", "-a", "|", ")"},
+			wantErr: "test.patch:3:1: disjunction branches cannot be empty",
+		},
+		{
+			desc:    "context line",
+			give:    []string{"(", " a", "|", "-b", ")"},
+			wantErr: `test.patch:2:1: unexpected " a": only "-" and "?" lines are allowed inside a disjunction`,
+		},
+		{
+			desc:    "added line",
+			give:    []string{"(", "-a", "|", "+b", ")"},
+			wantErr: `test.patch:4:1: unexpected "+b": only "-" and "?" lines are allowed inside a disjunction`,
+		},
+		{
+			desc: "too many optional lines",
+			give: []string{
+				"-a()", "?b1()", "?b2()", "?b3()", "?b4()", "?b5()",
+				"?b6()", "?b7()", "?b8()", "?b9()", "?b10()", "?b11()",
+			},
+			wantErr: "test.patch:12:1: patch expands to more than 1024 versions: use fewer disjunctions or optional lines",
+		},
+		{
+			desc: "too many nested versions",
+			give: []string{
+				"-a()",
+				"(",
+				"?b1()", "?b2()", "?b3()", "?b4()", "?b5()",
+				"|",
+				"?c1()", "?c2()", "?c3()", "?c4()", "?c5()",
+				")",
+				"(",
+				"?d1()", "?d2()", "?d3()", "?d4()", "?d5()",
+				"|",
+				"-e()",
+				")",
+			},
+			wantErr: "test.patch:15:1: patch expands to more than 1024 versions: use fewer disjunctions or optional lines",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			give := text.Unlines(tt.give...)
+			file := fset.AddFile("test.patch", -1, len(give))
+			file.SetLinesForContent(give)
+
+			_, err := newParser(fset).expandDisjunctions(linesOf(file, give))
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
+
+// linesOf splits the given contents of a file into a section.Section.
+func linesOf(file *token.File, contents []byte) section.Section {
+	var (
+		s   section.Section
+		off int
+	)
+	for _, line := range bytes.SplitAfter(contents, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		s = append(s, &section.Line{
+			StartPos: file.Pos(off),
+			Text:     bytes.TrimSuffix(line, []byte("\n")),
+		})
+		off += len(line)
+	}
+	return s
+}
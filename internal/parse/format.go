@@ -0,0 +1,712 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/uber-go/gopatch/internal/parse/section"
+	"github.com/uber-go/gopatch/internal/pgo/augment"
+)
+
+// Format formats the contents of a patch file into a canonical form.
+//
+// Code in the patch is formatted with gofmt, keeping the "-", "+", and " "
+// prefixes of its lines in place, and indented with tabs. Headers, include
+// directives, and metavariable declarations are normalized, blank lines are
+// collapsed, and comments are kept. Lines that can't be formatted on their
+// own, such as those with "when" constraints, are left as-is. Test files
+// are copied verbatim.
+//
+// Included files are not read, but the patch must otherwise be valid.
+func Format(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	p := newParser(fset)
+
+	changes, err := section.Split(fset, filename, src)
+	if err != nil {
+		return nil, err
+	}
+
+	includes, err := p.parseIncludes(changes[0])
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := make([]*Change, len(changes))
+	for i, c := range changes {
+		if parsed[i], err = p.parseChange(i, c); err != nil {
+			return nil, err
+		}
+	}
+
+	f := patchFormatter{
+		p:     p,
+		file:  fset.File(changes[0].Pos()),
+		lines: strings.Split(string(src), "\n"),
+	}
+	if err := f.formatProgram(changes, parsed, includes); err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(f.out, "\n") + "\n"), nil
+}
+
+// patchFormatter builds the formatted contents of a patch file, one line at
+// a time.
+type patchFormatter struct {
+	p     *parser
+	file  *token.File // file being formatted
+	lines []string    // lines of the file being formatted
+
+	out   []string // formatted lines
+	blank bool     // whether a blank line is due before the next line
+}
+
+// Writes a line to the output, preceded by a blank line if one is due.
+func (f *patchFormatter) line(s string) {
+	if f.blank {
+		f.out = append(f.out, "")
+		f.blank = false
+	}
+	f.out = append(f.out, s)
+}
+
+// Requests a blank line before the next line if lines were written since
+// the given number of lines. This collapses runs of blank lines and drops
+// them at the start of a block.
+func (f *patchFormatter) blankSince(n int) {
+	if len(f.out) > n {
+		f.blank = true
+	}
+}
+
+// Returns the 1-based line number of the given position in the file.
+func (f *patchFormatter) lineOf(pos token.Pos) int {
+	return f.file.Line(pos)
+}
+
+// Returns the contents of the given 1-based line of the file, without
+// trailing whitespace.
+func (f *patchFormatter) rawLine(n int) string {
+	return strings.TrimRightFunc(f.lines[n-1], unicode.IsSpace)
+}
+
+func (f *patchFormatter) formatProgram(changes section.Program, parsed []*Change, includes []*Include) error {
+	// Includes, and any comments around them, go before the comments of
+	// the first change.
+	start := len(f.out)
+	incs := make(map[int]*Include)
+	for _, inc := range includes {
+		incs[f.p.fset.Position(inc.Pos()).Line] = inc
+	}
+	for n := 1; n < f.changeStart(changes[0]); n++ {
+		switch text := f.rawLine(n); {
+		case len(strings.TrimSpace(text)) == 0:
+			f.blankSince(start)
+		case incs[n] != nil:
+			f.line(formatInclude(incs[n]))
+		default:
+			f.line(text)
+		}
+	}
+	f.blank = false
+
+	for i, c := range changes {
+		f.blankSince(0)
+
+		end := len(f.lines)
+		if i+1 < len(changes) {
+			end = f.changeStart(changes[i+1]) - 1
+		}
+		if err := f.formatChange(c, parsed[i], end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the line at which the given change starts, including the comments
+// right before it.
+func (f *patchFormatter) changeStart(c *section.Change) int {
+	return f.lineOf(c.Pos()) - len(c.Comments)
+}
+
+// Formats the given change, which ends at the given line.
+func (f *patchFormatter) formatChange(c *section.Change, change *Change, end int) error {
+	header := f.lineOf(c.Pos())
+	for n := f.changeStart(c); n < header; n++ {
+		f.line(f.rawLine(n))
+	}
+	f.line(formatHeader(change))
+
+	at := f.lineOf(c.AtPos)
+	f.formatMeta(change.Meta, header+1, at)
+	f.line("@@")
+
+	patchEnd := end + 1
+	if len(c.Tests) > 0 {
+		patchEnd = f.lineOf(c.Tests[0].NamePos)
+	}
+	if err := f.formatPatch(c.Patch, at+1, patchEnd); err != nil {
+		return err
+	}
+
+	for _, t := range c.Tests {
+		f.line("-- " + t.Name + " --")
+		data := string(t.Data)
+		if len(data) == 0 {
+			continue
+		}
+		for _, l := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+			f.out = append(f.out, l)
+		}
+	}
+	return nil
+}
+
+// Formats the metavariables section of a change found between the given
+// lines, exclusive.
+func (f *patchFormatter) formatMeta(meta *Meta, from, to int) {
+	decls := make(map[int][]string)
+	add := func(pos token.Pos, decl string) {
+		n := f.p.fset.Position(pos).Line
+		decls[n] = append(decls[n], decl)
+	}
+
+	// Params and vars may be interleaved. Visit them in the order they
+	// appear so that declarations sharing a line stay in order.
+	nodes := make([]interface{ Pos() token.Pos }, 0, len(meta.Params)+len(meta.Vars))
+	for _, d := range meta.Params {
+		nodes = append(nodes, d)
+	}
+	for _, d := range meta.Vars {
+		nodes = append(nodes, d)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].Pos() < nodes[j].Pos() })
+	for _, n := range nodes {
+		switch d := n.(type) {
+		case *ParamDecl:
+			add(d.Pos(), formatParamDecl(d))
+		case *VarDecl:
+			add(d.Pos(), formatVarDecl(d))
+		}
+	}
+
+	start := len(f.out)
+	for n := from; n < to; n++ {
+		text := f.rawLine(n)
+		switch {
+		case len(strings.TrimSpace(text)) == 0:
+			f.blankSince(start)
+		case isComment(text):
+			f.line(text)
+		default:
+			for _, d := range decls[n] {
+				f.line(d)
+			}
+		}
+	}
+	f.blank = false
+}
+
+// Formats the patch section of a change found between the given lines,
+// exclusive.
+func (f *patchFormatter) formatPatch(patch section.Section, from, to int) error {
+	code, err := f.p.formatCode(patch)
+	if err != nil {
+		return err
+	}
+
+	lines := make(map[int]int, len(patch)) // line number -> index in patch
+	for i, l := range patch {
+		lines[f.lineOf(l.Pos())] = i
+	}
+
+	start := len(f.out)
+	for n := from; n < to; n++ {
+		text := f.rawLine(n)
+		if i, ok := lines[n]; ok {
+			text = code[i]
+		}
+
+		if len(strings.TrimSpace(text)) == 0 {
+			f.blankSince(start)
+			continue
+		}
+		f.line(text)
+	}
+	f.blank = false
+	return nil
+}
+
+// Returns whether the given line is a comment.
+func isComment(s string) bool {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	return len(s) > 0 && s[0] == '#'
+}
+
+func formatInclude(inc *Include) string {
+	parts := []string{"include", inc.Path.Value}
+	for _, name := range inc.Changes {
+		parts = append(parts, name.Name)
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatHeader(c *Change) string {
+	var parts []string
+	if len(c.Name) > 0 {
+		parts = append(parts, c.Name)
+	}
+	for _, clause := range c.Clauses {
+		parts = append(parts, formatClause(clause))
+	}
+	if len(parts) == 0 {
+		return "@@"
+	}
+	return "@ " + strings.Join(parts, " ") + " @"
+}
+
+func formatClause(c Clause) string {
+	switch c := c.(type) {
+	case *FirstClause:
+		return "first"
+	case *WithinClause:
+		return "within " + c.Change.Name
+	case *FilesClause:
+		return "files " + joinStrings(c.Patterns)
+	case *ImportPathClause:
+		return "importpath " + joinStrings(c.Patterns)
+	case *TestsClause:
+		if c.Exclude {
+			return "notests"
+		}
+		return "tests"
+	case *TagClause:
+		return "tag " + c.Tag.Value
+	case *DependsClause:
+		return "depends on " + types.ExprString(c.Cond)
+	default:
+		panic(fmt.Sprintf("unknown clause type %T", c))
+	}
+}
+
+func formatParamDecl(d *ParamDecl) string {
+	var b strings.Builder
+	b.WriteString("param ")
+	for i, name := range d.Names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name.Name)
+	}
+	b.WriteString(" ")
+	b.WriteString(d.Type.Name)
+	return b.String()
+}
+
+func formatVarDecl(d *VarDecl) string {
+	var b strings.Builder
+	b.WriteString("var ")
+	for i, name := range d.Names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if d.Change != nil {
+			b.WriteString(d.Change.Name)
+			b.WriteString(".")
+		}
+		b.WriteString(name.Name)
+	}
+	b.WriteString(" ")
+	if d.FreshPos.IsValid() {
+		b.WriteString("fresh ")
+	}
+	b.WriteString(d.Type.Name)
+	if d.ListPos.IsValid() {
+		b.WriteString(" list")
+	}
+	if d.Seed != nil {
+		b.WriteString(" ")
+		b.WriteString(d.Seed.Value)
+	}
+	if c := d.Constraint; c != nil {
+		if c.Implements {
+			b.WriteString(" implements ")
+		} else {
+			b.WriteString(" : ")
+		}
+		b.WriteString(c.Type)
+	}
+	if d.Value != nil {
+		b.WriteString(" = ")
+		b.WriteString(types.ExprString(d.Value))
+	}
+	return b.String()
+}
+
+func joinStrings(lits []*ast.BasicLit) string {
+	values := make([]string, len(lits))
+	for i, lit := range lits {
+		values[i] = lit.Value
+	}
+	return strings.Join(values, " ")
+}
+
+// formatCode formats the code in the given patch section, returning the
+// formatted contents of each of its lines, with their prefixes.
+//
+// The "-" and " " lines are formatted as part of the "-" section of each
+// version of the patch, and the "+" lines as part of the "+" section. Lines
+// that couldn't be formatted are returned without trailing whitespace.
+func (p *parser) formatCode(patch section.Section) ([]string, error) {
+	versions, err := p.expandDisjunctions(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	// Positions at which lines of the "-" and "+" sections begin are
+	// either the positions of the original lines, or one past them if
+	// the lines have a prefix.
+	index := make(map[token.Pos]int, len(patch))
+	for i, l := range patch {
+		index[l.Pos()] = i
+	}
+	lineAt := func(pos token.Pos) (int, bool) {
+		if i, ok := index[pos]; ok {
+			return i, true
+		}
+		i, ok := index[pos-1]
+		return i, ok
+	}
+
+	minus := make([]*codeLine, len(patch))
+	plus := make([]*codeLine, len(patch))
+	fill := func(dst []*codeLine, v patchVersion) {
+		for pos, l := range formatPatchVersion(v) {
+			if i, ok := lineAt(pos); ok && dst[i] == nil {
+				dst[i] = l
+			}
+		}
+	}
+	for i, v := range versions {
+		m, pl := splitPatch(v)
+		fill(minus, m)
+		if i == 0 {
+			// All versions have the same "+" section.
+			fill(plus, pl)
+		}
+	}
+
+	lines := make([]string, len(patch))
+	for i, l := range patch {
+		text := string(bytes.TrimRightFunc(l.Text, unicode.IsSpace))
+		if len(strings.TrimSpace(text)) == 0 || disjunctionMarker(l) != "" {
+			lines[i] = strings.TrimSpace(text)
+			continue
+		}
+
+		prefix, code, formatted := " ", text, minus[i]
+		switch text[0] {
+		case '-', '?':
+			prefix, code = text[:1], text[1:]
+		case '+':
+			prefix, code, formatted = "+", text[1:], plus[i]
+		case ' ':
+			code = text[1:]
+		}
+		if formatted == nil && prefix == " " {
+			formatted = plus[i]
+		}
+
+		if formatted == nil {
+			lines[i] = prefix + code
+		} else {
+			lines[i] = prefix + strings.Repeat("\t", formatted.Indent) + formatted.Text
+		}
+	}
+	return lines, nil
+}
+
+// codeLine is a line of code formatted with gofmt.
+type codeLine struct {
+	// Number of tabs by which the line is indented.
+	Indent int
+
+	// Contents of the line without indentation.
+	Text string
+}
+
+// formatPatchVersion formats the code in one section of a version of a
+// patch with gofmt, returning the formatted lines keyed by their positions
+// in the patch. Lines that couldn't be formatted on their own are omitted.
+//
+// The code is augmented into a valid Go file as for parsing and formatted.
+// Tokens of the formatted file are then matched back to the lines they came
+// from.
+func formatPatchVersion(v patchVersion) map[token.Pos]*codeLine {
+	src := blankConstraints(v.Contents)
+	augmented, augs, adjs, err := augment.Augment(src)
+	if err != nil {
+		return nil
+	}
+	formatted, err := format.Source(augmented)
+	if err != nil {
+		return nil
+	}
+
+	before, after := scanCode(augmented), scanCode(formatted)
+	if len(before) != len(after) {
+		return nil
+	}
+	for i, t := range before {
+		u := after[i]
+		if t.Tok != u.Tok {
+			return nil
+		}
+		// gofmt may rewrite numbers and comments but it must not
+		// reorder identifiers or strings, as it does with imports.
+		if (t.Tok == token.IDENT || t.Tok == token.STRING) && t.Text != u.Text {
+			return nil
+		}
+	}
+
+	// Offsets of "..." in the augmented file. Named dots are replaced
+	// with two tokens, "_ d", the second of which we'll drop.
+	var (
+		dots     = make(map[int]struct{})
+		dotsName = make(map[int]struct{})
+
+		// Offset after which code is inside a generated function body.
+		funcBody = len(augmented)
+	)
+	for _, aug := range augs {
+		switch a := aug.(type) {
+		case *augment.Dots:
+			dots[a.DotsStart] = struct{}{}
+			if a.Named {
+				dotsName[a.DotsStart+2] = struct{}{}
+			}
+		case *augment.FakeFunc:
+			if a.Braces {
+				funcBody = a.FuncStart
+			}
+		}
+	}
+
+	// Group tokens by the lines they came from.
+	type span struct{ first, last int }
+	var (
+		spans = make([]*span, len(v.Lines))
+		order []int // indexes of lines with tokens
+	)
+	for i, t := range before {
+		off, ok := originalOffset(adjs, t.Off, len(src))
+		if !ok {
+			continue
+		}
+		line := sort.Search(len(v.Lines), func(j int) bool {
+			return v.Lines[j].Offset > off
+		}) - 1
+		if line < 0 {
+			continue
+		}
+		if spans[line] == nil {
+			spans[line] = &span{first: i}
+			order = append(order, line)
+		}
+		spans[line].last = i
+	}
+
+	lines := make(map[token.Pos]*codeLine)
+	for _, line := range order {
+		s := spans[line]
+
+		// The tokens must make up the entire line. Constraints on
+		// "..." were blanked out above so lines with them don't.
+		var want, got strings.Builder
+		for i := s.first; i <= s.last; i++ {
+			t := before[i]
+			if _, ok := dots[t.Off]; ok {
+				want.WriteString("...")
+			} else if _, ok := dotsName[t.Off]; !ok {
+				want.WriteString(t.Text)
+			}
+		}
+		start := v.Lines[line].Offset
+		end := len(v.Contents)
+		if line+1 < len(v.Lines) {
+			end = v.Lines[line+1].Offset
+		}
+		if removeSpaces(want.String()) != removeSpaces(string(v.Contents[start:end])) {
+			continue
+		}
+
+		// Rebuild the line from the formatted tokens, stopping if they
+		// don't fit on a single line.
+		ok := true
+		for i := s.first; i <= s.last && ok; i++ {
+			t, u := before[i], after[i]
+			if _, isName := dotsName[t.Off]; isName {
+				continue
+			}
+			if i > s.first {
+				space := formatted[after[i-1].End:u.Off]
+				if bytes.IndexByte(space, '\n') >= 0 {
+					ok = false
+				}
+				got.Write(space)
+			}
+			if _, isDots := dots[t.Off]; isDots {
+				got.WriteString("...")
+			} else {
+				got.WriteString(u.Text)
+			}
+		}
+		if !ok || strings.Contains(got.String(), "\n") {
+			continue
+		}
+
+		// The line must start on its own line in the formatted file,
+		// indented only by tabs.
+		first := after[s.first].Off
+		bol := bytes.LastIndexByte(formatted[:first], '\n') + 1
+		indent := formatted[bol:first]
+		if len(bytes.Trim(indent, "\t")) > 0 {
+			continue
+		}
+
+		l := codeLine{Indent: len(indent), Text: got.String()}
+		if before[s.first].Off > funcBody && l.Indent > 0 {
+			l.Indent-- // generated function body
+		}
+		lines[v.Lines[line].Pos] = &l
+	}
+	return lines
+}
+
+// Replaces "when" constraints following "..." in the given code with
+// spaces. Constraints are turned into statements when the code is
+// augmented, which gofmt would then move to their own lines.
+func blankConstraints(src []byte) []byte {
+	_, augs, adjs, err := augment.Augment(src)
+	if err != nil {
+		return src
+	}
+
+	var out []byte
+	for _, aug := range augs {
+		dots, ok := aug.(*augment.Dots)
+		if !ok {
+			continue
+		}
+		for _, w := range dots.When {
+			start, ok := originalOffset(adjs, w.WhenStart, len(src))
+			if !ok {
+				continue
+			}
+			if out == nil {
+				out = append([]byte(nil), src...)
+			}
+			for i := start; i < start+w.WhenEnd-w.WhenStart && i < len(out); i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+		}
+	}
+	if out == nil {
+		return src
+	}
+	return out
+}
+
+// Maps an offset in augmented code back to the code of the given length it
+// was generated from. Returns false if the offset is inside code generated
+// by the augmentation.
+func originalOffset(adjs []augment.PosAdjustment, off, size int) (int, bool) {
+	var reduceBy int
+	for _, adj := range adjs {
+		if off < adj.Offset {
+			break
+		}
+		if off < adj.Offset+adj.ReduceBy-reduceBy {
+			return 0, false
+		}
+		reduceBy = adj.ReduceBy
+	}
+	off -= reduceBy
+	return off, off < size
+}
+
+// codeToken is a token scanned from Go code.
+type codeToken struct {
+	Tok      token.Token
+	Off, End int
+	Text     string
+}
+
+// Scans the tokens of the given Go code, including comments and explicit
+// semicolons, but not semicolons inserted at the end of lines.
+func scanCode(src []byte) []codeToken {
+	file := token.NewFileSet().AddFile("", -1, len(src))
+
+	var (
+		s    scanner.Scanner
+		toks []codeToken
+	)
+	s.Init(file, src, nil /* error handler */, scanner.ScanComments)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return toks
+		}
+		if tok == token.SEMICOLON && lit != ";" {
+			continue
+		}
+
+		text := lit
+		if len(text) == 0 {
+			text = tok.String()
+		}
+		off := file.Offset(pos)
+		toks = append(toks, codeToken{Tok: tok, Off: off, End: off + len(text), Text: text})
+	}
+}
+
+// Returns the given string without whitespace.
+func removeSpaces(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,346 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		desc string
+		give []string
+
+		// Formatted patch. Defaults to give if unset.
+		want    []string
+		wantErr string
+	}{
+		{
+			desc: "formatted",
+			give: []string{
+				"@@",
+				"var x expression",
+				"@@",
+				"-foo(x)",
+				"+bar(x)",
+			},
+		},
+		{
+			desc: "code",
+			give: []string{
+				"@@",
+				"var err identifier",
+				"@@",
+				"if err   != nil {",
+				"-  return nil,err",
+				"+    return  wrap( err )",
+				"   }",
+			},
+			want: []string{
+				"@@",
+				"var err identifier",
+				"@@",
+				" if err != nil {",
+				"-\treturn nil, err",
+				"+\treturn wrap(err)",
+				" }",
+			},
+		},
+		{
+			desc: "declarations",
+			give: []string{
+				"@@",
+				"@@",
+				"-func (...) Do( ) {",
+				"+func (...) Do() error {",
+				"  ...",
+				"+ return nil",
+				" }",
+			},
+			want: []string{
+				"@@",
+				"@@",
+				"-func (...) Do() {",
+				"+func (...) Do() error {",
+				" \t...",
+				"+\treturn nil",
+				" }",
+			},
+		},
+		{
+			desc: "imports",
+			give: []string{
+				"@@",
+				"@@",
+				"-import   \"errors\"",
+				"",
+				"",
+				"-errors.New(fmt.Sprintf(...))",
+				"+fmt.Errorf( ... )",
+			},
+			want: []string{
+				"@@",
+				"@@",
+				"-import \"errors\"",
+				"",
+				"-errors.New(fmt.Sprintf(...))",
+				"+fmt.Errorf(...)",
+			},
+		},
+		{
+			desc: "disjunction and optional line",
+			give: []string{
+				"@@",
+				"@@",
+				"(",
+				"-a  ==  nil",
+				"|",
+				"-len(a)==0",
+				")",
+				"+isEmpty( a )",
+				"?defer  done()",
+			},
+			want: []string{
+				"@@",
+				"@@",
+				"(",
+				"-a == nil",
+				"|",
+				"-len(a) == 0",
+				")",
+				"+isEmpty(a)",
+				"?defer done()",
+			},
+		},
+		{
+			desc: "constraints are kept",
+			give: []string{
+				"@@",
+				"var mu expression",
+				"@@",
+				" mu.Lock()",
+				"+defer   mu.Unlock()",
+				" ... when != mu.Unlock()",
+			},
+			want: []string{
+				"@@",
+				"var mu expression",
+				"@@",
+				" mu.Lock()",
+				"+defer mu.Unlock()",
+				" ... when != mu.Unlock()",
+			},
+		},
+		{
+			desc: "header",
+			give: []string{
+				"@   foo   within bar   depends on  !baz&&(qux||quux)   @",
+				"@@",
+				"-x",
+				"+y",
+			},
+			want: []string{
+				"@ foo within bar depends on !baz && (qux || quux) @",
+				"@@",
+				"-x",
+				"+y",
+			},
+		},
+		{
+			desc: "clauses without name",
+			give: []string{
+				"@ notests   files \"a/**\"  \"b/**\" tag \"x\" @",
+				"@@",
+				"-x",
+				"+y",
+			},
+			want: []string{
+				"@ notests files \"a/**\" \"b/**\" tag \"x\" @",
+				"@@",
+				"-x",
+				"+y",
+			},
+		},
+		{
+			desc: "metavariables",
+			give: []string{
+				"@ a @",
+				"var x,y   identifier",
+				"@@",
+				"-x",
+				"+y",
+				"",
+				"",
+				"@@",
+				"",
+				"param   old ,new identifier",
+				"# comment",
+				"",
+				"",
+				"var a.x,a.y identifier",
+				"var tmp   fresh identifier   \"err\"",
+				"var args expression   list",
+				"var short identifier=trimPrefix(old,\"Get\")",
+				"var c expression   implements io.Closer",
+				"var d expression:*net/http.Client",
+				"",
+				"@@",
+				"-old",
+				"+new",
+			},
+			want: []string{
+				"@ a @",
+				"var x, y identifier",
+				"@@",
+				"-x",
+				"+y",
+				"",
+				"@@",
+				"param old, new identifier",
+				"# comment",
+				"",
+				"var a.x, a.y identifier",
+				"var tmp fresh identifier \"err\"",
+				"var args expression list",
+				"var short identifier = trimPrefix(old, \"Get\")",
+				"var c expression implements io.Closer",
+				"var d expression : *net/http.Client",
+				"@@",
+				"-old",
+				"+new",
+			},
+		},
+		{
+			desc: "includes and comments",
+			give: []string{
+				"",
+				"include   \"common.patch\"   foo  bar",
+				"# about includes   ",
+				"",
+				"",
+				"# Replaces x with y.",
+				"@@",
+				"@@",
+				"# inside the patch",
+				"-x",
+				"",
+				"",
+				"+y",
+				"",
+				"",
+			},
+			want: []string{
+				"include \"common.patch\" foo bar",
+				"# about includes",
+				"",
+				"# Replaces x with y.",
+				"@@",
+				"@@",
+				"# inside the patch",
+				"-x",
+				"",
+				"+y",
+			},
+		},
+		{
+			desc: "tests",
+			give: []string{
+				"@@",
+				"@@",
+				"-x",
+				"+y",
+				"-- a.in.go --",
+				"package a",
+				"",
+				"",
+				"var _ =   x",
+				"-- a.out.go --",
+				"package a",
+				"",
+				"",
+				"var _ =   y",
+				"",
+				"",
+				"",
+				"@@",
+				"@@",
+				"-z",
+				"+w",
+			},
+			want: []string{
+				"@@",
+				"@@",
+				"-x",
+				"+y",
+				"-- a.in.go --",
+				"package a",
+				"",
+				"",
+				"var _ =   x",
+				"-- a.out.go --",
+				"package a",
+				"",
+				"",
+				"var _ =   y",
+				"",
+				"@@",
+				"@@",
+				"-z",
+				"+w",
+			},
+		},
+		{
+			desc: "invalid patch",
+			give: []string{
+				"@@",
+				"var x expresion",
+				"@@",
+			},
+			wantErr: "test.patch:3:1: invalid change: patch cannot be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := Format("test.patch", text.Unlines(tt.give...))
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			want := tt.want
+			if want == nil {
+				want = tt.give
+			}
+			assert.Equal(t, string(text.Unlines(want...)), string(got))
+
+			again, err := Format("test.patch", got)
+			require.NoError(t, err)
+			assert.Equal(t, string(got), string(again), "formatting must be idempotent")
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parse
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/uber-go/gopatch/internal/parse/section"
+	"go.uber.org/multierr"
+)
+
+// Parses the clauses in the header of the change at index i.
+func (p *parser) parseClauses(i int, c *section.Change) ([]Clause, error) {
+	if len(c.Clauses) == 0 {
+		return nil, nil
+	}
+
+	parser := p.newSectionParser(p.sectionFilename(i, c, ".header"), c.Clauses)
+	return parser.parseClauses(), multierr.Combine(parser.errors...)
+}
+
+func (p *metaParser) parseClauses() []Clause {
+	var clauses []Clause
+	for !p.failed && p.tok != token.EOF && p.tok != token.SEMICOLON {
+		if c := p.parseClause(); c != nil {
+			clauses = append(clauses, c)
+		}
+	}
+	return clauses
+}
+
+func (p *metaParser) parseClause() Clause {
+	if p.tok != token.IDENT {
+		p.errf("unexpected %q, expected a clause", p.tok)
+		return nil
+	}
+
+	switch p.text {
+	case "first":
+		c := FirstClause{FirstPos: p.pos}
+		p.next() // first
+		return &c
+	case "within":
+		return p.parseWithinClause()
+	case "files":
+		return p.parseFilesClause()
+	case "importpath":
+		return p.parseImportPathClause()
+	case "tests", "notests":
+		c := TestsClause{TestsPos: p.pos, Exclude: p.text == "notests"}
+		p.next() // tests/notests
+		return &c
+	case "tag":
+		return p.parseTagClause()
+	case "depends":
+		return p.parseDependsClause()
+	default:
+		p.errf("unknown clause %q", p.text)
+		p.next()
+		return nil
+	}
+}
+
+func (p *metaParser) parseWithinClause() Clause {
+	c := WithinClause{WithinPos: p.pos}
+	p.next() // within
+
+	c.Change = p.parseIdent()
+	if c.Change == nil {
+		return nil
+	}
+	return &c
+}
+
+func (p *metaParser) parseFilesClause() Clause {
+	c := FilesClause{FilesPos: p.pos}
+	p.next() // files
+
+	if c.Patterns = p.parseStrings(); c.Patterns == nil {
+		return nil
+	}
+	return &c
+}
+
+// Parses one or more strings. Fails the parser and returns nil if there
+// are none.
+func (p *metaParser) parseStrings() []*ast.BasicLit {
+	var lits []*ast.BasicLit
+	for p.tok == token.STRING {
+		lits = append(lits, p.parseString())
+	}
+	if len(lits) == 0 {
+		p.errf("unexpected %q, expected a string", p.tok)
+	}
+	return lits
+}
+
+func (p *metaParser) parseImportPathClause() Clause {
+	c := ImportPathClause{ImportPathPos: p.pos}
+	p.next() // importpath
+
+	if c.Patterns = p.parseStrings(); c.Patterns == nil {
+		return nil
+	}
+	return &c
+}
+
+func (p *metaParser) parseTagClause() Clause {
+	c := TagClause{TagPos: p.pos}
+	p.next() // tag
+
+	if p.tok != token.STRING {
+		p.errf("unexpected %q, expected a string", p.tok)
+		return nil
+	}
+	c.Tag = p.parseString()
+	return &c
+}
+
+func (p *metaParser) parseDependsClause() Clause {
+	c := DependsClause{DependsPos: p.pos}
+	p.next() // depends
+
+	if p.tok != token.IDENT || p.text != "on" {
+		p.errf(`unexpected %q, expected "on"`, p.tok)
+		return nil
+	}
+	p.next() // on
+
+	if c.Cond = p.parseDependsOr(); c.Cond == nil {
+		return nil
+	}
+	return &c
+}
+
+// Parses a condition of a "depends on" clause. "||" binds more loosely
+// than "&&", which binds more loosely than "!".
+//
+//	foo || bar && !baz
+func (p *metaParser) parseDependsOr() ast.Expr {
+	x := p.parseDependsAnd()
+	for x != nil && p.tok == token.LOR {
+		pos := p.pos
+		p.next() // ||
+		y := p.parseDependsAnd()
+		if y == nil {
+			return nil
+		}
+		x = &ast.BinaryExpr{X: x, OpPos: pos, Op: token.LOR, Y: y}
+	}
+	return x
+}
+
+func (p *metaParser) parseDependsAnd() ast.Expr {
+	x := p.parseDependsUnary()
+	for x != nil && p.tok == token.LAND {
+		pos := p.pos
+		p.next() // &&
+		y := p.parseDependsUnary()
+		if y == nil {
+			return nil
+		}
+		x = &ast.BinaryExpr{X: x, OpPos: pos, Op: token.LAND, Y: y}
+	}
+	return x
+}
+
+func (p *metaParser) parseDependsUnary() ast.Expr {
+	switch p.tok {
+	case token.NOT:
+		pos := p.pos
+		p.next() // !
+		x := p.parseDependsUnary()
+		if x == nil {
+			return nil
+		}
+		return &ast.UnaryExpr{OpPos: pos, Op: token.NOT, X: x}
+
+	case token.LPAREN:
+		lparen := p.pos
+		p.next() // (
+		x := p.parseDependsOr()
+		if x == nil {
+			return nil
+		}
+		if p.tok != token.RPAREN {
+			p.errf(`unexpected %q, expected ")"`, p.tok)
+			return nil
+		}
+		rparen := p.pos
+		p.next() // )
+		return &ast.ParenExpr{Lparen: lparen, X: x, Rparen: rparen}
+
+	case token.IDENT:
+		return p.parseIdent()
+
+	default:
+		p.errf(`unexpected %q, expected the name of a change`, p.tok)
+		return nil
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/goast"
+	"github.com/uber-go/gopatch/internal/parse/section"
+)
+
+func TestParseClauses(t *testing.T) {
+	tests := []struct {
+		desc string
+		give string // header between the "@"s
+
+		// Positions in want are offsets in give starting at 1, adjusted
+		// relative to the header File's Base() later.
+		want []Clause
+
+		wantErrs []string
+	}{
+		{
+			desc: "no clauses",
+			give: "foo",
+		},
+		{
+			desc: "within",
+			give: "within bar",
+			want: []Clause{
+				&WithinClause{
+					WithinPos: 1,
+					Change:    &ast.Ident{Name: "bar", NamePos: 8},
+				},
+			},
+		},
+		{
+			desc: "first",
+			give: "foo first within bar",
+			want: []Clause{
+				&FirstClause{FirstPos: 1},
+				&WithinClause{
+					WithinPos: 7,
+					Change:    &ast.Ident{Name: "bar", NamePos: 14},
+				},
+			},
+		},
+		{
+			desc: "files",
+			give: `files "a/**" "*_test.go"`,
+			want: []Clause{
+				&FilesClause{
+					FilesPos: 1,
+					Patterns: []*ast.BasicLit{
+						{ValuePos: 7, Kind: token.STRING, Value: `"a/**"`},
+						{ValuePos: 14, Kind: token.STRING, Value: `"*_test.go"`},
+					},
+				},
+			},
+		},
+		{
+			desc: "importpath",
+			give: `importpath "example.com/..."`,
+			want: []Clause{
+				&ImportPathClause{
+					ImportPathPos: 1,
+					Patterns: []*ast.BasicLit{
+						{ValuePos: 12, Kind: token.STRING, Value: `"example.com/..."`},
+					},
+				},
+			},
+		},
+		{
+			desc: "tests",
+			give: "foo tests",
+			want: []Clause{
+				&TestsClause{TestsPos: 1},
+			},
+		},
+		{
+			desc: "notests",
+			give: "notests first",
+			want: []Clause{
+				&TestsClause{TestsPos: 1, Exclude: true},
+				&FirstClause{FirstPos: 9},
+			},
+		},
+		{
+			desc: "tag",
+			give: `tag "integration"`,
+			want: []Clause{
+				&TagClause{
+					TagPos: 1,
+					Tag:    &ast.BasicLit{ValuePos: 5, Kind: token.STRING, Value: `"integration"`},
+				},
+			},
+		},
+		{
+			desc: "depends",
+			give: "foo depends on bar",
+			want: []Clause{
+				&DependsClause{
+					DependsPos: 1,
+					Cond:       &ast.Ident{Name: "bar", NamePos: 12},
+				},
+			},
+		},
+		{
+			desc: "depends precedence",
+			give: "depends on !a || b && (c || d) first",
+			want: []Clause{
+				&DependsClause{
+					DependsPos: 1,
+					Cond: &ast.BinaryExpr{
+						X: &ast.UnaryExpr{
+							OpPos: 12,
+							Op:    token.NOT,
+							X:     &ast.Ident{Name: "a", NamePos: 13},
+						},
+						OpPos: 15,
+						Op:    token.LOR,
+						Y: &ast.BinaryExpr{
+							X:     &ast.Ident{Name: "b", NamePos: 18},
+							OpPos: 20,
+							Op:    token.LAND,
+							Y: &ast.ParenExpr{
+								Lparen: 23,
+								X: &ast.BinaryExpr{
+									X:     &ast.Ident{Name: "c", NamePos: 24},
+									OpPos: 26,
+									Op:    token.LOR,
+									Y:     &ast.Ident{Name: "d", NamePos: 29},
+								},
+								Rparen: 30,
+							},
+						},
+					},
+				},
+				&FirstClause{FirstPos: 32},
+			},
+		},
+		{
+			desc: "files without patterns",
+			give: "foo files",
+			wantErrs: []string{
+				`test.patch:1:12: unexpected ";", expected a string`,
+			},
+		},
+		{
+			desc: "tag without name",
+			give: "foo tag",
+			wantErrs: []string{
+				`test.patch:1:10: unexpected ";", expected a string`,
+			},
+		},
+		{
+			desc: "depends without on",
+			give: "foo depends bar",
+			wantErrs: []string{
+				`test.patch:1:15: unexpected "IDENT", expected "on"`,
+			},
+		},
+		{
+			desc: "depends without change",
+			give: "foo depends on !",
+			wantErrs: []string{
+				`test.patch:1:20: unexpected "EOF", expected the name of a change`,
+			},
+		},
+		{
+			desc: "depends unclosed paren",
+			give: "foo depends on (a || b",
+			wantErrs: []string{
+				`test.patch:1:25: unexpected ";", expected ")"`,
+			},
+		},
+		{
+			desc: "within without name",
+			give: "foo within",
+			wantErrs: []string{
+				`test.patch:1:13: unexpected ";", expected an identifier`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+
+			var src bytes.Buffer
+			fmt.Fprintf(&src, "@ %v @\n", tt.give)
+			fmt.Fprintln(&src, "@@")
+			fmt.Fprintln(&src, "-foo")
+
+			sections, err := section.Split(fset, "test.patch", src.Bytes())
+			require.NoError(t, err)
+			require.Len(t, sections, 1, "expected exactly one change")
+
+			got, err := newParser(fset).parseClauses(0, sections[0])
+			if len(tt.wantErrs) > 0 {
+				require.Error(t, err)
+				for _, msg := range tt.wantErrs {
+					assert.Contains(t, err.Error(), msg)
+				}
+				return
+			}
+			require.NoError(t, err)
+
+			if len(got) > 0 {
+				file := fset.File(got[0].Pos())
+				goast.OffsetPos(tt.want, file.Base()-1)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
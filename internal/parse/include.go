@@ -0,0 +1,231 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parse
+
+import (
+	"go/ast"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/uber-go/gopatch/internal/parse/section"
+	"go.uber.org/multierr"
+)
+
+// Parses the include directives at the top of the program that starts with
+// the given change.
+func (p *parser) parseIncludes(c *section.Change) ([]*Include, error) {
+	if len(c.Includes) == 0 {
+		return nil, nil
+	}
+
+	filename := p.fset.File(c.Pos()).Name() + ".include"
+	parser := p.newSectionParser(filename, c.Includes)
+	return parser.parseIncludes(), multierr.Combine(parser.errors...)
+}
+
+func (p *metaParser) parseIncludes() []*Include {
+	var incs []*Include
+	for !p.failed && p.tok != token.EOF {
+		if inc := p.parseInclude(); inc != nil {
+			incs = append(incs, inc)
+		}
+	}
+	return incs
+}
+
+// Parses a single include directive.
+//
+//	include "common.patch" getters setters
+func (p *metaParser) parseInclude() *Include {
+	if p.tok != token.IDENT || p.text != "include" {
+		p.errf(`unexpected %q, expected "include"`, p.tok)
+		return nil
+	}
+
+	inc := Include{IncludePos: p.pos}
+	p.next() // include
+
+	if p.tok != token.STRING {
+		p.errf("unexpected %q, expected a string", p.tok)
+		return nil
+	}
+	inc.Path = p.parseString()
+
+	for p.tok == token.IDENT {
+		inc.Changes = append(inc.Changes, p.parseIdent())
+	}
+
+	if p.tok != token.SEMICOLON {
+		p.errf(`unexpected %q, expected ";" or a newline`, p.tok)
+		return nil
+	}
+	p.next() // ;
+	return &inc
+}
+
+// Parses the files included by the file with the given name and returns the
+// changes they splice into its program.
+func (p *parser) spliceIncludes(filename string, incs []*Include) ([]*Change, error) {
+	var (
+		changes []*Change
+		seen    = make(map[*Change]struct{})
+	)
+	for _, inc := range incs {
+		included, err := p.include(filename, inc)
+		if err != nil {
+			return nil, err
+		}
+
+		// The same change may be included more than once if two
+		// included files include a third.
+		for _, c := range included {
+			if _, ok := seen[c]; !ok {
+				seen[c] = struct{}{}
+				changes = append(changes, c)
+			}
+		}
+	}
+	return changes, nil
+}
+
+// Parses the file included by the given directive in the file with the
+// given name, and returns the changes selected by the directive.
+func (p *parser) include(filename string, inc *Include) ([]*Change, error) {
+	path, err := strconv.Unquote(inc.Path.Value)
+	if err != nil {
+		return nil, p.errf(inc.Path.Pos(), "invalid path %v: %v", inc.Path.Value, err)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(filename), filepath.FromSlash(path))
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	for i, f := range p.including {
+		if f == path {
+			cycle := append(append([]string(nil), p.including[i:]...), path)
+			return nil, p.errf(inc.Path.Pos(), "include cycle: %v", strings.Join(cycle, " -> "))
+		}
+	}
+
+	prog, ok := p.included[path]
+	if !ok {
+		contents, err := p.readFile(path)
+		if err != nil {
+			return nil, p.errf(inc.Path.Pos(), "cannot include %v: %v", inc.Path.Value, err)
+		}
+
+		prog, err = p.parseProgram(path, contents)
+		if err != nil {
+			return nil, err
+		}
+		p.included[path] = prog
+	}
+
+	if len(inc.Changes) == 0 {
+		return prog.Changes, nil
+	}
+	return p.selectChanges(prog, inc)
+}
+
+// Returns the changes of an included program named by the directive, along
+// with the changes they depend on, in the order in which they appear in the
+// program.
+func (p *parser) selectChanges(prog *Program, inc *Include) ([]*Change, error) {
+	byName := make(map[string]*Change)
+	for _, c := range prog.Changes {
+		if len(c.Name) == 0 {
+			continue
+		}
+		// Changes left out by the selection aren't compiled so
+		// duplicates must be reported here.
+		if prev, ok := byName[c.Name]; ok {
+			return nil, p.errf(c.HeaderPos, "change %q is already declared at %v",
+				c.Name, p.fset.Position(prev.HeaderPos))
+		}
+		byName[c.Name] = c
+	}
+
+	selected := make(map[*Change]struct{})
+	var visit func(name string)
+	visit = func(name string) {
+		c, ok := byName[name]
+		if !ok {
+			// Unknown dependencies are reported by the compiler.
+			return
+		}
+		if _, ok := selected[c]; ok {
+			return
+		}
+		selected[c] = struct{}{}
+		for _, dep := range c.Dependencies() {
+			visit(dep)
+		}
+	}
+
+	for _, name := range inc.Changes {
+		if _, ok := byName[name.Name]; !ok {
+			return nil, p.errf(name.Pos(), "change %q not found in %v", name.Name, inc.Path.Value)
+		}
+		visit(name.Name)
+	}
+
+	var changes []*Change
+	for _, c := range prog.Changes {
+		if _, ok := selected[c]; ok {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// Dependencies returns the names of the changes that this change depends
+// on: the changes it runs within, those named in its "depends on" clauses,
+// and those it inherits metavariables from.
+func (c *Change) Dependencies() []string {
+	var deps []string
+	for _, clause := range c.Clauses {
+		switch clause := clause.(type) {
+		case *WithinClause:
+			if clause.Change != nil {
+				deps = append(deps, clause.Change.Name)
+			}
+		case *DependsClause:
+			ast.Inspect(clause.Cond, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Ident); ok {
+					deps = append(deps, ident.Name)
+				}
+				return true
+			})
+		}
+	}
+	if c.Meta != nil {
+		for _, v := range c.Meta.Vars {
+			if v != nil && v.Change != nil {
+				deps = append(deps, v.Change.Name)
+			}
+		}
+	}
+	return deps
+}
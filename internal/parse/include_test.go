@@ -0,0 +1,334 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parse
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestParseIncludes(t *testing.T) {
+	// Changes shared by the tests below.
+	common := text.Unlines(
+		"# Find foo.",
+		"@ foo @",
+		"var x identifier",
+		"@@",
+		"-foo(x)",
+		"+bar(x)",
+		"",
+		"@ baz within foo @",
+		"@@",
+		"-baz()",
+		"+baz(1)",
+		"",
+		"@ qux @",
+		"var foo.x identifier",
+		"@@",
+		"-qux(x)",
+		"+qux()",
+		"",
+		"@ quux @",
+		"@@",
+		"-quux()",
+		"+quux(1)",
+	)
+
+	tests := []struct {
+		desc  string
+		files map[string][]byte // relative to /patches
+		give  []byte            // contents of /patches/main.patch
+
+		wantNames []string // names of the changes in the program
+		wantErrs  []string
+	}{
+		{
+			desc:  "all changes",
+			files: map[string][]byte{"lib/common.patch": common},
+			give: text.Unlines(
+				`include "lib/common.patch"`,
+				"",
+				"@ mine @",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantNames: []string{"foo", "baz", "qux", "quux", "mine"},
+		},
+		{
+			desc:  "named changes",
+			files: map[string][]byte{"lib/common.patch": common},
+			give: text.Unlines(
+				`include "lib/common.patch" quux`,
+				"@ mine @",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantNames: []string{"quux", "mine"},
+		},
+		{
+			desc:  "dependencies",
+			files: map[string][]byte{"lib/common.patch": common},
+			give: text.Unlines(
+				`include "lib/common.patch" baz qux`,
+				"@ mine @",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantNames: []string{"foo", "baz", "qux", "mine"},
+		},
+		{
+			desc: "depends on",
+			files: map[string][]byte{
+				"lib/deps.patch": text.Unlines(
+					"@ a @", "@@", "-a", "+a()", "",
+					"@ b @", "@@", "-b", "+b()", "",
+					"@ c @", "@@", "-c", "+c()", "",
+					"@ d depends on a && !c @", "@@", "-d", "+d()",
+				),
+			},
+			give: text.Unlines(
+				`include "lib/deps.patch" d`,
+				"@ mine @",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantNames: []string{"a", "c", "d", "mine"},
+		},
+		{
+			desc: "relative to including file",
+			files: map[string][]byte{
+				"lib/all.patch":    text.Unlines(`include "common.patch" foo`, "@ all @", "@@", "-y", "+y()"),
+				"lib/common.patch": common,
+			},
+			give: text.Unlines(
+				`include "lib/all.patch"`,
+				"@ mine @",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantNames: []string{"foo", "all", "mine"},
+		},
+		{
+			desc: "included twice",
+			files: map[string][]byte{
+				"a.patch":      text.Unlines(`include "common.patch" quux`, "@ a @", "@@", "-a", "+a()"),
+				"b.patch":      text.Unlines(`include "common.patch" quux`, "@ b @", "@@", "-b", "+b()"),
+				"common.patch": common,
+			},
+			give: text.Unlines(
+				`include "a.patch"`,
+				`include "b.patch"`,
+				"@ mine @",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantNames: []string{"quux", "a", "b", "mine"},
+		},
+		{
+			desc: "cycle",
+			files: map[string][]byte{
+				"a.patch": text.Unlines(`include "b.patch"`, "@ a @", "@@", "-a", "+a()"),
+				"b.patch": text.Unlines(`include "main.patch"`, "@ b @", "@@", "-b", "+b()"),
+			},
+			give: text.Unlines(
+				`include "a.patch"`,
+				"@ mine @",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantErrs: []string{
+				`/patches/b.patch:1:9: include cycle: ` +
+					`/patches/main.patch -> /patches/a.patch -> /patches/b.patch -> /patches/main.patch`,
+			},
+		},
+		{
+			desc: "missing file",
+			give: text.Unlines(
+				`include "missing.patch"`,
+				"@@",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantErrs: []string{
+				`/patches/main.patch:1:9: cannot include "missing.patch"`,
+			},
+		},
+		{
+			desc:  "unknown change",
+			files: map[string][]byte{"common.patch": common},
+			give: text.Unlines(
+				`include "common.patch" foo nope`,
+				"@@",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantErrs: []string{
+				`/patches/main.patch:1:28: change "nope" not found in "common.patch"`,
+			},
+		},
+		{
+			desc: "duplicate name in included file",
+			files: map[string][]byte{
+				"dup.patch": text.Unlines(
+					"@ a @", "@@", "-a", "+a()", "",
+					"@ a @", "@@", "-b", "+b()",
+				),
+			},
+			give: text.Unlines(
+				`include "dup.patch" a`,
+				"@@",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantErrs: []string{
+				`/patches/dup.patch:6:1: change "a" is already declared at /patches/dup.patch:1:1`,
+			},
+		},
+		{
+			desc: "error in included file",
+			files: map[string][]byte{
+				"bad.patch": text.Unlines(
+					"@@",
+					"var x",
+					"@@",
+					"-x",
+					"+y",
+				),
+			},
+			give: text.Unlines(
+				`include "bad.patch"`,
+				"@@",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantErrs: []string{
+				`/patches/bad.patch:2:6: unexpected ";", expected an identifier`,
+			},
+		},
+		{
+			desc: "missing path",
+			give: text.Unlines(
+				"include foo",
+				"@@",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantErrs: []string{
+				`/patches/main.patch:1:9: unexpected "IDENT", expected a string`,
+			},
+		},
+		{
+			desc: "unexpected token",
+			give: text.Unlines(
+				`include "foo.patch", bar`,
+				"@@",
+				"@@",
+				"-x",
+				"+y",
+			),
+			wantErrs: []string{
+				`/patches/main.patch:1:20: unexpected ",", expected ";" or a newline`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			p := newParser(fset)
+			p.readFile = func(path string) ([]byte, error) {
+				rel, err := filepath.Rel("/patches", path)
+				if err != nil {
+					return nil, err
+				}
+				if rel == "main.patch" {
+					return tt.give, nil
+				}
+				if bs, ok := tt.files[filepath.ToSlash(rel)]; ok {
+					return bs, nil
+				}
+				return nil, os.ErrNotExist
+			}
+
+			prog, err := p.parseProgram("/patches/main.patch", tt.give)
+			if len(tt.wantErrs) > 0 {
+				require.Error(t, err)
+				for _, msg := range tt.wantErrs {
+					assert.Contains(t, err.Error(), msg)
+				}
+				return
+			}
+			require.NoError(t, err)
+
+			var names []string
+			for _, c := range prog.Changes {
+				names = append(names, c.Name)
+			}
+			assert.Equal(t, tt.wantNames, names)
+		})
+	}
+}
+
+func TestParseIncludesComments(t *testing.T) {
+	fset := token.NewFileSet()
+	p := newParser(fset)
+	p.readFile = func(string) ([]byte, error) {
+		return text.Unlines("# Shared change.", "@@", "@@", "-foo()", "+foo(1)"), nil
+	}
+
+	prog, err := p.parseProgram("main.patch", text.Unlines(
+		`include "common.patch"`,
+		"",
+		"# Local change.",
+		"@@",
+		"@@",
+		"-bar()",
+		"+bar(1)",
+	))
+	require.NoError(t, err)
+	require.Len(t, prog.Includes, 1)
+	assert.Equal(t, `"common.patch"`, prog.Includes[0].Path.Value)
+
+	require.Len(t, prog.Changes, 2)
+	assert.Equal(t, []string{"Shared change."}, prog.Changes[0].Comments)
+	assert.Equal(t, []string{"Local change."}, prog.Changes[1].Comments)
+
+	// Positions in included changes refer to the included file.
+	assert.Equal(t, "common.patch", filepath.Base(fset.Position(prog.Changes[0].Patch.Pos()).Filename))
+}
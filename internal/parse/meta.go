@@ -21,6 +21,7 @@
 package parse
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
 	"go/scanner"
@@ -32,39 +33,48 @@ import (
 
 // Parses the metavariables section of the change at index i.
 func (p *parser) parseMeta(i int, c *section.Change) (*Meta, error) {
-	metaContents, metaLines := section.ToBytes(c.Meta)
-
-	// We will create a new File with the contents of the metavariables
-	// section and map positions in it back to the original file for error
-	// messages.
+	parser := p.newSectionParser(p.sectionFilename(i, c, ".meta"), c.Meta)
+	return parser.parse(), multierr.Combine(parser.errors...)
+}
 
-	// Generate a fake name for the File.
+// Generates a fake name for a File holding a section of the change at index
+// i.
+func (p *parser) sectionFilename(i int, c *section.Change, suffix string) string {
 	filename := p.fset.File(c.Pos()).Name()
 	if len(c.Name) > 0 {
-		filename += c.Name + ".meta"
+		filename += c.Name + suffix
 	} else {
-		filename += fmt.Sprintf("%d.meta", i)
+		filename += fmt.Sprintf("%d%v", i, suffix)
 	}
+	return filename
+}
+
+// Builds a metaParser that scans the contents of the given section.
+func (p *parser) newSectionParser(filename string, s section.Section) *metaParser {
+	contents, lines := section.ToBytes(s)
 
-	file := p.fset.AddFile(filename, -1, len(metaContents))
-	for _, line := range metaLines {
+	// We will create a new File with the contents of the section and map
+	// positions in it back to the original file for error messages.
+	file := p.fset.AddFile(filename, -1, len(contents))
+	for _, line := range lines {
 		p := p.fset.Position(line.Pos)
 		file.AddLineColumnInfo(line.Offset, p.Filename, p.Line, p.Column)
 	}
 
-	parser := metaParser{fset: p.fset}
+	parser := metaParser{fset: p.fset, file: file, src: contents}
 	var scanner scanner.Scanner
-	scanner.Init(file, metaContents, parser.onError, 0 /* mode */)
+	scanner.Init(file, contents, parser.onError, 0 /* mode */)
 	parser.scanner = &scanner
 	parser.next() // read the first token
-
-	return parser.parse(), multierr.Combine(parser.errors...)
+	return &parser
 }
 
 type metaParser struct {
 	scanner *scanner.Scanner
 
 	fset *token.FileSet
+	file *token.File // file being scanned
+	src  []byte      // contents of file
 	pos  token.Pos   // current token position
 	tok  token.Token // current token
 	text string      // current token contents
@@ -97,11 +107,46 @@ func (p *metaParser) next() {
 func (p *metaParser) parse() *Meta {
 	var m Meta
 	for !p.failed && p.tok != token.EOF {
+		if p.tok == token.IDENT && p.text == "param" {
+			m.Params = append(m.Params, p.parseParamDecl())
+			continue
+		}
 		m.Vars = append(m.Vars, p.parseDecl())
 	}
 	return &m
 }
 
+// Parses and returns a ParamDecl.
+//
+//	param old, new identifier
+func (p *metaParser) parseParamDecl() *ParamDecl {
+	defer p.next()
+
+	d := ParamDecl{ParamPos: p.pos}
+	for {
+		p.next() // skip param/,
+		name := p.parseIdent()
+		if name == nil {
+			return nil
+		}
+		d.Names = append(d.Names, name)
+
+		if p.tok != token.COMMA {
+			break
+		}
+	}
+
+	if d.Type = p.parseIdent(); d.Type == nil {
+		return nil
+	}
+
+	if p.tok != token.SEMICOLON {
+		p.errf(`unexpected %q, expected ";" or a newline`, p.tok)
+		return nil
+	}
+	return &d
+}
+
 // Parses and returns a VarDecl.
 //
 //	var x, y, z Foo
@@ -109,7 +154,7 @@ func (p *metaParser) parseDecl() *VarDecl {
 	defer p.next()
 
 	if p.tok != token.VAR {
-		p.errf(`unexpected %q, expected "var"`, p.tok)
+		p.errf(`unexpected %q, expected "var" or "param"`, p.tok)
 		return nil
 	}
 
@@ -120,6 +165,28 @@ func (p *metaParser) parseDecl() *VarDecl {
 		if name == nil {
 			return nil
 		}
+
+		// change.name refers to a metavariable of another change.
+		if p.tok == token.PERIOD {
+			change := name
+			p.next() // .
+			if name = p.parseIdent(); name == nil {
+				return nil
+			}
+
+			switch {
+			case len(d.Names) == 0:
+				d.Change = change
+			case d.Change == nil || d.Change.Name != change.Name:
+				p.onError(p.fset.Position(change.Pos()), "all metavariables in "+
+					"a declaration must be inherited from the same change")
+				return nil
+			}
+		} else if d.Change != nil {
+			p.onError(p.fset.Position(name.Pos()), "all metavariables in "+
+				"a declaration must be inherited from the same change")
+			return nil
+		}
 		d.Names = append(d.Names, name)
 
 		if p.tok != token.COMMA {
@@ -127,11 +194,38 @@ func (p *metaParser) parseDecl() *VarDecl {
 		}
 	}
 
-	// A type name is expected after list of variables.
+	// A type name is expected after list of variables. It may be preceded
+	// by "fresh", and followed by "list" or a seed string.
+	if p.tok == token.IDENT && p.text == "fresh" {
+		d.FreshPos = p.pos
+		p.next() // fresh
+	}
 	d.Type = p.parseIdent()
 	if d.Type == nil {
 		return nil
 	}
+	if p.tok == token.IDENT && p.text == "list" {
+		d.ListPos = p.pos
+		p.next() // list
+	}
+	if p.tok == token.STRING {
+		d.Seed = p.parseString()
+	}
+
+	// Type constraints follow ":" or "implements".
+	if p.tok == token.COLON || (p.tok == token.IDENT && p.text == "implements") {
+		if d.Constraint = p.parseConstraint(); d.Constraint == nil {
+			return nil
+		}
+	}
+
+	// Computed metavariables specify their value after "=".
+	if p.tok == token.ASSIGN {
+		p.next() // =
+		if d.Value = p.parseValue(); d.Value == nil {
+			return nil
+		}
+	}
 
 	// go/scanner implicitly inserts SEMICOLON when a newline is found where a
 	// semicolon would be accepted. So we expect a semicolon after every var
@@ -144,6 +238,84 @@ func (p *metaParser) parseDecl() *VarDecl {
 	return &d
 }
 
+// Parses a type constraint. The type is everything up to the end of the
+// declaration.
+//
+//	: *net/http.Client
+//	implements io.Closer
+func (p *metaParser) parseConstraint() *TypeConstraint {
+	c := TypeConstraint{
+		KeywordPos: p.pos,
+		Implements: p.tok == token.IDENT,
+	}
+	p.next() // : or implements
+
+	start := p.pos
+	for p.tok != token.SEMICOLON && p.tok != token.ASSIGN && p.tok != token.EOF {
+		p.next()
+	}
+
+	typ := bytes.TrimRight(p.src[p.file.Offset(start):p.file.Offset(p.pos)], " \t")
+	if start == p.pos || len(typ) == 0 {
+		p.errf(`unexpected %q, expected a type`, p.tok)
+		return nil
+	}
+	c.TypePos = start
+	c.Type = string(typ)
+	return &c
+}
+
+// Parses the value of a computed metavariable: a string, a metavariable, or
+// a function call with values as its arguments.
+//
+//	trimPrefix(name, "Get")
+func (p *metaParser) parseValue() ast.Expr {
+	switch p.tok {
+	case token.STRING:
+		return p.parseString()
+	case token.IDENT:
+		// Handled below.
+	default:
+		p.errf(`unexpected %q, expected an identifier or a string`, p.tok)
+		return nil
+	}
+
+	name := p.parseIdent()
+	if p.tok != token.LPAREN {
+		return name
+	}
+
+	call := ast.CallExpr{Fun: name, Lparen: p.pos}
+	p.next() // (
+	for p.tok != token.RPAREN {
+		arg := p.parseValue()
+		if arg == nil {
+			return nil
+		}
+		call.Args = append(call.Args, arg)
+
+		if p.tok != token.COMMA {
+			break
+		}
+		p.next() // ,
+	}
+
+	if p.tok != token.RPAREN {
+		p.errf(`unexpected %q, expected ")"`, p.tok)
+		return nil
+	}
+	call.Rparen = p.pos
+	p.next() // )
+	return &call
+}
+
+// Reads and returns a string literal, advancing the parser to the next
+// token.
+func (p *metaParser) parseString() *ast.BasicLit {
+	defer p.next()
+	return &ast.BasicLit{ValuePos: p.pos, Kind: p.tok, Value: p.text}
+}
+
 // Reads and returns an identifier, advancing the parser to the next token.
 // Fails the parser and returns nil if an identifier was not found.
 func (p *metaParser) parseIdent() *ast.Ident {
@@ -130,6 +130,162 @@ func TestParseMeta(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "list",
+			give: text.Unlines("var args expression list"),
+			want: Meta{
+				Vars: []*VarDecl{
+					{
+						VarPos: 1,
+						Names: []*ast.Ident{
+							ident(5, "args"),
+						},
+						Type:    ident(10, "expression"),
+						ListPos: 21,
+					},
+				},
+			},
+		},
+		{
+			desc: "fresh",
+			give: text.Unlines("var tmp fresh identifier"),
+			want: Meta{
+				Vars: []*VarDecl{
+					{
+						VarPos: 1,
+						Names: []*ast.Ident{
+							ident(5, "tmp"),
+						},
+						FreshPos: 9,
+						Type:     ident(15, "identifier"),
+					},
+				},
+			},
+		},
+		{
+			desc: "fresh with seed",
+			give: text.Unlines(`var tmp fresh identifier "err"`),
+			want: Meta{
+				Vars: []*VarDecl{
+					{
+						VarPos: 1,
+						Names: []*ast.Ident{
+							ident(5, "tmp"),
+						},
+						FreshPos: 9,
+						Type:     ident(15, "identifier"),
+						Seed: &ast.BasicLit{
+							ValuePos: 26,
+							Kind:     token.STRING,
+							Value:    `"err"`,
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "computed",
+			give: text.Unlines(`var short identifier = trimPrefix(name, "Get")`),
+			want: Meta{
+				Vars: []*VarDecl{
+					{
+						VarPos: 1,
+						Names: []*ast.Ident{
+							ident(5, "short"),
+						},
+						Type: ident(11, "identifier"),
+						Value: &ast.CallExpr{
+							Fun:    ident(24, "trimPrefix"),
+							Lparen: 34,
+							Args: []ast.Expr{
+								ident(35, "name"),
+								&ast.BasicLit{
+									ValuePos: 41,
+									Kind:     token.STRING,
+									Value:    `"Get"`,
+								},
+							},
+							Rparen: 46,
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "type constraint",
+			give: text.Unlines("var x expression : *net/http.Client"),
+			want: Meta{
+				Vars: []*VarDecl{
+					{
+						VarPos: 1,
+						Names:  []*ast.Ident{ident(5, "x")},
+						Type:   ident(7, "expression"),
+						Constraint: &TypeConstraint{
+							KeywordPos: 18,
+							TypePos:    20,
+							Type:       "*net/http.Client",
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "implements constraint",
+			give: text.Unlines("var c, d expression implements io.Closer"),
+			want: Meta{
+				Vars: []*VarDecl{
+					{
+						VarPos: 1,
+						Names:  []*ast.Ident{ident(5, "c"), ident(8, "d")},
+						Type:   ident(10, "expression"),
+						Constraint: &TypeConstraint{
+							KeywordPos: 21,
+							Implements: true,
+							TypePos:    32,
+							Type:       "io.Closer",
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "inherited vars",
+			give: text.Unlines("var decl.foo, decl.bar identifier"),
+			want: Meta{
+				Vars: []*VarDecl{
+					{
+						VarPos: 1,
+						Change: ident(5, "decl"),
+						Names: []*ast.Ident{
+							ident(10, "foo"),
+							ident(20, "bar"),
+						},
+						Type: ident(24, "identifier"),
+					},
+				},
+			},
+		},
+		{
+			desc: "inherited from different changes",
+			give: text.Unlines("var a.foo, b.bar identifier"),
+			wantErrs: []string{
+				`test.patch:2:12: all metavariables in a declaration must be inherited from the same change`,
+			},
+		},
+		{
+			desc: "inherited and local vars",
+			give: text.Unlines("var a.foo, bar identifier"),
+			wantErrs: []string{
+				`test.patch:2:12: all metavariables in a declaration must be inherited from the same change`,
+			},
+		},
+		{
+			desc: "inherited without name",
+			give: text.Unlines("var a. identifier"),
+			wantErrs: []string{
+				`test.patch:2:18: unexpected ";", expected an identifier`,
+			},
+		},
 		{
 			desc: "variable without var",
 			give: text.Unlines("xar identifier"),
@@ -137,6 +293,52 @@ func TestParseMeta(t *testing.T) {
 				`test.patch:2:1: unexpected "IDENT", expected "var"`,
 			},
 		},
+		{
+			desc: "params",
+			give: text.Unlines(
+				"param old, new identifier",
+				"var x expression",
+				"param pkg string",
+			),
+			want: Meta{
+				Vars: []*VarDecl{
+					{
+						VarPos: 27,
+						Names:  []*ast.Ident{ident(31, "x")},
+						Type:   ident(33, "expression"),
+					},
+				},
+				Params: []*ParamDecl{
+					{
+						ParamPos: 1,
+						Names: []*ast.Ident{
+							ident(7, "old"),
+							ident(12, "new"),
+						},
+						Type: ident(16, "identifier"),
+					},
+					{
+						ParamPos: 44,
+						Names:    []*ast.Ident{ident(50, "pkg")},
+						Type:     ident(54, "string"),
+					},
+				},
+			},
+		},
+		{
+			desc: "param without type",
+			give: text.Unlines("param old"),
+			wantErrs: []string{
+				`test.patch:2:10: unexpected ";", expected an identifier`,
+			},
+		},
+		{
+			desc: "param with value",
+			give: text.Unlines("param old identifier = foo"),
+			wantErrs: []string{
+				`test.patch:2:22: unexpected "=", expected ";" or a newline`,
+			},
+		},
 		{
 			desc: "too many idents",
 			give: text.Unlines(
@@ -165,6 +367,27 @@ func TestParseMeta(t *testing.T) {
 				`test.patch:2:6: unexpected ";", expected an identifier`,
 			},
 		},
+		{
+			desc: "computed without value",
+			give: text.Unlines("var x identifier ="),
+			wantErrs: []string{
+				`test.patch:2:20: unexpected "EOF", expected an identifier or a string`,
+			},
+		},
+		{
+			desc: "computed with unclosed call",
+			give: text.Unlines("var x identifier = upper(y"),
+			wantErrs: []string{
+				`test.patch:2:27: unexpected ";", expected ")"`,
+			},
+		},
+		{
+			desc: "constraint without type",
+			give: text.Unlines("var x expression :"),
+			wantErrs: []string{
+				`test.patch:2:20: unexpected "EOF", expected a type`,
+			},
+		},
 		{
 			desc: "unrecognized token",
 			give: text.Unlines("var # foo"),
@@ -23,21 +23,38 @@ package parse
 import (
 	"fmt"
 	"go/token"
+	"os"
+	"path/filepath"
 
 	"github.com/uber-go/gopatch/internal/parse/section"
 )
 
-// Parse parses a Program.
+// Parse parses a Program. Files included by the program are read from disk
+// relative to the directory of filename.
 func Parse(fset *token.FileSet, filename string, contents []byte) (*Program, error) {
 	return newParser(fset).parseProgram(filename, contents)
 }
 
 type parser struct {
 	fset *token.FileSet
+
+	// Reads included files.
+	readFile func(string) ([]byte, error)
+
+	// Absolute paths of the files being parsed, starting with the
+	// outermost. Used to detect include cycles.
+	including []string
+
+	// Programs parsed from included files, keyed by absolute path.
+	included map[string]*Program
 }
 
 func newParser(fset *token.FileSet) *parser {
-	return &parser{fset: fset}
+	return &parser{
+		fset:     fset,
+		readFile: os.ReadFile,
+		included: make(map[string]*Program),
+	}
 }
 
 func (p *parser) errf(pos token.Pos, msg string, args ...any) error {
@@ -53,13 +70,28 @@ func (p *parser) parseProgram(filename string, contents []byte) (*Program, error
 		return nil, err
 	}
 
-	prog := Program{Changes: make([]*Change, len(changes))}
+	if abs, err := filepath.Abs(filename); err == nil {
+		p.including = append(p.including, abs)
+		defer func() { p.including = p.including[:len(p.including)-1] }()
+	}
+
+	var prog Program
+	prog.Includes, err = p.parseIncludes(changes[0])
+	if err != nil {
+		return nil, err
+	}
+
+	prog.Changes, err = p.spliceIncludes(filename, prog.Includes)
+	if err != nil {
+		return nil, err
+	}
+
 	for i, c := range changes {
 		change, err := p.parseChange(i, c)
 		if err != nil {
 			return nil, err
 		}
-		prog.Changes[i] = change
+		prog.Changes = append(prog.Changes, change)
 	}
 
 	return &prog, nil
@@ -23,9 +23,10 @@ package parse
 import (
 	"bytes"
 	"fmt"
-	goast "go/ast"
+	"go/ast"
 	"io"
 
+	"github.com/uber-go/gopatch/internal/goast"
 	"github.com/uber-go/gopatch/internal/parse/section"
 	"github.com/uber-go/gopatch/internal/pgo"
 )
@@ -48,9 +49,21 @@ func (p *parser) parsePatch(i int, c *section.Change) (*Patch, error) {
 		filename += fmt.Sprintf("/%d", i)
 	}
 
-	minus, plus := splitPatch(c.Patch)
+	versions, err := p.expandDisjunctions(c.Patch)
+	if err != nil {
+		return nil, err
+	}
+
+	// All versions have the same "+" section.
+	minus, plus := splitPatch(versions[0])
+
+	// A patch without a "+" section only searches for code. Use the "-"
+	// section in its place so that the patch doesn't change anything.
+	if len(bytes.TrimSpace(plus.Contents)) == 0 {
+		patch.SearchOnly = true
+		plus = minus
+	}
 
-	var err error
 	patch.Minus, err = p.parsePatchVersion(filename+".minus", minus)
 	if err != nil {
 		return nil, err
@@ -61,29 +74,52 @@ func (p *parser) parsePatch(i int, c *section.Change) (*Patch, error) {
 		return nil, err
 	}
 
-	// FIXME: Hack: If one of minus and plus believes their side is an
-	// statement and the other believes it's an expression, make them both
-	// expresisons.
-	switch m := patch.Minus.Node.(type) {
+	for i, v := range versions[1:] {
+		minus, _ := splitPatch(v)
+		alt, err := p.parsePatchVersion(fmt.Sprintf("%v.minus.%d", filename, i+1), minus)
+		if err != nil {
+			return nil, err
+		}
+
+		// All versions of the "-" section have the same layout. Move
+		// positions of the alternative into the same file as Minus so
+		// that nodes shared between them have the same positions.
+		minusFile := p.fset.File(patch.Minus.Node.Pos())
+		altFile := p.fset.File(alt.Node.Pos())
+		goast.OffsetPos(alt.Node, minusFile.Base()-altFile.Base())
+
+		patch.Alternatives = append(patch.Alternatives, alt)
+	}
+
+	matchStatementExpr(patch.Minus, patch.Plus)
+	for _, alt := range patch.Alternatives {
+		matchStatementExpr(alt, patch.Plus)
+	}
+
+	return &patch, nil
+}
+
+// FIXME: Hack: If one of minus and plus believes their side is an statement
+// and the other believes it's an expression, make them both statements.
+func matchStatementExpr(minus, plus *pgo.File) {
+	switch m := minus.Node.(type) {
 	case *pgo.Expr:
-		if _, ok := patch.Plus.Node.(*pgo.StmtList); ok {
-			patch.Minus.Node = &pgo.StmtList{
-				List: []goast.Stmt{
-					&goast.ExprStmt{X: m.Expr},
+		if _, ok := plus.Node.(*pgo.StmtList); ok {
+			minus.Node = &pgo.StmtList{
+				List: []ast.Stmt{
+					&ast.ExprStmt{X: m.Expr},
 				},
 			}
 		}
 	case *pgo.StmtList:
-		if p, ok := patch.Plus.Node.(*pgo.Expr); ok {
-			patch.Plus.Node = &pgo.StmtList{
-				List: []goast.Stmt{
-					&goast.ExprStmt{X: p.Expr},
+		if p, ok := plus.Node.(*pgo.Expr); ok {
+			plus.Node = &pgo.StmtList{
+				List: []ast.Stmt{
+					&ast.ExprStmt{X: p.Expr},
 				},
 			}
 		}
 	}
-
-	return &patch, nil
 }
 
 // parses one version of the unified diff of a file.
@@ -147,38 +183,42 @@ func splitPatch(patch section.Section) (before, after patchVersion) {
 		// it wasn't written to this time.
 		var skipMinus, skipPlus bool
 
+		// Don't modify the line in-place. The same patch may be split
+		// more than once.
+		text, pos := line.Text, line.StartPos
+
 		w := both
-		if len(line.Text) > 0 {
-			switch line.Text[0] {
+		if len(text) > 0 {
+			switch text[0] {
 			case '-':
 				skipPlus = true
 				w = &minus
-				line.Text = line.Text[1:]
-				line.StartPos++ // '-'
+				text = text[1:]
+				pos++ // '-'
 
 			case '+':
 				skipMinus = true
 				w = &plus
-				line.Text = line.Text[1:]
-				line.StartPos++ // '+'
+				text = text[1:]
+				pos++ // '+'
 			}
 		}
 
 		if !skipMinus {
 			minusLines = append(minusLines, section.LinePos{
 				Offset: minus.Len(),
-				Pos:    line.StartPos,
+				Pos:    pos,
 			})
 		}
 
 		if !skipPlus {
 			plusLines = append(plusLines, section.LinePos{
 				Offset: plus.Len(),
-				Pos:    line.StartPos,
+				Pos:    pos,
 			})
 		}
 
-		w.Write(line.Text)
+		w.Write(text)
 		w.Write(newline)
 	}
 
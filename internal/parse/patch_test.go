@@ -115,6 +115,33 @@ func TestParsePatch(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "search only",
+			give: sectionFromLines(
+				"-foo(",
+				"-  x,",
+				"-)",
+			),
+			want: &Patch{
+				Minus: &pgo.File{
+					Node: &pgo.Expr{
+						Expr: &ast.CallExpr{
+							Fun:  &ast.Ident{Name: "foo"},
+							Args: []ast.Expr{&ast.Ident{Name: "x"}},
+						},
+					},
+				},
+				Plus: &pgo.File{
+					Node: &pgo.Expr{
+						Expr: &ast.CallExpr{
+							Fun:  &ast.Ident{Name: "foo"},
+							Args: []ast.Expr{&ast.Ident{Name: "x"}},
+						},
+					},
+				},
+				SearchOnly: true,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -38,6 +38,12 @@ type Program []*Change
 
 // Change is a single change in a program.
 type Change struct {
+	// Include directives at the top of the file, if any.
+	//
+	// These may only appear before the first change in a file so this is
+	// always empty for other changes.
+	Includes Section
+
 	// Position at which the first @ of the header occurs.
 	HeaderPos token.Pos
 
@@ -46,6 +52,12 @@ type Change struct {
 	// If any, it is specified between the first pair of @@s in the change.
 	Name string
 
+	// Clauses following the name in the header of the change, if any.
+	//
+	// For example, in "@ foo within bar @", this is "within bar". Clauses
+	// are not interpreted during sectioning.
+	Clauses Section
+
 	// Metavariables section of the change.
 	Meta Section
 
@@ -58,6 +70,27 @@ type Change struct {
 
 	// Comments in the patch
 	Comments []string
+
+	// Test files that follow the patch section, if any.
+	Tests []*TestFile
+}
+
+// TestFile is a file embedded in a patch after a change, introduced by a
+// line in the form,
+//
+//	-- name --
+//
+// The contents of the file are not interpreted during sectioning.
+type TestFile struct {
+	// Position at which the name of the file begins.
+	NamePos token.Pos
+
+	// Name of the file.
+	Name string
+
+	// Contents of the file, up to the next file, the next change, or the
+	// end of the patch.
+	Data []byte
 }
 
 var _ ast.Node = (*Change)(nil)
@@ -172,32 +205,65 @@ func isComment(s []byte) bool {
 }
 
 func (p *programSplitter) readProgram() Program {
+	includes := p.readIncludes()
+
 	var prog Program
 	for !p.eof {
 		prog = append(prog, p.readChange())
 	}
+	if len(prog) > 0 {
+		prog[0].Includes = includes
+	}
 	if len(prog) == 0 {
 		p.errf(p.offset, "unexpected EOF, at least one change is required")
 	}
 	return prog
 }
 
+// Reads the include directives at the top of the file, skipping blank lines
+// between them.
+//
+//	include "common.patch"
+func (p *programSplitter) readIncludes() Section {
+	var s Section
+	for ; !p.eof; p.next() {
+		switch {
+		case len(bytes.TrimSpace(p.text)) == 0:
+			// skip
+		case isInclude(p.text):
+			s = append(s, &Line{StartPos: p.pos, Text: p.text})
+		default:
+			return s
+		}
+	}
+	return s
+}
+
+// Reports whether the line is an include directive.
+func isInclude(s []byte) bool {
+	rest, ok := bytes.CutPrefix(s, []byte("include"))
+	return ok && (len(rest) == 0 || unicode.IsSpace(rune(rest[0])))
+}
+
 // Read and return a Change, or nil if EOF was reached.
 func (p *programSplitter) readChange() *Change {
-	// Can't use a struct literal here because readName and readMeta advance
+	// Can't use a struct literal here because readHeader and readMeta advance
 	// p.pos between HeaderPos and AtPos.
 	var c Change
 	c.Comments = p.lastComments
 	c.HeaderPos = p.pos
-	c.Name = p.readName()
+	c.Name, c.Clauses = p.readHeader()
 	c.Meta = p.readMeta()
 	c.AtPos = p.pos
 	c.Patch = p.readPatch()
+	if _, ok := testFileName(p.text); ok && !p.eof {
+		c.Tests = p.readTests()
+	}
 	return &c
 }
 
-// Reads the name of a change.
-func (p *programSplitter) readName() string {
+// Reads the name of a change and the clauses that follow it, if any.
+func (p *programSplitter) readHeader() (name string, clauses Section) {
 	text := string(p.text)
 	defer p.next()
 
@@ -205,7 +271,7 @@ func (p *programSplitter) readName() string {
 	case text == "@@":
 		// unnamed
 	case len(text) > 2 && text[0] == '@' && text[len(text)-1] == '@':
-		// named
+		// named or with clauses
 
 		name := text[1:]          // leading @
 		name = name[:len(name)-1] // trailing @
@@ -223,9 +289,40 @@ func (p *programSplitter) readName() string {
 
 		name = strings.TrimRightFunc(name, unicode.IsSpace)
 
+		// A header that holds only a clause keyword, like "@ first @",
+		// names a change so that patches written before the clause
+		// existed keep working.
+		_, keyword := clauseKeywords[name]
+
+		// If the header opens with a clause, the change is unnamed.
+		// Otherwise, everything after the first word is a list of
+		// clauses only if it opens with a clause keyword. If it doesn't,
+		// we'll validate the entire string as a name so that stray
+		// spaces are reported as such.
+		rest, restShift := "", 0
+		if keyword {
+			// name is the keyword
+		} else if isClause(name) {
+			rest, restShift, name = name, shift, ""
+		} else if idx := strings.IndexFunc(name, unicode.IsSpace); idx >= 0 {
+			after := name[idx:]
+			trim := strings.IndexFunc(after, notIsSpace) // non-negative
+			if isClause(after[trim:]) {
+				rest, restShift = after[trim:], shift+idx+trim
+				name = name[:idx]
+			}
+		}
+
+		if len(rest) > 0 {
+			clauses = Section{{
+				StartPos: p.file.Pos(p.startOffset + restShift),
+				Text:     []byte(rest),
+			}}
+		}
+
 		i, ch, ok := validateChangeName(name)
 		if ok {
-			return name
+			return name, clauses
 		}
 
 		p.errf(p.startOffset+shift+i,
@@ -233,7 +330,38 @@ func (p *programSplitter) readName() string {
 	default:
 		p.errf(p.startOffset, `unexpected %q, expected "@@" or "@ change_name @"`, text)
 	}
-	return ""
+	return "", nil
+}
+
+// clauseKeywords is the list of keywords that may open a clause in the
+// header of a change.
+var clauseKeywords = map[string]struct{}{
+	"depends":    {},
+	"files":      {},
+	"first":      {},
+	"importpath": {},
+	"notests":    {},
+	"tag":        {},
+	"tests":      {},
+	"within":     {},
+}
+
+// IsClauseKeyword reports whether s is a keyword that may open a clause in
+// the header of a change.
+func IsClauseKeyword(s string) bool {
+	_, ok := clauseKeywords[s]
+	return ok
+}
+
+// Reports whether s opens with a clause keyword. The keyword must be the
+// entire first word so that names like "first_pass" aren't read as clauses.
+func isClause(s string) bool {
+	end := strings.IndexFunc(s, notIsIdentChar)
+	if end < 0 {
+		end = len(s)
+	}
+	_, ok := clauseKeywords[s[:end]]
+	return ok
 }
 
 // Reads the metavariables section of the change.
@@ -261,11 +389,109 @@ func (p *programSplitter) readPatch() Section {
 			// new change begins
 			break
 		}
+		if _, ok := testFileName(p.text); ok {
+			// test files begin
+			break
+		}
 		s = append(s, &Line{StartPos: p.pos, Text: p.text})
 	}
 	return s
 }
 
+// Reads the test files following the patch section of a change, starting
+// at the current line, and stopping when a new change is encountered or the
+// end of the file is reached.
+//
+// Test files are read verbatim so lines starting with "#" are part of their
+// contents, except for those immediately before the next change, which
+// document that change.
+func (p *programSplitter) readTests() []*TestFile {
+	var (
+		files []*TestFile
+		start int // offset at which the contents of the last file begin
+
+		// Offset at which the run of comment lines at the end of the
+		// last file begins, or -1 if it doesn't end with comments.
+		comments = -1
+	)
+	finish := func(end int) {
+		if len(files) > 0 {
+			files[len(files)-1].Data = p.content[start:end]
+		}
+	}
+
+	off := p.startOffset
+	for off < len(p.content) {
+		eol := bytes.IndexByte(p.content[off:], '\n')
+		next := len(p.content)
+		if eol >= 0 {
+			eol += off
+			next = eol + 1
+		} else {
+			eol = len(p.content)
+		}
+
+		line := p.content[off:eol]
+		if len(line) > 0 && line[0] == '@' {
+			// new change begins
+			break
+		}
+
+		if name, ok := testFileName(line); ok {
+			finish(off)
+			files = append(files, &TestFile{
+				NamePos: p.file.Pos(off + 3), // "-- "
+				Name:    name,
+			})
+			start, comments = next, -1
+		} else if isComment(line) {
+			if comments < 0 {
+				comments = off
+			}
+		} else {
+			comments = -1
+		}
+		off = next
+	}
+
+	// Comments right before the next change belong to it.
+	if off < len(p.content) && comments >= 0 {
+		off = comments
+	}
+	finish(off)
+
+	// Blank lines separating the last file from the next change aren't
+	// part of it.
+	if last := files[len(files)-1]; len(last.Data) > 0 {
+		data := bytes.TrimRight(last.Data, "\n")
+		if len(data) < len(last.Data) {
+			data = last.Data[:len(data)+1]
+		}
+		last.Data = data
+	}
+
+	p.offset = off
+	p.next()
+	return files
+}
+
+// Reports whether the line introduces a test file and returns the name of
+// the file.
+//
+//	-- name --
+func testFileName(s []byte) (string, bool) {
+	rest, ok := bytes.CutPrefix(s, []byte("-- "))
+	if !ok {
+		return "", false
+	}
+	rest, ok = bytes.CutSuffix(bytes.TrimRightFunc(rest, unicode.IsSpace), []byte(" --"))
+	if !ok {
+		return "", false
+	}
+	name := strings.TrimSpace(string(rest))
+	return name, len(name) > 0
+}
+
 // Validates that the given non-empty string is a valid Go identifier. If the
 // name is invalid, the first invalid character and the index at which it
 // occurs is returned.
@@ -290,3 +516,7 @@ func validateChangeName(s string) (i int, ch rune, ok bool) {
 func notIsSpace(ch rune) bool {
 	return !unicode.IsSpace(ch)
 }
+
+func notIsIdentChar(ch rune) bool {
+	return !unicode.IsLetter(ch) && !unicode.IsDigit(ch) && ch != '_'
+}
@@ -138,6 +138,42 @@ func TestSplit(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "name starting with keyword",
+			give: text.Unlines(
+				"@ first_pass @",
+				"@@",
+				"@ tag2 @",
+				"@@",
+			),
+			want: Program{
+				{
+					Name:      "first_pass",
+					HeaderPos: 1,
+					AtPos:     16,
+				},
+				{
+					Name:      "tag2",
+					HeaderPos: 19,
+					AtPos:     28,
+				},
+			},
+		},
+		{
+			desc: "name starting with keyword and clause",
+			give: text.Unlines(
+				"@ within_x first @",
+				"@@",
+			),
+			want: Program{
+				{
+					Name:      "within_x",
+					HeaderPos: 1,
+					Clauses:   Section{line(12, "first")},
+					AtPos:     20,
+				},
+			},
+		},
 		{
 			desc: "simple change",
 			give: text.Unlines(
@@ -246,6 +282,71 @@ func TestSplit(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "named change with clauses",
+			give: text.Unlines(
+				"@ inner within outer @",
+				"@@",
+				"-foo()",
+			),
+			want: Program{
+				{
+					HeaderPos: 1,
+					Name:      "inner",
+					Clauses: Section{
+						line(9, "within outer"),
+					},
+					AtPos: 24,
+					Patch: Section{
+						line(27, "-foo()"),
+					},
+				},
+			},
+			wantPosInfo: map[token.Pos]posInfo{
+				9: {L: 1, C: 9}, // within
+			},
+		},
+		{
+			desc: "keyword as name",
+			give: text.Unlines(
+				"@ first @",
+				"@@",
+				"@  within @",
+				"@@",
+			),
+			want: Program{
+				{
+					Name:      "first",
+					HeaderPos: 1,
+					AtPos:     11,
+				},
+				{
+					Name:      "within",
+					HeaderPos: 14,
+					AtPos:     26,
+				},
+			},
+		},
+		{
+			desc: "unnamed change with clauses",
+			give: text.Unlines(
+				"@within outer@",
+				"@@",
+				"-foo()",
+			),
+			want: Program{
+				{
+					HeaderPos: 1,
+					Clauses: Section{
+						line(2, "within outer"),
+					},
+					AtPos: 16,
+					Patch: Section{
+						line(19, "-foo()"),
+					},
+				},
+			},
+		},
 		{
 			desc: "multiple changes",
 			give: text.Unlines(
@@ -288,6 +389,156 @@ func TestSplit(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "includes",
+			give: text.Unlines(
+				`include "common.patch"`,
+				"",
+				`include "other.patch" foo`,
+				"@@",
+				"@@",
+				"-x()",
+			),
+			want: Program{
+				{
+					Includes: Section{
+						line(1, `include "common.patch"`),
+						line(25, `include "other.patch" foo`),
+					},
+					HeaderPos: 51,
+					AtPos:     54,
+					Patch: Section{
+						line(57, "-x()"),
+					},
+				},
+			},
+			wantPosInfo: map[token.Pos]posInfo{
+				25: {L: 3, C: 1}, // include
+				51: {L: 4, C: 1}, // @@
+			},
+		},
+		{
+			desc: "include after change",
+			give: text.Unlines(
+				"@@",
+				"@@",
+				"-x()",
+				`include "common.patch"`,
+			),
+			want: Program{
+				{
+					HeaderPos: 1,
+					AtPos:     4,
+					Patch: Section{
+						line(7, "-x()"),
+						line(12, `include "common.patch"`),
+					},
+				},
+			},
+		},
+		{
+			desc: "tests",
+			give: text.Unlines(
+				"@@",
+				"@@",
+				"-x()",
+				"+y()",
+				"-- a.in.go --",
+				"x()",
+				"# not a comment",
+				"-- a.out.go --",
+				"y()",
+				"# Next change.",
+				"@ b @",
+				"@@",
+				"-z()",
+			),
+			want: Program{
+				{
+					HeaderPos: 1,
+					AtPos:     4,
+					Patch: Section{
+						line(7, "-x()"),
+						line(12, "+y()"),
+					},
+					Tests: []*TestFile{
+						{NamePos: 20, Name: "a.in.go", Data: []byte("x()\n# not a comment\n")},
+						{NamePos: 54, Name: "a.out.go", Data: []byte("y()\n")},
+					},
+				},
+				{
+					HeaderPos: 85,
+					Name:      "b",
+					AtPos:     91,
+					Patch: Section{
+						line(94, "-z()"),
+					},
+					Comments: []string{"Next change."},
+				},
+			},
+			wantPosInfo: map[token.Pos]posInfo{
+				20: {L: 5, C: 4}, // a.in.go
+				54: {L: 8, C: 4}, // a.out.go
+			},
+		},
+		{
+			desc: "tests at end of file",
+			give: text.Unlines(
+				"@@",
+				"@@",
+				"-x()",
+				"-- a.in.go --",
+				"x()",
+				"",
+				"# trailing",
+			),
+			want: Program{
+				{
+					HeaderPos: 1,
+					AtPos:     4,
+					Patch: Section{
+						line(7, "-x()"),
+					},
+					Tests: []*TestFile{
+						{NamePos: 15, Name: "a.in.go", Data: []byte("x()\n\n# trailing\n")},
+					},
+				},
+			},
+		},
+		{
+			desc: "tests followed by blank lines",
+			give: text.Unlines(
+				"@@",
+				"@@",
+				"-x()",
+				"-- a.in.go --",
+				"x()",
+				"",
+				"",
+				"@@",
+				"@@",
+				"-y()",
+			),
+			want: Program{
+				{
+					HeaderPos: 1,
+					AtPos:     4,
+					Patch: Section{
+						line(7, "-x()"),
+					},
+					Tests: []*TestFile{
+						{NamePos: 15, Name: "a.in.go", Data: []byte("x()\n")},
+					},
+				},
+				{
+					HeaderPos: 32,
+					AtPos:     35,
+					Patch: Section{
+						line(38, "-y()"),
+					},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
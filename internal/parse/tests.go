@@ -0,0 +1,71 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parse
+
+import (
+	"strings"
+
+	"github.com/uber-go/gopatch/internal/parse/section"
+)
+
+const (
+	_testInputSuffix  = ".in.go"
+	_testOutputSuffix = ".out.go"
+)
+
+// Pairs up the test files embedded after a change into tests.
+func (p *parser) parseTests(c *section.Change) ([]*Test, error) {
+	var (
+		tests  []*Test
+		byName = make(map[string]*Test)
+	)
+	for _, f := range c.Tests {
+		switch {
+		case strings.HasSuffix(f.Name, _testInputSuffix):
+			name := strings.TrimSuffix(f.Name, _testInputSuffix)
+			if _, ok := byName[name]; ok {
+				return nil, p.errf(f.NamePos, "test %q is already defined", name)
+			}
+			t := &Test{NamePos: f.NamePos, Name: name, Input: f.Data}
+			byName[name] = t
+			tests = append(tests, t)
+
+		case strings.HasSuffix(f.Name, _testOutputSuffix):
+			name := strings.TrimSuffix(f.Name, _testOutputSuffix)
+			t, ok := byName[name]
+			if !ok {
+				return nil, p.errf(f.NamePos, "unexpected %q: expected %q before it", f.Name, name+_testInputSuffix)
+			}
+			if t.Output != nil {
+				return nil, p.errf(f.NamePos, "test %q already has an output", name)
+			}
+			t.Output = f.Data
+			if t.Output == nil {
+				t.Output = []byte{}
+			}
+
+		default:
+			return nil, p.errf(f.NamePos, "unexpected test file %q: expected NAME%v or NAME%v",
+				f.Name, _testInputSuffix, _testOutputSuffix)
+		}
+	}
+	return tests, nil
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parse
+
+import (
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/text"
+)
+
+func TestParseTests(t *testing.T) {
+	tests := []struct {
+		desc string
+		give []string // test files after the change
+
+		want     []*Test // without positions
+		wantErrs []string
+	}{
+		{
+			desc: "no tests",
+		},
+		{
+			desc: "input and output",
+			give: []string{
+				"-- a.in.go --",
+				"foo()",
+				"-- a.out.go --",
+				"bar()",
+			},
+			want: []*Test{
+				{Name: "a", Input: []byte("foo()\n"), Output: []byte("bar()\n")},
+			},
+		},
+		{
+			desc: "input only",
+			give: []string{
+				"-- a.in.go --",
+				"baz()",
+			},
+			want: []*Test{
+				{Name: "a", Input: []byte("baz()\n")},
+			},
+		},
+		{
+			desc: "empty output",
+			give: []string{
+				"-- a.in.go --",
+				"foo()",
+				"-- a.out.go --",
+			},
+			want: []*Test{
+				{Name: "a", Input: []byte("foo()\n"), Output: []byte{}},
+			},
+		},
+		{
+			desc: "output before input",
+			give: []string{
+				"-- a.out.go --",
+				"-- a.in.go --",
+			},
+			wantErrs: []string{
+				`test.patch:5:4: unexpected "a.out.go": expected "a.in.go" before it`,
+			},
+		},
+		{
+			desc: "duplicate input",
+			give: []string{
+				"-- a.in.go --",
+				"-- a.in.go --",
+			},
+			wantErrs: []string{
+				`test.patch:6:4: test "a" is already defined`,
+			},
+		},
+		{
+			desc: "duplicate output",
+			give: []string{
+				"-- a.in.go --",
+				"-- a.out.go --",
+				"-- a.out.go --",
+			},
+			wantErrs: []string{
+				`test.patch:7:4: test "a" already has an output`,
+			},
+		},
+		{
+			desc: "unknown file",
+			give: []string{
+				"-- a.go --",
+			},
+			wantErrs: []string{
+				`test.patch:5:4: unexpected test file "a.go": expected NAME.in.go or NAME.out.go`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			lines := append([]string{"@@", "@@", "-foo()", "+bar()"}, tt.give...)
+
+			prog, err := Parse(token.NewFileSet(), "test.patch", text.Unlines(lines...))
+			if len(tt.wantErrs) > 0 {
+				require.Error(t, err)
+				for _, msg := range tt.wantErrs {
+					assert.Contains(t, err.Error(), msg)
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, prog.Changes, 1)
+
+			got := prog.Changes[0].Tests
+			for _, tt := range got {
+				tt.NamePos = token.NoPos
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
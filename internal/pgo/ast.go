@@ -94,11 +94,17 @@ func (*GenDecl) pgoNode() {}
 
 // Dots is a "..." used as an expression.
 //
-// If used as a statement, Dots will be inside an ExprStmt.
+// If used as a statement, Dots will be inside an ExprStmt. If used in place
+// of a field or a spec of a const or var group, Dots will be the Type of an
+// ast.Field or ast.ValueSpec.
 type Dots struct {
 	ast.Expr
 
 	Dots token.Pos // position of dots
+
+	// Constraints on the code matched by these dots, if any. These are
+	// allowed only if the dots are used as a statement.
+	When []*DotsWhen
 }
 
 func (*Dots) pgoNode() {}
@@ -108,3 +114,27 @@ func (d *Dots) Pos() token.Pos { return d.Dots }
 
 // End returns the position after "...".
 func (d *Dots) End() token.Pos { return d.Dots + 3 }
+
+// DotsIdent is the name of identifiers standing in for "..." in the list of
+// names of a value declaration.
+//
+//	var a, ..., c string
+//
+// Other uses of "..." are represented with Dots.
+const DotsIdent = "..."
+
+// DotsWhen is a constraint on the code matched by a "...".
+//
+//	... when != x
+//	... when any
+type DotsWhen struct {
+	When token.Pos // position of "when"
+
+	// Any is set for "when any" constraints.
+	Any bool
+
+	// Operator and expression for the constraint unless Any is set. Op is
+	// either token.NEQ or token.EQL.
+	Op token.Token
+	X  ast.Expr
+}
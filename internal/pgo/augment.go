@@ -51,6 +51,10 @@ type augmenter struct {
 	// augmentations are indexed by position so that we can match them to
 	// nodes as we traverse.
 	augs map[augPos]augment.Augmentation
+
+	// "when" constraints indexed by the position of their expressions.
+	// These are populated as the Dots they belong to are visited.
+	whens map[augPos]*DotsWhen
 }
 
 // augPos is the position of an augmentation in the file.
@@ -66,9 +70,10 @@ func newAugmenter(file *token.File, augs []augment.Augmentation, adj *posAdjuste
 		index[augPos{start: start, end: end}] = aug
 	}
 	return &augmenter{
-		file: file,
-		augs: index,
-		adj:  adj,
+		file:  file,
+		augs:  index,
+		adj:   adj,
+		whens: make(map[augPos]*DotsWhen),
 	}
 }
 
@@ -98,6 +103,21 @@ func (a *augmenter) pop(n ast.Node) augment.Augmentation {
 	return aug
 }
 
+// popWhen retrieves the "when" constraint whose expression spans the
+// provided node, or nil if there isn't one. If a constraint was found, it is
+// removed from the list of known constraints.
+func (a *augmenter) popWhen(n ast.Node) *DotsWhen {
+	off := augPos{
+		start: a.file.Offset(n.Pos()),
+		end:   a.file.Offset(n.End()),
+	}
+	w, ok := a.whens[off]
+	if ok {
+		delete(a.whens, off)
+	}
+	return w
+}
+
 // Apply visits the given AST cursor. Use this with astutil.Apply.
 func (a *augmenter) Apply(cursor *astutil.Cursor) bool {
 	n := cursor.Node()
@@ -105,6 +125,20 @@ func (a *augmenter) Apply(cursor *astutil.Cursor) bool {
 		return false
 	}
 
+	if w := a.popWhen(n); w != nil {
+		// The expression of a "when" constraint was parsed as a
+		// statement following the dots. Move it into the
+		// constraint.
+		es, ok := n.(*ast.ExprStmt)
+		if !ok || cursor.Index() < 0 {
+			a.errf(n.Pos(), `unexpected %T after "when"`, n)
+			return false
+		}
+		w.X = es.X
+		cursor.Delete()
+		return false
+	}
+
 	aug := a.pop(n)
 	if aug == nil {
 		return true // keep looking
@@ -135,11 +169,38 @@ func (a *augmenter) Apply(cursor *astutil.Cursor) bool {
 	switch aug := aug.(type) {
 	case *augment.Dots:
 		dots := &Dots{Dots: n.Pos()}
+		if len(aug.When) > 0 && fieldType != goast.StmtType {
+			a.errf(n.Pos(), `"when" is allowed only on "..." used as a statement`)
+			return false
+		}
+
+		for _, w := range aug.When {
+			dw := &DotsWhen{When: a.file.Pos(w.WhenStart), Any: w.Any}
+			if !w.Any {
+				dw.Op = w.Op
+				a.whens[augPos{start: w.ExprStart, end: w.ExprEnd}] = dw
+			}
+			dots.When = append(dots.When, dw)
+		}
+
 		switch fieldType {
 		case goast.StmtType:
 			cursor.Replace(&ast.ExprStmt{X: dots})
 		case goast.FieldPtrType:
 			cursor.Replace(&ast.Field{Type: dots})
+		case goast.SpecType:
+			// ValueSpec needs at least one name to report its
+			// position.
+			cursor.Replace(&ast.ValueSpec{
+				Names: []*ast.Ident{{Name: "_", NamePos: n.Pos()}},
+				Type:  dots,
+			})
+		case goast.IdentPtrType:
+			if _, ok := cursor.Parent().(*ast.ValueSpec); !ok || cursor.Index() < 0 {
+				a.errf(n.Pos(), `found unexpected "..." inside %T`, n)
+				return false
+			}
+			cursor.Replace(&ast.Ident{Name: DotsIdent, NamePos: n.Pos()})
 		case goast.ExprType:
 			cursor.Replace(dots)
 		default:
@@ -159,5 +220,8 @@ func (a *augmenter) Err() error {
 	for _, aug := range a.augs {
 		a.errf(a.file.Pos(aug.Start()), "unused augmentation %T", aug)
 	}
+	for _, w := range a.whens {
+		a.errf(w.When, `could not find the expression for "when"`)
+	}
 	return multierr.Combine(a.errors...)
 }
@@ -25,6 +25,8 @@
 // affected nodes.
 package augment
 
+import "go/token"
+
 // Augmentation is an addition to the Go syntax for pgo.
 type Augmentation interface {
 	augmentation()
@@ -46,8 +48,12 @@ type Dots struct {
 	DotsStart, DotsEnd int
 
 	// Named indicates whether the dots replace a named entity — such
-	// as the named arguments or results of a function.
+	// as the named arguments or results of a function, or the specs of
+	// a const or var group.
 	Named bool
+
+	// Constraints following the dots on the same line, if any.
+	When []*When
 }
 
 func (*Dots) augmentation() {}
@@ -58,6 +64,30 @@ func (d *Dots) Start() int { return d.DotsStart }
 // End offset of Dots.
 func (d *Dots) End() int { return d.DotsEnd }
 
+// When is a constraint on the code matched by a "...", specified after it on
+// the same line.
+//
+//	... when != x
+//	... when any
+//
+// When is not an Augmentation by itself. It's recorded on the Dots it
+// follows.
+type When struct {
+	// Offsets of the "when" keyword and of the first character immediately
+	// after the constraint.
+	WhenStart, WhenEnd int
+
+	// Whether this is a "when any" constraint.
+	Any bool
+
+	// Operator of the constraint, token.NEQ or token.EQL, unless Any is
+	// set.
+	Op token.Token
+
+	// Offsets of the expression following Op, unless Any is set.
+	ExprStart, ExprEnd int
+}
+
 // FakePackage is a fake package clause included in the code. This is needed
 // if the source didn't contain a package clause.
 type FakePackage struct {
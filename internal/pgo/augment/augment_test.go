@@ -21,6 +21,7 @@
 package augment
 
 import (
+	"go/token"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -57,6 +58,54 @@ func TestAugment(t *testing.T) {
 				{Offset: 13, ReduceBy: 11},
 			},
 		},
+		{
+			desc: "comment before statement",
+			give: text.Unlines(
+				"package foo",
+				"",
+				"// bar",
+				"foo()",
+			),
+			wantSrc: text.Unlines(
+				"package foo",
+				"",
+				"func _() {",
+				"// bar",
+				"foo()",
+				"}",
+			),
+			wantAugs: []Augmentation{
+				&FakeFunc{FuncStart: 13, Braces: true},
+			},
+			wantAdjs: []PosAdjustment{
+				{Offset: 13, ReduceBy: 11},
+			},
+		},
+		{
+			desc: "comment after import",
+			give: text.Unlines(
+				"package foo",
+				"",
+				`import "fmt" // bar`,
+				"",
+				"foo()",
+			),
+			wantSrc: text.Unlines(
+				"package foo",
+				"",
+				`import "fmt" // bar`,
+				"",
+				"func _() {",
+				"foo()",
+				"}",
+			),
+			wantAugs: []Augmentation{
+				&FakeFunc{FuncStart: 34, Braces: true},
+			},
+			wantAdjs: []PosAdjustment{
+				{Offset: 34, ReduceBy: 11},
+			},
+		},
 		{
 			desc: "imports/group",
 			give: text.Unlines(
@@ -161,6 +210,24 @@ func TestAugment(t *testing.T) {
 				{Offset: 0, ReduceBy: 10},
 			},
 		},
+		{
+			desc: "doc comment",
+			give: text.Unlines(
+				"// Foo is a thing.",
+				"type Foo struct{}",
+			),
+			wantSrc: text.Unlines(
+				"package _",
+				"// Foo is a thing.",
+				"type Foo struct{}",
+			),
+			wantAugs: []Augmentation{
+				&FakePackage{PackageStart: 0},
+			},
+			wantAdjs: []PosAdjustment{
+				{Offset: 0, ReduceBy: 10},
+			},
+		},
 		{
 			desc: "dots/statements",
 			give: text.Unlines(
@@ -186,6 +253,87 @@ func TestAugment(t *testing.T) {
 				{Offset: 10, ReduceBy: 21},
 			},
 		},
+		{
+			desc: "dots/when",
+			give: text.Unlines(
+				"foo()",
+				"... when != bar(x) when any",
+				"baz()",
+			),
+			wantSrc: text.Unlines(
+				"package _",
+				"func _() {",
+				"foo()",
+				"dts ;       bar(x)         ",
+				"baz()",
+				"}",
+			),
+			wantAugs: []Augmentation{
+				&FakePackage{PackageStart: 0},
+				&FakeFunc{FuncStart: 10, Braces: true},
+				&Dots{
+					DotsStart: 27,
+					DotsEnd:   30,
+					When: []*When{
+						{
+							WhenStart: 31,
+							WhenEnd:   45,
+							Op:        token.NEQ,
+							ExprStart: 39,
+							ExprEnd:   45,
+						},
+						{
+							WhenStart: 46,
+							WhenEnd:   54,
+							Any:       true,
+						},
+					},
+				},
+			},
+			wantAdjs: []PosAdjustment{
+				{Offset: 0, ReduceBy: 10},
+				{Offset: 10, ReduceBy: 21},
+			},
+		},
+		{
+			desc: "dots/when multiline",
+			give: text.Unlines(
+				"... when == foo(",
+				"  x,",
+				")",
+				"bar()",
+			),
+			wantSrc: text.Unlines(
+				"package _",
+				"func _() {",
+				"dts ;       foo(",
+				"  x,",
+				")",
+				"bar()",
+				"}",
+			),
+			wantAugs: []Augmentation{
+				&FakePackage{PackageStart: 0},
+				&FakeFunc{FuncStart: 10, Braces: true},
+				&Dots{
+					DotsStart: 21,
+					DotsEnd:   24,
+					When: []*When{
+						{
+							WhenStart: 25,
+							WhenEnd:   44,
+							Op:        token.EQL,
+							ExprStart: 33,
+							ExprEnd:   44,
+						},
+					},
+				},
+			},
+			wantAdjs: []PosAdjustment{
+				{Offset: 0, ReduceBy: 10},
+				{Offset: 10, ReduceBy: 21},
+			},
+		},
 		{
 			desc: "dots/parameters",
 			give: text.Unlines(
@@ -258,6 +406,50 @@ func TestAugment(t *testing.T) {
 				{Offset: 0, ReduceBy: 10},
 			},
 		},
+		{
+			desc: "dots/value group",
+			give: text.Unlines(
+				"var (",
+				"\t...",
+				"\tfoo = bar",
+				"\t...",
+				")",
+			),
+			wantSrc: text.Unlines(
+				"package _",
+				"var (",
+				"\t_ d",
+				"\tfoo = bar",
+				"\t_ d",
+				")",
+			),
+			wantAugs: []Augmentation{
+				&FakePackage{PackageStart: 0},
+				&Dots{DotsStart: 17, DotsEnd: 20, Named: true},
+				&Dots{DotsStart: 33, DotsEnd: 36, Named: true},
+			},
+			wantAdjs: []PosAdjustment{
+				{Offset: 0, ReduceBy: 10},
+			},
+		},
+		{
+			desc: "dots/value names",
+			give: text.Unlines(
+				"const a, ..., c = 1, ..., 3",
+			),
+			wantSrc: text.Unlines(
+				"package _",
+				"const a, dts, c = 1, dts, 3",
+			),
+			wantAugs: []Augmentation{
+				&FakePackage{PackageStart: 0},
+				&Dots{DotsStart: 19, DotsEnd: 22},
+				&Dots{DotsStart: 31, DotsEnd: 34},
+			},
+			wantAdjs: []PosAdjustment{
+				{Offset: 0, ReduceBy: 10},
+			},
+		},
 		{
 			desc: "dots/single result",
 			give: text.Unlines(
@@ -36,7 +36,7 @@ func find(src []byte) ([]Augmentation, error) {
 
 	f := finder{file: file}
 	var s scanner.Scanner
-	s.Init(file, src, f.onError, 0 /* flags */)
+	s.Init(file, src, f.onError, scanner.ScanComments)
 	f.scanner = &s
 
 	f.next() // read first token
@@ -48,30 +48,69 @@ type finder struct {
 	scanner *scanner.Scanner
 
 	tok token.Token // current token
+	lit string      // literal text of the current token, if any
 	pos token.Pos   // position of current token
 
 	// Offset of tok inside the original source file. This is equal to
 	// file.Offset(pos).
 	offset int
 
+	// Offset of the first comment on its own line right before tok, or -1
+	// if there isn't one.
+	commentOffset int
+
 	// Augmentations and errors recorded so far.
 	augs   []Augmentation
 	errors []error
 }
 
+// Error is a problem found in the source passed to Augment.
+type Error struct {
+	// Offset in the source at which the problem was found.
+	Offset int
+
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("offset %d: %v", e.Offset, e.Msg)
+}
+
 // Called by go/scanner in case of errors.
 func (f *finder) onError(pos token.Position, msg string) {
-	f.errors = append(f.errors, fmt.Errorf("%v: %v", pos, msg))
+	f.errors = append(f.errors, &Error{Offset: pos.Offset, Msg: msg})
+}
+
+// Records an error at the position of the current token.
+func (f *finder) addError(msg string) {
+	f.errors = append(f.errors, &Error{Offset: f.offset, Msg: msg})
 }
 
 func (f *finder) append(aug Augmentation) {
 	f.augs = append(f.augs, aug)
 }
 
-// Advances the scanner.
+// Advances the scanner, skipping over comments.
 func (f *finder) next() {
-	f.pos, f.tok, _ = f.scanner.Scan()
-	f.offset = f.file.Offset(f.pos)
+	prevLine := 0
+	if f.pos.IsValid() {
+		prevLine = f.line(f.pos)
+	}
+
+	f.commentOffset = -1
+	for {
+		f.pos, f.tok, f.lit = f.scanner.Scan()
+		f.offset = f.file.Offset(f.pos)
+		if f.tok != token.COMMENT {
+			return
+		}
+
+		// Comments on the same line as the previous token follow
+		// it.
+		if f.commentOffset < 0 && f.line(f.pos) > prevLine {
+			f.commentOffset = f.offset
+		}
+	}
 }
 
 // Returns the line number for the provided token.Pos.
@@ -99,6 +138,8 @@ func (f *finder) process() {
 		f.ellipsis()
 	case token.FUNC:
 		f.function()
+	case token.CONST, token.VAR:
+		f.valueDecl()
 	default:
 		f.next()
 	}
@@ -107,8 +148,10 @@ func (f *finder) process() {
 // Ensures that we have a package clause, recording the need for one if not.
 func (f *finder) pkg() {
 	if f.tok != token.PACKAGE {
-		// Missing a package clause. Generate a fake one.
-		f.append(&FakePackage{PackageStart: f.offset})
+		// Missing a package clause. Generate a fake one at the very top
+		// so that comments before the first declaration stay attached
+		// to it.
+		f.append(&FakePackage{PackageStart: 0})
 		return
 	}
 
@@ -162,7 +205,11 @@ func (f *finder) topLevelDecl() {
 		// If users want to place multiple of these in the patch, they
 		// should use {} to ensure that the patch is interpreted as a
 		// list of statemetns.
-		f.next() // type/const/var
+		if f.tok == token.TYPE {
+			f.next() // type
+		} else {
+			f.valueDecl()
+		}
 	case token.FUNC:
 		f.funcDecl()
 	case token.LBRACE:
@@ -170,8 +217,13 @@ func (f *finder) topLevelDecl() {
 		f.append(&FakeFunc{FuncStart: f.offset})
 		f.next() // {
 	default:
-		// Add a fake func() { ... }
-		f.append(&FakeFunc{FuncStart: f.offset, Braces: true})
+		// Add a fake func() { ... }, including comments right before
+		// the first statement.
+		start := f.offset
+		if f.commentOffset >= 0 {
+			start = f.commentOffset
+		}
+		f.append(&FakeFunc{FuncStart: start, Braces: true})
 	}
 }
 
@@ -207,6 +259,12 @@ func (f *finder) ellipsis() {
 	// the same line.
 	sameLine := f.line(pos) == f.line(f.pos)
 
+	// ... when != foo
+	if sameLine && f.tok == token.IDENT && f.lit == "when" && f.offset > off+3 {
+		f.append(&Dots{DotsStart: off, DotsEnd: off + 3, When: f.whens(f.line(pos))})
+		return
+	}
+
 	// ...foo
 	if f.tok == token.IDENT && sameLine {
 		f.next() // leave unchanged
@@ -217,6 +275,53 @@ func (f *finder) ellipsis() {
 	f.append(&Dots{DotsStart: off, DotsEnd: off + 3})
 }
 
+// Processes a const or var declaration.
+func (f *finder) valueDecl() {
+	f.next() // const/var
+	if f.tok != token.LPAREN {
+		// return to process loop for a single spec
+		return
+	}
+	f.next() // (
+
+	for f.tok != token.RPAREN && f.tok != token.EOF {
+		if f.tok != token.ELLIPSIS {
+			f.valueSpec()
+			continue
+		}
+
+		// "..." on its own line stands in for any number of specs.
+		// ELLIPSIS doesn't cause the scanner to insert a SEMICOLON at
+		// the end of the line so we check for the next token on a
+		// different line instead. See also, ellipsis().
+		pos := f.pos
+		off := f.offset
+		f.next() // ...
+		switch {
+		case f.tok == token.SEMICOLON:
+			f.next() // ;
+		case f.tok == token.RPAREN, f.line(pos) != f.line(f.pos):
+			// ok
+		default:
+			// "..." was the start of a spec, e.g. "...foo".
+			f.valueSpec()
+			continue
+		}
+		f.append(&Dots{DotsStart: off, DotsEnd: off + 3, Named: true})
+	}
+	f.next() // )
+}
+
+// Processes a single spec inside a const or var group.
+func (f *finder) valueSpec() {
+	for f.tok != token.SEMICOLON && f.tok != token.RPAREN && f.tok != token.EOF {
+		f.process()
+	}
+	if f.tok == token.SEMICOLON {
+		f.next() // ;
+	}
+}
+
 // Processes a top-level function or method declaration.
 func (f *finder) funcDecl() {
 	f.next() // func
@@ -308,3 +413,71 @@ func (f *finder) fieldList() {
 		f.append(&Dots{DotsStart: off, DotsEnd: off + 3, Named: named})
 	}
 }
+
+// Processes one or more "when" constraints following a "..." on the given
+// line.
+func (f *finder) whens(line int) []*When {
+	var whens []*When
+	for f.tok == token.IDENT && f.lit == "when" && f.line(f.pos) == line {
+		w := When{WhenStart: f.offset}
+		f.next() // when
+
+		switch {
+		case f.tok == token.IDENT && f.lit == "any":
+			w.Any = true
+			w.WhenEnd = f.offset + len(f.lit)
+			f.next() // any
+
+		case f.tok == token.NEQ || f.tok == token.EQL:
+			w.Op = f.tok
+			f.next() // != or ==
+			w.ExprStart = f.offset
+			w.ExprEnd = f.whenExpr()
+			w.WhenEnd = w.ExprEnd
+			if w.ExprEnd == w.ExprStart {
+				f.addError(`expected an expression after "when"`)
+				return whens
+			}
+
+		default:
+			f.addError(`expected "!=", "==", or "any" after "when"`)
+			return whens
+		}
+
+		whens = append(whens, &w)
+	}
+	return whens
+}
+
+// Skips over the expression of a "when" constraint, returning the offset
+// immediately after it. The expression ends at the end of the statement or
+// at the next "when".
+func (f *finder) whenExpr() (end int) {
+	end = f.offset
+	var depth int
+	for f.tok != token.EOF {
+		switch f.tok {
+		case token.SEMICOLON:
+			if depth == 0 {
+				return end
+			}
+		case token.IDENT:
+			if depth == 0 && f.lit == "when" {
+				return end
+			}
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		}
+
+		// Operators don't have a literal.
+		if len(f.lit) > 0 {
+			end = f.offset + len(f.lit)
+		} else {
+			end = f.offset + len(f.tok.String())
+		}
+		f.next()
+	}
+	return end
+}
@@ -97,6 +97,37 @@ func rewrite(src []byte, augs []Augmentation) ([]byte, []PosAdjustment) {
 				dst.WriteString("dts")
 			}
 			a.DotsEnd = dst.Len()
+
+			// Constraints are turned into statements following
+			// the dots so that their expressions get parsed
+			// alongside the rest of the code.
+			//
+			//   ... when != x when any
+			//
+			// Becomes,
+			//
+			//   dts ;       x
+			//
+			// None of these require a PosAdjustment because the
+			// replacements are the same length as the originals.
+			prev := end
+			for _, w := range a.When {
+				dst.Write(src[prev:w.WhenStart])
+				delta := dst.Len() - w.WhenStart
+				if w.Any {
+					dst.Write(bytes.Repeat([]byte{' '}, w.WhenEnd-w.WhenStart))
+				} else {
+					dst.WriteByte(';')
+					dst.Write(bytes.Repeat([]byte{' '}, w.ExprStart-w.WhenStart-1))
+					dst.Write(src[w.ExprStart:w.ExprEnd])
+					w.ExprStart += delta
+					w.ExprEnd += delta
+				}
+				prev = w.WhenEnd
+				w.WhenStart += delta
+				w.WhenEnd += delta
+			}
+			end = prev
 		default:
 			panic(fmt.Sprintf("unknown augmentation type %T", a))
 		}
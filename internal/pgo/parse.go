@@ -21,6 +21,7 @@
 package pgo
 
 import (
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
@@ -29,6 +30,7 @@ import (
 
 	"github.com/uber-go/gopatch/internal/goast"
 	"github.com/uber-go/gopatch/internal/pgo/augment"
+	"go.uber.org/multierr"
 )
 
 // Parse parses a pgo file. AST nodes in the returned File reference a newly
@@ -49,7 +51,7 @@ func Parse(fset *token.FileSet, filename string, src []byte) (*File, error) {
 
 	src, augs, adjs, err := augment.Augment(src)
 	if err != nil {
-		return nil, err
+		return nil, augmentErrors(pgoFile, err)
 	}
 	sort.Sort(sort.Reverse(byOffset(adjs)))
 
@@ -125,8 +127,9 @@ func Parse(fset *token.FileSet, filename string, src []byte) (*File, error) {
 				// import/package-only transforms.
 			case 1:
 				// If the body contains a single expression, we want to do an
-				// expression transformation.
-				if es, ok := body.List[0].(*ast.ExprStmt); ok {
+				// expression transformation. Comments around it can only
+				// be matched on statements.
+				if es, ok := body.List[0].(*ast.ExprStmt); ok && !hasStmtComments(fset.File(es.Pos()), f.Comments, es) {
 					n = es.X
 				}
 			}
@@ -157,10 +160,43 @@ func Parse(fset *token.FileSet, filename string, src []byte) (*File, error) {
 	// back to the pgoFile we created above.
 	goast.TransformPos(node, adjuster.Pos)
 
+	// Comments attached to nodes were mapped with them. Map the rest.
+	augmented := fset.File(f.Pos())
+	for _, cg := range file.Comments {
+		if fset.File(cg.Pos()) == augmented {
+			goast.TransformPos(cg, adjuster.Pos)
+		}
+	}
+
 	file.Node = node
 	return &file, nil
 }
 
+// augmentErrors reports the errors found while augmenting the source of the
+// given file at their positions in the file.
+func augmentErrors(file *token.File, err error) error {
+	errs := multierr.Errors(err)
+	for i, err := range errs {
+		var aerr *augment.Error
+		if errors.As(err, &aerr) {
+			errs[i] = fmt.Errorf("%v: %v", file.Position(file.Pos(aerr.Offset)), aerr.Msg)
+		}
+	}
+	return multierr.Combine(errs...)
+}
+
+// hasStmtComments reports whether the given statement has a comment on the
+// line right above it or following it on its last line.
+func hasStmtComments(tfile *token.File, comments []*ast.CommentGroup, s ast.Stmt) bool {
+	start, end := tfile.Line(s.Pos()), tfile.Line(s.End())
+	for _, cg := range comments {
+		if tfile.Line(cg.End())+1 == start || (cg.Pos() >= s.End() && tfile.Line(cg.Pos()) == end) {
+			return true
+		}
+	}
+	return false
+}
+
 func filterImports(decls []ast.Decl) []ast.Decl {
 	newDecls := decls[:0]
 	for _, d := range decls {
@@ -240,6 +240,94 @@ func TestParse(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "dots with constraints",
+			give: text.Unlines(
+				"foo()",
+				"... when != bar() when any",
+			),
+			want: &File{
+				Node: &StmtList{
+					List: []ast.Stmt{
+						&ast.ExprStmt{
+							X: &ast.CallExpr{
+								Fun:    &ast.Ident{Name: "foo"},
+								Lparen: 3,
+								Rparen: 4,
+							},
+						},
+						&ast.ExprStmt{
+							X: &Dots{
+								Dots: 6,
+								When: []*DotsWhen{
+									{
+										When: 10,
+										Op:   token.NEQ,
+										X: &ast.CallExpr{
+											Fun:    &ast.Ident{Name: "bar", NamePos: 18},
+											Lparen: 21,
+											Rparen: 22,
+										},
+									},
+									{When: 24, Any: true},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "dots in value group",
+			give: text.Unlines(
+				"var (",
+				"\t...",
+				"\tfoo = bar",
+				")",
+			),
+			want: &File{
+				Node: &GenDecl{
+					GenDecl: &ast.GenDecl{
+						Tok:    token.VAR,
+						Lparen: 4,
+						Specs: []ast.Spec{
+							&ast.ValueSpec{
+								Names: []*ast.Ident{{Name: "_", NamePos: 7}},
+								Type:  &Dots{Dots: 7},
+							},
+							&ast.ValueSpec{
+								Names:  []*ast.Ident{{Name: "foo", NamePos: 12}},
+								Values: []ast.Expr{&ast.Ident{Name: "bar", NamePos: 18}},
+							},
+						},
+						Rparen: 22,
+					},
+				},
+			},
+		},
+		{
+			desc: "dots in value names",
+			give: text.Unlines(
+				"var a, ..., c string",
+			),
+			want: &File{
+				Node: &GenDecl{
+					GenDecl: &ast.GenDecl{
+						Tok: token.VAR,
+						Specs: []ast.Spec{
+							&ast.ValueSpec{
+								Names: []*ast.Ident{
+									{Name: "a", NamePos: 4},
+									{Name: DotsIdent, NamePos: 7},
+									{Name: "c", NamePos: 12},
+								},
+								Type: &ast.Ident{Name: "string", NamePos: 14},
+							},
+						},
+					},
+				},
+			},
+		},
 		{
 			desc: "dots as expression",
 			give: text.Unlines("foo(...)"),
@@ -305,6 +393,57 @@ func disconnectIdentObj(v reflect.Value) {
 	}
 }
 
+func TestParseComments(t *testing.T) {
+	tests := []struct {
+		desc      string
+		give      []byte
+		wantStmts bool // whether the node is a StmtList
+	}{
+		{
+			desc: "expression",
+			give: text.Unlines(
+				"// foo",
+				"",
+				"foo()",
+			),
+		},
+		{
+			desc: "expression with trailing comment",
+			give: text.Unlines(
+				"foo() // foo",
+			),
+			wantStmts: true,
+		},
+		{
+			desc: "expression with leading comment",
+			give: text.Unlines(
+				"// foo",
+				"foo()",
+			),
+			wantStmts: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fset := token.NewFileSet()
+			got, err := Parse(fset, "test.go", tt.give)
+			require.NoError(t, err)
+
+			_, isStmts := got.Node.(*StmtList)
+			assert.Equal(t, tt.wantStmts, isStmts, "node: %T", got.Node)
+
+			// Comments are mapped back to the patch like the rest
+			// of the file.
+			require.Len(t, got.Comments, 1)
+			pos := fset.Position(got.Comments[0].Pos())
+			assert.Equal(t, "test.go", pos.Filename)
+			assert.Equal(t, 1, pos.Line)
+			assert.Equal(t, "// foo", got.Comments[0].List[0].Text)
+		})
+	}
+}
+
 func TestParseError(t *testing.T) {
 	tests := []struct {
 		desc    string
@@ -337,6 +476,22 @@ func TestParseError(t *testing.T) {
 			),
 			wantErr: `test.go:1:6: found unexpected "..." inside *ast.Ident`,
 		},
+		{
+			desc: "bad when",
+			give: text.Unlines(
+				"foo()",
+				"... when bar()",
+			),
+			wantErr: `test.go:2:10: expected "!=", "==", or "any" after "when"`,
+		},
+		{
+			desc: "when without expression",
+			give: text.Unlines(
+				"foo()",
+				"... when !=",
+			),
+			wantErr: `test.go:2:13: expected an expression after "when"`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -20,7 +20,10 @@ type patchLoader struct {
 
 	// Pointer to parseAndCompile function,
 	// which we can use to swap out this logic.
-	parseAndCompile func(*token.FileSet, string, []byte) (*engine.Program, error)
+	parseAndCompile func(*token.FileSet, string, []byte, ...engine.CompileOption) (*engine.Program, error)
+
+	// Options used to compile all loaded patches.
+	compileOptions []engine.CompileOption
 }
 
 func newPatchLoader(fset *token.FileSet) *patchLoader {
@@ -41,7 +44,7 @@ func (l *patchLoader) LoadReader(name string, r io.Reader) error {
 		return fmt.Errorf("read: %w", err)
 	}
 
-	prog, err := l.parseAndCompile(l.fset, name, src)
+	prog, err := l.parseAndCompile(l.fset, name, src, l.compileOptions...)
 	if err != nil {
 		return err
 	}
@@ -90,12 +93,12 @@ func (l *patchLoader) LoadFileList(patchList string) (err error) {
 
 // parseAndCompile parses the given patch contents,
 // and compiles them into a gopatch program.
-func parseAndCompile(fset *token.FileSet, name string, src []byte) (*engine.Program, error) {
+func parseAndCompile(fset *token.FileSet, name string, src []byte, opts ...engine.CompileOption) (*engine.Program, error) {
 	astProg, err := parse.Parse(fset, name, src)
 	if err != nil {
 		return nil, fmt.Errorf("parse: %w", err)
 	}
-	prog, err := engine.Compile(fset, astProg)
+	prog, err := engine.Compile(fset, astProg, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("compile: %w", err)
 	}
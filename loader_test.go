@@ -44,9 +44,9 @@ func (m *fakeParseAndCompile) Expect(name string, body string) *loadCall {
 
 // Build builds the mock expectations into a function
 // that can be set on patchLoader.
-func (m *fakeParseAndCompile) Build() func(fs *token.FileSet, name string, body []byte) (*engine.Program, error) {
+func (m *fakeParseAndCompile) Build() func(fs *token.FileSet, name string, body []byte, opts ...engine.CompileOption) (*engine.Program, error) {
 	t := m.t
-	return func(fs *token.FileSet, name string, body []byte) (*engine.Program, error) {
+	return func(fs *token.FileSet, name string, body []byte, opts ...engine.CompileOption) (*engine.Program, error) {
 		require.NotEmpty(t, m.calls, "unexpected call parseAndCompile(%v, %q, %q)", name, body)
 		call := m.calls[0]
 		m.calls = m.calls[1:]
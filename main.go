@@ -38,6 +38,7 @@ import (
 	"github.com/jessevdk/go-flags"
 	"github.com/pkg/diff"
 	"github.com/uber-go/gopatch/internal/astdiff"
+	"github.com/uber-go/gopatch/internal/data"
 	"github.com/uber-go/gopatch/internal/engine"
 	"go.uber.org/multierr"
 	"golang.org/x/tools/imports"
@@ -52,20 +53,23 @@ type arguments struct {
 }
 
 type options struct {
-	Patches              []string  `short:"p" long:"patch" value-name:"file"`
-	PatchesFile          string    `short:"P" long:"patches-file" value-name:"file"`
-	Diff                 bool      `short:"d" long:"diff"`
-	DisplayVersion       bool      `long:"version"`
-	Print                bool      `long:"print-only"`
-	SkipImportProcessing bool      `long:"skip-import-processing"`
-	SkipGenerated        bool      `long:"skip-generated"`
-	Args                 arguments `positional-args:"yes"`
-	Verbose              bool      `short:"v" long:"verbose"`
+	Patches              []string          `short:"p" long:"patch" value-name:"file"`
+	PatchesFile          string            `short:"P" long:"patches-file" value-name:"file"`
+	Diff                 bool              `short:"d" long:"diff"`
+	DisplayVersion       bool              `long:"version"`
+	Print                bool              `long:"print-only"`
+	SkipImportProcessing bool              `long:"skip-import-processing"`
+	SkipGenerated        bool              `long:"skip-generated"`
+	TypeCheck            bool              `long:"type-check"`
+	Params               map[string]string `short:"D" long:"param" value-name:"name=value" key-value-delimiter:"="`
+	Explain              string            `long:"explain" value-name:"file.go:LINE"`
+	Args                 arguments         `positional-args:"yes"`
+	Verbose              bool              `short:"v" long:"verbose"`
 }
 
 func newArgParser() (*flags.Parser, *options) {
 	var opts options
-	parser := flags.NewParser(&opts, flags.HelpFlag)
+	parser := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash)
 	parser.Name = "gopatch"
 
 	// The following is more readable than long descriptions in struct
@@ -99,6 +103,20 @@ func newArgParser() (*flags.Parser, *options) {
 	parser.FindOptionByLongName("skip-generated").
 		Description = "Skips running on files with generated code."
 
+	parser.FindOptionByLongName("type-check").
+		Description = "Type-check packages before patching them so that metavariables " +
+		"with type constraints match only values of those types. " +
+		"Code that doesn't type-check is matched by syntax alone."
+
+	parser.FindOptionByLongName("param").
+		Description = "Value for a parameter declared in patches with \"param\". " +
+		"This may be provided multiple times to set different parameters."
+
+	parser.FindOptionByLongName("explain").
+		Description = "Report for each change whether it matched code on the given line " +
+		"of a Go file, and if not, the point at which matching failed. " +
+		"No files are modified and patterns are not required."
+
 	parser.Args()[0].
 		Description = "One or more files or directores containing Go code. " +
 		"When directories are provided, all Go files in them and their " +
@@ -110,6 +128,10 @@ func newArgParser() (*flags.Parser, *options) {
 // loadPatches loads patches specified by command line options.
 func loadPatches(fset *token.FileSet, opts *options, stdin io.Reader) ([]*engine.Program, error) {
 	loader := newPatchLoader(fset)
+	if len(opts.Params) > 0 {
+		loader.compileOptions = append(loader.compileOptions, engine.Params(opts.Params))
+	}
+
 	if len(opts.Patches) == 0 && len(opts.PatchesFile) == 0 {
 		// If -p and -P are unset, read from stdin.
 		if err := loader.LoadReader("stdin", stdin); err != nil {
@@ -129,9 +151,37 @@ func loadPatches(fset *token.FileSet, opts *options, stdin io.Reader) ([]*engine
 		}
 	}
 
+	declared := make(map[string]struct{})
+	for _, prog := range loader.Programs() {
+		for _, name := range prog.Params {
+			declared[name] = struct{}{}
+		}
+	}
+	if err := checkParams(opts.Params, declared); err != nil {
+		return nil, err
+	}
+
 	return loader.Programs(), nil
 }
 
+// checkParams reports an error for each parameter given a value with -D that
+// isn't declared by any of the patches so that misspelled names are caught.
+func checkParams(values map[string]string, declared map[string]struct{}) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if _, ok := declared[name]; !ok {
+			errs = append(errs, fmt.Errorf("unknown parameter %q: not declared by any patch", name))
+		}
+	}
+	return multierr.Combine(errs...)
+}
+
 // sourcePath is the path to a Go source file.
 type sourcePath struct {
 	// Form closest to what was provided by the user.
@@ -235,7 +285,25 @@ func runMain() (exitCode int) {
 	return 0
 }
 
+// Run runs gopatch with the given arguments.
+//
+// Commands are given as the first argument, which used to be a path to
+// patch. Paths with the same name as a command must be spelled differently,
+// e.g. "./test", or follow "--".
 func (cmd *mainCmd) Run(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "test":
+			return cmd.runTest(args[1:])
+		case "vet":
+			return cmd.runVet(args[1:])
+		case "fmt":
+			return cmd.runFmt(args[1:])
+		case "grep":
+			return cmd.runGrep(args[1:])
+		}
+	}
+
 	argParser, opts := newArgParser()
 	if _, err := argParser.ParseArgs(args); err != nil {
 		return err
@@ -245,7 +313,7 @@ func (cmd *mainCmd) Run(args []string) error {
 		return nil
 	}
 
-	if len(opts.Args.Patterns) == 0 {
+	if len(opts.Args.Patterns) == 0 && len(opts.Explain) == 0 {
 		argParser.WriteHelp(cmd.Stderr)
 		fmt.Fprintln(cmd.Stderr)
 
@@ -264,57 +332,42 @@ func (cmd *mainCmd) Run(args []string) error {
 		return err
 	}
 
-	patchRunner := newPatchRunner(fset, progs)
-
-	cwd, err := cmd.Getwd()
-	if err != nil {
-		return fmt.Errorf("getwd: %w", err)
+	if len(opts.Explain) > 0 {
+		return cmd.explain(fset, progs, opts, log)
 	}
 
-	files, err := findFiles(cwd, opts.Args.Patterns)
-	if err != nil {
-		return err
-	}
+	patchRunner := newPatchRunner(fset, progs)
 
 	var errors []error
-	for _, sourcePath := range files {
-		filename := sourcePath.Absolute
-		content, err := os.ReadFile(filename)
-		if err != nil {
-			return err
-		}
-		f, err := parser.ParseFile(fset, filename, content /* src */, parser.AllErrors|parser.ParseComments)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("could not parse %q: %v", filename, err))
-			continue
-		}
-
-		if opts.SkipGenerated && checkGeneratedCode(f) {
-			log.Printf("generated file %s: skipped", filename)
-			continue
+	fileOpts := fileOptions{SkipGenerated: opts.SkipGenerated, TypeCheck: opts.TypeCheck}
+	err = cmd.forEachFile(fset, opts.Args.Patterns, fileOpts, log, func(gf *goFile) error {
+		filename := gf.Path.Absolute
+		f, comments, ok := patchRunner.Apply(filename, gf.File, gf.Types)
+		for _, m := range patchRunner.found {
+			m.Pos.Filename = gf.Path.Provided
+			fmt.Fprintf(cmd.Stderr, "%v: %s\n", m.Pos, gf.Content[m.Start:m.End])
 		}
-
-		f, comments, ok := patchRunner.Apply(filename, f)
 		// If at least one patch didn't match, there's nothing to do.
 		// If --print-only was passed, print the contents out as-is.
 		if !ok {
 			if opts.Print {
-				if _, err := cmd.Stdout.Write(content); err != nil {
+				if _, err := cmd.Stdout.Write(gf.Content); err != nil {
 					return err
 				}
 			}
 			log.Printf("%s: skipped", filename)
-			continue
+			return nil
 		}
 
 		var out bytes.Buffer
 		if err := format.Node(&out, fset, f); err != nil {
 			log.Printf("%s: failed: %v", filename, err)
 			errors = append(errors, fmt.Errorf("failed to rewrite %q: %v", filename, err))
-			continue
+			return nil
 		}
 		bs := out.Bytes()
 		if !opts.SkipImportProcessing {
+			var err error
 			bs, err = imports.Process(filename, bs, &imports.Options{
 				Comments:   true,
 				TabIndent:  true,
@@ -325,16 +378,17 @@ func (cmd *mainCmd) Run(args []string) error {
 			// findFiles, loadPatches and format.Node()
 			if err != nil {
 				errors = append(errors, fmt.Errorf("reformat %q: %w", filename, err))
-				continue
+				return nil
 			}
 
 		}
 
+		var err error
 		switch {
 		case opts.Diff:
-			err = cmd.preview(sourcePath.Provided, content, bs, comments)
+			err = cmd.preview(gf.Path.Provided, gf.Content, bs, comments)
 		case opts.Print:
-			cmd.printComments(sourcePath.Provided, comments)
+			cmd.printComments(gf.Path.Provided, comments)
 			_, err = cmd.Stdout.Write(bs)
 		default:
 			err = os.WriteFile(filename, bs, 0o644)
@@ -342,12 +396,100 @@ func (cmd *mainCmd) Run(args []string) error {
 		if err != nil {
 			log.Printf("%s: failed: %v", filename, err)
 			errors = append(errors, err)
-			continue
+			return nil
 		}
 		log.Printf("%s: patched", filename)
-	}
+		return nil
+	})
 
 	errors = append(errors, patchRunner.errors...)
+	return multierr.Append(err, multierr.Combine(errors...))
+}
+
+// goFile is a Go source file found by forEachFile.
+type goFile struct {
+	Path    sourcePath
+	Content []byte
+	File    *ast.File
+
+	// Type information for the file, or nil if it wasn't type-checked.
+	Types *engine.TypeInfo
+}
+
+// fileOptions controls how forEachFile loads files.
+type fileOptions struct {
+	SkipGenerated bool // skip files with generated code
+	TypeCheck     bool // type-check packages before parsing them
+}
+
+// forEachFile finds the Go files matching the given patterns relative to the
+// working directory, and calls fn with each of them in order.
+//
+// Files that can't be parsed are skipped and reported in the returned error
+// once all other files have been visited. An error returned by fn stops the
+// iteration and is returned as-is.
+func (cmd *mainCmd) forEachFile(
+	fset *token.FileSet,
+	patterns []string,
+	opts fileOptions,
+	log *log.Logger,
+	fn func(*goFile) error,
+) error {
+	cwd, err := cmd.Getwd()
+	if err != nil {
+		return fmt.Errorf("getwd: %w", err)
+	}
+
+	files, err := findFiles(cwd, patterns)
+	if err != nil {
+		return err
+	}
+
+	var typed map[string]engine.TypedFile
+	if opts.TypeCheck {
+		filenames := make([]string, len(files))
+		for i, f := range files {
+			filenames[i] = f.Absolute
+		}
+		typed = engine.LoadTypes(fset, filenames, func(err error) {
+			log.Printf("type-check: %v", err)
+		})
+	}
+
+	var errors []error
+	for _, sourcePath := range files {
+		filename := sourcePath.Absolute
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		tf, ok := typed[filename]
+		f := tf.File
+		if !ok {
+			f, err = parser.ParseFile(fset, filename, content /* src */, parser.AllErrors|parser.ParseComments)
+			if err != nil {
+				errors = append(errors, fmt.Errorf("could not parse %q: %v", filename, err))
+				continue
+			}
+		}
+
+		if opts.SkipGenerated && checkGeneratedCode(f) {
+			log.Printf("generated file %s: skipped", filename)
+			continue
+		}
+
+		err = fn(&goFile{
+			Path:    sourcePath,
+			Content: content,
+			File:    f,
+			Types:   tf.Types,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	return multierr.Combine(errors...)
 }
 
@@ -385,6 +527,16 @@ type patchRunner struct {
 	fset    *token.FileSet
 	patches []*engine.Program
 	errors  []error
+
+	// Code matched by search-only changes during the last call to Apply.
+	found []foundMatch
+}
+
+// foundMatch is code matched by a search-only change.
+type foundMatch struct {
+	Pos   token.Position
+	Start int // offset of the matched code in the file
+	End   int // offset just past the matched code
 }
 
 func newPatchRunner(fset *token.FileSet, patches []*engine.Program) *patchRunner {
@@ -394,37 +546,79 @@ func newPatchRunner(fset *token.FileSet, patches []*engine.Program) *patchRunner
 	}
 }
 
-func (r *patchRunner) Apply(filename string, f *ast.File) (fout *ast.File, comments []string, matched bool) {
+// Apply runs all patches on the given file. Type information for the file
+// may be nil.
+//
+// Search-only changes don't modify the file. Matches of those that other
+// changes don't refer to are recorded in r.found instead.
+func (r *patchRunner) Apply(filename string, f *ast.File, ti *engine.TypeInfo) (fout *ast.File, comments []string, matched bool) {
 	snap := astdiff.Before(f, ast.NewCommentMap(r.fset, f, f.Comments))
-
-	for _, prog := range r.patches {
-		for _, c := range prog.Changes {
-			d, ok := c.Match(f)
-			if !ok {
-				// This patch didn't modify the file. Try the next one.
-				continue
+	r.found = r.found[:0]
+
+	err := matchChanges(r.patches, f, ti, func(c *engine.Change, d data.Data) error {
+		if c.SearchOnly {
+			// This patch doesn't modify the file. Record its matches
+			// before other changes renumber the lines of the file,
+			// unless it only provides context to other changes.
+			if c.Referenced {
+				return nil
 			}
+			tfile := r.fset.File(f.Pos())
+			for _, m := range c.Matches(d) {
+				r.found = append(r.found, foundMatch{
+					Pos:   r.fset.Position(m.Region.Pos),
+					Start: tfile.Offset(m.Region.Pos),
+					End:   tfile.Offset(m.Region.End),
+				})
+			}
+			return nil
+		}
 
-			matched = true
-			comments = c.Comments
-
-			cl := engine.NewChangelog()
+		matched = true
+		comments = c.Comments
 
-			var err error
-			fout, err = c.Replace(d, cl)
-			if err != nil {
-				r.errors = append(r.errors, fmt.Errorf("could not update %q: %v", filename, err))
-				return nil, comments, false
-			}
+		cl := engine.NewChangelog()
 
-			snap = snap.Diff(fout, cl)
-			cleanupFilePos(r.fset.File(fout.Pos()), cl, fout.Comments)
+		var err error
+		fout, err = c.Replace(d, cl)
+		if err != nil {
+			return fmt.Errorf("could not update %q: %v", filename, err)
 		}
+
+		snap = snap.Diff(fout, cl)
+		cleanupFilePos(r.fset.File(fout.Pos()), cl, fout.Comments)
+		return nil
+	})
+	if err != nil {
+		r.errors = append(r.errors, err)
+		return nil, comments, false
 	}
 
 	return fout, comments, matched
 }
 
+// matchChanges runs the changes of all programs on the given file in order,
+// calling fn with each change that matched and the data it captured. Type
+// information for the file may be nil.
+//
+// An error returned by fn stops the run and is returned as-is.
+func matchChanges(progs []*engine.Program, f *ast.File, ti *engine.TypeInfo, fn func(*engine.Change, data.Data) error) error {
+	for _, prog := range progs {
+		bindings := engine.NewBindings()
+		bindings.Types = ti
+		for _, c := range prog.Changes {
+			d, ok := c.Match(f, bindings)
+			if !ok {
+				continue
+			}
+			if err := fn(c, d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func cleanupFilePos(tfile *token.File, cl engine.Changelog, comments []*ast.CommentGroup) {
 	linesToDelete := make(map[int]struct{})
 	for _, dr := range cl.ChangedIntervals() {
@@ -438,11 +632,12 @@ func cleanupFilePos(tfile *token.File, cl engine.Changelog, comments []*ast.Comm
 			}
 		}
 
-		// Remove comments in the changed sections of the code.
+		// Remove comments in the changed sections of the code, except
+		// those generated by the patch.
 		for _, cg := range comments {
 			var list []*ast.Comment
 			for _, c := range cg.List {
-				if c.Pos() >= dr.Start && c.End() <= dr.End {
+				if c.Pos() >= dr.Start && c.End() <= dr.End && !cl.IsCommented(c) {
 					continue
 				}
 				list = append(list, c)
@@ -24,14 +24,18 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"go/ast"
 	"go/token"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/gopatch/internal/text"
 )
 
 func TestNewArgParser(t *testing.T) {
@@ -264,3 +268,801 @@ func TestCheckGeneratedCode(t *testing.T) {
 		})
 	}
 }
+
+func TestTypeCheck(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.go"), []byte(`package app
+
+import (
+	"io"
+	"os"
+)
+
+func run(f *os.File, c io.Closer) {
+	f.Close()
+	c.Close()
+	missing().Close()
+}
+`), 0o644))
+
+	patch := `@@
+var x expression : *os.File
+@@
+-x.Close()
++x.Sync()
+`
+
+	tests := []struct {
+		desc string
+		args []string
+		want string
+	}{
+		{
+			desc: "type-check",
+			args: []string{"--type-check"},
+			want: "	f.Sync()\n	c.Close()\n	missing().Sync()\n",
+		},
+		{
+			desc: "syntax only",
+			want: "	f.Sync()\n	c.Sync()\n	missing().Sync()\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			cmd := mainCmd{
+				Stdin:  bytes.NewReader([]byte(patch)),
+				Stdout: &stdout,
+				Stderr: &stderr,
+				Getwd:  func() (string, error) { return dir, nil },
+			}
+			args := append(tt.args, "--print-only", "app.go")
+			require.NoError(t, cmd.Run(args), "stderr: %s", stderr.String())
+			assert.Contains(t, stdout.String(), tt.want)
+		})
+	}
+}
+
+func TestRunSearchOnly(t *testing.T) {
+	dir := t.TempDir()
+	src := []byte(`package app
+
+func run() {
+	foo()
+	bar()
+	foo()
+}
+`)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.go"), src, 0o644))
+
+	var stdout, stderr bytes.Buffer
+	cmd := mainCmd{
+		Stdin:  strings.NewReader("@@\n@@\n-foo()\n\n@@\n@@\n-bar()\n+baz()\n"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Getwd:  func() (string, error) { return dir, nil },
+	}
+	require.NoError(t, cmd.Run([]string{"app.go"}))
+	assert.Equal(t, "app.go:4:2: foo()\napp.go:6:2: foo()\n", stderr.String())
+
+	got, err := os.ReadFile(filepath.Join(dir, "app.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(got), "\tbaz()\n")
+	assert.NotContains(t, string(got), "bar()")
+}
+
+func TestParams(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.go"), []byte(`package app
+
+func run() {
+	Foo()
+	Baz()
+}
+`), 0o644))
+
+	patch := `@@
+param OLD, NEW identifier
+@@
+-OLD()
++NEW()
+`
+
+	tests := []struct {
+		desc    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			desc: "set",
+			args: []string{"-D", "OLD=Foo", "--param", "NEW=Bar"},
+			want: "	Bar()\n	Baz()\n",
+		},
+		{
+			desc:    "missing",
+			args:    []string{"-D", "OLD=Foo"},
+			wantErr: `missing value for parameter "NEW"`,
+		},
+		{
+			desc:    "unknown",
+			args:    []string{"-D", "OLD=Foo", "-D", "NEW=Bar", "-D", "NWE=Baz"},
+			wantErr: `unknown parameter "NWE": not declared by any patch`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			cmd := mainCmd{
+				Stdin:  bytes.NewReader([]byte(patch)),
+				Stdout: &stdout,
+				Stderr: &stderr,
+				Getwd:  func() (string, error) { return dir, nil },
+			}
+			err := cmd.Run(append(tt.args, "--print-only", "app.go"))
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err, "stderr: %s", stderr.String())
+			assert.Contains(t, stdout.String(), tt.want)
+		})
+	}
+}
+
+func TestRunTest(t *testing.T) {
+	patch := `@ foo @
+@@
+-foo()
++bar()
+-- call.in.go --
+package x
+
+func y() {
+	foo()
+}
+-- call.out.go --
+package x
+
+func y() {
+	bar()
+}
+-- untouched.in.go --
+package x
+
+func y() { baz() }
+
+@ qux depends on foo @
+@@
+-qux()
++quux()
+-- both.in.go --
+package x
+
+func y() {
+	foo()
+	qux()
+}
+-- both.out.go --
+package x
+
+func y() {
+	bar()
+	WANT()
+}
+
+@@
+@@
+-bar()
++baz()
+-- independent.in.go --
+package x
+
+func y() {
+	foo()
+	bar()
+}
+-- independent.out.go --
+package x
+
+func y() {
+	foo()
+	baz()
+}
+`
+
+	tests := []struct {
+		desc       string
+		args       []string
+		want       string
+		wantStdout []string
+		wantErr    string
+	}{
+		{
+			desc: "pass",
+			want: "quux",
+			wantStdout: []string{
+				"ok\t%v\t4 tests\n",
+			},
+		},
+		{
+			desc: "verbose",
+			args: []string{"-v"},
+			want: "quux",
+			wantStdout: []string{
+				"--- PASS: %v:5:4: call\n",
+				"--- PASS: %v:17:4: untouched\n",
+				"--- PASS: %v:26:4: both\n",
+				"--- PASS: %v:45:4: independent\n",
+			},
+		},
+		{
+			desc: "fail",
+			want: "qux",
+			wantStdout: []string{
+				"--- FAIL: %v:26:4: both\n",
+				"-\tqux()\n+\tquux()\n",
+				"FAIL\t%v\n",
+			},
+			wantErr: "1 of 4 tests failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "foo.patch")
+			require.NoError(t, os.WriteFile(path,
+				bytes.ReplaceAll([]byte(patch), []byte("WANT"), []byte(tt.want)), 0o644))
+
+			var stdout, stderr bytes.Buffer
+			cmd := mainCmd{
+				Stdout: &stdout,
+				Stderr: &stderr,
+				Getwd:  os.Getwd,
+			}
+			err := cmd.Run(append(append([]string{"test"}, tt.args...), path))
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				require.NoError(t, err, "stdout: %s", stdout.String())
+			}
+
+			for _, want := range tt.wantStdout {
+				if strings.Contains(want, "%v") {
+					want = fmt.Sprintf(want, path)
+				}
+				assert.Contains(t, stdout.String(), want)
+			}
+		})
+	}
+
+	t.Run("no patches", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		cmd := mainCmd{Stdout: &stdout, Stderr: &stderr, Getwd: os.Getwd}
+		err := cmd.Run([]string{"test"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "please provide at least one patch file")
+	})
+
+	t.Run("directory named test", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "test"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "test", "a.go"),
+			[]byte("package x\n\nfunc y() { foo() }\n"), 0o644))
+
+		var stdout, stderr bytes.Buffer
+		cmd := mainCmd{
+			Stdin:  strings.NewReader("@@\n@@\n-foo()\n+bar()\n"),
+			Stdout: &stdout,
+			Stderr: &stderr,
+			Getwd:  func() (string, error) { return dir, nil },
+		}
+		for _, args := range [][]string{{"./test", "-d"}, {"-d", "--", "test"}} {
+			stdout.Reset()
+			cmd.Stdin = strings.NewReader("@@\n@@\n-foo()\n+bar()\n")
+			require.NoError(t, cmd.Run(args), "stderr: %s", stderr.String())
+			assert.Contains(t, stdout.String(), "+func y() { bar() }", "args: %q", args)
+		}
+
+		// Without either, it's the test command.
+		err := cmd.Run([]string{"test", "-d"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown flag `d'")
+	})
+}
+
+func TestRunVet(t *testing.T) {
+	tests := []struct {
+		desc       string
+		give       string
+		wantStdout []string
+		wantErr    string
+	}{
+		{
+			desc: "clean",
+			give: "@@\nvar x expression\n@@\n-foo(x)\n+bar(x)\n",
+		},
+		{
+			desc:       "problems",
+			give:       "@@\nvar x, y expression\n@@\n-foo(x)\n+bar(x, ...)\n",
+			wantStdout: []string{"%v:2:8: metavariable \"y\" is declared but never used\n", "%v:5:9: "},
+			wantErr:    "found 2 problems",
+		},
+		{
+			desc: "compile error",
+			give: "@@\nvar x, y expression\n@@\n-foo()\n+bar(x)\n",
+			wantStdout: []string{
+				"%v:2:8: metavariable \"y\" is declared but never used\n",
+				"%v:5:6: metavariable \"x\" is used in the \"+\" section",
+			},
+			wantErr: "found 2 problems",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "foo.patch")
+			require.NoError(t, os.WriteFile(path, []byte(tt.give), 0o644))
+
+			var stdout, stderr bytes.Buffer
+			cmd := mainCmd{Stdout: &stdout, Stderr: &stderr, Getwd: os.Getwd}
+			err := cmd.Run([]string{"vet", path})
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				require.NoError(t, err, "stdout: %s", stdout.String())
+				assert.Empty(t, stdout.String())
+			}
+
+			for _, want := range tt.wantStdout {
+				assert.Contains(t, stdout.String(), fmt.Sprintf(want, path))
+			}
+		})
+	}
+}
+
+func TestRunFmt(t *testing.T) {
+	const (
+		unformatted = "@@\nvar x   expression\n@@\n-foo( x )\n+bar(x)\n"
+		formatted   = "@@\nvar x expression\n@@\n-foo(x)\n+bar(x)\n"
+	)
+
+	tests := []struct {
+		desc       string
+		give       string
+		args       []string
+		wantStdout []string // with %v replaced by the path of the patch
+		wantFile   string   // contents of the patch afterwards, if changed
+		wantErr    string
+	}{
+		{
+			desc:       "print",
+			give:       unformatted,
+			wantStdout: []string{formatted},
+		},
+		{
+			desc:       "list",
+			give:       unformatted,
+			args:       []string{"-l"},
+			wantStdout: []string{"%v\n"},
+		},
+		{
+			desc: "list formatted",
+			give: formatted,
+			args: []string{"-l"},
+		},
+		{
+			desc:     "write",
+			give:     unformatted,
+			args:     []string{"-w"},
+			wantFile: formatted,
+		},
+		{
+			desc:       "diff",
+			give:       unformatted,
+			args:       []string{"-d"},
+			wantStdout: []string{"--- %v\n", "-var x   expression\n+var x expression\n"},
+		},
+		{
+			desc:    "invalid patch",
+			give:    "@@\n@@\n",
+			wantErr: "invalid change: patch cannot be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "foo.patch")
+			require.NoError(t, os.WriteFile(path, []byte(tt.give), 0o644))
+
+			var stdout, stderr bytes.Buffer
+			cmd := mainCmd{Stdout: &stdout, Stderr: &stderr, Getwd: os.Getwd}
+			err := cmd.Run(append(append([]string{"fmt"}, tt.args...), path))
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			if len(tt.wantStdout) == 0 {
+				assert.Empty(t, stdout.String())
+			}
+			for _, want := range tt.wantStdout {
+				assert.Contains(t, stdout.String(), strings.ReplaceAll(want, "%v", path))
+			}
+
+			wantFile := tt.give
+			if len(tt.wantFile) > 0 {
+				wantFile = tt.wantFile
+			}
+			got, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, wantFile, string(got))
+		})
+	}
+
+	t.Run("invalid patch first", func(t *testing.T) {
+		dir := t.TempDir()
+		bad := filepath.Join(dir, "bad.patch")
+		good := filepath.Join(dir, "good.patch")
+		require.NoError(t, os.WriteFile(bad, []byte("@@\n@@\n"), 0o644))
+		require.NoError(t, os.WriteFile(good, []byte(unformatted), 0o644))
+
+		var stdout, stderr bytes.Buffer
+		cmd := mainCmd{Stdout: &stdout, Stderr: &stderr, Getwd: os.Getwd}
+		err := cmd.Run([]string{"fmt", "-l", "-w", bad, good})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), fmt.Sprintf("format patch %q: ", bad))
+		assert.Contains(t, err.Error(), "invalid change: patch cannot be empty")
+		assert.Equal(t, good+"\n", stdout.String())
+
+		got, err := os.ReadFile(good)
+		require.NoError(t, err)
+		assert.Equal(t, formatted, string(got))
+	})
+
+	t.Run("no patches", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		cmd := mainCmd{Stdout: &stdout, Stderr: &stderr, Getwd: os.Getwd}
+		err := cmd.Run([]string{"fmt"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "please provide at least one patch file")
+	})
+}
+
+func TestRunExplain(t *testing.T) {
+	patch := text.Unlines(
+		"@ wrap @",
+		"var err expression",
+		"@@",
+		`-fmt.Errorf("failed: %v", err)`,
+		`+fmt.Errorf("failed: %w", err)`,
+		"",
+		"@ depends on wrap @",
+		"var x expression",
+		"@@",
+		"-foo(x, x)",
+		"+bar(x)",
+	)
+	src := text.Unlines(
+		"package x",
+		"",
+		`import "fmt"`,
+		"",
+		"func y(err error) error {",
+		"	foo(a, b)",
+		`	return fmt.Errorf("failed: %v", err)`,
+		"}",
+	)
+
+	tests := []struct {
+		desc       string
+		src        []byte // contents of foo.go, if not src
+		explain    string
+		wantStdout []string
+		wantErr    string
+	}{
+		{
+			desc:    "matched",
+			explain: "foo.go:7",
+			wantStdout: []string{
+				`foo.patch:4:1: change "wrap" matched foo.go:7`,
+				"foo.patch:10:1: change did not match foo.go:7:9: " +
+					"expected *ast.Ident, found *ast.SelectorExpr (foo.patch:10:2)",
+			},
+		},
+		{
+			desc:    "metavariable conflict",
+			explain: "foo.go:6",
+			wantStdout: []string{
+				`foo.patch:4:1: change "wrap" did not match foo.go:6:2: ` +
+					"expected *ast.SelectorExpr, found *ast.Ident (foo.patch:4:2)",
+				"foo.patch:10:1: change did not match foo.go:6:9: " +
+					`metavariable "x" already matched a, found b (foo.patch:10:9)`,
+			},
+		},
+		{
+			desc:    "no code",
+			explain: "foo.go:2",
+			wantStdout: []string{
+				`foo.patch:4:1: change "wrap" did not match foo.go:2: no code on line 2`,
+				"foo.patch:10:1: change did not match foo.go:2: no code on line 2",
+			},
+		},
+		{
+			desc:    "no line",
+			explain: "foo.go",
+			wantErr: `invalid --explain "foo.go": expected file.go:LINE`,
+		},
+		{
+			desc:    "bad line",
+			explain: "foo.go:0",
+			wantErr: `invalid --explain "foo.go:0": line must be a positive number`,
+		},
+		{
+			desc:    "line out of range",
+			explain: "foo.go:42",
+			wantErr: `invalid --explain "foo.go:42": foo.go has only 8 lines`,
+		},
+		{
+			desc:    "last line",
+			explain: "foo.go:8",
+			wantStdout: []string{
+				`foo.patch:4:1: change "wrap" did not match foo.go:5:1: ` +
+					"expected *ast.CallExpr, found *ast.FuncDecl (foo.patch:4:2)",
+				"foo.patch:10:1: change did not match foo.go:5:1: " +
+					"expected *ast.CallExpr, found *ast.FuncDecl (foo.patch:10:2)",
+			},
+		},
+		{
+			desc:    "after last line",
+			explain: "foo.go:9",
+			wantErr: `invalid --explain "foo.go:9": foo.go has only 8 lines`,
+		},
+		{
+			desc:    "last line without newline",
+			src:     bytes.TrimSuffix(src, []byte("\n")),
+			explain: "foo.go:8",
+			wantStdout: []string{
+				`foo.patch:4:1: change "wrap" did not match foo.go:5:1: ` +
+					"expected *ast.CallExpr, found *ast.FuncDecl (foo.patch:4:2)",
+				"foo.patch:10:1: change did not match foo.go:5:1: " +
+					"expected *ast.CallExpr, found *ast.FuncDecl (foo.patch:10:2)",
+			},
+		},
+		{
+			desc:    "after last line without newline",
+			src:     bytes.TrimSuffix(src, []byte("\n")),
+			explain: "foo.go:9",
+			wantErr: `invalid --explain "foo.go:9": foo.go has only 8 lines`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			src := src
+			if tt.src != nil {
+				src = tt.src
+			}
+
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "foo.patch"), patch, 0o644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "foo.go"), src, 0o644))
+
+			var stdout, stderr bytes.Buffer
+			cmd := mainCmd{
+				Stdout: &stdout,
+				Stderr: &stderr,
+				Getwd:  func() (string, error) { return dir, nil },
+			}
+			err := cmd.Run([]string{
+				"-p", filepath.Join(dir, "foo.patch"),
+				"--explain", tt.explain,
+			})
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			got := strings.ReplaceAll(stdout.String(), dir+string(filepath.Separator), "")
+			assert.Equal(t, strings.Join(tt.wantStdout, "\n")+"\n", got)
+
+			// The file must be left unchanged.
+			gotSrc, err := os.ReadFile(filepath.Join(dir, "foo.go"))
+			require.NoError(t, err)
+			assert.Equal(t, string(src), string(gotSrc))
+		})
+	}
+}
+
+func TestRunGrep(t *testing.T) {
+	files := map[string][]byte{
+		"a.go": text.Unlines(
+			"package x",
+			"",
+			"func y() {",
+			"	a.Lock()",
+			"	defer a.Unlock()",
+			"	foo(a, b)",
+			"}",
+		),
+		"b/b.go": text.Unlines(
+			"package b",
+			"",
+			"var z = foo(1, 2)",
+		),
+	}
+
+	tests := []struct {
+		desc       string
+		patch      []byte
+		args       []string
+		wantStdout []string
+
+		wantErr      string
+		wantErrCount int // number of times wantErr is reported
+	}{
+		{
+			desc: "search only",
+			patch: text.Unlines(
+				"@@",
+				"var x, y expression",
+				"@@",
+				"-foo(x, y)",
+			),
+			wantStdout: []string{
+				"a.go:6:2: foo(a, b)",
+				"b/b.go:3:9: foo(1, 2)",
+			},
+		},
+		{
+			desc: "plus section ignored",
+			patch: text.Unlines(
+				"@@",
+				"var x, y expression",
+				"@@",
+				"-foo(x, y)",
+				"+bar(y, x)",
+			),
+			args: []string{"a.go"},
+			wantStdout: []string{
+				"a.go:6:2: foo(a, b)",
+			},
+		},
+		{
+			desc: "format",
+			patch: text.Unlines(
+				"@ lock @",
+				"var mu expression",
+				"@@",
+				"-mu.Lock()",
+				"-defer mu.Unlock()",
+			),
+			args: []string{"--format", "{{pos}} {{change}} {{.mu}}"},
+			wantStdout: []string{
+				"a.go:4:2 lock a",
+			},
+		},
+		{
+			desc: "computed identifier",
+			patch: text.Unlines(
+				"@@",
+				"var name identifier",
+				"var x, y expression",
+				"var upper identifier = upperFirst(name)",
+				"@@",
+				"-name(x, y)",
+			),
+			args: []string{"--format", "{{.name}} {{.upper}}"},
+			wantStdout: []string{
+				"foo Foo",
+				"foo Foo",
+			},
+		},
+		{
+			desc: "unknown metavariable",
+			patch: text.Unlines(
+				"@@",
+				"var x, y expression",
+				"@@",
+				"-foo(x, y)",
+			),
+			args:         []string{"-f", "{{.z}}"},
+			wantErr:      `a.go:6:2: template: format:1:2: executing "format" at <.z>: map has no entry for key "z"`,
+			wantErrCount: 1,
+		},
+		{
+			desc: "unknown metavariable in one change",
+			patch: text.Unlines(
+				"@@",
+				"var x, y expression",
+				"@@",
+				"-foo(x, y)",
+				"",
+				"@@",
+				"@@",
+				"-foo(...)",
+			),
+			args: []string{"-f", "{{.x}}"},
+			wantStdout: []string{
+				"a",
+				"1",
+			},
+			wantErr:      `map has no entry for key "x"`,
+			wantErrCount: 1,
+		},
+		{
+			desc: "invalid format",
+			patch: text.Unlines(
+				"@@",
+				"@@",
+				"-foo()",
+			),
+			args:         []string{"-f", "{{"},
+			wantErr:      "invalid --format",
+			wantErrCount: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, src := range files {
+				path := filepath.Join(dir, filepath.FromSlash(name))
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+				require.NoError(t, os.WriteFile(path, src, 0o644))
+			}
+			patchPath := filepath.Join(t.TempDir(), "foo.patch")
+			require.NoError(t, os.WriteFile(patchPath, tt.patch, 0o644))
+
+			args := append([]string{"grep", "-p", patchPath}, tt.args...)
+			if !slices.Contains(tt.args, "a.go") {
+				args = append(args, ".")
+			}
+
+			var stdout, stderr bytes.Buffer
+			cmd := mainCmd{
+				Stdout: &stdout,
+				Stderr: &stderr,
+				Getwd:  func() (string, error) { return dir, nil },
+			}
+			err := cmd.Run(args)
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErrCount, strings.Count(err.Error(), tt.wantErr), "error: %v", err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			var wantStdout string
+			if len(tt.wantStdout) > 0 {
+				wantStdout = strings.Join(tt.wantStdout, "\n") + "\n"
+			}
+			assert.Equal(t, wantStdout, stdout.String())
+
+			// Files must be left unchanged.
+			for name, src := range files {
+				got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				require.NoError(t, err)
+				assert.Equal(t, string(src), string(got))
+			}
+		})
+	}
+
+	t.Run("no patterns", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		cmd := mainCmd{Stdout: &stdout, Stderr: &stderr, Getwd: os.Getwd}
+		err := cmd.Run([]string{"grep", "-p", "foo.patch"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "please provide at least one pattern")
+	})
+}
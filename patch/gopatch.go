@@ -39,6 +39,10 @@ func Parse(patchFileName string, src []byte) (*File, error) {
 }
 
 // Apply takes the Go file name and its contents and returns a Go file with the patch applied.
+//
+// The file name decides which changes apply to the file if they filter files
+// by path or import path. Import paths are determined from the nearest go.mod
+// file, so the name should be the path to the file on disk.
 func (f *File) Apply(filename string, src []byte) ([]byte, error) {
 	base, err := parser.ParseFile(f.fset, filename, src, parser.AllErrors|parser.ParseComments)
 	if err != nil {
@@ -49,9 +53,10 @@ func (f *File) Apply(filename string, src []byte) ([]byte, error) {
 
 	var fout *ast.File
 	var retErr error
+	bindings := engine.NewBindings()
 	for _, c := range f.prog.Changes {
-		d, ok := c.Match(base)
-		if !ok {
+		d, ok := c.Match(base, bindings)
+		if !ok || c.SearchOnly {
 			// This patch didn't modify the file. Try the next one.
 			continue
 		}
@@ -109,11 +114,12 @@ func cleanupFilePos(tfile *token.File, cl engine.Changelog, comments []*ast.Comm
 			}
 		}
 
-		// Remove comments in the changed sections of the code.
+		// Remove comments in the changed sections of the code, except
+		// those generated by the patch.
 		for _, cg := range comments {
 			var list []*ast.Comment
 			for _, c := range cg.List {
-				if c.Pos() >= dr.Start && c.End() <= dr.End {
+				if c.Pos() >= dr.Start && c.End() <= dr.End && !cl.IsCommented(c) {
 					continue
 				}
 				list = append(list, c)
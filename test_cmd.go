@@ -0,0 +1,252 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/pkg/diff"
+	"github.com/uber-go/gopatch/internal/engine"
+	"github.com/uber-go/gopatch/internal/parse"
+	"golang.org/x/tools/imports"
+)
+
+type testArguments struct {
+	Patches []string `positional-arg-name:"patch"`
+}
+
+type testOptions struct {
+	Params  map[string]string `short:"D" long:"param" value-name:"name=value" key-value-delimiter:"="`
+	Verbose bool              `short:"v" long:"verbose"`
+	Args    testArguments     `positional-args:"yes"`
+}
+
+func newTestArgParser() (*flags.Parser, *testOptions) {
+	var opts testOptions
+	parser := flags.NewParser(&opts, flags.HelpFlag)
+	parser.Name = "gopatch test"
+
+	parser.FindOptionByLongName("param").
+		Description = "Value for a parameter declared in patches with \"param\". " +
+		"This may be provided multiple times to set different parameters."
+
+	parser.FindOptionByLongName("verbose").
+		Description = "Report tests that passed in addition to those that failed."
+
+	parser.Args()[0].
+		Description = "One or more patch files whose embedded tests should be run."
+
+	return parser, &opts
+}
+
+// runTest runs the tests embedded in patch files, reporting a diff for each
+// test whose output doesn't match what the patch produced.
+func (cmd *mainCmd) runTest(args []string) error {
+	argParser, opts := newTestArgParser()
+	if _, err := argParser.ParseArgs(args); err != nil {
+		return err
+	}
+
+	if len(opts.Args.Patches) == 0 {
+		argParser.WriteHelp(cmd.Stderr)
+		fmt.Fprintln(cmd.Stderr)
+
+		return errors.New("please provide at least one patch file")
+	}
+
+	var total, failed int
+	declared := make(map[string]struct{})
+	for _, path := range opts.Args.Patches {
+		n, nfailed, err := cmd.testPatch(path, opts, declared)
+		if err != nil {
+			return fmt.Errorf("load patch %q: %w", path, err)
+		}
+		total += n
+		failed += nfailed
+
+		if nfailed > 0 {
+			fmt.Fprintf(cmd.Stdout, "FAIL\t%v\n", path)
+		} else {
+			fmt.Fprintf(cmd.Stdout, "ok\t%v\t%d tests\n", path, n)
+		}
+	}
+
+	if err := checkParams(opts.Params, declared); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tests failed", failed, total)
+	}
+	return nil
+}
+
+// testPatch runs the tests embedded in the patch file at the given path,
+// returning the number of tests that ran and the number of those that
+// failed. Parameters declared by the patch are added to declared.
+//
+// Tests of a change run only that change and the changes it depends on,
+// directly or indirectly, in the order in which they appear in the patch.
+func (cmd *mainCmd) testPatch(path string, opts *testOptions, declared map[string]struct{}) (total, failed int, err error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fset := token.NewFileSet()
+	aprog, err := parse.Parse(fset, path, src)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse: %w", err)
+	}
+	for _, name := range engine.ParamNames(aprog) {
+		declared[name] = struct{}{}
+	}
+
+	var compileOpts []engine.CompileOption
+	if len(opts.Params) > 0 {
+		compileOpts = append(compileOpts, engine.Params(opts.Params))
+	}
+	prog, err := engine.Compile(fset, aprog, compileOpts...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("compile: %w", err)
+	}
+
+	for i, achange := range aprog.Changes {
+		if len(achange.Tests) == 0 {
+			continue
+		}
+
+		sub := &engine.Program{Changes: withDependencies(aprog, prog, i)}
+		for _, tt := range achange.Tests {
+			pos := fset.Position(tt.NamePos)
+			if pos.Filename != path {
+				// Tests of included changes run with the file that
+				// declares them.
+				continue
+			}
+
+			total++
+			got, want, err := runPatchTest(fset, filepath.Dir(path), sub, tt)
+			switch {
+			case err != nil:
+				failed++
+				fmt.Fprintf(cmd.Stdout, "--- FAIL: %v: %v\n", pos, tt.Name)
+				fmt.Fprintf(cmd.Stdout, "\t%v\n", err)
+			case !bytes.Equal(got, want):
+				failed++
+				fmt.Fprintf(cmd.Stdout, "--- FAIL: %v: %v\n", pos, tt.Name)
+				if err := diff.Text("want", "got", want, got, cmd.Stdout); err != nil {
+					return total, failed, err
+				}
+			case opts.Verbose:
+				fmt.Fprintf(cmd.Stdout, "--- PASS: %v: %v\n", pos, tt.Name)
+			}
+		}
+	}
+
+	return total, failed, nil
+}
+
+// withDependencies returns the compiled change at index i of the program,
+// preceded by the changes it depends on, directly or indirectly, in the
+// order in which they appear in the program.
+func withDependencies(aprog *parse.Program, prog *engine.Program, i int) []*engine.Change {
+	indexes := make(map[string]int)
+	for j, achange := range aprog.Changes[:i] {
+		if len(achange.Name) > 0 {
+			indexes[achange.Name] = j
+		}
+	}
+
+	selected := make([]bool, i+1)
+	var visit func(j int)
+	visit = func(j int) {
+		if selected[j] {
+			return
+		}
+		selected[j] = true
+		for _, name := range aprog.Changes[j].Dependencies() {
+			// Changes may refer only to changes declared before
+			// them.
+			if k, ok := indexes[name]; ok && k < j {
+				visit(k)
+			}
+		}
+	}
+	visit(i)
+
+	var changes []*engine.Change
+	for j, ok := range selected {
+		if ok {
+			changes = append(changes, prog.Changes[j])
+		}
+	}
+	return changes
+}
+
+// runPatchTest runs the given program on the input of a test as if it were
+// a file inside dir, returning the resulting contents of the file and the
+// contents the test expects.
+func runPatchTest(fset *token.FileSet, dir string, prog *engine.Program, tt *parse.Test) (got, want []byte, err error) {
+	want = tt.Output
+	if want == nil {
+		want = tt.Input
+	}
+
+	// Name the file after the test so that file filters apply to it.
+	filename := filepath.Join(dir, tt.Name+".go")
+	f, err := parser.ParseFile(fset, filename, tt.Input, parser.AllErrors|parser.ParseComments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse input: %v", err)
+	}
+
+	runner := newPatchRunner(fset, []*engine.Program{prog})
+	f, _, ok := runner.Apply(filename, f, nil /* types */)
+	if len(runner.errors) > 0 {
+		return nil, nil, runner.errors[0]
+	}
+	if !ok {
+		return tt.Input, want, nil
+	}
+
+	var out bytes.Buffer
+	if err := format.Node(&out, fset, f); err != nil {
+		return nil, nil, fmt.Errorf("could not format output: %v", err)
+	}
+	got, err = imports.Process(filename, out.Bytes(), &imports.Options{
+		Comments:   true,
+		TabIndent:  true,
+		TabWidth:   8,
+		FormatOnly: true,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("reformat output: %w", err)
+	}
+	return got, want, nil
+}
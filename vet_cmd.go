@@ -0,0 +1,147 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/uber-go/gopatch/internal/engine"
+	"github.com/uber-go/gopatch/internal/parse"
+	"go.uber.org/multierr"
+)
+
+type vetArguments struct {
+	Patches []string `positional-arg-name:"patch"`
+}
+
+type vetOptions struct {
+	Params map[string]string `short:"D" long:"param" value-name:"name=value" key-value-delimiter:"="`
+	Args   vetArguments      `positional-args:"yes"`
+}
+
+func newVetArgParser() (*flags.Parser, *vetOptions) {
+	var opts vetOptions
+	parser := flags.NewParser(&opts, flags.HelpFlag)
+	parser.Name = "gopatch vet"
+
+	parser.FindOptionByLongName("param").
+		Description = "Value for a parameter declared in patches with \"param\". " +
+		"This may be provided multiple times to set different parameters."
+
+	parser.Args()[0].
+		Description = "One or more patch files to check."
+
+	return parser, &opts
+}
+
+// runVet reports likely mistakes in patch files, one per line, prefixed with
+// their positions.
+func (cmd *mainCmd) runVet(args []string) error {
+	argParser, opts := newVetArgParser()
+	if _, err := argParser.ParseArgs(args); err != nil {
+		return err
+	}
+
+	if len(opts.Args.Patches) == 0 {
+		argParser.WriteHelp(cmd.Stderr)
+		fmt.Fprintln(cmd.Stderr)
+
+		return errors.New("please provide at least one patch file")
+	}
+
+	var problems int
+	declared := make(map[string]struct{})
+	for _, path := range opts.Args.Patches {
+		n, err := cmd.vetPatch(path, opts, declared)
+		if err != nil {
+			return fmt.Errorf("load patch %q: %w", path, err)
+		}
+		problems += n
+	}
+
+	if err := checkParams(opts.Params, declared); err != nil {
+		return err
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problems", problems)
+	}
+	return nil
+}
+
+// vetPatch reports problems found in the patch file at the given path,
+// returning the number of problems. Errors that prevent the patch from
+// compiling are reported as problems.
+//
+// Problems in changes included from other files are reported only if they
+// prevent the patch from compiling. The included files should be checked on
+// their own.
+//
+// Parameters declared by the patch are added to declared.
+func (cmd *mainCmd) vetPatch(path string, opts *vetOptions, declared map[string]struct{}) (int, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	fset := token.NewFileSet()
+	prog, err := parse.Parse(fset, path, src)
+	if err != nil {
+		return cmd.printErrors(err), nil
+	}
+	for _, name := range engine.ParamNames(prog) {
+		declared[name] = struct{}{}
+	}
+
+	var compileOpts []engine.CompileOption
+	if len(opts.Params) > 0 {
+		compileOpts = append(compileOpts, engine.Params(opts.Params))
+	}
+	diags, err := engine.Vet(fset, prog, compileOpts...)
+
+	var n int
+	for _, d := range diags {
+		pos := fset.Position(d.Pos)
+		if pos.Filename != path && !d.Error {
+			continue
+		}
+		fmt.Fprintf(cmd.Stdout, "%v: %v\n", pos, d.Message)
+		n++
+	}
+	if err != nil {
+		n += cmd.printErrors(err)
+	}
+	return n, nil
+}
+
+// printErrors prints each of the errors combined in err on its own line,
+// returning the number of errors.
+func (cmd *mainCmd) printErrors(err error) int {
+	errs := multierr.Errors(err)
+	for _, err := range errs {
+		fmt.Fprintln(cmd.Stdout, err)
+	}
+	return len(errs)
+}